@@ -0,0 +1,221 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrAckTimeout is returned when fewer peers than required acknowledged an
+// update before the deadline, see WithRequiredAcks
+var ErrAckTimeout = errors.New("timed out waiting for peer acknowledgements")
+
+// DefaultAckTimeout is the WithRequiredAcks timeout used when the one given
+// is zero or less
+const DefaultAckTimeout = 30 * time.Second
+
+// WithRequiredAcks makes Update and the UpdateFor* methods wait until n other
+// watchers have applied the update, failing with ErrAckTimeout after timeout,
+// DefaultAckTimeout if it's zero or less. A peer acknowledges once all its
+// callbacks returned without error.
+func WithRequiredAcks(n int, timeout time.Duration) Option {
+	return optionFunc(func(o *options) {
+		if timeout <= 0 {
+			timeout = DefaultAckTimeout
+		}
+		o.requiredAcks = n
+		o.ackTimeout = timeout
+	})
+}
+
+// ackWaiters routes received acknowledgements to the waiting publishers
+type ackWaiters struct {
+	mu      sync.Mutex
+	pending map[string]*ackWaiter
+}
+
+type ackWaiter struct {
+	ch   chan string
+	seen map[string]bool
+}
+
+func (a *ackWaiters) register(correlation string, n int) chan string {
+	ch := make(chan string, n)
+	a.mu.Lock()
+	a.pending[correlation] = &ackWaiter{ch: ch, seen: map[string]bool{}}
+	a.mu.Unlock()
+	return ch
+}
+
+func (a *ackWaiters) unregister(correlation string) {
+	a.mu.Lock()
+	delete(a.pending, correlation)
+	a.mu.Unlock()
+}
+
+// deliver hands the responding origin to the waiter, dropping duplicates,
+// acks for updates nobody waits for any more and acks beyond the required
+// count
+func (a *ackWaiters) deliver(correlation, origin string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	waiter, ok := a.pending[correlation]
+	if !ok || waiter.seen[origin] {
+		return
+	}
+	waiter.seen[origin] = true
+	select {
+	case waiter.ch <- origin:
+	default:
+	}
+}
+
+// DeliveryReport tells which peers acknowledged an update, see
+// UpdateWithReport
+type DeliveryReport struct {
+	// Acked lists the instance IDs of the peers that applied the update, in
+	// the order they acknowledged it.
+	Acked []string
+	// Missing lists the other known origins, see KnownOrigins, or the
+	// target of an UpdateTo, that hadn't acknowledged the update when the
+	// wait ended, sorted: they lag behind or are gone.
+	Missing []string
+}
+
+// AckTimeoutError is returned, matching ErrAckTimeout, when fewer peers than
+// required acknowledged an update before the deadline
+type AckTimeoutError struct {
+	Required int
+	Report   DeliveryReport
+}
+
+func (e *AckTimeoutError) Error() string {
+	return fmt.Sprintf("%s: %d of %d", ErrAckTimeout, len(e.Report.Acked), e.Required)
+}
+
+func (e *AckTimeoutError) Unwrap() error { return ErrAckTimeout }
+
+// UpdateWithReport is like UpdateContext but also returns which peers
+// acknowledged the update with WithRequiredAcks, and which didn't, so
+// lagging nodes can be detected. The report is also returned when waiting
+// for the acknowledgements timed out; it's empty without WithRequiredAcks.
+func (w *Watcher) UpdateWithReport(ctx context.Context) (DeliveryReport, error) {
+	m := w.newMessage(w.reloadSignal(), Update)
+	w.setCompactionKey(m, UpdateMessage{Op: Update})
+	return w.broadcastReport(ctx, m)
+}
+
+// broadcast sends an update and, if acknowledgements are required, waits
+// for them. A targeted update is only acknowledged by its target.
+func (w *Watcher) broadcast(ctx context.Context, m *pubsub.Message) error {
+	_, err := w.broadcastReport(ctx, m)
+	return err
+}
+
+// broadcastReport is broadcast returning the acknowledgements received
+func (w *Watcher) broadcastReport(ctx context.Context, m *pubsub.Message) (DeliveryReport, error) {
+	if w.opts.legacyMode {
+		return DeliveryReport{}, w.sendLegacy(ctx)
+	}
+	w.warnUnwired()
+	ctx, span := w.startPublishSpan(ctx, m)
+	defer span.End()
+	w.log(LevelDebug, "Publishing update", "op", m.Metadata[w.metadataKey(metaOp)],
+		"sequence", m.Metadata[w.metadataKey(metaSequence)], LogFieldCorrelation, w.messageCorrelation(m))
+	w.publishLocally(m)
+	n := w.opts.requiredAcks
+	if n <= 0 {
+		return DeliveryReport{}, w.sendUpdate(ctx, m)
+	}
+	target := m.Metadata[w.metadataKey(metaTarget)]
+	if target != "" {
+		n = 1
+	}
+
+	correlation := w.messageCorrelation(m)
+	m.Metadata[w.metadataKey(metaReplyTo)] = correlation
+	ch := w.acks.register(correlation, n)
+	defer w.acks.unregister(correlation)
+
+	if err := w.sendUpdate(ctx, m); err != nil {
+		return DeliveryReport{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.opts.ackTimeout)
+	defer cancel()
+	var report DeliveryReport
+	for len(report.Acked) < n {
+		select {
+		case origin := <-ch:
+			report.Acked = append(report.Acked, origin)
+		case <-ctx.Done():
+			report.Missing = w.missingAcks(target, report.Acked)
+			return report, &AckTimeoutError{Required: n, Report: report}
+		}
+	}
+	report.Missing = w.missingAcks(target, report.Acked)
+	return report, nil
+}
+
+// missingAcks returns the peers expected to acknowledge an update, its
+// target if any or else the known origins, that aren't in acked
+func (w *Watcher) missingAcks(target string, acked []string) []string {
+	done := make(map[string]bool, len(acked))
+	for _, origin := range acked {
+		done[origin] = true
+	}
+	expected := []string{target}
+	if target == "" {
+		expected = expected[:0]
+		for origin := range w.KnownOrigins() {
+			expected = append(expected, origin)
+		}
+	}
+	var missing []string
+	for _, origin := range expected {
+		if origin != w.opts.instanceID && !done[origin] {
+			missing = append(missing, origin)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// sendUpdate queues m for the WithAsyncSend sender or sends it right away
+func (w *Watcher) sendUpdate(ctx context.Context, m *pubsub.Message) error {
+	if w.queue.queued() {
+		return w.enqueue(ctx, m)
+	}
+	return w.sendNow(ctx, m)
+}
+
+// sendNow sends m within the WithMaxConcurrentSends cap
+func (w *Watcher) sendNow(ctx context.Context, m *pubsub.Message) error {
+	w.quiet.begin()
+	defer w.quiet.end()
+	release, err := w.acquireSend(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := w.send(ctx, m); err != nil {
+		return err
+	}
+	if w.isOwnUpdate(m) {
+		atomic.AddUint64(&w.quiet.sent, 1)
+	}
+	return nil
+}
+
+func (w *Watcher) sendAck(correlation string) {
+	m := w.newControlMessage(kindAck, map[string]string{metaCorrelation: correlation})
+	if err := w.send(w.lifecycle(), m); err != nil {
+		w.log(LevelWarn, "Failed to acknowledge update", "error", err, "correlation", correlation)
+	}
+}
@@ -0,0 +1,139 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRequiredAcks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	newPeer := func(opts ...Option) *Watcher {
+		w, err := NewWithOptions(ctx, "fake://acks", append(opts, WithURLMux(broker.mux()))...)
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		t.Cleanup(w.Close)
+		return w
+	}
+
+	publisher := newPeer(WithRequiredAcks(2, time.Second*5))
+	publisher.SetUpdateCallback(func(string) {})
+
+	applied := make(chan string, 4)
+	for _, name := range []string{"peer-1", "peer-2"} {
+		name := name
+		peer := newPeer(WithInstanceID(name))
+		peer.SetUpdateCallback(func(string) {
+			applied <- name
+		})
+	}
+
+	if err := publisher.Update(); err != nil {
+		t.Fatalf("Update didn't collect the peer acks: %s", err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("Update returned before both peers applied it, %d applied", len(applied))
+	}
+}
+
+func TestRequiredAcksDefaultTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		if got := buildOptions(WithRequiredAcks(1, timeout)).ackTimeout; got != DefaultAckTimeout {
+			t.Fatalf("Timeout %s: waiting %s for acks, want %s", timeout, got, DefaultAckTimeout)
+		}
+	}
+}
+
+func TestRequiredAcksTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	publisher, err := NewWithOptions(ctx, "fake://acks", WithURLMux(broker.mux()), WithRequiredAcks(2, time.Millisecond*300))
+	if err != nil {
+		t.Fatalf("Failed to create publisher, error: %s", err)
+	}
+	defer publisher.Close()
+
+	peer, err := NewWithOptions(ctx, "fake://acks", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create peer, error: %s", err)
+	}
+	defer peer.Close()
+	peer.SetUpdateCallback(func(string) {})
+
+	// a peer failing to apply the update doesn't acknowledge it
+	failing, err := NewWithOptions(ctx, "fake://acks", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create peer, error: %s", err)
+	}
+	defer failing.Close()
+	failing.SetUpdateCallbackEx(func(UpdateMessage) error {
+		return errors.New("apply failed")
+	})
+
+	start := time.Now()
+	err = publisher.UpdateForAddPolicy("p", "p", "alice", "data1", "read")
+	var timeoutErr *AckTimeoutError
+	if !errors.Is(err, ErrAckTimeout) || !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected ErrAckTimeout, got: %v", err)
+	}
+	if acked := timeoutErr.Report.Acked; timeoutErr.Required != 2 || len(acked) != 1 || acked[0] != peer.opts.instanceID {
+		t.Fatalf("Expected only %s to acknowledge, got %+v", peer.opts.instanceID, timeoutErr)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*300 {
+		t.Fatalf("Update gave up before the deadline, after %s", elapsed)
+	}
+}
+
+func TestDeliveryReport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	newPeer := func(opts ...Option) *Watcher {
+		w, err := NewWithOptions(ctx, "fake://acks", append(opts, WithURLMux(broker.mux()))...)
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		t.Cleanup(w.Close)
+		return w
+	}
+
+	publisher := newPeer(WithRequiredAcks(2, time.Second*5))
+	publisher.SetUpdateCallback(func(string) {})
+	for _, name := range []string{"peer-1", "peer-2"} {
+		newPeer(WithInstanceID(name)).SetUpdateCallback(func(string) {})
+	}
+	// a lagging peer the publisher heard from before
+	lagging := newPeer(WithInstanceID("peer-3"))
+	lagging.SetUpdateCallbackEx(func(UpdateMessage) error {
+		return errors.New("apply failed")
+	})
+	if err := lagging.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		_, ok := publisher.KnownOrigins()["peer-3"]
+		return ok
+	})
+
+	report, err := publisher.UpdateWithReport(ctx)
+	if err != nil {
+		t.Fatalf("Update didn't collect the peer acks: %s", err)
+	}
+	sort.Strings(report.Acked)
+	if !reflect.DeepEqual(report.Acked, []string{"peer-1", "peer-2"}) {
+		t.Fatalf("Expected peer-1 and peer-2 to acknowledge, got %v", report.Acked)
+	}
+	if !reflect.DeepEqual(report.Missing, []string{"peer-3"}) {
+		t.Fatalf("Expected peer-3 to be missing, got %v", report.Missing)
+	}
+}
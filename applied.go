@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin"
+)
+
+// LastApplied tells when each callback and enforcer last applied an update
+// successfully, measured with the WithClock clock, e.g. for a readiness probe
+// checking the policy is fresh. Times are zero until the first success.
+type LastApplied struct {
+	// Callback is the time for the SetUpdateCallback callback.
+	Callback time.Time `json:"callback"`
+	// CallbackEx is the time for the SetUpdateCallbackEx callback.
+	CallbackEx time.Time `json:"callbackEx"`
+	// Batch is the time for the SetUpdateBatchCallback callback.
+	Batch time.Time `json:"batch"`
+	// Enforcers has the time for each enforcer registered with AddEnforcer.
+	Enforcers map[*casbin.Enforcer]time.Time `json:"-"`
+}
+
+// lastApplied records when the callbacks last succeeded. The enforcers keep
+// their own time in their lane.
+type lastApplied struct {
+	mu         sync.Mutex
+	callback   time.Time
+	callbackEx time.Time
+	// callbackBatch is updated once per batch
+	callbackBatch time.Time
+}
+
+// LastApplied returns when each callback and enforcer last applied an
+// update successfully. Updates dropped before reaching them, e.g. filtered
+// or already applied, and failed applies leave the times unchanged.
+func (w *Watcher) LastApplied() LastApplied {
+	w.applied.mu.Lock()
+	la := LastApplied{Callback: w.applied.callback, CallbackEx: w.applied.callbackEx, Batch: w.applied.callbackBatch}
+	w.applied.mu.Unlock()
+
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	la.Enforcers = make(map[*casbin.Enforcer]time.Time, len(w.enforcers))
+	for _, lane := range w.enforcers {
+		lane.mu.Lock()
+		la.Enforcers[lane.e] = lane.applied
+		lane.mu.Unlock()
+	}
+	return la
+}
+
+// recordApplied wraps done to record the time in *at when err is nil
+func (w *Watcher) recordApplied(at *time.Time, mu sync.Locker, done func(error)) func(error) {
+	return func(err error) {
+		if err == nil {
+			now := w.opts.clock.Now()
+			mu.Lock()
+			*at = now
+			mu.Unlock()
+		}
+		done(err)
+	}
+}
@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin"
+)
+
+func TestLastApplied(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://applied", WithURLMux((&fakeBroker{}).mux()), WithInstanceID("me"),
+		WithSelfFilter(SelfFilterAll), WithClock(clock), WithAckOnlyOnSuccess(), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	e := casbin.NewEnforcer("./test_data/model.conf", "./test_data/policy.csv")
+	w.AddEnforcer(e, nil)
+	fail := make(chan bool, 1)
+	fail <- false
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		if <-fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	// the outcome is reported once the update was applied
+	received := func() {
+		t.Helper()
+		select {
+		case <-events:
+		case <-time.After(time.Second * 5):
+			t.Fatal("Update wasn't handled in time")
+		}
+	}
+
+	// the watcher's own update is filtered
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	received()
+	la := w.LastApplied()
+	if !la.CallbackEx.IsZero() || !la.Enforcers[e].IsZero() {
+		t.Fatalf("Expected no applied update after a filtered one, got %+v", la)
+	}
+
+	clock.advance(time.Minute)
+	applied := clock.Now()
+	if err := w.topic.Send(ctx, stampedMessage(w, "other", "1", "elsewhere", "1")); err != nil {
+		t.Fatalf("Failed to send message: %s", err)
+	}
+	received()
+	la = w.LastApplied()
+	if !la.CallbackEx.Equal(applied) || !la.Enforcers[e].Equal(applied) {
+		t.Fatalf("Expected the update applied at %s, got %+v", applied, la)
+	}
+	if !la.Callback.IsZero() {
+		t.Fatalf("Expected no time for the unset callback, got %s", la.Callback)
+	}
+
+	// a failed apply leaves the time of the callback unchanged
+	clock.advance(time.Minute)
+	fail <- true
+	if err := w.topic.Send(ctx, stampedMessage(w, "other", "2", "elsewhere", "1")); err != nil {
+		t.Fatalf("Failed to send message: %s", err)
+	}
+	received()
+	la = w.LastApplied()
+	if !la.CallbackEx.Equal(applied) {
+		t.Fatalf("Expected the failed apply not to count, got %s", la.CallbackEx)
+	}
+	if !la.Enforcers[e].Equal(clock.Now()) {
+		t.Fatalf("Expected the enforcer applied at %s, got %s", clock.Now(), la.Enforcers[e])
+	}
+}
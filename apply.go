@@ -0,0 +1,81 @@
+package watcher
+
+import (
+	"fmt"
+
+	"github.com/casbin/casbin"
+)
+
+// ApplyTo applies the change described by um to the in-memory policy of e,
+// without writing to its adapter or notifying its watcher, so it can be
+// used as a SetUpdateCallbackEx callback:
+//
+//	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+//		_, err := ApplyTo(e, um)
+//		return err
+//	})
+//
+// Update, and UpdateForSavePolicy without a snapshot, reload the policy from
+// the adapter. Role links are rebuilt after changes to the "g" section.
+// applied reports whether the policy changed; adding a rule e already has
+// or removing one it doesn't is not an error. Enforcers aren't safe for
+// concurrent use, see WithApplyLock.
+func ApplyTo(e *casbin.Enforcer, um UpdateMessage) (applied bool, err error) {
+	switch um.Op {
+	case Update:
+		return true, e.LoadPolicy()
+	case UpdateForSavePolicy:
+		if um.Snapshot == nil {
+			return true, e.LoadPolicy()
+		}
+		if err := um.Snapshot.Apply(e.GetModel()); err != nil {
+			return false, err
+		}
+		e.BuildRoleLinks()
+		return true, nil
+	case UpdateForAddPolicy, UpdateForRemovePolicy, UpdateForRemoveFilteredPolicy,
+		UpdateForAddPolicies, UpdateForRemovePolicies, UpdateForUpdatePolicy, UpdateForUpdatePolicies:
+	default:
+		return false, fmt.Errorf("%w: %q", ErrUnknownOp, um.Op)
+	}
+
+	m := e.GetModel()
+	if _, ok := m[um.Sec][um.Ptype]; !ok {
+		return false, fmt.Errorf("%w: %s.%s", ErrUnknownPolicyType, um.Sec, um.Ptype)
+	}
+	switch um.Op {
+	case UpdateForAddPolicy:
+		applied = m.AddPolicy(um.Sec, um.Ptype, um.Params)
+	case UpdateForRemovePolicy:
+		applied = m.RemovePolicy(um.Sec, um.Ptype, um.Params)
+	case UpdateForRemoveFilteredPolicy:
+		applied = m.RemoveFilteredPolicy(um.Sec, um.Ptype, um.FieldIndex, um.FieldValues...)
+	case UpdateForAddPolicies:
+		for _, rule := range um.Rules {
+			applied = m.AddPolicy(um.Sec, um.Ptype, rule) || applied
+		}
+	case UpdateForRemovePolicies:
+		for _, rule := range um.Rules {
+			applied = m.RemovePolicy(um.Sec, um.Ptype, rule) || applied
+		}
+	case UpdateForUpdatePolicy:
+		if m.RemovePolicy(um.Sec, um.Ptype, um.Params) {
+			m.AddPolicy(um.Sec, um.Ptype, um.NewParams)
+			applied = true
+		}
+	case UpdateForUpdatePolicies:
+		if len(um.Rules) != len(um.NewRules) {
+			return false, fmt.Errorf("%s replaces %d rules with %d", um.Op, len(um.Rules), len(um.NewRules))
+		}
+		for i, rule := range um.Rules {
+			if m.RemovePolicy(um.Sec, um.Ptype, rule) {
+				m.AddPolicy(um.Sec, um.Ptype, um.NewRules[i])
+				applied = true
+			}
+		}
+	}
+	if applied && um.Sec == "g" {
+		e.BuildRoleLinks()
+	}
+	return applied, nil
+}
@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/casbin/casbin"
+)
+
+func TestApplyTo(t *testing.T) {
+	e := casbin.NewEnforcer("./test_data/model.conf", "./test_data/policy.csv")
+
+	apply := func(um UpdateMessage, wantApplied bool) {
+		t.Helper()
+		applied, err := ApplyTo(e, um)
+		if err != nil {
+			t.Fatalf("Failed to apply %s, error: %s", um.Op, err)
+		}
+		if applied != wantApplied {
+			t.Fatalf("Expected %s to report applied %t, got %t", um.Op, wantApplied, applied)
+		}
+	}
+
+	apply(UpdateMessage{Op: UpdateForAddPolicy, Sec: "p", Ptype: "p", Params: []string{"carol", "data3", "read"}}, true)
+	if !e.Enforce("carol", "data3", "read") {
+		t.Fatal("The added rule wasn't applied")
+	}
+	apply(UpdateMessage{Op: UpdateForAddPolicy, Sec: "p", Ptype: "p", Params: []string{"carol", "data3", "read"}}, false)
+
+	apply(UpdateMessage{Op: UpdateForRemovePolicy, Sec: "p", Ptype: "p", Params: []string{"alice", "data1", "read"}}, true)
+	if e.Enforce("alice", "data1", "read") {
+		t.Fatal("The removed rule wasn't applied")
+	}
+
+	apply(UpdateMessage{Op: UpdateForRemoveFilteredPolicy, Sec: "p", Ptype: "p", FieldIndex: 0, FieldValues: []string{"data2_admin"}}, true)
+	if e.Enforce("alice", "data2", "read") {
+		t.Fatal("The rules matching the filter weren't removed")
+	}
+
+	apply(UpdateMessage{Op: UpdateForAddPolicy, Sec: "g", Ptype: "g", Params: []string{"carol", "bob"}}, true)
+	if !e.Enforce("carol", "data2", "write") {
+		t.Fatal("The role links weren't rebuilt")
+	}
+
+	apply(UpdateMessage{Op: UpdateForUpdatePolicy, Sec: "p", Ptype: "p", Params: []string{"bob", "data2", "write"}, NewParams: []string{"bob", "data2", "read"}}, true)
+	if e.Enforce("bob", "data2", "write") || !e.Enforce("bob", "data2", "read") {
+		t.Fatal("The replaced rule wasn't applied")
+	}
+
+	// a reload restores the policy of the adapter
+	apply(UpdateMessage{Op: Update}, true)
+	if e.Enforce("carol", "data3", "read") || !e.Enforce("alice", "data1", "read") || !e.Enforce("alice", "data2", "read") {
+		t.Fatal("The policy wasn't reloaded")
+	}
+
+	_, err := ApplyTo(e, UpdateMessage{Op: UpdateForAddPolicy, Sec: "p", Ptype: "p2", Params: []string{"carol"}})
+	if !errors.Is(err, ErrUnknownPolicyType) {
+		t.Fatalf("Expected ErrUnknownPolicyType, got: %v", err)
+	}
+	_, err = ApplyTo(e, UpdateMessage{Op: "UpdateForSomethingNew"})
+	if !errors.Is(err, ErrUnknownOp) {
+		t.Fatalf("Expected ErrUnknownOp, got: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestApplyLock(t *testing.T) {
+	ctx := context.Background()
+
+	// policy stands in for an enforcer that isn't safe for concurrent
+	// writes; the race detector flags unserialized access to it
+	policy := map[int]int{}
+	var running, overlapped, applied int32
+	apply := func() {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		policy[len(policy)] = len(policy)
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&applied, 1)
+	}
+
+	lock := &sync.Mutex{}
+	broker := &fakeBroker{}
+	var watchers []*Watcher
+	for i := 0; i < 2; i++ {
+		w, err := NewWithOptions(ctx, "fake://apply-lock", WithURLMux(broker.mux()), WithApplyLock(lock))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		defer w.Close()
+		// both callbacks of both watchers touch the same enforcer
+		w.SetUpdateCallback(func(string) { apply() })
+		w.SetUpdateCallbackEx(func(UpdateMessage) error {
+			apply()
+			return nil
+		})
+		watchers = append(watchers, w)
+	}
+
+	const updates = 10
+	for i := 0; i < updates; i++ {
+		if err := watchers[i%2].Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	// every update reaches both callbacks of both watchers
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&applied) == updates*4 })
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Fatal("Callbacks sharing an apply lock ran concurrently")
+	}
+}
+
+func TestApplyLockOwned(t *testing.T) {
+	o := buildOptions(WithApplyLock(nil))
+	if o.applyLock == nil {
+		t.Fatal("Expected the watcher to own an apply lock")
+	}
+}
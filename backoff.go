@@ -0,0 +1,63 @@
+package watcher
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff sets the delays between the attempts of reconnects, of opening
+// the topic and subscription with WithOpenRetry and of the sends retried
+// by WithAsyncSend. Next returns the delay before the given attempt of a
+// retry loop, starting at 1, and Reset is called once a loop succeeded.
+// Several loops may call them at the same time.
+type Backoff interface {
+	Next(attempt int) time.Duration
+	Reset()
+}
+
+// DefaultBackoff is the backoff used unless WithBackoff is given: from 100ms,
+// doubling up to 30s, each delay lengthened by up to a fifth at random so
+// watchers disconnected together don't retry in lockstep.
+var DefaultBackoff Backoff = ExponentialBackoff{Min: reconnectMinBackoff, Max: reconnectMaxBackoff, Jitter: 0.2}
+
+// WithBackoff sets the delays between retries, DefaultBackoff by default.
+func WithBackoff(b Backoff) Option {
+	return optionFunc(func(o *options) {
+		o.backoff = b
+	})
+}
+
+// ExponentialBackoff waits Min before the first attempt and twice as long
+// before each following one, up to Max. Each delay is then lengthened by a
+// random fraction of itself of up to Jitter, e.g. 0.2 for up to 20%.
+type ExponentialBackoff struct {
+	Min, Max time.Duration
+	Jitter   float64
+}
+
+// Next implements Backoff.
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	d := b.Min
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d += time.Duration(rand.Float64() * b.Jitter * float64(d))
+	}
+	return d
+}
+
+// Reset implements Backoff, there's nothing to reset.
+func (ExponentialBackoff) Reset() {}
+
+// ConstantBackoff waits the same time before every attempt.
+type ConstantBackoff time.Duration
+
+// Next implements Backoff.
+func (b ConstantBackoff) Next(int) time.Duration { return time.Duration(b) }
+
+// Reset implements Backoff, there's nothing to reset.
+func (ConstantBackoff) Reset() {}
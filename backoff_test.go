@@ -0,0 +1,134 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestBackoffs(t *testing.T) {
+	constant := ConstantBackoff(time.Second)
+	for attempt := 1; attempt <= 5; attempt++ {
+		if d := constant.Next(attempt); d != time.Second {
+			t.Fatalf("Expected a constant 1s backoff, got %s for attempt %d", d, attempt)
+		}
+	}
+
+	exponential := ExponentialBackoff{Min: time.Second, Max: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := exponential.Next(attempt + 1); d != want {
+			t.Fatalf("Expected a %s backoff for attempt %d, got %s", want, attempt+1, d)
+		}
+	}
+
+	exponential.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := exponential.Next(2); d < 2*time.Second || d > 3*time.Second {
+			t.Fatalf("Expected a backoff between 2s and 3s, got %s", d)
+		}
+	}
+}
+
+// recordingBackoff records the attempts it's asked the delay of
+type recordingBackoff struct {
+	ConstantBackoff
+	mu       sync.Mutex
+	attempts []int
+	resets   int
+}
+
+func (b *recordingBackoff) Next(attempt int) time.Duration {
+	b.mu.Lock()
+	b.attempts = append(b.attempts, attempt)
+	b.mu.Unlock()
+	return b.ConstantBackoff.Next(attempt)
+}
+
+func (b *recordingBackoff) Reset() {
+	b.mu.Lock()
+	b.resets++
+	b.mu.Unlock()
+}
+
+// take returns the attempts and resets recorded since the last call
+func (b *recordingBackoff) take() ([]int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	attempts, resets := b.attempts, b.resets
+	b.attempts, b.resets = nil, 0
+	return attempts, resets
+}
+
+func TestCustomBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failOpens, failSends int32
+	errUnavailable := errors.New("broker unavailable")
+	broker := &fakeBroker{
+		openSubErr: func(*url.URL) error {
+			if atomic.AddInt32(&failOpens, -1) >= 0 {
+				return errUnavailable
+			}
+			return nil
+		},
+		sendErr: func(context.Context, []*driver.Message) error {
+			if atomic.AddInt32(&failSends, -1) >= 0 {
+				return errUnavailable
+			}
+			return nil
+		},
+		errorCode: func(error) gcerrors.ErrorCode { return gcerrors.Internal },
+	}
+	backoff := &recordingBackoff{ConstantBackoff: ConstantBackoff(time.Millisecond * 20)}
+	logs := &syncBuffer{}
+	w, err := NewWithOptions(ctx, "fake://backoff", WithURLMux(broker.mux()), WithBackoff(backoff),
+		WithAsyncSend(4, SendLimitBlock, 3), WithLogger(NewJSONLogger(logs)))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	expect := func(what string, want []int) {
+		t.Helper()
+		attempts, resets := backoff.take()
+		if len(attempts) != len(want) || resets != 1 {
+			t.Fatalf("Expected %s to wait for attempts %v and reset once, got %v and %d resets", what, want, attempts, resets)
+		}
+		for i := range want {
+			if attempts[i] != want[i] {
+				t.Fatalf("Expected %s to wait for attempts %v, got %v", what, want, attempts)
+			}
+		}
+	}
+
+	// the reconnect waits before each of its three attempts
+	atomic.StoreInt32(&failOpens, 2)
+	broker.subscriptions()[0].fail(errors.New("connection reset"))
+	waitFor(t, time.Second*5, func() bool { return w.Stats().ReconnectDuration.Count == 1 })
+	expect("the reconnect", []int{1, 2, 3})
+	if d := w.Stats().ReconnectDuration.Max; d < time.Millisecond*60 {
+		t.Fatalf("Expected the reconnect to wait 3 times 20ms, took %s", d)
+	}
+	if n := strings.Count(logs.String(), `"backoff":20000000`); n != 2 {
+		t.Fatalf("Expected 2 failed attempts logged with a 20ms backoff, got %d in %s", n, logs.String())
+	}
+
+	// the queued send waits before each of its two retries
+	atomic.StoreInt32(&failSends, 2)
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to queue update: %s", err)
+	}
+	if err := w.Quiesce(ctx); err != nil {
+		t.Fatalf("Failed to quiesce: %s", err)
+	}
+	expect("the send", []int{1, 2})
+}
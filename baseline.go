@@ -0,0 +1,115 @@
+package watcher
+
+import (
+	"sync"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithBaselineBuffer holds the incremental updates received before the
+// first successful reload, so they aren't applied on top of a policy that
+// was never loaded, e.g. because the database was down at startup. The
+// reload is the WithInitialResync one, UpdateAndReload or a received Update
+// or UpdateForSavePolicy whose callbacks all succeeded; the held updates are
+// then dispatched in the order they were received. Up to size updates are
+// held, unacknowledged; beyond that they're nacked to be redelivered later.
+func WithBaselineBuffer(size int) Option {
+	return optionFunc(func(o *options) {
+		o.baselineBuffer = size
+	})
+}
+
+// baseline holds the updates received before the first successful reload
+type baseline struct {
+	mu          sync.Mutex
+	established bool
+	// replaying is set while the held updates are being dispatched
+	replaying bool
+	held      []heldMessage
+}
+
+type heldMessage struct {
+	msg    *pubsub.Message
+	body   string
+	settle func(AckOutcome)
+}
+
+// isReload reports whether msg makes the callbacks reload the whole policy
+func (w *Watcher) isReload(msg *pubsub.Message) bool {
+	switch UpdateType(msg.Metadata[w.metadataKey(metaOp)]) {
+	case "", Update, UpdateForSavePolicy:
+		return true
+	}
+	return false
+}
+
+// holdUntilBaseline holds msg if it's an incremental update received before
+// the first successful reload, and reports whether it did
+func (w *Watcher) holdUntilBaseline(msg *pubsub.Message, body string, settle func(AckOutcome)) bool {
+	if w.opts.baselineBuffer <= 0 || w.opts.strictOrdering || w.isReload(msg) {
+		return false
+	}
+	b := &w.baseline
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.established {
+		return false
+	}
+	if len(b.held) >= w.opts.baselineBuffer {
+		w.log(LevelWarn, "Baseline buffer full, nacking update received before the first reload", "id", msg.LoggableID)
+		settle(Nacked)
+		return true
+	}
+	w.log(LevelDebug, "Holding update received before the first reload", "id", msg.LoggableID)
+	b.held = append(b.held, heldMessage{msg: msg, body: body, settle: settle})
+	return true
+}
+
+// baselineHook returns the func establishing the baseline once the
+// callbacks of msg succeeded, or nil if msg can't establish it
+func (w *Watcher) baselineHook(msg *pubsub.Message) func(ok bool) {
+	if w.opts.baselineBuffer <= 0 || !w.isReload(msg) {
+		return nil
+	}
+	w.baseline.mu.Lock()
+	established := w.baseline.established
+	w.baseline.mu.Unlock()
+	if established {
+		return nil
+	}
+	return func(ok bool) {
+		if ok {
+			w.establishBaseline()
+		}
+	}
+}
+
+// establishBaseline dispatches the held updates in order. Updates received
+// meanwhile are held behind them until none is left.
+func (w *Watcher) establishBaseline() {
+	if w.opts.baselineBuffer <= 0 {
+		return
+	}
+	b := &w.baseline
+	b.mu.Lock()
+	if b.established || b.replaying {
+		b.mu.Unlock()
+		return
+	}
+	b.replaying = true
+	for {
+		held := b.held
+		b.held = nil
+		if len(held) == 0 {
+			b.established, b.replaying = true, false
+			b.mu.Unlock()
+			w.log(LevelInfo, "Policy loaded, no longer holding updates")
+			return
+		}
+		b.mu.Unlock()
+		for _, h := range held {
+			w.dispatchMessage(h.msg, h.body, h.settle, nil)
+		}
+		b.mu.Lock()
+	}
+}
@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBaselineBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://baseline", WithURLMux((&fakeBroker{}).mux()),
+		WithBaselineBuffer(10), WithCallbackConcurrency(1), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var dbUp int32
+	applied := make(chan string, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		if um.Op == Update {
+			if atomic.LoadInt32(&dbUp) == 0 {
+				return errors.New("database unavailable")
+			}
+			applied <- "reload"
+			return nil
+		}
+		applied <- um.Params[0]
+		return nil
+	})
+	expectNothing := func() {
+		t.Helper()
+		select {
+		case got := <-applied:
+			t.Fatalf("Update %s applied before the policy was loaded", got)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := w.UpdateForAddPolicy("p", "p", user, "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	expectNothing()
+
+	// a failed reload doesn't release them
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.UpdateForAddPolicy("p", "p", "carol", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectNothing()
+
+	atomic.StoreInt32(&dbUp, 1)
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	for _, want := range []string{"reload", "alice", "bob", "carol"} {
+		select {
+		case got := <-applied:
+			if got != want {
+				t.Fatalf("Expected %s to be applied next, got %s", want, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Update %s wasn't applied", want)
+		}
+	}
+
+	// once loaded, updates are applied right away
+	if err := w.UpdateForAddPolicy("p", "p", "dave", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case got := <-applied:
+		if got != "dave" {
+			t.Fatalf("Expected dave to be applied, got %s", got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update received after the reload wasn't applied")
+	}
+}
@@ -0,0 +1,202 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithReceiveBatch receives messages in batches of up to size, for
+// providers where pulling many at once is cheaper: once a message arrives,
+// the watcher waits up to linger of the WithClock clock for the others
+// before processing them together. Their updates are delivered to the
+// SetUpdateBatchCallback callback in a single call, and the messages of a
+// batch are acked or nacked together once each has an outcome. A batch
+// stops growing once WithMaxUnacked messages are unacked, and batching is
+// disabled by WithStrictOrdering.
+func WithReceiveBatch(size int, linger time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.batchSize = size
+		o.batchLinger = linger
+	})
+}
+
+// SetUpdateBatchCallback sets the callback receiving the updates of a batch
+// received with WithReceiveBatch in a single call, in the order received,
+// e.g. to apply them in one transaction. Batches are delivered one at a
+// time, in order, and updates received outside a batch, e.g. held until the
+// baseline or without WithReceiveBatch, as batches of one. Like other
+// callbacks it runs alongside the SetUpdateCallback and SetUpdateCallbackEx
+// callbacks, and its failure is reported for each update of the batch.
+func (w *Watcher) SetUpdateBatchCallback(callbackFunc func([]UpdateMessage) error) error {
+	w.connMu.Lock()
+	w.callbackBatch = callbackFunc
+	w.connMu.Unlock()
+	if callbackFunc != nil {
+		w.callbackSet()
+	}
+	return nil
+}
+
+// receivedBatch is a batch of messages received together. Their settling is
+// held until each has an outcome, and their updates are collected for the
+// batch callback until flushed.
+type receivedBatch struct {
+	mu      sync.Mutex
+	pending int
+	// settles holds the settling of each message, in the order received
+	settles []func()
+	flushed bool
+	updates []UpdateMessage
+	dones   []func(error)
+	size    int
+}
+
+// batching reports whether messages are received in batches
+func (w *Watcher) batching() bool {
+	return w.opts.batchSize > 1 && !w.opts.strictOrdering
+}
+
+// receiveBatch receives the messages following first, until the batch is
+// full, linger elapsed or WithMaxUnacked messages are unacked. A receive
+// error ends the batch and is left to the next receive.
+func (w *Watcher) receiveBatch(rc *receiveContext, sub subscriptionReceiver, first *pubsub.Message) []*pubsub.Message {
+	msgs := []*pubsub.Message{first}
+	ctx, cancel := context.WithCancel(rc.ctx)
+	defer cancel()
+	timer := w.opts.clock.AfterFunc(w.opts.batchLinger, cancel)
+	defer timer.Stop()
+	for len(msgs) < w.opts.batchSize && w.hasRoom(len(msgs)) {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// hasRoom reports whether n more messages can be received without reaching
+// WithMaxUnacked
+func (w *Watcher) hasRoom(n int) bool {
+	return w.opts.maxUnacked <= 0 || w.unacked.count()+n < w.opts.maxUnacked
+}
+
+// processBatch handles the messages of a batch, received from sub, and
+// delivers their updates to the batch callback
+func (w *Watcher) processBatch(ctx context.Context, sub subscriptionReceiver, msgs []*pubsub.Message) {
+	batch := &receivedBatch{pending: len(msgs), settles: make([]func(), len(msgs))}
+	for _, msg := range msgs {
+		w.batches.Store(msg, batch)
+	}
+	for i, msg := range msgs {
+		i := i
+		w.processMessage(ctx, sub, msg, func(settle func()) {
+			batch.settle(i, settle)
+		})
+	}
+	for _, msg := range msgs {
+		w.batches.Delete(msg)
+	}
+	w.flushBatch(batch)
+}
+
+// flushBatchOf dispatches the updates collected so far in the batch of msg,
+// if it's being processed, so an update dispatched right away, like the
+// reload of WithGapResync, isn't applied before them. The following updates
+// of the batch are then dispatched alone.
+func (w *Watcher) flushBatchOf(msg *pubsub.Message) {
+	if b, ok := w.batches.Load(msg); ok {
+		w.flushBatch(b.(*receivedBatch))
+	}
+}
+
+// flushBatch dispatches the updates collected in b to the batch callback
+func (w *Watcher) flushBatch(b *receivedBatch) {
+	if updates, dones, size := b.flush(); len(updates) > 0 {
+		w.connMu.RLock()
+		callback := w.callbackBatch
+		w.connMu.RUnlock()
+		w.dispatchBatch(callback, updates, dones, size)
+	}
+}
+
+// settle holds fn, settling the i-th message, until every message of the
+// batch has an outcome, and then settles them in the order received
+func (b *receivedBatch) settle(i int, fn func()) {
+	b.mu.Lock()
+	b.settles[i] = fn
+	b.pending--
+	if b.pending > 0 {
+		b.mu.Unlock()
+		return
+	}
+	settles := b.settles
+	b.settles = nil
+	b.mu.Unlock()
+	for _, fn := range settles {
+		fn()
+	}
+}
+
+// add collects um, whose message is size bytes, for the batch callback,
+// which calls done. It returns false if the batch was already flushed.
+func (b *receivedBatch) add(um UpdateMessage, size int, done func(error)) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.flushed {
+		return false
+	}
+	b.updates = append(b.updates, um)
+	b.dones = append(b.dones, done)
+	b.size += size
+	return true
+}
+
+// flush returns the updates collected and their done funcs, and stops
+// collecting
+func (b *receivedBatch) flush() ([]UpdateMessage, []func(error), int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushed = true
+	updates, dones, size := b.updates, b.dones, b.size
+	b.updates, b.dones, b.size = nil, nil, 0
+	return updates, dones, size
+}
+
+// batchUpdate delivers um to callback, with the batch of msg if it is still
+// being received, or alone otherwise
+func (w *Watcher) batchUpdate(callback func([]UpdateMessage) error, msg *pubsub.Message, um UpdateMessage, done func(error)) {
+	if b, ok := w.batches.Load(msg); ok && b.(*receivedBatch).add(um, len(msg.Body), done) {
+		return
+	}
+	w.dispatchBatch(callback, []UpdateMessage{um}, []func(error){done}, len(msg.Body))
+}
+
+// dispatchBatch runs callback for updates, of size bytes in total, after the
+// batches dispatched before, and passes its result to each of dones
+func (w *Watcher) dispatchBatch(callback func([]UpdateMessage) error, updates []UpdateMessage, dones []func(error), size int) {
+	priority := PriorityNormal
+	for _, um := range updates {
+		if um.Priority > priority {
+			priority = um.Priority
+		}
+	}
+	prev, leave := w.batchLane.join()
+	w.routines.start(func() {
+		<-prev
+		w.dispatch(size, priority, func() error {
+			if callback == nil {
+				return errNotRun
+			}
+			return callback(updates)
+		}, w.recordApplied(&w.applied.callbackBatch, &w.applied.mu, func(err error) {
+			leave()
+			for _, done := range dones {
+				done(err)
+			}
+		}))
+	})
+}
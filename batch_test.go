@@ -0,0 +1,132 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReceiveBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the linger never elapses on the fake clock, so the batch fills up
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://batch", WithURLMux((&fakeBroker{}).mux()),
+		WithReceiveBatch(3, time.Second), WithClock(newFakeClock()), WithAckOnlyOnSuccess(), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	batches := make(chan []UpdateMessage, 10)
+	release := make(chan struct{})
+	w.SetUpdateBatchCallback(func(updates []UpdateMessage) error {
+		batches <- updates
+		<-release
+		return nil
+	})
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := w.UpdateForAddPolicy("p", "p", user, "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	select {
+	case updates := <-batches:
+		if len(updates) != 3 {
+			t.Fatalf("Expected the 3 updates in one batch, got %d", len(updates))
+		}
+		for i, user := range []string{"alice", "bob", "carol"} {
+			if updates[i].Params[0] != user {
+				t.Fatalf("Expected update %d for %s, got %v", i+1, user, updates[i].Params)
+			}
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Batch wasn't delivered")
+	}
+	// the messages are settled together once the batch was applied
+	select {
+	case e := <-events:
+		t.Fatalf("Message settled before the batch was applied: %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	origin := w.opts.instanceID
+	expectAcks(t, events, ackEvent{1, origin, Acked}, ackEvent{2, origin, Acked}, ackEvent{3, origin, Acked})
+	select {
+	case updates := <-batches:
+		t.Fatalf("Unexpected second batch: %+v", updates)
+	default:
+	}
+}
+
+func TestReceiveBatchLinger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://batch", WithURLMux((&fakeBroker{}).mux()),
+		WithReceiveBatch(10, 50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	batches := make(chan []UpdateMessage, 10)
+	w.SetUpdateBatchCallback(func(updates []UpdateMessage) error {
+		batches <- updates
+		return nil
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	// a partial batch is delivered once the linger elapsed
+	select {
+	case updates := <-batches:
+		if len(updates) != 1 || updates[0].Op != Update {
+			t.Fatalf("Expected a batch with the update, got %+v", updates)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Partial batch wasn't delivered")
+	}
+}
+
+func TestReceiveBatchGapResync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://batch", WithURLMux((&fakeBroker{}).mux()),
+		WithReceiveBatch(10, 20*time.Millisecond), WithGapResync())
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	sequences := make(chan uint64, 10)
+	w.SetUpdateBatchCallback(func(updates []UpdateMessage) error {
+		for _, um := range updates {
+			sequences <- um.Sequence
+		}
+		return nil
+	})
+
+	// the reload for the gap runs while its batch is being processed
+	for _, seq := range []string{"1", "3", "4"} {
+		if err := w.topic.Send(ctx, stampedMessage(w, "other", seq, "elsewhere", "1")); err != nil {
+			t.Fatalf("Failed to send message: %s", err)
+		}
+	}
+	for _, want := range []uint64{1, 3, 4} {
+		select {
+		case seq := <-sequences:
+			if seq != want {
+				t.Fatalf("Expected update %d, got %d", want, seq)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Update %d wasn't applied", want)
+		}
+	}
+	if n := w.Stats().Gaps; n != 1 {
+		t.Fatalf("Expected 1 gap, got %d", n)
+	}
+}
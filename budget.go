@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// WithMemoryBudget bounds the updates held in memory by the buffering
+// features, callbacks waiting for a WithCallbackConcurrency slot and the
+// WithDebounce pending update, to maxMessages updates and maxBytes bytes of
+// body in total. When an update would exceed the budget the oldest held
+// updates are shed: they are dropped without running their callbacks and
+// counted in Stats. Zero means no bound for either limit, the default.
+//
+// The WithUpdatesChannel buffer is bounded by its own size and isn't part of
+// the budget.
+func WithMemoryBudget(maxMessages int, maxBytes int64) Option {
+	return optionFunc(func(o *options) {
+		o.budgetMessages = maxMessages
+		o.budgetBytes = maxBytes
+	})
+}
+
+// memoryBudget is shared by every feature holding updates in memory. Entries
+// are kept in the order they were held so the oldest is shed first.
+type memoryBudget struct {
+	mu          sync.Mutex
+	maxMessages int
+	maxBytes    int64
+	bytes       int64
+	entries     list.List
+	shed        *uint64
+}
+
+type budgetEntry struct {
+	size   int64
+	elem   *list.Element
+	onShed func()
+}
+
+func newMemoryBudget(maxMessages int, maxBytes int64, shed *uint64) *memoryBudget {
+	return &memoryBudget{maxMessages: maxMessages, maxBytes: maxBytes, shed: shed}
+}
+
+func (b *memoryBudget) unbounded() bool {
+	return b.maxMessages <= 0 && b.maxBytes <= 0
+}
+
+func (b *memoryBudget) over() bool {
+	return (b.maxMessages > 0 && b.entries.Len() > b.maxMessages) ||
+		(b.maxBytes > 0 && b.bytes > b.maxBytes)
+}
+
+// hold accounts for an update of size bytes until release is called. onShed
+// is called if the update is shed first. Shedding happens in evict, which the
+// caller must call once it no longer holds locks onShed funcs may take.
+func (b *memoryBudget) hold(size int64, onShed func()) (e *budgetEntry, evict func()) {
+	if b.unbounded() {
+		return nil, func() {}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e = &budgetEntry{size: size, onShed: onShed}
+	e.elem = b.entries.PushBack(e)
+	b.bytes += size
+
+	var shed []func()
+	for b.over() {
+		oldest := b.entries.Remove(b.entries.Front()).(*budgetEntry)
+		oldest.elem = nil
+		b.bytes -= oldest.size
+		atomic.AddUint64(b.shed, 1)
+		shed = append(shed, oldest.onShed)
+	}
+	return e, func() {
+		for _, fn := range shed {
+			fn()
+		}
+	}
+}
+
+// release stops accounting for e. It returns false if e was shed, in which
+// case its onShed func owns the update.
+func (b *memoryBudget) release(e *budgetEntry) bool {
+	if e == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e.elem == nil {
+		return false
+	}
+	b.entries.Remove(e.elem)
+	e.elem = nil
+	b.bytes -= e.size
+	return true
+}
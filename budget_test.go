@@ -0,0 +1,107 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudgetShedsOldest(t *testing.T) {
+	var shedCount uint64
+	b := newMemoryBudget(0, 10, &shedCount)
+
+	var shed []string
+	hold := func(name string, size int64) *budgetEntry {
+		e, evict := b.hold(size, func() { shed = append(shed, name) })
+		evict()
+		return e
+	}
+
+	first := hold("first", 4)
+	second := hold("second", 4)
+	if len(shed) != 0 {
+		t.Fatalf("Nothing should be shed within the budget, shed %v", shed)
+	}
+	third := hold("third", 4)
+	if len(shed) != 1 || shed[0] != "first" || shedCount != 1 {
+		t.Fatalf("Expected the oldest entry to be shed, shed %v, counted %d", shed, shedCount)
+	}
+	if b.release(first) {
+		t.Fatal("Releasing a shed entry must report it was shed")
+	}
+	if !b.release(second) || !b.release(third) {
+		t.Fatal("Releasing held entries must succeed")
+	}
+	if b.bytes != 0 || b.entries.Len() != 0 {
+		t.Fatalf("Budget not empty after releasing everything: %d bytes, %d entries", b.bytes, b.entries.Len())
+	}
+
+	// an update larger than the whole budget can't be held at all
+	big := hold("big", 11)
+	if b.release(big) || shedCount != 2 {
+		t.Fatalf("Expected the oversized entry to be shed, counted %d", shedCount)
+	}
+}
+
+func TestMemoryBudgetWaitingCallbacks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://budget", WithURLMux(broker.mux()),
+		WithCallbackConcurrency(1), WithMemoryBudget(2, 0))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	unblock := make(chan struct{})
+	applied := make(chan uint64, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		if um.Sequence == 1 {
+			<-unblock
+		}
+		applied <- um.Sequence
+		return nil
+	})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	// wait until the first callback occupies the only slot
+	waitFor(t, time.Second*5, func() bool {
+		w.limiter.mu.Lock()
+		defer w.limiter.mu.Unlock()
+		return w.limiter.active == 1
+	})
+
+	for i := 0; i < 4; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return w.Stats().Shed == 2 })
+	close(unblock)
+
+	var got []uint64
+	for len(got) < 3 {
+		select {
+		case seq := <-applied:
+			got = append(got, seq)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Expected 3 applied updates, got %v", got)
+		}
+	}
+	if got[0] != 1 || got[1]+got[2] != 4+5 {
+		t.Fatalf("Expected the 2 oldest waiting updates to be shed, applied %v", got)
+	}
+
+	select {
+	case seq := <-applied:
+		t.Fatalf("Shed update %d was applied", seq)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if shed := w.Stats().Shed; shed != 2 {
+		t.Fatalf("Expected 2 shed updates, got %d", shed)
+	}
+}
@@ -0,0 +1,33 @@
+package watcher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WithMaxBufferedUpdateAge discards the updates buffered by WithAsyncSend
+// or WithSpillFile for longer than maxAge instead of publishing them, e.g.
+// so the peers aren't flooded with obsolete updates once an outage of hours
+// is over. The age counts from the Update call. Discarded updates are logged
+// and counted in Stats().Expired. Zero, the default, publishes every update.
+func WithMaxBufferedUpdateAge(maxAge time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.maxBufferedAge = maxAge
+	})
+}
+
+// expireBuffered reports whether the update with the given metadata,
+// buffered since queued, is too old to be published, and counts it if it is.
+// Updates with an unknown age, spilled by earlier versions, are never too old.
+func (w *Watcher) expireBuffered(metadata map[string]string, queued time.Time) bool {
+	if w.opts.maxBufferedAge <= 0 || queued.IsZero() {
+		return false
+	}
+	age := w.opts.clock.Now().Sub(queued)
+	if age <= w.opts.maxBufferedAge {
+		return false
+	}
+	atomic.AddUint64(&w.stats.expired, 1)
+	w.log(LevelWarn, "Discarding buffered update", "sequence", metadata[w.metadataKey(metaSequence)], "age", age)
+	return true
+}
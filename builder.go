@@ -0,0 +1,104 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrInvalidConfig is returned by Builder.Build when a required setting is
+// missing or a setting is invalid
+var ErrInvalidConfig = errors.New("invalid watcher configuration")
+
+// Builder assembles the configuration of a watcher by method chaining, as an
+// alternative to passing options to NewWithOptions:
+//
+//	w, err := NewBuilder().
+//		TopicURL("nats://casbin-policy-updates").
+//		WithInstanceID("node-1").
+//		With(WithAckOnlyOnSuccess()).
+//		Build(ctx)
+//
+// The methods set the options of the same name; With adds any other option.
+type Builder struct {
+	topicURL string
+	opts     []Option
+	err      error
+}
+
+// NewBuilder returns an empty Builder. TopicURL is required.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// TopicURL sets the URL of the topic updates are published to, and
+// subscribed to unless SubscriptionURL is set.
+func (b *Builder) TopicURL(u string) *Builder {
+	b.topicURL = u
+	return b
+}
+
+// SubscriptionURL sets the URL of the subscription, see WithSubscriptionURL.
+func (b *Builder) SubscriptionURL(u string) *Builder {
+	if b.err == nil && u != "" {
+		b.err = checkURL("subscription", u)
+	}
+	return b.With(WithSubscriptionURL(u))
+}
+
+// WithInstanceID sets the instance ID, see the WithInstanceID option.
+func (b *Builder) WithInstanceID(id string) *Builder {
+	if b.err == nil && id == "" {
+		b.err = fmt.Errorf("%w: empty instance ID", ErrInvalidConfig)
+	}
+	return b.With(WithInstanceID(id))
+}
+
+// WithMetadataPrefix sets the metadata prefix, see the WithMetadataPrefix
+// option.
+func (b *Builder) WithMetadataPrefix(prefix string) *Builder {
+	return b.With(WithMetadataPrefix(prefix))
+}
+
+// WithLogger sets the logger, see the WithLogger option.
+func (b *Builder) WithLogger(logger Logger) *Builder {
+	if b.err == nil && logger == nil {
+		b.err = fmt.Errorf("%w: nil logger", ErrInvalidConfig)
+	}
+	return b.With(WithLogger(logger))
+}
+
+// With adds opts, applied in order after those set before.
+func (b *Builder) With(opts ...Option) *Builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build validates the configuration and creates the watcher, like
+// NewWithOptions. It fails with an error wrapping ErrInvalidConfig when the
+// topic URL is missing or a setting is invalid.
+func (b *Builder) Build(ctx context.Context) (*Watcher, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.topicURL == "" {
+		return nil, fmt.Errorf("%w: no topic URL", ErrInvalidConfig)
+	}
+	if err := checkURL("topic", b.topicURL); err != nil {
+		return nil, err
+	}
+	return NewWithOptions(ctx, b.topicURL, b.opts...)
+}
+
+// checkURL makes sure u is a URL with a scheme, which selects the provider
+func checkURL(kind, u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("%w: %s URL %q, error: %s", ErrInvalidConfig, kind, u, err)
+	}
+	if parsed.Scheme == "" {
+		return fmt.Errorf("%w: %s URL %q has no scheme", ErrInvalidConfig, kind, u)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBuilderValidates(t *testing.T) {
+	ctx := context.Background()
+	mux := (&fakeBroker{}).mux()
+
+	for name, b := range map[string]*Builder{
+		"no topic":           NewBuilder().With(WithURLMux(mux)),
+		"no scheme":          NewBuilder().TopicURL("policy-updates").With(WithURLMux(mux)),
+		"invalid sub URL":    NewBuilder().TopicURL("fake://policy").SubscriptionURL("%zz").With(WithURLMux(mux)),
+		"empty instance ID":  NewBuilder().TopicURL("fake://policy").WithInstanceID("").With(WithURLMux(mux)),
+		"nil logger":         NewBuilder().TopicURL("fake://policy").WithLogger(nil).With(WithURLMux(mux)),
+		"sub URL w/o scheme": NewBuilder().TopicURL("fake://policy").SubscriptionURL("policy").With(WithURLMux(mux)),
+	} {
+		w, err := b.Build(ctx)
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("%s: expected ErrInvalidConfig, got: %v", name, err)
+		}
+		if w != nil {
+			t.Fatalf("%s: Build returned a watcher", name)
+		}
+	}
+}
+
+func TestBuilderMatchesOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mux := (&fakeBroker{}).mux()
+	logger := NewJSONLogger(&syncBuffer{})
+	built, err := NewBuilder().
+		TopicURL("fake://topic").
+		SubscriptionURL("fake://subscription").
+		WithInstanceID("node-1").
+		WithMetadataPrefix("myapp-").
+		WithLogger(logger).
+		With(WithURLMux(mux), WithAckOnlyOnSuccess()).
+		Build(ctx)
+	if err != nil {
+		t.Fatalf("Failed to build watcher, error: %s", err)
+	}
+	defer built.Close()
+	direct, err := NewWithOptions(ctx, "fake://topic", WithSubscriptionURL("fake://subscription"),
+		WithInstanceID("node-1"), WithMetadataPrefix("myapp-"), WithLogger(logger),
+		WithURLMux(mux), WithAckOnlyOnSuccess())
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer direct.Close()
+
+	if built.topicURL != direct.topicURL || built.subURL != direct.subURL {
+		t.Fatalf("Expected URLs %s and %s, got %s and %s", direct.topicURL, direct.subURL, built.topicURL, built.subURL)
+	}
+	b, d := built.opts, direct.opts
+	if b.instanceID != d.instanceID || b.metadataPrefix != d.metadataPrefix || b.logger != d.logger ||
+		b.urlMux != d.urlMux || b.ackOnlyOnSuccess != d.ackOnlyOnSuccess {
+		t.Fatalf("Built options differ: %+v", b)
+	}
+}
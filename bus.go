@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"strconv"
+	"sync"
+
+	"gocloud.dev/pubsub"
+)
+
+// localBusID is the LoggableID of the messages received from the local bus
+const localBusID = "local bus"
+
+// LocalBus connects the watchers of one process, e.g. a monolith with
+// several enforcers, so they get each other's updates without a round trip
+// to the broker, see WithLocalBus
+type LocalBus struct {
+	mu       sync.RWMutex
+	watchers map[*Watcher]struct{}
+}
+
+// NewLocalBus creates an empty LocalBus
+func NewLocalBus() *LocalBus {
+	return &LocalBus{watchers: map[*Watcher]struct{}{}}
+}
+
+// WithLocalBus joins the watcher to b. The updates it sends are handed to the
+// other watchers on b before being published, and these ignore the copy
+// that comes back from the broker; remote watchers get it as usual.
+func WithLocalBus(b *LocalBus) Option {
+	return optionFunc(func(o *options) {
+		o.localBus = b
+	})
+}
+
+func (b *LocalBus) join(w *Watcher) {
+	b.mu.Lock()
+	b.watchers[w] = struct{}{}
+	b.mu.Unlock()
+}
+
+func (b *LocalBus) leave(w *Watcher) {
+	b.mu.Lock()
+	delete(b.watchers, w)
+	b.mu.Unlock()
+}
+
+// peers returns the watchers on b other than w
+func (b *LocalBus) peers(w *Watcher) []*Watcher {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	peers := make([]*Watcher, 0, len(b.watchers))
+	for peer := range b.watchers {
+		if peer != w {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// publishLocally hands a copy of m to the other watchers on the local bus
+func (w *Watcher) publishLocally(m *pubsub.Message) {
+	if w.opts.localBus == nil {
+		return
+	}
+	for _, peer := range w.opts.localBus.peers(w) {
+		peer.receiveLocally(m)
+	}
+}
+
+// receiveLocally handles an update from the local bus like one from the
+// subscription, and remembers it so its echo from the broker is ignored
+func (w *Watcher) receiveLocally(m *pubsub.Message) {
+	msg := &pubsub.Message{LoggableID: localBusID, Body: m.Body, Metadata: make(map[string]string, len(m.Metadata))}
+	for k, v := range m.Metadata {
+		msg.Metadata[k] = v
+	}
+	w.handleMessage(msg, func(outcome AckOutcome) {
+		w.reportAck(msg, outcome)
+		w.recordMessage(msg, outcome)
+	})
+	if seq, err := strconv.ParseUint(msg.Metadata[w.metadataKey(metaSequence)], 10, 64); err == nil {
+		w.busApplied.add(msg.Metadata[w.metadataKey(metaOrigin)], seq)
+	}
+}
+
+// peerApplied remembers, by origin, the updates received from the local bus
+type peerApplied struct {
+	mu      sync.Mutex
+	origins map[string]*localApplied
+}
+
+func (p *peerApplied) add(origin string, seq uint64) {
+	p.mu.Lock()
+	if p.origins == nil {
+		p.origins = map[string]*localApplied{}
+	}
+	applied := p.origins[origin]
+	if applied == nil {
+		applied = &localApplied{}
+		p.origins[origin] = applied
+	}
+	p.mu.Unlock()
+	applied.add(seq)
+}
+
+// take reports whether the update from origin was added, and forgets it
+func (p *peerApplied) take(origin string, seq uint64) bool {
+	p.mu.Lock()
+	applied := p.origins[origin]
+	p.mu.Unlock()
+	return applied != nil && applied.take(seq)
+}
@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestLocalBus(t *testing.T) {
+	ctx := context.Background()
+
+	// the broker holds the update until released, so whatever arrives
+	// before it did so came through the bus
+	release := make(chan struct{})
+	broker := &fakeBroker{
+		sendErr: func(context.Context, []*driver.Message) error {
+			<-release
+			return nil
+		},
+	}
+	bus := NewLocalBus()
+	sender, err := NewWithOptions(ctx, "fake://bus", WithURLMux(broker.mux()), WithLocalBus(bus))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer sender.Close()
+	events := make(chan ackEvent, 4)
+	local, err := NewWithOptions(ctx, "fake://bus", WithURLMux(broker.mux()), WithLocalBus(bus), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create local watcher, error: %s", err)
+	}
+	defer local.Close()
+	remote, err := NewWithOptions(ctx, "fake://bus", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create remote watcher, error: %s", err)
+	}
+	defer remote.Close()
+
+	var localCalls, remoteCalls int32
+	local.SetUpdateCallback(func(string) { atomic.AddInt32(&localCalls, 1) })
+	remote.SetUpdateCallback(func(string) { atomic.AddInt32(&remoteCalls, 1) })
+
+	sent := make(chan error, 1)
+	go func() { sent <- sender.Update() }()
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&localCalls) == 1 })
+	if n := atomic.LoadInt32(&remoteCalls); n != 0 {
+		t.Fatalf("Remote watcher got the update before it was published, %d calls", n)
+	}
+
+	close(release)
+	if err := <-sent; err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&remoteCalls) == 1 })
+
+	// the local watcher ignores the copy coming back from the broker
+	expectAcks(t, events,
+		ackEvent{1, sender.opts.instanceID, Acked},
+		ackEvent{1, sender.opts.instanceID, Dropped})
+	if n := atomic.LoadInt32(&localCalls); n != 1 {
+		t.Fatalf("Expected the local watcher to apply the update once, got %d calls", n)
+	}
+
+	// a closed watcher leaves the bus
+	local.Close()
+	if peers := bus.peers(sender); len(peers) != 0 {
+		t.Fatalf("Closed watcher still on the bus: %d peers", len(peers))
+	}
+}
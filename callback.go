@@ -0,0 +1,322 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrCallbackPanic is matched by the error a panicking callback is
+	// converted to by default, see WithPanicHandler
+	ErrCallbackPanic = errors.New("callback panicked")
+
+	// errNotRun completes callbacks that never ran: shed, or dropped on Close
+	errNotRun = errors.New("callback not run")
+)
+
+// limiter bounds the number of callbacks running at the same time. The
+// bound is read on every acquire so Reconfigure takes effect immediately.
+// Callbacks waiting for a slot are queued by priority.
+type limiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	waiting  waitQueue
+	arrivals uint64
+}
+
+// acquire waits until sw is at the top of the queue and a slot is free. It
+// gives up and returns false once abandoned reports true, which must be set
+// while holding l.mu.
+func (l *limiter) acquire(sw *slotWaiter, limit func() int, abandoned func() bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for n := limit(); n > 0 && (l.active >= n || l.waiting[0] != sw); n = limit() {
+		if abandoned() {
+			l.leaveQueueLocked(sw)
+			return false
+		}
+		l.cond.Wait()
+	}
+	l.leaveQueueLocked(sw)
+	l.active++
+	return true
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// dispatch runs fn in its own goroutine within the callback concurrency
+// limit, ahead of the waiting callbacks of lower priority, and passes its
+// result, or the error a panic was converted to, to done. While it waits for
+// a slot the update of size bytes counts towards the memory budget, and if
+// it is shed done gets errNotRun instead.
+func (w *Watcher) dispatch(size, priority int, fn func() error, done func(error)) {
+	abandoned := false
+	sw := w.limiter.enqueue(priority)
+	entry, evict := w.budget.hold(int64(size), func() {
+		w.limiter.mu.Lock()
+		abandoned = true
+		w.limiter.mu.Unlock()
+		w.limiter.cond.Broadcast()
+	})
+	w.routines.start(func() {
+		acquired := w.limiter.acquire(sw,
+			func() int { return w.currentTunables().callbackConcurrency },
+			func() bool { return abandoned },
+		)
+		if !w.budget.release(entry) {
+			if acquired {
+				w.limiter.release()
+			}
+			w.log(LevelWarn, "Memory budget exceeded, dropping update waiting for a callback slot")
+			done(errNotRun)
+			return
+		}
+		defer w.limiter.release()
+		err := w.call(func() error {
+			start := w.opts.clock.Now()
+			defer func() { w.recordApplyLatency(w.opts.clock.Now().Sub(start)) }()
+			return fn()
+		})
+		done(err)
+	})
+	evict()
+}
+
+func (w *Watcher) recordApplyLatency(d time.Duration) {
+	w.applyLatency.record(d)
+	w.observe(MetricApplyLatency, d)
+}
+
+// call runs fn under the WithApplyLock lock, converting a panic into an
+// error with the panic handler
+func (w *Watcher) call(fn func() error) (err error) {
+	if l := w.opts.applyLock; l != nil {
+		l.Lock()
+		defer l.Unlock()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = w.opts.panicHandler(r)
+		}
+	}()
+	return fn()
+}
+
+// defaultPanicHandler wraps the recovered value in an error matching
+// ErrCallbackPanic
+func defaultPanicHandler(recovered interface{}) error {
+	return fmt.Errorf("%w: %v", ErrCallbackPanic, recovered)
+}
+
+// WithPanicHandler sets how a value recovered from a panicking callback is
+// turned into the error handled like one returned by the callback. By
+// default it is an error matching ErrCallbackPanic. Returning nil treats the
+// callback as successful.
+func WithPanicHandler(handler func(recovered interface{}) error) Option {
+	return optionFunc(func(o *options) {
+		o.panicHandler = handler
+	})
+}
+
+// WithCallbackTimeout bounds the context given to each call of the
+// SetUpdateCallbackCtx callback to d. Zero, the default, leaves it bounded
+// by the watcher's lifetime only.
+func WithCallbackTimeout(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.callbackTimeout = d
+	})
+}
+
+// SetUpdateCallbackCtx is like SetUpdateCallback, but each call gets a
+// context cancelled when the watcher is closed, or its context is done,
+// and once the WithCallbackTimeout timeout expired, so a long reload can be
+// aborted cleanly.
+func (w *Watcher) SetUpdateCallbackCtx(callbackFunc func(context.Context, string)) error {
+	if callbackFunc == nil {
+		return w.SetUpdateCallback(nil)
+	}
+	return w.SetUpdateCallback(func(body string) {
+		ctx, cancel := w.callbackContext()
+		defer cancel()
+		callbackFunc(ctx, body)
+	})
+}
+
+// callbackContext returns the context of a SetUpdateCallbackCtx call
+func (w *Watcher) callbackContext() (context.Context, context.CancelFunc) {
+	w.connMu.RLock()
+	lifecycle := w.lifecycle()
+	w.connMu.RUnlock()
+	if w.opts.callbackTimeout > 0 {
+		return context.WithTimeout(lifecycle, w.opts.callbackTimeout)
+	}
+	return context.WithCancel(lifecycle)
+}
+
+// WithApplyLock runs every callback while holding l, so callbacks touching
+// the same enforcer never run at the same time, whatever the
+// WithCallbackConcurrency limit. The watchers sharing an enforcer can share
+// l; with nil the watcher uses a mutex of its own.
+func WithApplyLock(l sync.Locker) Option {
+	return optionFunc(func(o *options) {
+		if l == nil {
+			l = &sync.Mutex{}
+		}
+		o.applyLock = l
+	})
+}
+
+// CallbackError is sent on the Errors channel when a callback fails or panics
+type CallbackError struct {
+	// Update is the update the callback was called for. Its Origin,
+	// Sequence and Op come from the message metadata when the body couldn't
+	// be decoded, e.g. for a legacy callback.
+	Update UpdateMessage
+	Err    error
+}
+
+func (e *CallbackError) Error() string {
+	return fmt.Sprintf("callback failed for %s update %d from %q, error: %s", e.Update.Op, e.Update.Sequence, e.Update.Origin, e.Err)
+}
+
+func (e *CallbackError) Unwrap() error { return e.Err }
+
+// WithErrorChannel enables the channel returned by Errors, buffered with
+// size slots. Errors are dropped while it is full.
+func WithErrorChannel(size int) Option {
+	return optionFunc(func(o *options) {
+		if size < 1 {
+			size = 1
+		}
+		o.errorsBuffer = size
+	})
+}
+
+// Errors returns the channel receiving callback failures as *CallbackError
+// and undecodable updates when the watcher was created with
+// WithErrorChannel, or nil otherwise. The channel is closed by Close.
+func (w *Watcher) Errors() <-chan error {
+	return w.errs
+}
+
+func (w *Watcher) reportCallbackError(um UpdateMessage, err error) {
+	atomic.AddUint64(&w.stats.callbackErrors, 1)
+	w.log(LevelError, "Update callback failed", "error", err, "op", um.Op, "origin", um.Origin, "sequence", um.Sequence,
+		LogFieldCorrelation, um.CorrelationID)
+	w.pushError(&CallbackError{Update: um, Err: err})
+}
+
+func (w *Watcher) pushError(err error) {
+	if w.errs == nil {
+		return
+	}
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
+
+// debouncer collapses bursts of bodies into a single trailing call. The done
+// funcs of every collapsed trigger are called once that call completes, or
+// with errNotRun if the watcher is closed first.
+// The pending body counts towards the memory budget; if it is shed the
+// collapsed triggers complete with errNotRun.
+type debouncer struct {
+	clock    Clock
+	budget   *memoryBudget
+	routines *goroutineTracker
+	mu       sync.Mutex
+	timer    Timer
+	// gen identifies the current timer so a replaced one that fires anyway
+	// does nothing
+	gen     uint64
+	pending string
+	entry   *budgetEntry
+	done    []func(error)
+}
+
+func (d *debouncer) trigger(window time.Duration, body string, fire func(string, func(error)), done func(error)) {
+	d.mu.Lock()
+	d.budget.release(d.entry)
+	var entry *budgetEntry
+	entry, evict := d.budget.hold(int64(len(body)), func() { d.shed(entry) })
+	d.pending, d.entry = body, entry
+	d.done = append(d.done, done)
+	if d.timer != nil {
+		d.timer.Stop()
+	} else {
+		d.routines.add(1)
+	}
+	d.gen++
+	gen := d.gen
+	d.timer = d.clock.AfterFunc(window, func() {
+		d.mu.Lock()
+		if d.gen != gen || d.timer == nil {
+			d.mu.Unlock()
+			return
+		}
+		d.budget.release(d.entry)
+		body, done := d.pending, d.done
+		d.timer, d.entry, d.done = nil, nil, nil
+		d.routines.add(-1)
+		d.mu.Unlock()
+		fire(body, func(err error) {
+			for _, fn := range done {
+				fn(err)
+			}
+		})
+	})
+	d.mu.Unlock()
+	evict()
+}
+
+// shed drops the pending body if entry still holds it
+func (d *debouncer) shed(entry *budgetEntry) {
+	d.mu.Lock()
+	if d.entry != entry {
+		d.mu.Unlock()
+		return
+	}
+	done := d.cancelLocked()
+	d.mu.Unlock()
+	for _, fn := range done {
+		fn(errNotRun)
+	}
+}
+
+func (d *debouncer) stop() {
+	d.mu.Lock()
+	done := d.cancelLocked()
+	d.mu.Unlock()
+	for _, fn := range done {
+		fn(errNotRun)
+	}
+}
+
+// cancelLocked drops the pending body and returns the done funcs to call
+func (d *debouncer) cancelLocked() []func(error) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+		d.routines.add(-1)
+	}
+	d.budget.release(d.entry)
+	done := d.done
+	d.entry, d.done = nil, nil
+	return done
+}
@@ -0,0 +1,244 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestCallbackFailuresAreHandledUniformly(t *testing.T) {
+	errApply := errors.New("apply failed")
+
+	for _, tc := range []struct {
+		name     string
+		callback func(UpdateMessage) error
+		want     error
+	}{
+		{"returned error", func(UpdateMessage) error { return errApply }, errApply},
+		{"panic", func(UpdateMessage) error { panic("nil model") }, ErrCallbackPanic},
+		{"success", func(UpdateMessage) error { return nil }, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			broker := &fakeBroker{}
+			w, err := NewWithOptions(ctx, "fake://callbacks", WithURLMux(broker.mux()),
+				WithAckOnlyOnSuccess(), WithErrorChannel(4), WithLogger(NewJSONLogger(&syncBuffer{})))
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+			w.SetUpdateCallbackEx(tc.callback)
+
+			if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			sub := broker.subscriptions()[0]
+			var acked, settled bool
+			waitFor(t, time.Second*5, func() bool {
+				sub.mu.Lock()
+				defer sub.mu.Unlock()
+				acked, settled = sub.acks[driver.AckID(1)]
+				return settled
+			})
+
+			if tc.want == nil {
+				if !acked {
+					t.Fatal("Successfully applied update was nacked")
+				}
+				select {
+				case err := <-w.Errors():
+					t.Fatalf("Unexpected error reported: %s", err)
+				default:
+				}
+				return
+			}
+
+			if acked {
+				t.Fatal("Failed update was acked")
+			}
+			select {
+			case err := <-w.Errors():
+				var cbErr *CallbackError
+				if !errors.As(err, &cbErr) || !errors.Is(err, tc.want) {
+					t.Fatalf("Expected a CallbackError matching %v, got: %v", tc.want, err)
+				}
+				if cbErr.Update.Op != UpdateForAddPolicy || cbErr.Update.Sequence != 1 || cbErr.Update.Origin != w.opts.instanceID {
+					t.Fatalf("Unexpected update in the error: %+v", cbErr.Update)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("No error reported in time")
+			}
+			if n := w.Stats().CallbackErrors; n != 1 {
+				t.Fatalf("Expected 1 callback error counted, got %d", n)
+			}
+		})
+	}
+}
+
+func TestLegacyCallbackPanic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recovered := make(chan interface{}, 1)
+	w, err := NewWithOptions(ctx, "fake://callbacks", WithURLMux((&fakeBroker{}).mux()),
+		WithLogger(NewJSONLogger(&syncBuffer{})),
+		WithPanicHandler(func(r interface{}) error {
+			recovered <- r
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {
+		panic("boom")
+	})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case r := <-recovered:
+		if r != "boom" {
+			t.Fatalf("Unexpected recovered value: %v", r)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Panic handler wasn't called in time")
+	}
+	// a nil error from the handler means the panic is ignored
+	time.Sleep(time.Millisecond * 50)
+	if n := w.Stats().CallbackErrors; n != 0 {
+		t.Fatalf("Expected the ignored panic not to be counted, got %d", n)
+	}
+}
+
+func TestLegacyCallbackErrorContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	topic, err := broker.mux().OpenTopic(ctx, "fake://callbacks")
+	if err != nil {
+		t.Fatalf("Failed to open topic, error: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+	w, err := NewWithOptions(ctx, "fake://callbacks", WithURLMux(broker.mux()),
+		WithErrorChannel(4), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {
+		panic("boom")
+	})
+
+	// a legacy publisher's body can't be decoded, the context comes from
+	// the metadata
+	err = topic.Send(ctx, &pubsub.Message{Body: []byte("reload please"), Metadata: map[string]string{
+		DefaultMetadataPrefix + metaOrigin:   "peer",
+		DefaultMetadataPrefix + metaSequence: "7",
+		DefaultMetadataPrefix + metaOp:       string(Update),
+	}})
+	if err != nil {
+		t.Fatalf("Failed to send message, error: %s", err)
+	}
+	select {
+	case err := <-w.Errors():
+		var cbErr *CallbackError
+		if !errors.As(err, &cbErr) || !errors.Is(err, ErrCallbackPanic) {
+			t.Fatalf("Expected a CallbackError matching %v, got: %v", ErrCallbackPanic, err)
+		}
+		if cbErr.Update.Op != Update || cbErr.Update.Sequence != 7 || cbErr.Update.Origin != "peer" {
+			t.Fatalf("Unexpected update in the error: %+v", cbErr.Update)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("No error reported in time")
+	}
+}
+
+func TestCallbackContext(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		want error
+	}{
+		{"cancelled by Close", nil, context.Canceled},
+		{"timed out", []Option{WithCallbackTimeout(time.Millisecond * 50)}, context.DeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			opts := append([]Option{WithURLMux((&fakeBroker{}).mux())}, tc.opts...)
+			w, err := NewWithOptions(ctx, "fake://callback", opts...)
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+
+			started := make(chan struct{})
+			ended := make(chan error, 1)
+			w.SetUpdateCallbackCtx(func(ctx context.Context, body string) {
+				close(started)
+				<-ctx.Done()
+				ended <- ctx.Err()
+			})
+			if err := w.Update(); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			select {
+			case <-started:
+			case <-time.After(time.Second * 5):
+				t.Fatal("The callback wasn't called")
+			}
+			if tc.want == context.Canceled {
+				w.Close()
+			}
+			select {
+			case err := <-ended:
+				if err != tc.want {
+					t.Fatalf("Expected the callback context to end with %v, got %v", tc.want, err)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("The callback context wasn't cancelled")
+			}
+		})
+	}
+}
+
+func TestDebounceClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	w, err := NewWithOptions(ctx, "fake://debounce-clock", WithURLMux((&fakeBroker{}).mux()),
+		WithClock(clock), WithDebounce(time.Minute), WithRecentMessages(3))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls int32
+	w.SetUpdateCallback(func(string) { atomic.AddInt32(&calls, 1) })
+
+	for i := 0; i < 3; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return len(w.Diagnostics().RecentMessages) == 3 })
+	time.Sleep(time.Millisecond * 100)
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Fatalf("Expected no reload before the window elapsed, got %d", got)
+	}
+
+	clock.advance(time.Minute)
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 1 })
+}
@@ -0,0 +1,97 @@
+package watcher
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/casbin/casbin/model"
+	"gocloud.dev/pubsub"
+)
+
+// WithSnapshotSource makes the watcher answer the snapshot requests of
+// peers catching up, see WithSnapshotOnReconnect, with an
+// UpdateForSavePolicy carrying the snapshot of model(), e.g. the model of
+// the local enforcer, addressed to the requester only.
+func WithSnapshotSource(model func() model.Model) Option {
+	return optionFunc(func(o *options) {
+		o.snapshotSource = model
+	})
+}
+
+// WithSnapshotOnReconnect makes the watcher ask its peers for a snapshot of
+// the policy once it reconnected after its subscription failed, to catch up
+// on the updates missed meanwhile in one shot rather than at the next
+// change. Peers with WithSnapshotSource answer with an UpdateForSavePolicy
+// carrying UpdateMessage.Snapshot, delivered to the callbacks like any
+// other. Only the first answer to a request is applied, the others are
+// dropped.
+func WithSnapshotOnReconnect() Option {
+	return optionFunc(func(o *options) {
+		o.snapshotOnReconnect = true
+	})
+}
+
+// catchup tracks the snapshot request awaiting an answer
+type catchup struct {
+	mu      sync.Mutex
+	pending string
+}
+
+// requestSnapshot asks the peers for a snapshot in the background
+func (w *Watcher) requestSnapshot() {
+	id := newInstanceID()
+	w.catchup.mu.Lock()
+	w.catchup.pending = id
+	w.catchup.mu.Unlock()
+	w.routines.start(func() {
+		m := w.newControlMessage(kindSnapshotRequest, map[string]string{metaSnapshotFor: id})
+		if err := w.send(w.lifecycle(), m); err != nil {
+			w.log(LevelWarn, "Failed to request a snapshot", "error", err)
+			w.pushError(fmt.Errorf("failed to request a snapshot, error: %w", err))
+			return
+		}
+		w.log(LevelInfo, "Requested a snapshot to catch up", "request", id)
+	})
+}
+
+// answerSnapshotRequest sends the snapshot of WithSnapshotSource to the
+// watcher that requested it with msg, in the background
+func (w *Watcher) answerSnapshotRequest(msg *pubsub.Message) {
+	if w.opts.snapshotSource == nil || w.isOwnMessage(msg) {
+		return
+	}
+	requester := msg.Metadata[w.metadataKey(metaOrigin)]
+	id := msg.Metadata[w.metadataKey(metaSnapshotFor)]
+	w.routines.start(func() {
+		um := UpdateMessage{Op: UpdateForSavePolicy, Priority: PriorityHigh,
+			Snapshot: NewPolicySnapshot(w.opts.snapshotSource())}
+		body, err := w.codec.Marshal(um)
+		if err != nil {
+			w.log(LevelError, "Failed to encode snapshot", "error", err)
+			return
+		}
+		m := w.newMessage(body, um.Op)
+		m.Metadata[w.metadataKey(metaTarget)] = requester
+		m.Metadata[w.metadataKey(metaSnapshotFor)] = id
+		if err := w.broadcast(w.lifecycle(), m); err != nil {
+			w.log(LevelWarn, "Failed to answer snapshot request", "error", err, "requester", requester)
+		}
+	})
+}
+
+// isStaleSnapshot reports whether msg answers a snapshot request that was
+// already answered, or that this watcher didn't make. The first answer
+// clears the pending request.
+func (w *Watcher) isStaleSnapshot(msg *pubsub.Message) bool {
+	id, ok := msg.Metadata[w.metadataKey(metaSnapshotFor)]
+	if !ok {
+		return false
+	}
+	w.catchup.mu.Lock()
+	defer w.catchup.mu.Unlock()
+	if id == "" || id != w.catchup.pending {
+		return true
+	}
+	w.catchup.pending = ""
+	return false
+}
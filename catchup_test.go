@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin"
+	"github.com/casbin/casbin/model"
+)
+
+func TestSnapshotOnReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	source := casbin.NewEnforcer("./test_data/model.conf", "./test_data/policy.csv")
+	peer, err := NewWithOptions(ctx, "fake://catchup", WithURLMux(broker.mux()),
+		WithSnapshotSource(func() model.Model { return source.GetModel() }))
+	if err != nil {
+		t.Fatalf("Failed to create peer, error: %s", err)
+	}
+	defer peer.Close()
+
+	w, err := NewWithOptions(ctx, "fake://catchup", WithURLMux(broker.mux()), WithSnapshotOnReconnect(),
+		WithBackoff(ConstantBackoff(time.Millisecond)))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	// the enforcer missed the policy while disconnected
+	e := casbin.NewEnforcer("./test_data/model.conf")
+	applied := make(chan UpdateMessage, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		if um.Snapshot != nil {
+			if err := um.Snapshot.Apply(e.GetModel()); err != nil {
+				return err
+			}
+			e.BuildRoleLinks()
+		}
+		applied <- um
+		return nil
+	})
+
+	broker.subscriptions()[1].fail(errors.New("connection reset"))
+	select {
+	case um := <-applied:
+		if um.Op != UpdateForSavePolicy || um.Origin != peer.opts.instanceID {
+			t.Fatalf("Expected the snapshot of the peer, got %s from %s", um.Op, um.Origin)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("No snapshot received after reconnecting")
+	}
+	if !e.Enforce("alice", "data2", "read") {
+		t.Fatal("Expected the snapshot to be applied")
+	}
+
+	// an answer to a request already answered is dropped
+	peer.answerSnapshotRequest(w.newControlMessage(kindSnapshotRequest, map[string]string{metaSnapshotFor: "stale"}))
+	select {
+	case um := <-applied:
+		t.Fatalf("Unexpected update applied: %s", um.Op)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
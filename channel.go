@@ -0,0 +1,78 @@
+package watcher
+
+// Backpressure decides what happens to an update when the Updates channel is
+// full
+type Backpressure int
+
+// Backpressure policies
+const (
+	// BackpressureBlock stops receiving until the consumer makes room.
+	BackpressureBlock Backpressure = iota
+	// BackpressureDrop acknowledges and discards the update.
+	BackpressureDrop
+	// BackpressureNack asks the provider to redeliver the update later.
+	// Providers that can't nack fall back to BackpressureBlock.
+	BackpressureNack
+)
+
+// WithUpdatesChannel enables delivery of decoded updates on the channel
+// returned by Updates, buffered with size slots, in addition to any
+// callbacks. policy applies when the buffer is full.
+func WithUpdatesChannel(size int, policy Backpressure) Option {
+	return optionFunc(func(o *options) {
+		if size < 1 {
+			size = 1
+		}
+		o.updatesBuffer = size
+		o.backpressure = policy
+	})
+}
+
+// Updates returns the channel on which decoded updates are delivered when the
+// watcher was created with WithUpdatesChannel, or nil otherwise. The channel
+// is closed by Close.
+func (w *Watcher) Updates() <-chan UpdateMessage {
+	return w.updates
+}
+
+// deliverToChannel puts um on the updates channel. It returns Dropped or
+// Nacked when the channel is full and the policy says so; a nacked message
+// must not be processed further.
+func (w *Watcher) deliverToChannel(um UpdateMessage, nackable bool) AckOutcome {
+	if w.updates == nil {
+		return Acked
+	}
+
+	w.connMu.RLock()
+	if w.closed {
+		w.connMu.RUnlock()
+		return Acked
+	}
+	// Close only closes the channel once the deliveries in progress
+	// returned, so a full channel is waited on without holding the lock,
+	// which would block the writers and every reader queued behind them
+	w.delivering.Add(1)
+	w.connMu.RUnlock()
+	defer w.delivering.Done()
+
+	select {
+	case w.updates <- um:
+		return Acked
+	default:
+	}
+
+	switch {
+	case w.opts.backpressure == BackpressureDrop:
+		w.log(LevelWarn, "Updates channel full, dropping update", "op", um.Op, "origin", um.Origin, "sequence", um.Sequence)
+		return Dropped
+	case w.opts.backpressure == BackpressureNack && nackable:
+		w.log(LevelDebug, "Updates channel full, nacking update", "op", um.Op, "origin", um.Origin, "sequence", um.Sequence)
+		return Nacked
+	}
+
+	select {
+	case w.updates <- um:
+	case <-w.closedCh:
+	}
+	return Acked
+}
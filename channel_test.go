@@ -0,0 +1,196 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newChannelWatcher(t *testing.T, ctx context.Context, broker *fakeBroker, size int, policy Backpressure) *Watcher {
+	w, err := NewWithOptions(ctx, "fake://updates", WithURLMux(broker.mux()), WithUpdatesChannel(size, policy))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	t.Cleanup(w.Close)
+	return w
+}
+
+func TestUpdatesChannel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w := newChannelWatcher(t, ctx, broker, 1, BackpressureBlock)
+
+	var calls int32
+	w.SetUpdateCallbackEx(func(UpdateMessage) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	// with a single slot, the later updates wait until the consumer catches up
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := w.UpdateForAddPolicy("p", "p", user, "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		select {
+		case um := <-w.Updates():
+			if um.Op != UpdateForAddPolicy || um.Params[0] != user {
+				t.Fatalf("Expected the update for %s, got: %+v", user, um)
+			}
+			if um.Origin != w.opts.instanceID {
+				t.Fatalf("Unexpected origin: %q", um.Origin)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Update for %s wasn't delivered on the channel in time", user)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 3 })
+
+	w.Close()
+	if _, ok := <-w.Updates(); ok {
+		t.Fatal("Expected Close to close the updates channel")
+	}
+}
+
+func TestUpdatesChannelDrop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w := newChannelWatcher(t, ctx, broker, 1, BackpressureDrop)
+
+	for i := 0; i < 3; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	sub := broker.subscriptions()[0]
+	waitFor(t, time.Second*5, func() bool {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		return len(sub.acks) == 3
+	})
+
+	if n := len(w.Updates()); n != 1 {
+		t.Fatalf("Expected the channel to hold 1 update, got %d", n)
+	}
+	if um := <-w.Updates(); um.Sequence != 1 {
+		t.Fatalf("Expected the first update to be kept, got sequence %d", um.Sequence)
+	}
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for id, acked := range sub.acks {
+		if !acked {
+			t.Fatalf("Dropped update %v was nacked", id)
+		}
+	}
+}
+
+func TestUpdatesChannelNack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w := newChannelWatcher(t, ctx, broker, 1, BackpressureNack)
+
+	calls := make(chan UpdateMessage, 2)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		calls <- um
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	sub := broker.subscriptions()[0]
+	waitFor(t, time.Second*5, func() bool {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		return len(sub.acks) == 2
+	})
+
+	sub.mu.Lock()
+	acked, nacked := sub.acks[1], sub.acks[2]
+	sub.mu.Unlock()
+	if !acked || nacked {
+		t.Fatalf("Expected the first update acked and the second nacked, got %v and %v", acked, !nacked)
+	}
+
+	// a nacked update is left for redelivery, so the callbacks mustn't see it
+	select {
+	case um := <-calls:
+		if um.Sequence != 1 {
+			t.Fatalf("Callback invoked for the nacked update, sequence %d", um.Sequence)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Callback wasn't invoked for the delivered update")
+	}
+	select {
+	case um := <-calls:
+		t.Fatalf("Callback invoked for the nacked update, sequence %d", um.Sequence)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestUpdatesChannelBlockedClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w := newChannelWatcher(t, ctx, broker, 1, BackpressureBlock)
+
+	for i := 0; i < 2; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close deadlocked on a full updates channel")
+	}
+}
+
+func TestUpdatesChannelBlockedWriters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w := newChannelWatcher(t, ctx, broker, 1, BackpressureBlock)
+
+	for i := 0; i < 2; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// the delivery waiting on the full channel doesn't hold back writers,
+	// nor the readers queued behind them
+	done := make(chan struct{})
+	go func() {
+		w.SetUpdateCallback(func(string) {})
+		_ = w.Update()
+		w.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Blocked on a full updates channel")
+	}
+}
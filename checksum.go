@@ -0,0 +1,99 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"strconv"
+	"strings"
+
+	"gocloud.dev/pubsub"
+)
+
+var (
+	// ErrChecksumMismatch is returned for a received message whose body
+	// doesn't match its checksum, e.g. because it was truncated
+	ErrChecksumMismatch = errors.New("message body doesn't match its checksum")
+	// ErrUnknownChecksum is returned for a received message whose checksum
+	// was computed with an unknown algorithm
+	ErrUnknownChecksum = errors.New("message checksum computed with an unknown algorithm")
+)
+
+// Checksum is an algorithm stamping message bodies, see WithChecksum
+type Checksum int
+
+// Checksum algorithms
+const (
+	// ChecksumNone sends no checksum, the default.
+	ChecksumNone Checksum = iota
+	// ChecksumCRC32 is CRC-32 with the Castagnoli polynomial.
+	ChecksumCRC32
+	// ChecksumCRC64 is CRC-64 with the ECMA polynomial.
+	ChecksumCRC64
+)
+
+var (
+	crc32Table = crc32.MakeTable(crc32.Castagnoli)
+	crc64Table = crc64.MakeTable(crc64.ECMA)
+)
+
+// String returns the name the algorithm is identified by in the metadata
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumCRC32:
+		return "crc32c"
+	case ChecksumCRC64:
+		return "crc64"
+	}
+	return "none"
+}
+
+func (c Checksum) sum(body []byte) (uint64, bool) {
+	switch c {
+	case ChecksumCRC32:
+		return uint64(crc32.Checksum(body, crc32Table)), true
+	case ChecksumCRC64:
+		return crc64.Checksum(body, crc64Table), true
+	}
+	return 0, false
+}
+
+// WithChecksum stamps a checksum of the body, as sent after any compression,
+// into the metadata of every message. Watchers always verify the checksum
+// of the messages carrying one and drop those that don't match. It catches
+// accidental corruption and truncation, not tampering.
+func WithChecksum(c Checksum) Option {
+	return optionFunc(func(o *options) {
+		o.checksum = c
+	})
+}
+
+// stampChecksum adds the checksum of the body of m
+func (w *Watcher) stampChecksum(m *pubsub.Message) {
+	sum, ok := w.opts.checksum.sum(m.Body)
+	if !ok {
+		return
+	}
+	m.Metadata[w.metadataKey(metaChecksum)] = w.opts.checksum.String() + ":" + strconv.FormatUint(sum, 16)
+}
+
+// verifyChecksum checks the body of msg against its checksum, if it has one
+func (w *Watcher) verifyChecksum(msg *pubsub.Message) error {
+	value, ok := msg.Metadata[w.metadataKey(metaChecksum)]
+	if !ok {
+		return nil
+	}
+	name, want, _ := strings.Cut(value, ":")
+	for _, c := range []Checksum{ChecksumCRC32, ChecksumCRC64} {
+		if c.String() != name {
+			continue
+		}
+		sum, _ := c.sum(msg.Body)
+		if strconv.FormatUint(sum, 16) != want {
+			return fmt.Errorf("%w: %s of %d bytes is %x, expected %s", ErrChecksumMismatch, name, len(msg.Body), sum, want)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownChecksum, name)
+}
@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestChecksum(t *testing.T) {
+	for _, checksum := range []Checksum{ChecksumCRC32, ChecksumCRC64} {
+		t.Run(checksum.String(), func(t *testing.T) {
+			ctx := context.Background()
+
+			// truncate is set to cut the bodies in transit
+			var truncate int32
+			broker := &fakeBroker{
+				sendErr: func(_ context.Context, ms []*driver.Message) error {
+					if atomic.LoadInt32(&truncate) != 0 {
+						for _, m := range ms {
+							m.Body = m.Body[:len(m.Body)/2]
+						}
+					}
+					return nil
+				},
+			}
+			events := make(chan ackEvent, 2)
+			w, err := NewWithOptions(ctx, "fake://checksum", WithURLMux(broker.mux()),
+				WithChecksum(checksum), WithErrorChannel(1), recordAcks(events))
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+
+			var applied int32
+			w.SetUpdateCallbackEx(func(UpdateMessage) error {
+				atomic.AddInt32(&applied, 1)
+				return nil
+			})
+
+			if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+
+			atomic.StoreInt32(&truncate, 1)
+			if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			expectAcks(t, events, ackEvent{2, w.opts.instanceID, Dropped})
+			select {
+			case err := <-w.Errors():
+				if !errors.Is(err, ErrChecksumMismatch) {
+					t.Fatalf("Expected ErrChecksumMismatch, got: %v", err)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("The corrupted message wasn't reported")
+			}
+			waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&applied) == 1 })
+		})
+	}
+}
+
+func TestChecksumUnknown(t *testing.T) {
+	w := newWatcher("", buildOptions())
+	msg := w.newMessage([]byte(legacyUpdateBody), Update)
+	msg.Metadata[w.metadataKey(metaChecksum)] = "sha0:00"
+	if err := w.verifyChecksum(msg); !errors.Is(err, ErrUnknownChecksum) {
+		t.Fatalf("Expected ErrUnknownChecksum, got: %v", err)
+	}
+	delete(msg.Metadata, w.metadataKey(metaChecksum))
+	if err := w.verifyChecksum(msg); err != nil {
+		t.Fatalf("A message without checksum must be accepted, got: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gocloud.dev/gcerrors"
+)
+
+// ErrorKind tells the receive loop how to react to a subscription error
+type ErrorKind int
+
+// Error kinds
+const (
+	// Transient errors are logged and the subscription is reopened.
+	Transient ErrorKind = iota
+	// Fatal errors are logged and the watcher stops receiving for good.
+	Fatal
+	// Shutdown errors mean the subscription was closed on purpose; the
+	// watcher stops receiving without logging an error.
+	Shutdown
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case Transient:
+		return "transient"
+	case Fatal:
+		return "fatal"
+	case Shutdown:
+		return "shutdown"
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// ErrorClassifier sorts the errors returned while receiving and sending
+type ErrorClassifier func(error) ErrorKind
+
+// WithErrorClassifier replaces DefaultErrorClassifier to tune which provider
+// errors trigger a reconnect, and which send errors are retryable
+func WithErrorClassifier(classifier ErrorClassifier) Option {
+	return optionFunc(func(o *options) {
+		o.errorClassifier = classifier
+	})
+}
+
+// DefaultErrorClassifier treats context cancellation, as when the watcher
+// closes, as Shutdown, errors a retry can't fix, such as missing
+// permissions, a deleted subscription or a disabled topic, as Fatal, and
+// everything else, including an expired deadline, as Transient.
+func DefaultErrorClassifier(err error) ErrorKind {
+	if errors.Is(err, context.Canceled) {
+		return Shutdown
+	}
+	if errors.Is(err, ErrTopicDisabled) {
+		return Fatal
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.Canceled:
+		return Shutdown
+	case gcerrors.NotFound, gcerrors.PermissionDenied, gcerrors.InvalidArgument, gcerrors.Unimplemented:
+		return Fatal
+	}
+	return Transient
+}
+
+// SendError is returned by Update and the UpdateFor* methods when sending
+// fails. Kind is how the error classifier sorted Err.
+type SendError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("failed to send update (%s), error: %s", e.Kind, e.Err)
+}
+
+func (e *SendError) Unwrap() error { return e.Err }
+
+// Retryable reports whether sending again may succeed, that is whether the
+// error is Transient.
+func (e *SendError) Retryable() bool { return e.Kind == Transient }
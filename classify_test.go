@@ -0,0 +1,219 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub/driver"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes and reads
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestErrorClassifier(t *testing.T) {
+	var (
+		errTransient = errors.New("connection reset")
+		errFatal     = errors.New("subscription deleted")
+		errShutdown  = errors.New("drained for maintenance")
+	)
+	classifier := func(err error) ErrorKind {
+		switch {
+		case errors.Is(err, errFatal):
+			return Fatal
+		case errors.Is(err, errShutdown):
+			return Shutdown
+		}
+		return Transient
+	}
+
+	for _, tc := range []struct {
+		err       error
+		reconnect bool
+		logged    string
+	}{
+		{errTransient, true, "Error while receiving an update message"},
+		{errFatal, false, "Subscription failed permanently, not reconnecting"},
+		{errShutdown, false, ""},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var logs syncBuffer
+			broker := &fakeBroker{}
+			w, err := NewWithOptions(ctx, "fake://classify", WithURLMux(broker.mux()),
+				WithErrorClassifier(classifier), WithLogger(NewJSONLogger(&logs)))
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+
+			failed := broker.subscriptions()[0]
+			failed.fail(tc.err)
+
+			if tc.reconnect {
+				waitFor(t, time.Second*5, func() bool {
+					subs := broker.subscriptions()
+					return len(subs) == 1 && subs[0] != failed && w.Connected()
+				})
+			} else {
+				waitFor(t, time.Second*5, func() bool { return w.GoroutineCount() == 0 })
+				if w.Connected() {
+					t.Fatal("Watcher still reports being connected after giving up")
+				}
+				time.Sleep(reconnectMinBackoff * 2)
+				if n := len(broker.subscriptions()); n != 1 {
+					t.Fatalf("Watcher reopened the subscription, %d open", n)
+				}
+			}
+
+			if tc.logged != "" && !strings.Contains(logs.String(), tc.logged) {
+				t.Fatalf("Expected %q to be logged, got: %s", tc.logged, logs.String())
+			}
+			if tc.logged == "" && strings.Contains(logs.String(), `"level":"error"`) {
+				t.Fatalf("Shutdown logged an error: %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want ErrorKind
+	}{
+		{context.Canceled, Shutdown},
+		{fmt.Errorf("receive: %w", context.DeadlineExceeded), Transient},
+		{errors.New("connection reset"), Transient},
+	} {
+		if got := DefaultErrorClassifier(tc.err); got != tc.want {
+			t.Fatalf("Expected %v to be %s, got %s", tc.err, tc.want, got)
+		}
+	}
+
+	// provider errors are classified by the code their driver assigns
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errDenied := errors.New("denied")
+	broker := &fakeBroker{}
+	broker.errorCode = func(err error) gcerrors.ErrorCode {
+		if errors.Is(err, errDenied) {
+			return gcerrors.PermissionDenied
+		}
+		return gcerrors.Unknown
+	}
+	w, err := NewWithOptions(ctx, "fake://classify", WithURLMux(broker.mux()), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	broker.subscriptions()[0].fail(errDenied)
+	waitFor(t, time.Second*5, func() bool { return w.GoroutineCount() == 0 })
+	if n := len(broker.subscriptions()); n != 1 {
+		t.Fatalf("Watcher reconnected after a permission error, %d subscriptions open", n)
+	}
+}
+
+func TestSendErrorRetryable(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		errTransient = errors.New("broker unavailable")
+		errPermanent = errors.New("topic deleted")
+	)
+	var fail error
+	broker := &fakeBroker{
+		sendErr: func(context.Context, []*driver.Message) error { return fail },
+		errorCode: func(err error) gcerrors.ErrorCode {
+			if errors.Is(err, errPermanent) {
+				return gcerrors.NotFound
+			}
+			return gcerrors.Internal
+		},
+	}
+	w, err := NewWithOptions(ctx, "fake://send-errors", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{errTransient, true},
+		{errPermanent, false},
+	} {
+		fail = tc.err
+		err := w.Update()
+		var sendErr *SendError
+		if !errors.As(err, &sendErr) {
+			t.Fatalf("Expected a *SendError, got: %v", err)
+		}
+		if sendErr.Retryable() != tc.retryable || !errors.Is(err, tc.err) {
+			t.Fatalf("%v: got %s error, retryable %t", tc.err, sendErr.Kind, sendErr.Retryable())
+		}
+	}
+
+	w.Close()
+	var sendErr *SendError
+	if err := w.Update(); !errors.As(err, &sendErr) || sendErr.Retryable() {
+		t.Fatalf("Sending after Close must not be retryable, got: %v", err)
+	}
+}
+
+func TestTopicDisabled(t *testing.T) {
+	ctx := context.Background()
+
+	errDisabled := errors.New("entity is disabled")
+	RegisterTopicDisabledDetector(func(err error) bool { return errors.Is(err, errDisabled) })
+
+	fail := errDisabled
+	broker := &fakeBroker{
+		sendErr: func(context.Context, []*driver.Message) error { return fail },
+	}
+	w, err := NewWithOptions(ctx, "fake://topic-disabled", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	err = w.Update()
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || !errors.Is(err, ErrTopicDisabled) || !errors.Is(err, errDisabled) {
+		t.Fatalf("Expected a send error wrapping ErrTopicDisabled, got: %v", err)
+	}
+	if sendErr.Kind != Fatal {
+		t.Fatalf("Expected a disabled topic to be fatal, got %s", sendErr.Kind)
+	}
+
+	// other send errors aren't taken for a disabled topic
+	fail = errors.New("connection reset")
+	if err := w.Update(); errors.Is(err, ErrTopicDisabled) {
+		t.Fatalf("Expected a plain send error, got: %v", err)
+	}
+}
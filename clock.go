@@ -0,0 +1,32 @@
+package watcher
+
+import "time"
+
+// Clock tells the time and schedules the watcher's timers. The system clock
+// is used unless WithClock is given, e.g. to control time in tests.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer scheduled with Clock.AfterFunc
+type Timer interface {
+	// Stop prevents the timer from firing and reports whether it did so.
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// WithClock sets the clock used for timed behaviour such as SetLogLevelFor.
+func WithClock(clock Clock) Option {
+	return optionFunc(func(o *options) {
+		o.clock = clock
+	})
+}
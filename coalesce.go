@@ -0,0 +1,93 @@
+package watcher
+
+import "sync"
+
+// WithCoalescedReloads sets whether updates arriving while the
+// SetUpdateCallback callback is running are collapsed: instead of starting
+// another reload alongside it, a single trailing reload with the last body
+// received runs once it returns. Disabled by default. It can be changed with
+// Reconfigure.
+func WithCoalescedReloads(enabled bool) Option {
+	return runtimeOption(func(t *tunables) {
+		t.coalesceReloads = enabled
+	})
+}
+
+// coalescer lets one reload run at a time and collapses the triggers that
+// arrive meanwhile into a single trailing one. The done funcs of every
+// collapsed trigger are called once the trailing reload completes, or with
+// errNotRun if the watcher is closed first.
+type coalescer struct {
+	mu      sync.Mutex
+	running bool
+	stopped bool
+	// pending is the trailing reload, set while one is running
+	pending *coalescedReload
+}
+
+type coalescedReload struct {
+	body string
+	fire func(string, func(error))
+	done []func(error)
+}
+
+// wrap returns fire coalesced with the other wrapped reloads
+func (c *coalescer) wrap(fire func(string, func(error))) func(string, func(error)) {
+	return func(body string, done func(error)) {
+		c.trigger(body, fire, done)
+	}
+}
+
+func (c *coalescer) trigger(body string, fire func(string, func(error)), done func(error)) {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		done(errNotRun)
+		return
+	}
+	if c.running {
+		if c.pending == nil {
+			c.pending = &coalescedReload{}
+		}
+		c.pending.body, c.pending.fire = body, fire
+		c.pending.done = append(c.pending.done, done)
+		c.mu.Unlock()
+		return
+	}
+	c.running = true
+	c.mu.Unlock()
+	c.run(&coalescedReload{body: body, fire: fire, done: []func(error){done}})
+}
+
+// run fires r and, once it completes, the reload that became pending
+// meanwhile
+func (c *coalescer) run(r *coalescedReload) {
+	r.fire(r.body, func(err error) {
+		for _, fn := range r.done {
+			fn(err)
+		}
+		c.mu.Lock()
+		next := c.pending
+		c.pending = nil
+		if next == nil || c.stopped {
+			c.running = false
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+		c.run(next)
+	})
+}
+
+func (c *coalescer) stop() {
+	c.mu.Lock()
+	c.stopped = true
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if pending != nil {
+		for _, fn := range pending.done {
+			fn(errNotRun)
+		}
+	}
+}
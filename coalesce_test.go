@@ -0,0 +1,68 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescedReloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://coalesce", WithURLMux((&fakeBroker{}).mux()),
+		WithCoalescedReloads(true), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls, running, overlapped int32
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	w.SetUpdateCallback(func(string) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&running, -1)
+	})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The first update didn't start a reload")
+	}
+
+	// every update arriving during the reload is received before it returns
+	for i := 0; i < 5; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 6 updates were received", i)
+		}
+	}
+	close(release)
+
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 2 })
+	time.Sleep(time.Millisecond * 200)
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("Expected the updates during the reload to trigger one more reload, got %d", got-1)
+	}
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Fatal("Coalesced reloads ran concurrently")
+	}
+	waitFor(t, time.Second*5, func() bool { return w.GoroutineCount() == 1 })
+}
@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Codec encodes update messages into message bodies and back
+type Codec interface {
+	// Name identifies the codec, e.g. in logs and configuration dumps.
+	Name() string
+	Marshal(UpdateMessage) ([]byte, error)
+	Unmarshal([]byte) (UpdateMessage, error)
+}
+
+// ErrUnknownOp is returned when a decoded message has no recognised operation
+var ErrUnknownOp = errors.New("update message has no known operation")
+
+// JSONCodec encodes update messages as JSON objects. Rules are encoded as
+// JSON arrays of strings, so values containing commas, quotes or any other
+// special characters survive the round trip unchanged.
+type JSONCodec struct{}
+
+// Name implements Codec.
+func (JSONCodec) Name() string { return "json" }
+
+// Marshal implements Codec.
+func (JSONCodec) Marshal(um UpdateMessage) ([]byte, error) {
+	return json.Marshal(um)
+}
+
+// Unmarshal implements Codec.
+func (JSONCodec) Unmarshal(body []byte) (UpdateMessage, error) {
+	var um UpdateMessage
+	if err := json.Unmarshal(body, &um); err != nil {
+		return UpdateMessage{}, err
+	}
+	if um.Op == "" {
+		return UpdateMessage{}, ErrUnknownOp
+	}
+	return um, nil
+}
+
+// BodyDecoder translates the body and metadata of a message from a
+// publisher other than a watcher into the string passed to the
+// SetUpdateCallback callback. It returns false to skip the message.
+type BodyDecoder func(body []byte, metadata map[string]string) (string, bool)
+
+// WithBodyDecoder passes messages that weren't published by a watcher using
+// the same metadata prefix through decoder instead of ignoring them. The
+// callbacks receive the translated body; SetUpdateCallbackEx callbacks see
+// it decoded like any other body, so translating to the "Casbin Update"
+// body delivers it as an Update.
+func WithBodyDecoder(decoder BodyDecoder) Option {
+	return optionFunc(func(o *options) {
+		o.bodyDecoder = decoder
+	})
+}
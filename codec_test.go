@@ -0,0 +1,145 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// adversarialRules contains rule values that would be corrupted by a naive
+// comma-join encoding
+var adversarialRules = [][]string{
+	{"alice, bob", "data1", "read"},
+	{`"alice"`, `data "quoted", still data`, `'single'`},
+	{`back\slash`, `\"escaped\"`, `\\`},
+	{"", " ", ","},
+	{"line\nbreak", "tab\there", "carriage\rreturn"},
+	{`{"op":"UpdateForAddPolicy"}`, "[1,2]", "]["},
+	{"юникод", "日本語", "emoji 🚀"},
+	{"Casbin Update"},
+}
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	codec := JSONCodec{}
+
+	messages := []UpdateMessage{
+		{Op: UpdateForAddPolicy, Sec: "p", Ptype: "p", Params: adversarialRules[0]},
+		{Op: UpdateForRemovePolicy, Sec: "g", Ptype: "g", Params: adversarialRules[1]},
+		{Op: UpdateForAddPolicies, Sec: "p", Ptype: "p", Rules: adversarialRules},
+		{Op: UpdateForRemovePolicies, Sec: "p", Ptype: "p", Rules: adversarialRules},
+		{Op: UpdateForRemoveFilteredPolicy, Sec: "p", Ptype: "p", FieldIndex: 1, FieldValues: adversarialRules[2]},
+		{Op: UpdateForUpdatePolicy, Sec: "p", Ptype: "p", Params: adversarialRules[3], NewParams: adversarialRules[4]},
+		{Op: UpdateForUpdatePolicies, Sec: "p", Ptype: "p", Rules: adversarialRules[:4], NewRules: adversarialRules[4:]},
+	}
+
+	for _, want := range messages {
+		body, err := codec.Marshal(want)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s: %s", want.Op, err)
+		}
+		got, err := codec.Unmarshal(body)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %s: %s", want.Op, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Round trip mismatch for %s:\n got %#v\nwant %#v", want.Op, got, want)
+		}
+	}
+}
+
+func TestJSONCodecRejectsUnknownBody(t *testing.T) {
+	codec := JSONCodec{}
+
+	if _, err := codec.Unmarshal([]byte("not json")); err == nil {
+		t.Fatal("Expected an error for a non JSON body")
+	}
+	if _, err := codec.Unmarshal([]byte(`{"sec":"p"}`)); err != ErrUnknownOp {
+		t.Fatalf("Expected ErrUnknownOp, got: %v", err)
+	}
+}
+
+func TestUpdateForAddPoliciesPreservesRules(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := New(ctx, "mem://codec-topic")
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	received := make(chan UpdateMessage, 1)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		received <- um
+		return nil
+	})
+
+	if err := w.UpdateForAddPolicies("p", "p", adversarialRules...); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+
+	select {
+	case um := <-received:
+		if um.Op != UpdateForAddPolicies {
+			t.Fatalf("Unexpected op: %s", um.Op)
+		}
+		if !reflect.DeepEqual(um.Rules, adversarialRules) {
+			t.Fatalf("Rules were not preserved:\n got %q\nwant %q", um.Rules, adversarialRules)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The update wasn't received in time")
+	}
+}
+
+func TestBodyDecoder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	decoder := func(body []byte, metadata map[string]string) (string, bool) {
+		if metadata["source"] != "legacy-publisher" {
+			return "", false
+		}
+		return "reload: " + string(body), true
+	}
+	w, err := NewWithOptions(ctx, "fake://decoder", WithURLMux((&fakeBroker{}).mux()), WithBodyDecoder(decoder))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	received := make(chan string, 3)
+	w.SetUpdateCallback(func(msg string) {
+		received <- msg
+	})
+
+	foreign := []*pubsub.Message{
+		{Body: []byte("ignored"), Metadata: map[string]string{"source": "someone-else"}},
+		{Body: []byte("policies v42"), Metadata: map[string]string{"source": "legacy-publisher"}},
+	}
+	for _, m := range foreign {
+		if err := w.topic.Send(ctx, m); err != nil {
+			t.Fatalf("Failed to send foreign message: %s", err)
+		}
+	}
+	// the watcher's own messages don't go through the decoder
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+
+	// callbacks run concurrently, so the order of delivery isn't guaranteed
+	want := map[string]bool{"reload: policies v42": true, legacyUpdateBody: true}
+	for len(want) > 0 {
+		select {
+		case got := <-received:
+			if !want[got] {
+				t.Fatalf("Unexpected body delivered: %q", got)
+			}
+			delete(want, got)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%v weren't delivered in time", want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package watcher
+
+import (
+	"strings"
+	"sync"
+
+	"gocloud.dev/pubsub"
+)
+
+// CompactionKeySetter sets key as the compaction key of a message being
+// sent, through the asFunc of its BeforeSend hook, and returns false when
+// it doesn't support the provider.
+type CompactionKeySetter func(asFunc func(interface{}) bool, key string) bool
+
+var (
+	compactionKeySettersMu sync.RWMutex
+	compactionKeySetters   []CompactionKeySetter
+)
+
+// RegisterCompactionKeySetter adds s to the setters used by
+// WithCompactionKey. Drivers whose provider compacts topics by key
+// register one on import.
+func RegisterCompactionKeySetter(s CompactionKeySetter) {
+	compactionKeySettersMu.Lock()
+	defer compactionKeySettersMu.Unlock()
+	compactionKeySetters = append(compactionKeySetters, s)
+}
+
+// WithCompactionKey keys every Update and UpdateFor* update sent with
+// key(um), so a broker compacting the topic, like Kafka with
+// cleanup.policy=compact, only retains the latest update per key. Updates
+// for which it returns "" aren't keyed. The key is also carried in the
+// metadata, and receivers find it in UpdateMessage.CompactionKey. With
+// providers no registered CompactionKeySetter supports, only the metadata
+// is set.
+func WithCompactionKey(key func(um UpdateMessage) string) Option {
+	return optionFunc(func(o *options) {
+		o.compactionKey = key
+	})
+}
+
+// ResourceCompactionKey keys an update by the resource it changes: its
+// section, policy type and the first field of its rule, e.g. "g/g/alice"
+// for a role of alice, so only the latest change of each subject or role is
+// retained. Reloads are keyed by their type, and so are the other updates
+// when they carry no rule. Every update but the latest of a resource is
+// discarded by compaction, including those changing other rules of it, so
+// receivers catching up from a compacted topic should reload the policy.
+func ResourceCompactionKey(um UpdateMessage) string {
+	rule := um.Params
+	if len(rule) == 0 && len(um.Rules) > 0 {
+		rule = um.Rules[0]
+	}
+	if len(rule) == 0 {
+		return string(um.Op)
+	}
+	return strings.Join([]string{um.Sec, um.Ptype, rule[0]}, "/")
+}
+
+// setCompactionKey keys m, the message of um, with WithCompactionKey
+func (w *Watcher) setCompactionKey(m *pubsub.Message, um UpdateMessage) {
+	if w.opts.compactionKey == nil {
+		return
+	}
+	key := w.opts.compactionKey(um)
+	if key == "" {
+		return
+	}
+	m.Metadata[w.metadataKey(metaCompactionKey)] = key
+	m.BeforeSend = func(asFunc func(interface{}) bool) error {
+		compactionKeySettersMu.RLock()
+		defer compactionKeySettersMu.RUnlock()
+		for _, s := range compactionKeySetters {
+			if s(asFunc, key) {
+				return nil
+			}
+		}
+		w.warnCapability("compaction", "The provider doesn't support compaction keys, sending them in the metadata only")
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// compactedRecord stands for a provider message with a compaction key
+type compactedRecord struct {
+	key string
+}
+
+func init() {
+	RegisterCompactionKeySetter(func(as func(interface{}) bool, key string) bool {
+		var r *compactedRecord
+		if !as(&r) {
+			return false
+		}
+		r.key = key
+		return true
+	})
+}
+
+func TestCompactionKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var records []*compactedRecord
+	broker := &fakeBroker{topicAs: func(i interface{}) bool {
+		p, ok := i.(**compactedRecord)
+		if ok {
+			*p = &compactedRecord{}
+			mu.Lock()
+			records = append(records, *p)
+			mu.Unlock()
+		}
+		return ok
+	}}
+	w, err := NewWithOptions(ctx, "fake://compaction", WithURLMux(broker.mux()),
+		WithCompactionKey(ResourceCompactionKey))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	keys := make(chan string, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		keys <- um.CompactionKey
+		return nil
+	})
+
+	if err := w.UpdateForAddPolicy("g", "g", "alice", "admin"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.UpdateForRemovePolicies("p", "p", []string{"bob", "data1", "read"}); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+
+	want := []string{"g/g/alice", "p/p/bob", string(Update)}
+	mu.Lock()
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d keyed messages, got %d", len(want), len(records))
+	}
+	for i, r := range records {
+		if r.key != want[i] {
+			t.Fatalf("Expected message %d to be keyed %q, got %q", i+1, want[i], r.key)
+		}
+	}
+	mu.Unlock()
+	received := map[string]bool{}
+	for range want {
+		select {
+		case key := <-keys:
+			received[key] = true
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of %d updates were received", len(received), len(want))
+		}
+	}
+	for _, key := range want {
+		if !received[key] {
+			t.Fatalf("No update was received with key %q, got %v", key, received)
+		}
+	}
+}
+
+func TestCompactionKeyUnsupported(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://compaction", WithURLMux((&fakeBroker{}).mux()),
+		WithCompactionKey(func(UpdateMessage) string { return "policy" }), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	keys := make(chan string, 1)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		keys <- um.CompactionKey
+		return nil
+	})
+
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case got := <-keys:
+		if got != "policy" {
+			t.Fatalf("Expected the key in the metadata, got %q", got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The update wasn't received")
+	}
+}
@@ -0,0 +1,149 @@
+package watcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrUnknownCompression is returned for a received message compressed with an
+// algorithm no registered Compressor is named after
+var ErrUnknownCompression = errors.New("message compressed with an unknown algorithm")
+
+// Compressor compresses message bodies. Name is carried in the message
+// metadata so the receiver picks the Compressor with the same name to
+// decompress the body.
+type Compressor interface {
+	Name() string
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// GzipCompressor compresses bodies with gzip at Level, or
+// gzip.DefaultCompression if zero. Watchers always accept gzip bodies.
+type GzipCompressor struct {
+	Level int
+}
+
+// Name implements Compressor.
+func (GzipCompressor) Name() string { return "gzip" }
+
+// Compress implements Compressor.
+func (c GzipCompressor) Compress(body []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements Compressor.
+func (GzipCompressor) Decompress(body []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// DecompressLimited implements LimitedDecompressor.
+func (GzipCompressor) DecompressLimited(body []byte, limit int64) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	// reading one byte past the limit tells an oversized body apart
+	out, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("%w: over %d bytes decompressed", ErrPayloadTooLarge, limit)
+	}
+	return out, nil
+}
+
+// WithCompression compresses the bodies of at least threshold bytes with c
+// before sending them. Receivers need a Compressor with the same name, see
+// WithDecompressors.
+func WithCompression(c Compressor, threshold int) Option {
+	return optionFunc(func(o *options) {
+		o.compressor = c
+		o.compressThreshold = threshold
+		o.decompressors = append(o.decompressors, c)
+	})
+}
+
+// WithDecompressors lets the watcher receive bodies compressed with cs, in
+// addition to gzip and the WithCompression compressor.
+func WithDecompressors(cs ...Compressor) Option {
+	return optionFunc(func(o *options) {
+		o.decompressors = append(o.decompressors, cs...)
+	})
+}
+
+// compress replaces the body of m by its compressed form and tags the
+// algorithm when it reaches the threshold
+func (w *Watcher) compress(m *pubsub.Message) error {
+	c := w.opts.compressor
+	if c == nil || len(m.Body) < w.opts.compressThreshold {
+		return nil
+	}
+	key := w.metadataKey(metaEncoding)
+	if _, done := m.Metadata[key]; done {
+		return nil
+	}
+	body, err := c.Compress(m.Body)
+	if err != nil {
+		return fmt.Errorf("failed to compress message with %s, error: %w", c.Name(), err)
+	}
+	m.Body = body
+	m.Metadata[key] = c.Name()
+	return nil
+}
+
+// decompress restores the body of msg if it was compressed
+func (w *Watcher) decompress(msg *pubsub.Message) error {
+	name, ok := msg.Metadata[w.metadataKey(metaEncoding)]
+	if !ok {
+		return nil
+	}
+	var c Compressor
+	for _, d := range w.opts.decompressors {
+		if d.Name() == name {
+			c = d
+		}
+	}
+	if c == nil && name == (GzipCompressor{}).Name() {
+		c = GzipCompressor{}
+	}
+	if c == nil {
+		return fmt.Errorf("%w: %q", ErrUnknownCompression, name)
+	}
+	body, err := w.decompressBody(c, msg.Body)
+	if errors.Is(err, ErrPayloadTooLarge) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress message with %s, error: %w", name, err)
+	}
+	msg.Body = body
+	return nil
+}
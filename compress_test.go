@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+func TestCompressionThreshold(t *testing.T) {
+	w := newWatcher("", buildOptions(WithCompression(GzipCompressor{}, 64)))
+
+	small := w.newMessage([]byte(legacyUpdateBody), Update)
+	if err := w.compress(small); err != nil {
+		t.Fatal(err)
+	}
+	if string(small.Body) != legacyUpdateBody || small.Metadata[w.metadataKey(metaEncoding)] != "" {
+		t.Fatalf("Small body was compressed: %q", small.Body)
+	}
+
+	body := []byte(strings.Repeat("p, alice, data1, read\n", 10))
+	large := w.newMessage(body, Update)
+	if err := w.compress(large); err != nil {
+		t.Fatal(err)
+	}
+	if large.Metadata[w.metadataKey(metaEncoding)] != "gzip" || !bytes.HasPrefix(large.Body, []byte{0x1f, 0x8b}) {
+		t.Fatalf("Large body wasn't gzipped: %v", large.Metadata)
+	}
+	if err := w.decompress(large); err != nil || !bytes.Equal(large.Body, body) {
+		t.Fatalf("Body didn't survive the round trip, error: %v", err)
+	}
+}
+
+func TestCompressionInterop(t *testing.T) {
+	ctx := context.Background()
+
+	broker := &fakeBroker{}
+	sender, err := NewWithOptions(ctx, "fake://compressed", WithURLMux(broker.mux()),
+		WithCompression(GzipCompressor{Level: 9}, 16))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer sender.Close()
+	receiver, err := NewWithOptions(ctx, "fake://compressed", WithURLMux(broker.mux()), WithErrorChannel(2))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer receiver.Close()
+
+	received := make(chan UpdateMessage, 2)
+	receiver.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		if um.Origin != receiver.opts.instanceID {
+			received <- um
+		}
+		return nil
+	})
+
+	rule := []string{"alice", "data1", strings.Repeat("read,", 20)}
+	if err := sender.UpdateForAddPolicy("p", "p", rule...); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case um := <-received:
+		if um.Op != UpdateForAddPolicy || !reflect.DeepEqual(um.Params, rule) {
+			t.Fatalf("Unexpected update: %+v", um)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Compressed update wasn't delivered")
+	}
+
+	// an algorithm the receiver doesn't know is rejected
+	m := &pubsub.Message{Body: []byte("compressed"), Metadata: map[string]string{
+		receiver.metadataKey(metaOrigin):   "other",
+		receiver.metadataKey(metaOp):       string(Update),
+		receiver.metadataKey(metaEncoding): "lz4",
+	}}
+	if err := receiver.topic.Send(ctx, m); err != nil {
+		t.Fatalf("Failed to send message: %s", err)
+	}
+	select {
+	case err := <-receiver.Errors():
+		if !errors.Is(err, ErrUnknownCompression) {
+			t.Fatalf("Expected ErrUnknownCompression, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Unknown algorithm wasn't reported")
+	}
+	select {
+	case um := <-received:
+		t.Fatalf("Message with an unknown algorithm delivered: %+v", um)
+	default:
+	}
+}
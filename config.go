@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets in the URLs returned by Config
+const redacted = "xxxxx"
+
+// unparseableURL replaces the URLs Config can't parse, which may hold
+// secrets it can't find
+const unparseableURL = "<unparseable URL>"
+
+// Config is a snapshot of the effective settings of a watcher, once the
+// options and defaults are applied, returned by Config.
+type Config struct {
+	// TopicURL and SubscriptionURL have their passwords and secret query
+	// parameters, like tokens and signatures, redacted, or are replaced if
+	// they can't be parsed.
+	TopicURL            string         `json:"topicURL"`
+	SubscriptionURL     string         `json:"subscriptionURL"`
+	Name                string         `json:"name"`
+	InstanceID          string         `json:"instanceID"`
+	MetadataPrefix      string         `json:"metadataPrefix"`
+	Codec               string         `json:"codec"`
+	LegacyMode          bool           `json:"legacyMode"`
+	LogLevel            LogLevel       `json:"logLevel"`
+	SelfFilter          SelfFilterMode `json:"selfFilter"`
+	CallbackConcurrency int            `json:"callbackConcurrency"`
+	Debounce            time.Duration  `json:"debounce"`
+	MinReloadInterval   time.Duration  `json:"minReloadInterval"`
+	CoalesceReloads     bool           `json:"coalesceReloads"`
+	StrictOrdering      bool           `json:"strictOrdering"`
+	Backoff             Backoff        `json:"backoff"`
+	OpenRetries         int            `json:"openRetries"`
+	OpenTimeout         time.Duration  `json:"openTimeout"`
+	SendTimeout         time.Duration  `json:"sendTimeout"`
+	AckTimeout          time.Duration  `json:"ackTimeout"`
+	RequiredAcks        int            `json:"requiredAcks"`
+	CallbackTimeout     time.Duration  `json:"callbackTimeout"`
+	ReceiveRefresh      time.Duration  `json:"receiveRefresh"`
+	HealthDebounce      time.Duration  `json:"healthDebounce"`
+	HeartbeatInterval   time.Duration  `json:"heartbeatInterval"`
+	MaxPayloadBytes     int64          `json:"maxPayloadBytes"`
+	MaxUnacked          int            `json:"maxUnacked"`
+	AckOnlyOnSuccess    bool           `json:"ackOnlyOnSuccess"`
+}
+
+// Config returns the effective settings of the watcher, including those
+// changed since with Reconfigure or SwitchTopic, for debugging and to check
+// options took effect.
+func (w *Watcher) Config() Config {
+	w.connMu.RLock()
+	topicURL := w.topicURL
+	w.connMu.RUnlock()
+	t := w.currentTunables()
+	return Config{
+		TopicURL:            redactURL(topicURL),
+		SubscriptionURL:     redactURL(w.subURL),
+		Name:                w.opts.name,
+		InstanceID:          w.opts.instanceID,
+		MetadataPrefix:      w.opts.metadataPrefix,
+		Codec:               w.codec.Name(),
+		LegacyMode:          w.opts.legacyMode,
+		LogLevel:            t.logLevel,
+		SelfFilter:          t.selfFilter,
+		CallbackConcurrency: t.callbackConcurrency,
+		Debounce:            t.debounce,
+		MinReloadInterval:   t.minReloadInterval,
+		CoalesceReloads:     t.coalesceReloads,
+		StrictOrdering:      w.opts.strictOrdering,
+		Backoff:             w.opts.backoff,
+		OpenRetries:         w.opts.openRetries,
+		OpenTimeout:         w.opts.openTimeout,
+		SendTimeout:         w.opts.sendTimeout,
+		AckTimeout:          w.opts.ackTimeout,
+		RequiredAcks:        w.opts.requiredAcks,
+		CallbackTimeout:     w.opts.callbackTimeout,
+		ReceiveRefresh:      w.opts.receiveRefresh,
+		HealthDebounce:      w.opts.healthDebounce,
+		HeartbeatInterval:   w.opts.heartbeatInterval,
+		MaxPayloadBytes:     w.opts.maxPayloadBytes,
+		MaxUnacked:          w.opts.maxUnacked,
+		AckOnlyOnSuccess:    w.opts.ackOnlyOnSuccess,
+	}
+}
+
+// redactURL hides the password and the query parameters that look like
+// secrets in u, e.g. Azure SAS signatures or access tokens. A u that can't
+// be parsed is replaced altogether.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return unparseableURL
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+	}
+	q := parsed.Query()
+	secret := false
+	for k := range q {
+		if isSecretParam(k) {
+			q.Set(k, redacted)
+			secret = true
+		}
+	}
+	if secret {
+		parsed.RawQuery = q.Encode()
+	}
+	return parsed.String()
+}
+
+// isSecretParam reports whether the query parameter name looks like it holds
+// a secret
+func isSecretParam(name string) bool {
+	name = strings.ToLower(name)
+	if name == "sig" {
+		return true
+	}
+	for _, s := range []string{"password", "secret", "token", "key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	reconnectMinBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// connState tracks whether the watcher is connected. ready is closed while
+// connected and replaced when the connection is lost, so waiters can block
+// on it. changed is closed and replaced on every change.
+type connState struct {
+	mu        sync.Mutex
+	connected bool
+	ready     chan struct{}
+	changed   chan struct{}
+	// reason and since describe the last change, see HealthStatus
+	reason string
+	since  time.Time
+}
+
+func newConnState() connState {
+	return connState{ready: make(chan struct{}), changed: make(chan struct{})}
+}
+
+// setConnected records the connection state; reason explains the change to
+// the OnHealthChange hook
+func (w *Watcher) setConnected(connected bool, reason string) {
+	w.state.mu.Lock()
+	defer w.state.mu.Unlock()
+	if connected == w.state.connected {
+		return
+	}
+	w.health.observe(connected, reason)
+	w.state.connected = connected
+	w.state.reason, w.state.since = reason, w.opts.clock.Now()
+	close(w.state.changed)
+	w.state.changed = make(chan struct{})
+	if connected {
+		close(w.state.ready)
+	} else {
+		w.state.ready = make(chan struct{})
+	}
+}
+
+// Connected reports whether the watcher currently has both the topic and the
+// subscription open and its receive loop is running.
+func (w *Watcher) Connected() bool {
+	w.state.mu.Lock()
+	defer w.state.mu.Unlock()
+	return w.state.connected
+}
+
+// HealthStatus is the connection state of a watcher, see Diagnostics
+type HealthStatus struct {
+	Connected bool `json:"connected"`
+	// Healthy is the state last reported to the OnHealthChange hook, which
+	// lags behind Connected by the WithHealthDebounce window.
+	Healthy bool `json:"healthy"`
+	// Reason and Since describe the last change of Connected, if any.
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+}
+
+// healthStatus returns the current HealthStatus
+func (w *Watcher) healthStatus() HealthStatus {
+	w.state.mu.Lock()
+	status := HealthStatus{Connected: w.state.connected, Reason: w.state.reason, Since: w.state.since}
+	w.state.mu.Unlock()
+	status.Healthy = w.health.healthy()
+	return status
+}
+
+// WaitConnected blocks until the watcher is connected, ctx is done or the
+// watcher is closed.
+func (w *Watcher) WaitConnected(ctx context.Context) error {
+	w.state.mu.Lock()
+	ready := w.state.ready
+	w.state.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-w.closedCh:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Watcher) isClosed() bool {
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	return w.closed
+}
+
+// reconnect replaces a failed subscription, retrying with the WithBackoff
+// backoff until it succeeds or the watcher is closed. It returns nil when the
+// receive loop should stop. cause is the error the subscription failed with.
+func (w *Watcher) reconnect(ctx context.Context, failed subscriptionReceiver, cause error) subscriptionReceiver {
+	w.setConnected(false, "receive failed: "+cause.Error())
+
+	shutdownSubscription(failed)
+
+	n := atomic.AddUint64(&w.stats.reconnects, 1)
+	start := w.opts.clock.Now()
+	w.log(LevelInfo, "Reconnecting", "reconnect", n, "cause", cause)
+	abandon := func(attempt int) subscriptionReceiver {
+		w.log(LevelInfo, "Reconnect abandoned", "reconnect", n, "attempts", attempt,
+			"duration", w.opts.clock.Now().Sub(start), "outcome", "closed")
+		return nil
+	}
+
+	first := w.restartAttempt()
+	for attempt := 1; ; attempt++ {
+		step := first + attempt - 1
+		backoff := w.opts.backoff.Next(step)
+		select {
+		case <-ctx.Done():
+			return abandon(attempt - 1)
+		case <-w.closedCh:
+			return abandon(attempt - 1)
+		case <-time.After(backoff):
+		}
+
+		sub, err := w.openSubscription(ctx)
+		if err != nil {
+			w.log(LevelWarn, "Reconnect failed", "error", err, "reconnect", n, "attempt", attempt, "backoff", backoff)
+			continue
+		}
+
+		w.connMu.Lock()
+		if w.closed {
+			w.connMu.Unlock()
+			_ = sub.Shutdown(context.Background())
+			return abandon(attempt)
+		}
+		w.sub = sub
+		w.connMu.Unlock()
+
+		d := w.opts.clock.Now().Sub(start)
+		w.log(LevelInfo, "Reconnected", "reconnect", n, "attempts", attempt, "duration", d, "outcome", "connected")
+		w.observe(MetricReconnectDuration, d)
+		w.reconnectDuration.record(d)
+		w.opts.backoff.Reset()
+		w.reconnected(step, backoff)
+		w.setConnected(true, "reconnected")
+		if w.opts.snapshotOnReconnect {
+			w.requestSnapshot()
+		}
+		return sub
+	}
+}
+
+// WithOpenRetry makes NewWithOptions retry opening the topic and the
+// subscription up to attempts more times, with the same backoff as
+// reconnects, before giving up. A topic mismatch found by
+// WithStrictBinding is never retried. By default nothing is retried.
+func WithOpenRetry(attempts int) Option {
+	return optionFunc(func(o *options) {
+		o.openRetries = attempts
+	})
+}
+
+// retryableOpenError reports whether opening again may succeed: errors
+// caused by the configuration are returned right away
+func retryableOpenError(err error) bool {
+	return !errors.Is(err, ErrTopicMismatch) &&
+		!errors.Is(err, ErrFilterUnsupported) &&
+		!errors.Is(err, ErrConsumerNameUnsupported)
+}
+
+// retryOpen calls open until it succeeds, the WithOpenRetry attempts are used
+// up or ctx is done, and returns the last error.
+func (w *Watcher) retryOpen(ctx context.Context, what string, open func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := open()
+		if err == nil && attempt > 1 {
+			w.opts.backoff.Reset()
+		}
+		if err == nil || attempt > w.opts.openRetries || !retryableOpenError(err) {
+			return err
+		}
+		backoff := w.opts.backoff.Next(attempt)
+		w.log(LevelWarn, "Failed to open "+what+", retrying", "error", err, "attempt", attempt, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
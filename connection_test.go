@@ -0,0 +1,202 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it returns true or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := New(ctx, "mem://connected-topic")
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	if !w.Connected() {
+		t.Fatal("Watcher should be connected after New")
+	}
+
+	received := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) {
+		received <- msg
+	})
+
+	// break the subscription underneath the receive loop to force a reconnect
+	w.connMu.RLock()
+	sub := w.sub
+	w.connMu.RUnlock()
+	if err := sub.Shutdown(ctx); err != nil {
+		t.Fatalf("Failed to shut down subscription: %s", err)
+	}
+
+	waitFor(t, time.Second*5, func() bool { return !w.Connected() })
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second*5)
+	defer waitCancel()
+	if err := w.WaitConnected(waitCtx); err != nil {
+		t.Fatalf("Watcher didn't reconnect: %s", err)
+	}
+	if !w.Connected() {
+		t.Fatal("Watcher should be connected after reconnecting")
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update after reconnect: %s", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update wasn't received after reconnect")
+	}
+
+	w.Close()
+	if w.Connected() {
+		t.Fatal("Watcher should not be connected after Close")
+	}
+	if err := w.WaitConnected(ctx); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed, got: %v", err)
+	}
+}
+
+func TestOpenRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	unavailable := errors.New("broker unavailable")
+	failTwice := func(calls *int32) func(*url.URL) error {
+		return func(*url.URL) error {
+			if atomic.AddInt32(calls, 1) <= 2 {
+				return unavailable
+			}
+			return nil
+		}
+	}
+
+	var topicCalls, subCalls int32
+	broker := &fakeBroker{}
+	broker.openTopicErr = failTwice(&topicCalls)
+	broker.openSubErr = failTwice(&subCalls)
+
+	if _, err := NewWithOptions(ctx, "fake://retry", WithURLMux(broker.mux())); !errors.Is(err, unavailable) {
+		t.Fatalf("Expected construction without retries to fail, got: %v", err)
+	}
+
+	topicCalls = 0
+	w, err := NewWithOptions(ctx, "fake://retry", WithURLMux(broker.mux()), WithOpenRetry(2))
+	if err != nil {
+		t.Fatalf("Failed to create watcher with retries, error: %s", err)
+	}
+	defer w.Close()
+	if topicCalls != 3 || subCalls != 3 {
+		t.Fatalf("Expected 3 attempts at each open, got %d topic and %d subscription", topicCalls, subCalls)
+	}
+	if !w.Connected() {
+		t.Fatal("Watcher not connected after retried construction")
+	}
+}
+
+func TestOpenTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the opener ignores its context, like a driver stuck dialing
+	unblock := make(chan struct{})
+	defer close(unblock)
+	broker := &fakeBroker{}
+	broker.openSubErr = func(*url.URL) error {
+		<-unblock
+		return nil
+	}
+
+	start := time.Now()
+	_, err := NewWithOptions(ctx, "fake://slow", WithURLMux(broker.mux()), WithOpenTimeout(50*time.Millisecond))
+	if !errors.Is(err, ErrOpenTimeout) {
+		t.Fatalf("Expected the subscription open to time out, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Fatalf("New took %s to give up", elapsed)
+	}
+}
+
+func TestOpenRetryUnlocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attempted := make(chan struct{}, 1)
+	broker := &fakeBroker{}
+	broker.openSubErr = func(*url.URL) error {
+		select {
+		case attempted <- struct{}{}:
+		default:
+		}
+		return errors.New("broker unavailable")
+	}
+	w := NewUnconnected("fake://retry", WithURLMux(broker.mux()),
+		WithOpenRetry(10), WithBackoff(ConstantBackoff(time.Hour)))
+	opened := make(chan error, 1)
+	go func() { opened <- w.Open(ctx) }()
+	<-attempted
+
+	// the watcher stays usable while Open waits to retry
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if w.Connected() {
+			t.Error("Watcher connected before its subscription opened")
+		}
+		if err := w.SetUpdateCallback(func(string) {}); err != nil {
+			t.Errorf("Failed to set callback: %s", err)
+		}
+		if err := w.Open(ctx); !errors.Is(err, ErrAlreadyOpen) {
+			t.Errorf("Expected ErrAlreadyOpen while opening, got: %v", err)
+		}
+		w.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Watcher blocked while Open was retrying")
+	}
+
+	cancel()
+	if err := <-opened; !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed from an Open interrupted by Close, got: %v", err)
+	}
+}
+
+// nilTransport opens nothing without failing
+type nilTransport struct{}
+
+func (nilTransport) openTopic(context.Context, string) (topicSender, error) { return nil, nil }
+func (nilTransport) openSubscription(context.Context, string) (subscriptionReceiver, error) {
+	return nil, nil
+}
+
+func TestOpenNothing(t *testing.T) {
+	_, err := NewWithOptions(context.Background(), "nil://topic", optionFunc(func(o *options) {
+		o.transport = nilTransport{}
+	}))
+	if err == nil || !strings.Contains(err.Error(), "returned none") {
+		t.Fatalf("Expected opening nothing to fail, got: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package watcher
+
+import "errors"
+
+// ErrConsumerNameUnsupported is returned when WithConsumerName is used with a
+// subscription URL whose scheme has no registered consumer name parameter.
+var ErrConsumerNameUnsupported = errors.New("consumer names are not supported for this provider")
+
+// consumerParam registers no scheme by default: the gocloud.dev NATS opener
+// reads a queue parameter, but the members of a queue group share the
+// updates instead of each receiving them
+var consumerParam = subscriptionParam{
+	unsupported: ErrConsumerNameUnsupported,
+}
+
+// RegisterConsumerName makes WithConsumerName pass the consumer name for
+// subscription URLs with the given scheme as the key query parameter.
+// None is registered by default.
+func RegisterConsumerName(scheme, key string) {
+	consumerParam.register(scheme, key)
+}
+
+// WithConsumerName passes name to the subscription opener as the query
+// parameter registered for the subscription URL's scheme with
+// RegisterConsumerName, e.g. the durable name of a provider's consumer. It
+// fails with ErrConsumerNameUnsupported when the scheme has none registered,
+// like nats, whose queue groups would split the updates between watchers.
+func WithConsumerName(name string) Option {
+	return optionFunc(func(o *options) {
+		o.consumerName = name
+	})
+}
@@ -0,0 +1,26 @@
+package watcher
+
+import "gocloud.dev/pubsub"
+
+// LogFieldCorrelation is the log field carrying the correlation ID of an
+// update. The publisher logs it when publishing the update and every watcher
+// when applying it, at LevelDebug, so a single search ties a policy change to
+// each of its applications. Callbacks find it in UpdateMessage.CorrelationID.
+const LogFieldCorrelation = "correlation"
+
+// correlationID identifies the update with the given sequence from origin
+// across watchers
+func correlationID(origin, sequence string) string {
+	return origin + "-" + sequence
+}
+
+// messageCorrelation returns the correlation ID of msg, empty if it lacks an
+// origin or a sequence
+func (w *Watcher) messageCorrelation(msg *pubsub.Message) string {
+	origin := msg.Metadata[w.metadataKey(metaOrigin)]
+	sequence := msg.Metadata[w.metadataKey(metaSequence)]
+	if origin == "" || sequence == "" {
+		return ""
+	}
+	return correlationID(origin, sequence)
+}
@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logField returns the field of the first JSON log entry with the message
+// msg, and whether there is one
+func logField(t *testing.T, logs, msg, field string) (interface{}, bool) {
+	t.Helper()
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to parse log entry %q, error: %s", scanner.Text(), err)
+		}
+		if entry["msg"] == msg {
+			return entry[field], true
+		}
+	}
+	return nil, false
+}
+
+func TestCorrelationLogs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	var publisherLogs, receiverLogs syncBuffer
+	publisher, err := NewWithOptions(ctx, "fake://correlation", WithURLMux(broker.mux()),
+		WithLogLevel(LevelDebug), WithLogger(NewJSONLogger(&publisherLogs)))
+	if err != nil {
+		t.Fatalf("Failed to create publisher, error: %s", err)
+	}
+	defer publisher.Close()
+	receiver, err := NewWithOptions(ctx, "fake://correlation", WithURLMux(broker.mux()),
+		WithLogLevel(LevelDebug), WithLogger(NewJSONLogger(&receiverLogs)))
+	if err != nil {
+		t.Fatalf("Failed to create receiver, error: %s", err)
+	}
+	defer receiver.Close()
+	applied := make(chan UpdateMessage, 10)
+	receiver.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		return nil
+	})
+
+	if err := publisher.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	var um UpdateMessage
+	select {
+	case um = <-applied:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The update wasn't applied")
+	}
+	if um.CorrelationID == "" {
+		t.Fatal("Expected the callback to be given the correlation ID")
+	}
+
+	published, ok := logField(t, publisherLogs.String(), "Publishing update", LogFieldCorrelation)
+	if !ok || published != um.CorrelationID {
+		t.Fatalf("Expected the publish log to carry %q, got %v: %s", um.CorrelationID, published, publisherLogs.String())
+	}
+	waitFor(t, time.Second*5, func() bool {
+		_, ok := logField(t, receiverLogs.String(), "Applied update", LogFieldCorrelation)
+		return ok
+	})
+	if got, _ := logField(t, receiverLogs.String(), "Applied update", LogFieldCorrelation); got != um.CorrelationID {
+		t.Fatalf("Expected the apply log to carry %q, got %v", um.CorrelationID, got)
+	}
+}
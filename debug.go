@@ -0,0 +1,32 @@
+package watcher
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// DebugHandler returns an http.Handler serving the current Diagnostics as
+// JSON, e.g. to mount at /debug/casbin-watcher. It answers with 503 Service
+// Unavailable while the watcher isn't connected, so it doubles as a health
+// check.
+func (w *Watcher) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		diag := w.Diagnostics()
+		body, err := json.MarshalIndent(diag, "", "  ")
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		if !diag.Connected {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = rw.Write(append(body, '\n'))
+	})
+}
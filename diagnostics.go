@@ -0,0 +1,251 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// OriginInfo describes a watcher seen on the topic
+type OriginInfo struct {
+	LastSequence uint64    `json:"lastSequence"`
+	LastSeen     time.Time `json:"lastSeen"`
+	// Hostname and PID are those of the last update with process metadata.
+	Hostname string `json:"hostname,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+	// Conflicting is set once the ID is seen from two processes at the same
+	// time, see WithDuplicateIDStrategy.
+	Conflicting bool `json:"conflicting,omitempty"`
+	// Epoch, LastHeartbeat and HeartbeatInterval are set once the watcher
+	// sent a heartbeat, see WithHeartbeat. The epoch changes when it
+	// restarts.
+	Epoch             int64         `json:"epoch,omitempty"`
+	LastHeartbeat     time.Time     `json:"lastHeartbeat,omitempty"`
+	HeartbeatInterval time.Duration `json:"heartbeatInterval,omitempty"`
+	// LastPing is when the last Ping of the watcher was received.
+	LastPing time.Time `json:"lastPing,omitempty"`
+}
+
+// RecentMessage summarises a received update, see WithRecentMessages
+type RecentMessage struct {
+	Origin   string     `json:"origin,omitempty"`
+	Sequence uint64     `json:"sequence,omitempty"`
+	Op       UpdateType `json:"op,omitempty"`
+	Hostname string     `json:"hostname,omitempty"`
+	PID      int        `json:"pid,omitempty"`
+	Node     string     `json:"node,omitempty"`
+	// MessageID is the provider's native ID, see UpdateMessage.MessageID.
+	MessageID string     `json:"messageID,omitempty"`
+	Received  time.Time  `json:"received"`
+	Outcome   AckOutcome `json:"outcome"`
+}
+
+// Diagnostics is the watcher state written by WriteDiagnostics
+type Diagnostics struct {
+	Time           time.Time             `json:"time"`
+	InstanceID     string                `json:"instanceID"`
+	Connected      bool                  `json:"connected"`
+	Health         HealthStatus          `json:"health"`
+	Stats          Stats                 `json:"stats"`
+	KnownOrigins   map[string]OriginInfo `json:"knownOrigins"`
+	RecentMessages []RecentMessage       `json:"recentMessages"`
+}
+
+// WithRecentMessages keeps a summary of the last n updates received, returned
+// by RecentMessages. None are kept by default.
+func WithRecentMessages(n int) Option {
+	return optionFunc(func(o *options) {
+		o.recentMessages = n
+	})
+}
+
+// WithDiagnosticsDump writes the Diagnostics as a JSON line to out every
+// interval, if positive, and whenever one of sigs is received, so the last
+// known state survives the process for a post-mortem.
+func WithDiagnosticsDump(out io.Writer, interval time.Duration, sigs ...os.Signal) Option {
+	return optionFunc(func(o *options) {
+		o.diagnostics = func(w *Watcher) error { return w.WriteDiagnostics(out) }
+		o.diagnosticsInterval = interval
+		o.diagnosticsSignals = sigs
+	})
+}
+
+// WithDiagnosticsFile is like WithDiagnosticsDump but replaces the file at
+// path with each new dump.
+func WithDiagnosticsFile(path string, interval time.Duration, sigs ...os.Signal) Option {
+	return optionFunc(func(o *options) {
+		o.diagnostics = func(w *Watcher) error { return w.writeDiagnosticsFile(path) }
+		o.diagnosticsInterval = interval
+		o.diagnosticsSignals = sigs
+	})
+}
+
+// diagnostics tracks the peers and messages seen for Diagnostics
+type diagnostics struct {
+	mu      sync.Mutex
+	origins map[string]OriginInfo
+	// replaced holds the processes each origin has been seen replaced by
+	replaced map[string]map[string]bool
+	// recent is a ring buffer, next is where the following message goes
+	recent []RecentMessage
+	next   int
+	full   bool
+}
+
+func (w *Watcher) recordMessage(msg *pubsub.Message, outcome AckOutcome) {
+	origin := msg.Metadata[w.metadataKey(metaOrigin)]
+	kind := msg.Metadata[w.metadataKey(metaKind)]
+	keepRecent := len(w.diag.recent) > 0 && kind == ""
+	if kind == kindLeave || origin == "" && !keepRecent {
+		// a leaving origin was just forgotten
+		return
+	}
+
+	var um UpdateMessage
+	w.readMetadata(msg, &um)
+	now := w.opts.clock.Now()
+
+	var duplicate *DuplicateInstanceError
+	defer func() {
+		if duplicate != nil {
+			w.reportDuplicate(duplicate)
+		}
+	}()
+	w.diag.mu.Lock()
+	defer w.diag.mu.Unlock()
+	if origin != "" {
+		info := w.diag.origins[origin]
+		if um.Sequence > info.LastSequence {
+			info.LastSequence = um.Sequence
+		}
+		info.LastSeen = now
+		duplicate = w.trackIdentity(origin, &info, um)
+		w.diag.origins[origin] = info
+	}
+	if !keepRecent {
+		return
+	}
+	w.diag.recent[w.diag.next] = RecentMessage{
+		Origin:    origin,
+		Sequence:  um.Sequence,
+		Op:        UpdateType(msg.Metadata[w.metadataKey(metaOp)]),
+		Hostname:  um.Hostname,
+		PID:       um.PID,
+		Node:      um.Node,
+		MessageID: w.nativeMessageID(msg),
+		Received:  now,
+		Outcome:   outcome,
+	}
+	if w.diag.next++; w.diag.next == len(w.diag.recent) {
+		w.diag.next, w.diag.full = 0, true
+	}
+}
+
+// forgetOrigin drops a watcher that announced it's leaving
+func (w *Watcher) forgetOrigin(origin string) {
+	w.diag.mu.Lock()
+	defer w.diag.mu.Unlock()
+	delete(w.diag.origins, origin)
+	delete(w.diag.replaced, origin)
+}
+
+// KnownOrigins returns the watchers seen on the topic by instance ID,
+// including this one if it receives its own messages
+func (w *Watcher) KnownOrigins() map[string]OriginInfo {
+	w.diag.mu.Lock()
+	defer w.diag.mu.Unlock()
+	origins := make(map[string]OriginInfo, len(w.diag.origins))
+	for k, v := range w.diag.origins {
+		origins[k] = v
+	}
+	return origins
+}
+
+// RecentMessages returns the last updates received, oldest first, see
+// WithRecentMessages
+func (w *Watcher) RecentMessages() []RecentMessage {
+	w.diag.mu.Lock()
+	defer w.diag.mu.Unlock()
+	if !w.diag.full {
+		return append([]RecentMessage(nil), w.diag.recent[:w.diag.next]...)
+	}
+	return append(append([]RecentMessage(nil), w.diag.recent[w.diag.next:]...), w.diag.recent[:w.diag.next]...)
+}
+
+// Diagnostics returns the current diagnostic state of the watcher
+func (w *Watcher) Diagnostics() Diagnostics {
+	health := w.healthStatus()
+	return Diagnostics{
+		Time:           w.opts.clock.Now().UTC(),
+		InstanceID:     w.opts.instanceID,
+		Connected:      health.Connected,
+		Health:         health,
+		Stats:          w.Stats(),
+		KnownOrigins:   w.KnownOrigins(),
+		RecentMessages: w.RecentMessages(),
+	}
+}
+
+// WriteDiagnostics writes the current Diagnostics to out as a JSON line
+func (w *Watcher) WriteDiagnostics(out io.Writer) error {
+	line, err := json.Marshal(w.Diagnostics())
+	if err != nil {
+		return fmt.Errorf("failed to encode diagnostics, error: %w", err)
+	}
+	_, err = out.Write(append(line, '\n'))
+	return err
+}
+
+// writeDiagnosticsFile replaces path through a rename, so a reader never
+// sees a partial dump
+func (w *Watcher) writeDiagnosticsFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create diagnostics file, error: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := w.WriteDiagnostics(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write diagnostics file, error: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// dumpDiagnostics runs until the watcher is closed, writing the diagnostics
+// on every tick and signal
+func (w *Watcher) dumpDiagnostics() {
+	var tick <-chan time.Time
+	if w.opts.diagnosticsInterval > 0 {
+		ticker := time.NewTicker(w.opts.diagnosticsInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var sigs chan os.Signal
+	if len(w.opts.diagnosticsSignals) > 0 {
+		sigs = make(chan os.Signal, 1)
+		signal.Notify(sigs, w.opts.diagnosticsSignals...)
+		defer signal.Stop(sigs)
+	}
+
+	for {
+		select {
+		case <-w.closedCh:
+			return
+		case <-tick:
+		case <-sigs:
+		}
+		if err := w.opts.diagnostics(w); err != nil {
+			w.log(LevelWarn, "Failed to dump diagnostics", "error", err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lastDiagnostics decodes the last dump written to logs
+func lastDiagnostics(t *testing.T, dump string) (Diagnostics, bool) {
+	var last Diagnostics
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(dump))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("Dump is not valid JSON: %q, error: %s", scanner.Text(), err)
+		}
+		found = true
+	}
+	return last, found
+}
+
+func TestDiagnosticsDump(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var dump syncBuffer
+	w, err := NewWithOptions(ctx, "fake://diagnostics", WithURLMux((&fakeBroker{}).mux()),
+		WithInstanceID("node-1"), WithRecentMessages(2),
+		// every update exceeds the budget, so each one is shed
+		WithMemoryBudget(0, 1),
+		WithDiagnosticsDump(&dump, time.Millisecond*20))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	for i := 0; i < 3; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return w.Stats().Shed == 3 })
+
+	var d Diagnostics
+	waitFor(t, time.Second*5, func() bool {
+		var ok bool
+		d, ok = lastDiagnostics(t, dump.String())
+		return ok && d.Stats.Shed == 3
+	})
+
+	if d.InstanceID != "node-1" || !d.Connected {
+		t.Fatalf("Unexpected watcher state in dump: %+v", d)
+	}
+	if origin, ok := d.KnownOrigins["node-1"]; !ok || origin.LastSequence != 3 {
+		t.Fatalf("Expected node-1 at sequence 3 in known origins, got %+v", d.KnownOrigins)
+	}
+	if len(d.RecentMessages) != 2 || d.RecentMessages[0].Sequence != 2 || d.RecentMessages[1].Sequence != 3 {
+		t.Fatalf("Expected the last 2 messages, got %+v", d.RecentMessages)
+	}
+	if d.RecentMessages[1].Op != Update || d.RecentMessages[1].Outcome != Acked {
+		t.Fatalf("Unexpected recent message: %+v", d.RecentMessages[1])
+	}
+}
+
+func TestDiagnosticsClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	w, err := NewWithOptions(ctx, "fake://diagnostics-clock", WithURLMux((&fakeBroker{}).mux()),
+		WithInstanceID("node-1"), WithRecentMessages(1), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return len(w.Diagnostics().RecentMessages) == 1 })
+
+	now := clock.Now()
+	d := w.Diagnostics()
+	if !d.Time.Equal(now) || !d.RecentMessages[0].Received.Equal(now) || !d.KnownOrigins["node-1"].LastSeen.Equal(now) {
+		t.Fatalf("Expected every diagnostic time at %s, got %+v", now, d)
+	}
+}
+
+func TestDiagnosticsFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "watcher.json")
+	w, err := NewWithOptions(ctx, "fake://diagnostics", WithURLMux((&fakeBroker{}).mux()),
+		WithInstanceID("node-1"), WithDiagnosticsFile(path, time.Millisecond*20))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return false
+		}
+		d, ok := lastDiagnostics(t, string(content))
+		return ok && d.KnownOrigins["node-1"].LastSequence == 1
+	})
+
+	// each dump replaces the previous one
+	time.Sleep(time.Millisecond * 60)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dump: %s", err)
+	}
+	if n := strings.Count(string(content), "\n"); n != 1 {
+		t.Fatalf("Expected a single dump in the file, got %d", n)
+	}
+}
+
+func TestLeaveAnnouncement(t *testing.T) {
+	ctx := context.Background()
+
+	broker := &fakeBroker{}
+	observer, err := NewWithOptions(ctx, "fake://leave", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer observer.Close()
+	leaving, err := NewWithOptions(ctx, "fake://leave", WithURLMux(broker.mux()), WithInstanceID("leaving"), WithLeaveAnnouncement())
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+
+	if err := leaving.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		_, ok := observer.KnownOrigins()["leaving"]
+		return ok
+	})
+
+	leaving.Close()
+	waitFor(t, time.Second*5, func() bool {
+		_, ok := observer.KnownOrigins()["leaving"]
+		return !ok
+	})
+}
+
+func TestDebugHandler(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://debug", WithURLMux((&fakeBroker{}).mux()),
+		WithInstanceID("debug"), WithRecentMessages(5))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return len(w.RecentMessages()) == 1 })
+
+	server := httptest.NewServer(w.DebugHandler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/debug/casbin-watcher")
+	if err != nil {
+		t.Fatalf("GET failed: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response %s, %s", res.Status, res.Header.Get("Content-Type"))
+	}
+	var diag Diagnostics
+	if err := json.NewDecoder(res.Body).Decode(&diag); err != nil {
+		t.Fatalf("Response is not valid JSON: %s", err)
+	}
+	if diag.InstanceID != "debug" || !diag.Connected || diag.KnownOrigins["debug"].LastSequence != 1 ||
+		len(diag.RecentMessages) != 1 || diag.RecentMessages[0].Outcome != Acked {
+		t.Fatalf("Response doesn't reflect the watcher state: %+v", diag)
+	}
+	if h := diag.Health; !h.Connected || !h.Healthy || h.Reason != "subscription opened" || h.Since.IsZero() {
+		t.Fatalf("Response doesn't reflect the connection state: %+v", h)
+	}
+
+	res, err = http.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected POST to be rejected, got %s", res.Status)
+	}
+
+	w.Close()
+	res, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a closed watcher to be reported unavailable, got %s", res.Status)
+	}
+	diag = Diagnostics{}
+	if err := json.NewDecoder(res.Body).Decode(&diag); err != nil {
+		t.Fatalf("Response is not valid JSON: %s", err)
+	}
+	if h := diag.Health; h.Connected || h.Reason != "closed" {
+		t.Fatalf("Response doesn't reflect the closed connection: %+v", h)
+	}
+}
@@ -0,0 +1,56 @@
+package watcher
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrTopicDisabled is wrapped by the send errors of a topic that could be
+// opened but refuses messages because it's disabled, e.g. a Service Bus
+// topic disabled or send-disabled in the portal. DefaultErrorClassifier
+// treats it as Fatal, as the topic needs to be re-enabled.
+var ErrTopicDisabled = errors.New("topic is disabled")
+
+// TopicDisabledDetector reports whether err, returned when sending, means
+// the topic is disabled.
+type TopicDisabledDetector func(err error) bool
+
+var (
+	topicDisabledDetectorsMu sync.RWMutex
+	topicDisabledDetectors   []TopicDisabledDetector
+)
+
+// RegisterTopicDisabledDetector adds d to the detectors consulted when a send
+// fails. Drivers whose provider can disable topics register one on import.
+func RegisterTopicDisabledDetector(d TopicDisabledDetector) {
+	topicDisabledDetectorsMu.Lock()
+	defer topicDisabledDetectorsMu.Unlock()
+	topicDisabledDetectors = append(topicDisabledDetectors, d)
+}
+
+// topicDisabledError wraps a send error a detector recognized, so it matches
+// both ErrTopicDisabled and the provider error
+type topicDisabledError struct {
+	err error
+}
+
+func (e *topicDisabledError) Error() string {
+	return ErrTopicDisabled.Error() + ": " + e.err.Error()
+}
+
+func (e *topicDisabledError) Unwrap() error { return e.err }
+
+func (e *topicDisabledError) Is(target error) bool { return target == ErrTopicDisabled }
+
+// detectTopicDisabled wraps err with ErrTopicDisabled when a registered
+// detector recognizes it, and returns it unchanged otherwise
+func detectTopicDisabled(err error) error {
+	topicDisabledDetectorsMu.RLock()
+	defer topicDisabledDetectorsMu.RUnlock()
+	for _, d := range topicDisabledDetectors {
+		if d(err) {
+			return &topicDisabledError{err: err}
+		}
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+)
+
+// SetUpdateCallbackDrained is like SetUpdateCallback but, once callbackFunc
+// is set, waits for the calls of the previous callbacks still running to
+// return, e.g. before releasing the enforcer they reloaded. Updates received
+// meanwhile already go to callbackFunc. It returns ctx's error if ctx is
+// done first, with callbackFunc set all the same.
+func (w *Watcher) SetUpdateCallbackDrained(ctx context.Context, callbackFunc func(string)) error {
+	w.connMu.Lock()
+	w.callbackFunc = callbackFunc
+	swapped := w.callbackRuns.ticket()
+	w.connMu.Unlock()
+	if callbackFunc != nil {
+		w.callbackSet()
+	}
+	return w.callbackRuns.waitBefore(ctx, swapped)
+}
+
+// callbackRuns tracks the running calls of the legacy callback, numbered in
+// the order they started
+type callbackRuns struct {
+	mu      sync.Mutex
+	next    uint64
+	running map[uint64]bool
+	// changed is closed and replaced when a call returns
+	changed chan struct{}
+}
+
+// ticket returns the number of the next call to start
+func (r *callbackRuns) ticket() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next
+}
+
+// start records a call starting and returns the func recording its return
+func (r *callbackRuns) start() (finished func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		r.running = map[uint64]bool{}
+		r.changed = make(chan struct{})
+	}
+	n := r.next
+	r.next++
+	r.running[n] = true
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.running, n)
+		close(r.changed)
+		r.changed = make(chan struct{})
+	}
+}
+
+// waitBefore waits for the calls numbered below ticket to return
+func (r *callbackRuns) waitBefore(ctx context.Context, ticket uint64) error {
+	for {
+		r.mu.Lock()
+		pending := false
+		for n := range r.running {
+			if n < ticket {
+				pending = true
+				break
+			}
+		}
+		changed := r.changed
+		r.mu.Unlock()
+		if !pending {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// beginLegacyCallback returns the legacy callback, if set, and the func to
+// call once the call returned
+func (w *Watcher) beginLegacyCallback() (callback func(string), finished func()) {
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	if w.callbackFunc == nil {
+		return nil, nil
+	}
+	return w.callbackFunc, w.callbackRuns.start()
+}
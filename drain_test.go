@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetUpdateCallbackDrained(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://drain", WithURLMux((&fakeBroker{}).mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	w.SetUpdateCallback(func(string) {
+		close(started)
+		<-release
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The callback didn't start")
+	}
+
+	// the plain swap doesn't wait for the running callback
+	swapped := make(chan struct{})
+	go func() {
+		w.SetUpdateCallback(func(string) {})
+		close(swapped)
+	}()
+	select {
+	case <-swapped:
+	case <-time.After(time.Second * 5):
+		t.Fatal("SetUpdateCallback blocked on the running callback")
+	}
+
+	short, cancelShort := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancelShort()
+	if err := w.SetUpdateCallbackDrained(short, func(string) {}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the drained swap to wait for the running callback, got: %v", err)
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- w.SetUpdateCallbackDrained(ctx, func(string) {})
+	}()
+	select {
+	case err := <-drained:
+		t.Fatalf("The drained swap returned before the callback did: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(release)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Failed to swap the callback: %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The drained swap didn't return once the callback did")
+	}
+}
@@ -1,6 +1,83 @@
 package awssnssqs
 
 import (
+	"context"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqsv2 "github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	awsv1 "github.com/aws/aws-sdk-go/aws"
+	sqsv1 "github.com/aws/aws-sdk-go/service/sqs"
+	"gocloud.dev/pubsub"
+
+	watcher "github.com/fresh8gaming/casbin-go-cloud-watcher"
+
 	// initialize aws sns & sqs drivers
 	_ "gocloud.dev/pubsub/awssnssqs"
 )
+
+func init() {
+	watcher.RegisterMessageIDExtractor(messageID)
+	watcher.RegisterLeaseExtender(extendLease)
+}
+
+// messageID returns the SQS message ID, with either version of the AWS SDK.
+func messageID(msg *pubsub.Message) (string, bool) {
+	var m sqstypes.Message
+	if msg.As(&m) {
+		return aws.ToString(m.MessageId), true
+	}
+	var mv1 *sqsv1.Message
+	if msg.As(&mv1) {
+		return awsv1.StringValue(mv1.MessageId), true
+	}
+	return "", false
+}
+
+// extendLease changes the visibility timeout of the SQS message, with either
+// version of the AWS SDK.
+func extendLease(ctx context.Context, subURL string, sub *pubsub.Subscription, msg *pubsub.Message, d time.Duration) error {
+	timeout := int32(d / time.Second)
+	var client *sqsv2.Client
+	var m sqstypes.Message
+	if sub.As(&client) && msg.As(&m) {
+		queueURL, err := queueURL(subURL)
+		if err != nil {
+			return err
+		}
+		_, err = client.ChangeMessageVisibility(ctx, &sqsv2.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(queueURL),
+			ReceiptHandle:     m.ReceiptHandle,
+			VisibilityTimeout: timeout,
+		})
+		return err
+	}
+	var clientv1 *sqsv1.SQS
+	var mv1 *sqsv1.Message
+	if sub.As(&clientv1) && msg.As(&mv1) {
+		queueURL, err := queueURL(subURL)
+		if err != nil {
+			return err
+		}
+		_, err = clientv1.ChangeMessageVisibilityWithContext(ctx, &sqsv1.ChangeMessageVisibilityInput{
+			QueueUrl:          awsv1.String(queueURL),
+			ReceiptHandle:     mv1.ReceiptHandle,
+			VisibilityTimeout: awsv1.Int64(int64(timeout)),
+		})
+		return err
+	}
+	return watcher.ErrLeaseUnsupported
+}
+
+// queueURL derives the queue URL from an awssqs:// subscription URL, like
+// the awssqs URL opener.
+func queueURL(subURL string) (string, error) {
+	u, err := url.Parse(subURL)
+	if err != nil {
+		return "", err
+	}
+	return "https://" + path.Join(u.Host, u.Path), nil
+}
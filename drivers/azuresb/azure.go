@@ -1,6 +1,61 @@
 package azuresb
 
 import (
+	"context"
+	"strings"
+	"time"
+
+	servicebus "github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"gocloud.dev/pubsub"
+
+	watcher "github.com/fresh8gaming/casbin-go-cloud-watcher"
+
 	// Enable Azure driver
 	_ "gocloud.dev/pubsub/azuresb"
 )
+
+func init() {
+	watcher.RegisterScheduler(schedule)
+	watcher.RegisterMessageIDExtractor(messageID)
+	watcher.RegisterTopicDisabledDetector(topicDisabled)
+}
+
+// topicDisabled reports whether err carries the AMQP condition Service Bus
+// returns for disabled and send-disabled entities. The SDK doesn't expose the
+// condition, only the error text.
+func topicDisabled(err error) bool {
+	return strings.Contains(err.Error(), "com.microsoft:entity-disabled")
+}
+
+// messageID returns the Service Bus message ID, which the sender may have
+// chosen.
+func messageID(msg *pubsub.Message) (string, bool) {
+	var rm *servicebus.ReceivedMessage
+	if !msg.As(&rm) {
+		return "", false
+	}
+	return rm.MessageID, true
+}
+
+// schedule sends m as a Service Bus scheduled message, with the metadata
+// carried as application properties like the driver does for other sends.
+func schedule(ctx context.Context, topic *pubsub.Topic, m *pubsub.Message, at time.Time) (func(context.Context) error, error) {
+	var sender *servicebus.Sender
+	if !topic.As(&sender) {
+		return nil, watcher.ErrSchedulingUnsupported
+	}
+	sbm := &servicebus.Message{Body: m.Body}
+	if len(m.Metadata) > 0 {
+		sbm.ApplicationProperties = make(map[string]interface{}, len(m.Metadata))
+		for k, v := range m.Metadata {
+			sbm.ApplicationProperties[k] = v
+		}
+	}
+	seqs, err := sender.ScheduleMessages(ctx, []*servicebus.Message{sbm}, at, nil)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		return sender.CancelScheduledMessages(ctx, seqs, nil)
+	}, nil
+}
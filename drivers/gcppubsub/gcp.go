@@ -1,6 +1,92 @@
 package gcppubsub
 
 import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	raw "cloud.google.com/go/pubsub/apiv1"
+	"gocloud.dev/pubsub"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+
+	watcher "github.com/fresh8gaming/casbin-go-cloud-watcher"
+
 	// Enable GCP driver
 	_ "gocloud.dev/pubsub/gcppubsub"
 )
+
+func init() {
+	watcher.RegisterBindingVerifier(verifyBinding)
+	watcher.RegisterMessageIDExtractor(messageID)
+	watcher.RegisterLeaseExtender(extendLease)
+}
+
+// messageID returns the ID Pub/Sub assigned to the message.
+func messageID(msg *pubsub.Message) (string, bool) {
+	var pm *pb.PubsubMessage
+	if !msg.As(&pm) {
+		return "", false
+	}
+	return pm.MessageId, true
+}
+
+// extendLease modifies the ack deadline of the message.
+func extendLease(ctx context.Context, subURL string, sub *pubsub.Subscription, msg *pubsub.Message, d time.Duration) error {
+	var client *raw.SubscriberClient
+	var rm *pb.ReceivedMessage
+	if !sub.As(&client) || !msg.As(&rm) {
+		return watcher.ErrLeaseUnsupported
+	}
+	subPath, err := resourcePath(subURL, "subscriptions")
+	if err != nil {
+		return err
+	}
+	return client.ModifyAckDeadline(ctx, &pb.ModifyAckDeadlineRequest{
+		Subscription:       subPath,
+		AckIds:             []string{rm.AckId},
+		AckDeadlineSeconds: int32(d / time.Second),
+	})
+}
+
+// verifyBinding checks the topic the GCP subscription is attached to.
+func verifyBinding(ctx context.Context, topicURL, subURL string, sub *pubsub.Subscription) error {
+	var client *raw.SubscriberClient
+	if !sub.As(&client) || !strings.HasPrefix(topicURL, "gcppubsub://") {
+		// a topic on another provider may be mirrored into the subscription
+		return watcher.ErrBindingUnknown
+	}
+	topicPath, err := resourcePath(topicURL, "topics")
+	if err != nil {
+		return err
+	}
+	subPath, err := resourcePath(subURL, "subscriptions")
+	if err != nil {
+		return err
+	}
+
+	s, err := client.GetSubscription(ctx, &pb.GetSubscriptionRequest{Subscription: subPath})
+	if err != nil {
+		return fmt.Errorf("failed to get subscription %s, error: %w", subPath, err)
+	}
+	if s.Topic != topicPath {
+		return fmt.Errorf("%w: %s is bound to %s, not %s", watcher.ErrTopicMismatch, subPath, s.Topic, topicPath)
+	}
+	return nil
+}
+
+// resourcePath turns gcppubsub://projects/p/<kind>/name and the short
+// gcppubsub://p/name form into a full resource path.
+func resourcePath(rawURL, kind string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	p := path.Join(u.Host, u.Path)
+	if strings.HasPrefix(p, "projects/") {
+		return p, nil
+	}
+	return path.Join("projects", u.Host, kind, strings.TrimPrefix(u.Path, "/")), nil
+}
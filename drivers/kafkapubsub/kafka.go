@@ -1,6 +1,40 @@
 package kafkapubsub
 
 import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"gocloud.dev/pubsub"
+
+	watcher "github.com/fresh8gaming/casbin-go-cloud-watcher"
+
 	// Enable Kafka driver
 	_ "gocloud.dev/pubsub/kafkapubsub"
 )
+
+func init() {
+	watcher.RegisterMessageIDExtractor(messageID)
+	watcher.RegisterCompactionKeySetter(setCompactionKey)
+}
+
+// messageID identifies the message by its topic, partition and offset as
+// topic/partition/offset, Kafka has no message IDs.
+func messageID(msg *pubsub.Message) (string, bool) {
+	var cm *sarama.ConsumerMessage
+	if !msg.As(&cm) {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%d/%d", cm.Topic, cm.Partition, cm.Offset), true
+}
+
+// setCompactionKey sets the record key, which a topic with
+// cleanup.policy=compact retains the latest record of. It takes precedence
+// over the key read from the metadata with the KeyName option.
+func setCompactionKey(as func(interface{}) bool, key string) bool {
+	var pm *sarama.ProducerMessage
+	if !as(&pm) {
+		return false
+	}
+	pm.Key = sarama.StringEncoder(key)
+	return true
+}
@@ -1,6 +1,25 @@
 package rabbitpubsub
 
 import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gocloud.dev/pubsub"
+
+	watcher "github.com/fresh8gaming/casbin-go-cloud-watcher"
+
 	// Enable RabbitMQ driver
 	_ "gocloud.dev/pubsub/rabbitpubsub"
 )
+
+func init() {
+	watcher.RegisterMessageIDExtractor(messageID)
+}
+
+// messageID returns the AMQP message-id property, empty unless the publisher
+// set one.
+func messageID(msg *pubsub.Message) (string, bool) {
+	var d amqp.Delivery
+	if !msg.As(&d) {
+		return "", false
+	}
+	return d.MessageId, true
+}
@@ -0,0 +1,47 @@
+package watcher
+
+import (
+	"encoding/base64"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithDualFormat eases rolling out structured updates to a fleet still
+// running watchers that only understand the "Casbin Update" body. Until
+// the given time, the UpdateFor* methods send that body, which makes
+// every receiver reload, and carry the encoded update in the metadata.
+// Receivers aware of it prefer the structured update whenever it is there,
+// whether or not they use this option themselves.
+func WithDualFormat(until time.Time) Option {
+	return optionFunc(func(o *options) {
+		o.dualFormatUntil = until
+	})
+}
+
+// newDualFormatMessage builds the message of an update encoded as body
+// when WithDualFormat is in its transition period, or returns nil
+func (w *Watcher) newDualFormatMessage(body []byte, op UpdateType) *pubsub.Message {
+	if !w.opts.clock.Now().Before(w.opts.dualFormatUntil) {
+		return nil
+	}
+	m := w.newMessage(w.reloadSignal(), op)
+	m.Metadata[w.metadataKey(metaPayload)] = base64.StdEncoding.EncodeToString(body)
+	return m
+}
+
+// preferStructured replaces the legacy body of a dual format message by the
+// update it carries. A payload that can't be decoded leaves the legacy body,
+// which still makes the callbacks reload.
+func (w *Watcher) preferStructured(msg *pubsub.Message) {
+	payload, ok := msg.Metadata[w.metadataKey(metaPayload)]
+	if !ok {
+		return
+	}
+	body, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		w.log(LevelWarn, "Ignoring undecodable structured payload", "error", err, "id", msg.LoggableID)
+		return
+	}
+	msg.Body = body
+}
@@ -0,0 +1,76 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDualFormat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	clock := newFakeClock()
+	publisher, err := NewWithOptions(ctx, "fake://dual", WithURLMux(broker.mux()),
+		WithClock(clock), WithDualFormat(clock.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("Failed to create publisher, error: %s", err)
+	}
+	defer publisher.Close()
+	structured, err := NewWithOptions(ctx, "fake://dual", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create structured receiver, error: %s", err)
+	}
+	defer structured.Close()
+	legacy, err := NewWithOptions(ctx, "fake://dual", WithURLMux(broker.mux()), WithLegacyMode())
+	if err != nil {
+		t.Fatalf("Failed to create legacy receiver, error: %s", err)
+	}
+	defer legacy.Close()
+
+	updates := make(chan UpdateMessage, 2)
+	structured.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		updates <- um
+		return nil
+	})
+	bodies := make(chan string, 2)
+	legacy.SetUpdateCallback(func(body string) { bodies <- body })
+
+	receive := func() (UpdateMessage, string) {
+		t.Helper()
+		var um UpdateMessage
+		var body string
+		for i := 0; i < 2; i++ {
+			select {
+			case um = <-updates:
+			case body = <-bodies:
+			case <-time.After(time.Second * 5):
+				t.Fatal("The update wasn't received by both watchers")
+			}
+		}
+		return um, body
+	}
+
+	// during the transition both receivers understand the same message
+	if err := publisher.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	um, body := receive()
+	if um.Op != UpdateForAddPolicy || len(um.Params) != 3 || um.Params[0] != "alice" {
+		t.Fatalf("Expected the structured update, got %+v", um)
+	}
+	if body != legacyUpdateBody {
+		t.Fatalf("Expected the legacy receiver to get %q, got %q", legacyUpdateBody, body)
+	}
+
+	// afterwards only the structured body is sent
+	clock.advance(time.Hour)
+	if err := publisher.UpdateForRemovePolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	um, body = receive()
+	if um.Op != UpdateForRemovePolicy || body == legacyUpdateBody {
+		t.Fatalf("Expected a structured body after the transition, got %+v and %q", um, body)
+	}
+}
@@ -0,0 +1,124 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrDuplicateInstanceID is matched by the *DuplicateInstanceError sent on
+// the Errors channel
+var ErrDuplicateInstanceID = errors.New("instance ID used by more than one process")
+
+// DuplicateIDStrategy sets how the watcher reacts to an instance ID used by
+// more than one process
+type DuplicateIDStrategy int
+
+// Duplicate instance ID strategies
+const (
+	// DuplicateIDWarn logs a warning and sends a *DuplicateInstanceError on
+	// the Errors channel.
+	DuplicateIDWarn DuplicateIDStrategy = iota
+	// DuplicateIDDeliver also stops self-filtering the messages stamped
+	// with this watcher's instance ID by another process, so its updates
+	// aren't lost to the ambiguous ID.
+	DuplicateIDDeliver
+)
+
+// WithDuplicateIDStrategy sets the reaction to an instance ID seen from two
+// processes at the same time, DuplicateIDWarn by default. Processes are told
+// apart by the hostname and PID stamped with WithProcessMetadata, so
+// publishers not using it are never reported. An ID seen from a second
+// hostname is reported right away; one moving to a new PID on the same host
+// is only reported once the previous process comes back, which a restart
+// doesn't explain.
+func WithDuplicateIDStrategy(strategy DuplicateIDStrategy) Option {
+	return optionFunc(func(o *options) {
+		o.duplicateIDStrategy = strategy
+	})
+}
+
+// DuplicateInstanceError reports an instance ID used from two hosts, or
+// alternating between processes of one host
+type DuplicateInstanceError struct {
+	InstanceID string
+	// Processes are the hostname/PID pairs seen using the ID.
+	Processes []string
+}
+
+func (e *DuplicateInstanceError) Error() string {
+	return fmt.Sprintf("instance ID %q used by %v", e.InstanceID, e.Processes)
+}
+
+func (e *DuplicateInstanceError) Is(target error) bool { return target == ErrDuplicateInstanceID }
+
+// processIdentity is the hostname/PID pair identifying the publisher of um,
+// or empty when it wasn't stamped
+func processIdentity(um UpdateMessage) string {
+	if um.Hostname == "" && um.PID == 0 {
+		return ""
+	}
+	return um.Hostname + "/" + strconv.Itoa(um.PID)
+}
+
+// trackIdentity records the process behind an update from origin. It must be
+// called with w.diag.mu held and returns the error to report when the
+// origin turns out to be shared: it moves to another host, or a process it
+// had been replaced by comes back.
+func (w *Watcher) trackIdentity(origin string, info *OriginInfo, um UpdateMessage) *DuplicateInstanceError {
+	id := processIdentity(um)
+	current := processIdentity(UpdateMessage{Hostname: info.Hostname, PID: info.PID})
+	if id == "" || id == current {
+		return nil
+	}
+	hostname := info.Hostname
+	info.Hostname, info.PID = um.Hostname, um.PID
+	if current == "" {
+		return nil
+	}
+
+	replaced := w.diag.replaced[origin]
+	if replaced == nil {
+		replaced = map[string]bool{}
+		w.diag.replaced[origin] = replaced
+	}
+	replaced[current] = true
+	// a restart keeps the hostname
+	moved := hostname != "" && um.Hostname != "" && hostname != um.Hostname
+	if (!moved && !replaced[id]) || info.Conflicting {
+		return nil
+	}
+	info.Conflicting = true
+	return &DuplicateInstanceError{InstanceID: origin, Processes: []string{current, id}}
+}
+
+// reportDuplicate logs and sends err, which mustn't be called while holding
+// w.diag.mu
+func (w *Watcher) reportDuplicate(err *DuplicateInstanceError) {
+	w.log(LevelWarn, "Instance ID used by more than one process", "origin", err.InstanceID, "processes", err.Processes)
+	w.pushError(err)
+}
+
+// isOwnMessage reports whether msg should be self-filtered: it carries this
+// watcher's instance ID and, with DuplicateIDDeliver, no other process's
+// hostname and PID
+func (w *Watcher) isOwnMessage(msg *pubsub.Message) bool {
+	if !w.isSelf(msg) {
+		return false
+	}
+	if w.opts.duplicateIDStrategy != DuplicateIDDeliver {
+		return true
+	}
+	var um UpdateMessage
+	w.readMetadata(msg, &um)
+	id := processIdentity(um)
+	return id == "" || id == localProcessIdentity()
+}
+
+func localProcessIdentity() string {
+	hostname, _ := os.Hostname()
+	return processIdentity(UpdateMessage{Hostname: hostname, PID: os.Getpid()})
+}
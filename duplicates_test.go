@@ -0,0 +1,144 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// stampedMessage is an update from origin claiming to be published by the
+// process hostname/pid
+func stampedMessage(w *Watcher, origin, seq, hostname, pid string) *pubsub.Message {
+	return &pubsub.Message{Body: []byte(legacyUpdateBody), Metadata: map[string]string{
+		w.metadataKey(metaOrigin):   origin,
+		w.metadataKey(metaSequence): seq,
+		w.metadataKey(metaOp):       string(Update),
+		w.metadataKey(metaHostname): hostname,
+		w.metadataKey(metaPID):      pid,
+	}}
+}
+
+func TestDuplicateInstanceID(t *testing.T) {
+	ctx := context.Background()
+
+	var logs syncBuffer
+	w, err := NewWithOptions(ctx, "fake://duplicates", WithURLMux((&fakeBroker{}).mux()),
+		WithErrorChannel(4), WithLogger(NewJSONLogger(&logs)))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	messages := []*pubsub.Message{
+		// a restart moves the ID to a new process, which isn't a conflict
+		stampedMessage(w, "restarted", "7", "host-a", "10"),
+		stampedMessage(w, "restarted", "1", "host-a", "30"),
+		// two processes sharing the ID take turns
+		stampedMessage(w, "shared", "1", "host-a", "10"),
+		stampedMessage(w, "shared", "1", "host-b", "20"),
+		stampedMessage(w, "shared", "2", "host-a", "10"),
+		stampedMessage(w, "shared", "3", "host-b", "20"),
+	}
+	for _, m := range messages {
+		if err := w.topic.Send(ctx, m); err != nil {
+			t.Fatalf("Failed to send message: %s", err)
+		}
+	}
+
+	select {
+	case err := <-w.Errors():
+		var dup *DuplicateInstanceError
+		if !errors.Is(err, ErrDuplicateInstanceID) || !errors.As(err, &dup) || dup.InstanceID != "shared" {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Duplicate instance ID wasn't reported")
+	}
+	waitFor(t, time.Second*5, func() bool {
+		return w.KnownOrigins()["shared"].LastSequence == 3
+	})
+	select {
+	case err := <-w.Errors():
+		t.Fatalf("Conflict reported more than once: %v", err)
+	default:
+	}
+	if !strings.Contains(logs.String(), "Instance ID used by more than one process") {
+		t.Fatalf("No warning logged: %s", logs.String())
+	}
+	origins := w.KnownOrigins()
+	if !origins["shared"].Conflicting || origins["restarted"].Conflicting {
+		t.Fatalf("Unexpected origins: %+v", origins)
+	}
+}
+
+func TestDuplicateInstanceIDOtherHost(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://duplicates", WithURLMux((&fakeBroker{}).mux()),
+		WithErrorChannel(4), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	// a second host is reported without waiting for the first to come back
+	for _, m := range []*pubsub.Message{
+		stampedMessage(w, "shared", "1", "host-a", "10"),
+		stampedMessage(w, "shared", "2", "host-b", "10"),
+	} {
+		if err := w.topic.Send(ctx, m); err != nil {
+			t.Fatalf("Failed to send message: %s", err)
+		}
+	}
+
+	select {
+	case err := <-w.Errors():
+		var dup *DuplicateInstanceError
+		if !errors.As(err, &dup) || dup.InstanceID != "shared" ||
+			len(dup.Processes) != 2 || dup.Processes[0] != "host-a/10" || dup.Processes[1] != "host-b/10" {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Duplicate instance ID wasn't reported")
+	}
+	if !w.KnownOrigins()["shared"].Conflicting {
+		t.Fatalf("Expected the origin to be conflicting: %+v", w.KnownOrigins())
+	}
+}
+
+func TestDuplicateIDDeliver(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://duplicates", WithURLMux((&fakeBroker{}).mux()), WithInstanceID("me"),
+		WithSelfFilter(SelfFilterAll), WithDuplicateIDStrategy(DuplicateIDDeliver))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	received := make(chan string, 2)
+	w.SetUpdateCallback(func(msg string) {
+		received <- msg
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.topic.Send(ctx, stampedMessage(w, "me", "1", "elsewhere", "1")); err != nil {
+		t.Fatalf("Failed to send message: %s", err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update from another process using the same ID was filtered")
+	}
+	select {
+	case <-received:
+		t.Fatal("Own update wasn't filtered")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin"
+)
+
+// enforcerLane is an enforcer registered with AddEnforcer. Its updates are
+// chained so they're applied one at a time, in the order received.
+type enforcerLane struct {
+	e     *casbin.Enforcer
+	lock  sync.Locker
+	order lane
+	mu    sync.Mutex
+	// applied is when an update was last applied successfully, see
+	// LastApplied
+	applied time.Time
+}
+
+// AddEnforcer registers e to receive every update, applied with ApplyTo
+// while holding lock, or a mutex of its own if nil, e.g. for several
+// enforcers of one model in a process. Each enforcer gets the updates in the
+// order they were received, one at a time, and independently of the other
+// enforcers and callbacks, so a slow reload of one doesn't hold back the
+// others. Failures are handled like those of callbacks. The updates still
+// share the WithCallbackConcurrency slots and WithApplyLock, if given. The
+// returned func unregisters e.
+func (w *Watcher) AddEnforcer(e *casbin.Enforcer, lock sync.Locker) (remove func()) {
+	if lock == nil {
+		lock = &sync.Mutex{}
+	}
+	lane := &enforcerLane{e: e, lock: lock}
+	w.connMu.Lock()
+	w.enforcers = append(w.enforcers, lane)
+	w.connMu.Unlock()
+	w.callbackSet()
+	return func() {
+		w.connMu.Lock()
+		defer w.connMu.Unlock()
+		for i, l := range w.enforcers {
+			if l == lane {
+				w.enforcers = append(w.enforcers[:i:i], w.enforcers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// apply applies um to the enforcer under its lock
+func (l *enforcerLane) apply(um UpdateMessage) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return ApplyTo(l.e, um)
+}
+
+// dispatchToEnforcer queues um for the enforcer of lane and calls done once
+// it was applied. It must be called in the order updates are received.
+func (w *Watcher) dispatchToEnforcer(lane *enforcerLane, size int, um UpdateMessage, done func(error)) {
+	prev, leave := lane.order.join()
+	w.routines.start(func() {
+		<-prev
+		w.dispatch(size, um.Priority, func() error {
+			return w.applyToEnforcer(lane, um)
+		}, w.recordApplied(&lane.applied, &lane.mu, func(err error) {
+			leave()
+			done(err)
+		}))
+	})
+}
@@ -0,0 +1,69 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin"
+)
+
+func TestAddEnforcer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://enforcers", WithURLMux((&fakeBroker{}).mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	slow := casbin.NewEnforcer("./test_data/model.conf", "./test_data/policy.csv")
+	fast := casbin.NewEnforcer("./test_data/model.conf", "./test_data/policy.csv")
+	slowLock, fastLock := &sync.Mutex{}, &sync.Mutex{}
+	w.AddEnforcer(slow, slowLock)
+	w.AddEnforcer(fast, fastLock)
+	has := func(e *casbin.Enforcer, l sync.Locker) bool {
+		l.Lock()
+		defer l.Unlock()
+		return e.Enforce("carol", "data3", "read")
+	}
+
+	// the slow enforcer is stuck in a reload
+	slowLock.Lock()
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.UpdateForAddPolicy("p", "p", "carol", "data3", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return has(fast, fastLock) })
+
+	slowLock.Unlock()
+	waitFor(t, time.Second*5, func() bool { return has(slow, slowLock) })
+}
+
+func TestRemoveEnforcer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://enforcers", WithURLMux((&fakeBroker{}).mux()), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	e := casbin.NewEnforcer("./test_data/model.conf", "./test_data/policy.csv")
+	remove := w.AddEnforcer(e, nil)
+	remove()
+	if err := w.UpdateForAddPolicy("p", "p", "carol", "data3", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+	time.Sleep(time.Millisecond * 50)
+	if e.Enforce("carol", "data3", "read") {
+		t.Fatal("The update was applied to a removed enforcer")
+	}
+}
@@ -0,0 +1,313 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/driver"
+)
+
+// fakeBroker is an in-process provider delivering every message to every
+// open subscription in publish order. Unlike mempubsub it keeps ordering and
+// lets tests reach the driver level to inject failures.
+type fakeBroker struct {
+	mu        sync.Mutex
+	subs      []*fakeSubscription
+	nextAckID int
+
+	// topicAs and subAs back the As methods of the driver types
+	topicAs func(i interface{}) bool
+	subAs   func(i interface{}) bool
+	// messageAs, when set, backs the As method of received messages
+	messageAs func(m *driver.Message, i interface{}) bool
+	// openTopicErr and openSubErr, when set, fail the opener
+	openTopicErr func(u *url.URL) error
+	openSubErr   func(u *url.URL) error
+	// sendErr, when set, fails SendBatch
+	sendErr func(ctx context.Context, ms []*driver.Message) error
+	// errorCode, when set, backs the ErrorCode methods of the driver types
+	errorCode func(error) gcerrors.ErrorCode
+	// noNack makes the subscriptions report that they can't nack
+	noNack bool
+}
+
+func (b *fakeBroker) code(err error) gcerrors.ErrorCode {
+	if b.errorCode == nil {
+		return gcerrors.Unknown
+	}
+	return b.errorCode(err)
+}
+
+// mux returns a URL mux serving the broker under the fake:// scheme
+func (b *fakeBroker) mux() *pubsub.URLMux {
+	mux := new(pubsub.URLMux)
+	mux.RegisterTopic("fake", b)
+	mux.RegisterSubscription("fake", b)
+	return mux
+}
+
+func (b *fakeBroker) OpenTopicURL(ctx context.Context, u *url.URL) (*pubsub.Topic, error) {
+	if b.openTopicErr != nil {
+		if err := b.openTopicErr(u); err != nil {
+			return nil, err
+		}
+	}
+	return pubsub.NewTopic(&fakeTopic{broker: b}, nil), nil
+}
+
+func (b *fakeBroker) OpenSubscriptionURL(ctx context.Context, u *url.URL) (*pubsub.Subscription, error) {
+	if b.openSubErr != nil {
+		if err := b.openSubErr(u); err != nil {
+			return nil, err
+		}
+	}
+	return pubsub.NewSubscription(b.newSubscription(), nil, nil), nil
+}
+
+func (b *fakeBroker) newSubscription() *fakeSubscription {
+	s := &fakeSubscription{broker: b, ready: make(chan struct{}, 1), acks: map[driver.AckID]bool{}, noNack: b.noNack}
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return s
+}
+
+// subscriptions returns the subscriptions opened so far
+func (b *fakeBroker) subscriptions() []*fakeSubscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*fakeSubscription(nil), b.subs...)
+}
+
+type fakeTopic struct {
+	broker *fakeBroker
+}
+
+func (t *fakeTopic) SendBatch(ctx context.Context, ms []*driver.Message) error {
+	b := t.broker
+	if b.sendErr != nil {
+		if err := b.sendErr(ctx, ms); err != nil {
+			return err
+		}
+	}
+	asFunc := func(i interface{}) bool { return b.topicAs != nil && b.topicAs(i) }
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range ms {
+		if m.BeforeSend != nil {
+			if err := m.BeforeSend(asFunc); err != nil {
+				return err
+			}
+		}
+		b.nextAckID++
+		m.AckID = b.nextAckID
+		m.LoggableID = fmt.Sprintf("fake #%d", b.nextAckID)
+		if m.AfterSend != nil {
+			if err := m.AfterSend(asFunc); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range b.subs {
+		s.add(ms)
+	}
+	return nil
+}
+
+func (t *fakeTopic) IsRetryable(error) bool { return false }
+func (t *fakeTopic) As(i interface{}) bool {
+	return t.broker.topicAs != nil && t.broker.topicAs(i)
+}
+func (t *fakeTopic) ErrorAs(error, interface{}) bool        { return false }
+func (t *fakeTopic) ErrorCode(err error) gcerrors.ErrorCode { return t.broker.code(err) }
+func (t *fakeTopic) Close() error                           { return nil }
+
+type fakeSubscription struct {
+	broker *fakeBroker
+	ready  chan struct{}
+
+	mu         sync.Mutex
+	queue      []*driver.Message
+	receiveErr error
+	closed     bool
+	// acks records the disposition of every acked (true) or nacked (false) message
+	acks map[driver.AckID]bool
+	// noNack makes the subscription report that it can't nack
+	noNack bool
+}
+
+func (s *fakeSubscription) add(ms []*driver.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for _, m := range ms {
+		c := *m
+		c.AsFunc = s.As
+		if as := s.broker.messageAs; as != nil {
+			c.AsFunc = func(i interface{}) bool { return as(&c, i) }
+		}
+		s.queue = append(s.queue, &c)
+	}
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// fail makes the next ReceiveBatch return err
+func (s *fakeSubscription) fail(err error) {
+	s.mu.Lock()
+	s.receiveErr = err
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *fakeSubscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*driver.Message, error) {
+	for {
+		s.mu.Lock()
+		if err := s.receiveErr; err != nil {
+			s.receiveErr = nil
+			s.mu.Unlock()
+			return nil, err
+		}
+		if len(s.queue) > 0 {
+			n := len(s.queue)
+			if n > maxMessages {
+				n = maxMessages
+			}
+			msgs := s.queue[:n]
+			s.queue = s.queue[n:]
+			s.mu.Unlock()
+			return msgs, nil
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.ready:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *fakeSubscription) SendAcks(ctx context.Context, ackIDs []driver.AckID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ackIDs {
+		s.acks[id] = true
+	}
+	return nil
+}
+
+func (s *fakeSubscription) CanNack() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.noNack
+}
+
+func (s *fakeSubscription) SendNacks(ctx context.Context, ackIDs []driver.AckID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ackIDs {
+		s.acks[id] = false
+	}
+	return nil
+}
+
+func (s *fakeSubscription) IsRetryable(error) bool { return false }
+func (s *fakeSubscription) As(i interface{}) bool {
+	return s.broker.subAs != nil && s.broker.subAs(i)
+}
+func (s *fakeSubscription) ErrorAs(error, interface{}) bool        { return false }
+func (s *fakeSubscription) ErrorCode(err error) gcerrors.ErrorCode { return s.broker.code(err) }
+
+func (s *fakeSubscription) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// fakeClock is a Clock whose time only moves with advance
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// advance moves the time forward by d and runs the timers due, in the
+// calling goroutine
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		switch {
+		case t.stopped:
+		case !t.at.After(c.now):
+			t.stopped = true
+			due = append(due, t)
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrRuleNotFound is the failure of an enforcer registered with AddEnforcer
+// to apply a replacement whose old rules it doesn't have, with
+// WithReloadOnApplyFailure
+var ErrRuleNotFound = errors.New("rule to replace not found")
+
+// WithReloadOnApplyFailure reloads the whole policy when the callbacks fail
+// to apply an incremental update, e.g. because the local policy diverged and
+// the rule to replace isn't found, so the watcher converges instead of
+// keeping the failure. Enforcers registered with AddEnforcer fail with
+// ErrRuleNotFound when an update from another watcher replaces rules they
+// don't have. The callbacks are run again as for a generic Update,
+// and the update counts as applied, e.g. for WithAckOnlyOnSuccess, if the
+// reload succeeds. The original failure is still reported.
+func WithReloadOnApplyFailure() Option {
+	return optionFunc(func(o *options) {
+		o.reloadOnApplyFailure = true
+	})
+}
+
+// isIncremental reports whether op changes part of the policy, rather than
+// reloading or replacing it
+func (op UpdateType) isIncremental() bool {
+	return op != Update && op != UpdateForSavePolicy
+}
+
+// replaces reports whether op replaces rules, which diverged if they're
+// missing, unlike rules added twice or removed when already gone
+func (op UpdateType) replaces() bool {
+	return op == UpdateForUpdatePolicy || op == UpdateForUpdatePolicies
+}
+
+// applyToEnforcer applies um to the enforcer of lane, failing with
+// ErrRuleNotFound when it diverged and WithReloadOnApplyFailure can reload it
+func (w *Watcher) applyToEnforcer(lane *enforcerLane, um UpdateMessage) error {
+	applied, err := lane.apply(um)
+	// the rules replaced by an update of this watcher are already gone
+	if err == nil && !applied && w.opts.reloadOnApplyFailure && um.Op.replaces() && um.Origin != w.opts.instanceID {
+		return fmt.Errorf("%w: %s.%s", ErrRuleNotFound, um.Sec, um.Ptype)
+	}
+	return err
+}
+
+// reloadOnFailure wraps the done func of the callbacks of the incremental
+// update um, received as msg, to reload the policy when they failed
+func (w *Watcher) reloadOnFailure(msg *pubsub.Message, um UpdateMessage, done func(ok bool)) func(ok bool) {
+	return func(ok bool) {
+		if ok {
+			if done != nil {
+				done(true)
+			}
+			return
+		}
+		w.log(LevelWarn, "Failed to apply incremental update, reloading the policy", "op", um.Op, "origin", um.Origin,
+			"sequence", um.Sequence, LogFieldCorrelation, um.CorrelationID)
+		reload := UpdateMessage{Op: Update, Origin: um.Origin, Sequence: um.Sequence, CorrelationID: um.CorrelationID}
+		w.executeCallback(msg, w.opts.reloadSignal, reload, nil, done)
+	}
+}
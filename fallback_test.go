@@ -0,0 +1,95 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin"
+)
+
+func TestReloadOnApplyFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://fallback", WithURLMux((&fakeBroker{}).mux()),
+		WithReloadOnApplyFailure(), WithAckOnlyOnSuccess(), recordAcks(events),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	errNotFound := errors.New("rule to replace not found")
+	applied := make(chan UpdateMessage, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		if um.Op == UpdateForUpdatePolicy {
+			return errNotFound
+		}
+		return nil
+	})
+
+	if err := w.UpdateForUpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	// the update is acked once the reload converged instead of nacked
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+
+	for i, op := range []UpdateType{UpdateForUpdatePolicy, Update} {
+		select {
+		case um := <-applied:
+			if um.Op != op {
+				t.Fatalf("Expected call %d to be %s, got %s", i+1, op, um.Op)
+			}
+			if um.Sequence != 1 {
+				t.Fatalf("Expected the reload for update 1, got %d", um.Sequence)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 2 callbacks ran", i)
+		}
+	}
+	if n := w.Stats().CallbackErrors; n != 1 {
+		t.Fatalf("Expected the failed apply to be reported, got %d errors", n)
+	}
+}
+
+func TestReloadOnApplyFailureEnforcer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://fallback", WithURLMux(broker.mux()),
+		WithReloadOnApplyFailure(), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	sender, err := NewWithOptions(ctx, "fake://fallback", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer sender.Close()
+
+	// the local policy diverged: the rule to replace is gone
+	e := casbin.NewEnforcer("./test_data/model.conf", "./test_data/policy.csv")
+	e.GetModel().RemovePolicy("p", "p", []string{"alice", "data1", "read"})
+	var mu sync.Mutex
+	w.AddEnforcer(e, &mu)
+
+	if err := sender.UpdateForUpdatePolicy("p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"}); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	// the enforcer reloaded the policy from its adapter
+	waitFor(t, time.Second*5, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return e.Enforce("alice", "data1", "read")
+	})
+	if n := w.Stats().CallbackErrors; n != 1 {
+		t.Fatalf("Expected the missing rule to be reported, got %d errors", n)
+	}
+}
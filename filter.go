@@ -0,0 +1,27 @@
+package watcher
+
+import "errors"
+
+// ErrFilterUnsupported is returned when WithSubscriptionFilter is used with a
+// subscription URL whose scheme has no registered filter parameter.
+var ErrFilterUnsupported = errors.New("subscription filters are not supported for this provider")
+
+var filterParam = subscriptionParam{unsupported: ErrFilterUnsupported}
+
+// RegisterSubscriptionFilter makes WithSubscriptionFilter pass the filter
+// expression for subscription URLs with the given scheme as the key query
+// parameter. Register one for URL openers that accept a server-side filter.
+func RegisterSubscriptionFilter(scheme, key string) {
+	filterParam.register(scheme, key)
+}
+
+// WithSubscriptionFilter passes expr to the subscription opener as the query
+// parameter registered for the subscription URL's scheme with
+// RegisterSubscriptionFilter. The filter is applied by the provider; the
+// watcher only forwards it, and fails with ErrFilterUnsupported when the
+// scheme has none registered.
+func WithSubscriptionFilter(expr string) Option {
+	return optionFunc(func(o *options) {
+		o.subscriptionFilter = expr
+	})
+}
@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func init() {
+	RegisterSubscriptionFilter("fake", "filter")
+	RegisterConsumerName("fake", "consumer")
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	ctx := context.Background()
+
+	var opened []url.Values
+	broker := &fakeBroker{openSubErr: func(u *url.URL) error {
+		opened = append(opened, u.Query())
+		return nil
+	}}
+	w, err := NewWithOptions(ctx, "fake://policy?region=eu", WithURLMux(broker.mux()),
+		WithSubscriptionFilter(`attributes.tenant = "a"`))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	w.Close()
+	if len(opened) != 1 {
+		t.Fatalf("Subscription opened %d times, want 1", len(opened))
+	}
+	if got := opened[0].Get("filter"); got != `attributes.tenant = "a"` {
+		t.Fatalf("Filter forwarded as %q", got)
+	}
+	if got := opened[0].Get("region"); got != "eu" {
+		t.Fatalf("Existing query parameter lost, got %q", got)
+	}
+
+	// without a filter the URL is left alone
+	opened = nil
+	w, err = NewWithOptions(ctx, "fake://policy", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	w.Close()
+	if _, ok := opened[0]["filter"]; ok {
+		t.Fatal("Filter parameter set without WithSubscriptionFilter")
+	}
+}
+
+func TestSubscriptionFilterUnsupported(t *testing.T) {
+	w := newWatcher("", buildOptions(WithSubscriptionURL("mem://policy"), WithSubscriptionFilter("tenant = a")))
+	_, err := w.subscriptionURL()
+	if !errors.Is(err, ErrFilterUnsupported) {
+		t.Fatalf("Expected ErrFilterUnsupported, got: %v", err)
+	}
+}
+
+func TestConsumerName(t *testing.T) {
+	ctx := context.Background()
+
+	var opened []url.Values
+	broker := &fakeBroker{openSubErr: func(u *url.URL) error {
+		opened = append(opened, u.Query())
+		return nil
+	}}
+	w, err := NewWithOptions(ctx, "fake://policy", WithURLMux(broker.mux()), WithConsumerName("policy-workers"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	w.Close()
+	if got := opened[0].Get("consumer"); got != "policy-workers" {
+		t.Fatalf("Consumer name forwarded as %q", got)
+	}
+
+	// a NATS queue group would split the updates between the watchers
+	for _, subURL := range []string{"nats://policy", "mem://policy"} {
+		w = newWatcher("", buildOptions(WithSubscriptionURL(subURL), WithConsumerName("policy-workers")))
+		if _, err := w.subscriptionURL(); !errors.Is(err, ErrConsumerNameUnsupported) {
+			t.Fatalf("Expected ErrConsumerNameUnsupported for %s, got: %v", subURL, err)
+		}
+	}
+}
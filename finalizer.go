@@ -0,0 +1,22 @@
+package watcher
+
+// WithMinimalFinalizer makes the cleanup run when a watcher that wasn't
+// closed is garbage collected only stop receiving and leave the rest of the
+// shutdown to a goroutine of its own, so a slow subscription Shutdown
+// doesn't stall the finalizer goroutine and the other finalizers behind it.
+// Close still shuts the watcher down fully before returning.
+func WithMinimalFinalizer() Option {
+	return optionFunc(func(o *options) {
+		o.minimalFinalizer = true
+	})
+}
+
+// release is the minimal finalizer: it stops the receive loop and closes
+// the watcher in the background, without blocking
+func (w *Watcher) release() {
+	w.connMu.RLock()
+	stop := w.stopLifecycle
+	w.connMu.RUnlock()
+	stop()
+	go w.closeWithTimeout()
+}
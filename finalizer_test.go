@@ -0,0 +1,60 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMinimalFinalizer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := &pipe{shutdown: make(chan struct{})}
+	newWatcher := func() *Watcher {
+		t.Helper()
+		w, err := NewWithOptions(ctx, "pipe://finalizer", withPipe(p), WithMinimalFinalizer())
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		return w
+	}
+
+	// the finalizer returns while the subscription is still shutting down
+	collected := newWatcher()
+	finalized := make(chan struct{})
+	go func() {
+		finalizer(collected)
+		close(finalized)
+	}()
+	select {
+	case <-finalized:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The finalizer blocked on the subscription shutdown")
+	}
+	select {
+	case <-collected.lifecycle().Done():
+	default:
+		t.Fatal("The finalizer didn't stop the receive loop")
+	}
+
+	// Close waits for it
+	w := newWatcher()
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the subscription shut down")
+	case <-time.After(time.Millisecond * 100):
+	}
+	close(p.shutdown)
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close didn't return once the subscription shut down")
+	}
+	waitFor(t, time.Second*5, func() bool { return collected.isClosed() })
+}
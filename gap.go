@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithGapResync reloads the whole policy when updates went missing, instead
+// of applying the following incremental updates to a policy they no longer
+// fit. The sequence numbers of each origin are followed, and an update
+// skipping some is a gap: the callbacks are run as for an Update, and the
+// update itself, already part of the reloaded policy, is dropped. Receiving
+// waits for the reload, so the following updates are applied as usual on the
+// reloaded policy. If the reload fails the update is nacked, to try again on
+// its redelivery. Gaps are counted in Stats().Gaps.
+// Only use it with providers delivering the updates of an origin in order,
+// such as Kafka or ordered Pub/Sub subscriptions, or reordered updates are
+// taken for gaps.
+func WithGapResync() Option {
+	return optionFunc(func(o *options) {
+		o.gapResync = true
+	})
+}
+
+// sequenceGaps follows the last sequence received from each origin
+type sequenceGaps struct {
+	mu   sync.Mutex
+	last map[string]uint64
+}
+
+// resyncOnGap checks msg for a gap with WithGapResync and reloads the policy
+// if there's one. It reports whether msg was settled.
+func (w *Watcher) resyncOnGap(msg *pubsub.Message, settle func(AckOutcome)) bool {
+	if !w.opts.gapResync {
+		return false
+	}
+	origin, seq, ok := w.messageSequence(msg)
+	if !ok {
+		return false
+	}
+	g := &w.gaps
+	g.mu.Lock()
+	if g.last == nil {
+		g.last = map[string]uint64{}
+	}
+	last := g.last[origin]
+	// a lower sequence is a redelivery, or the origin restarted from 1
+	if seq > last || seq == 1 {
+		g.last[origin] = seq
+	}
+	g.mu.Unlock()
+	if last == 0 || seq <= last+1 {
+		return false
+	}
+
+	atomic.AddUint64(&w.stats.gaps, 1)
+	w.log(LevelWarn, "Updates went missing, reloading the policy", "origin", origin, "expected", last+1, "sequence", seq)
+	// receiving waits for the reload, so it can't wait for the batch of msg
+	// to be flushed
+	w.flushBatchOf(msg)
+	applied := make(chan bool, 1)
+	w.executeCallback(msg, w.opts.reloadSignal, UpdateMessage{Op: Update, Origin: origin, Sequence: seq}, nil, func(ok bool) { applied <- ok })
+	if !<-applied {
+		// the reload is retried when msg is redelivered
+		g.mu.Lock()
+		if g.last[origin] == seq {
+			g.last[origin] = last
+		}
+		g.mu.Unlock()
+		settle(Nacked)
+		return true
+	}
+	w.establishBaseline()
+	w.recordContentRevision()
+	settle(Dropped)
+	return true
+}
@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+func TestGapResync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	topic, err := broker.mux().OpenTopic(ctx, "fake://gaps")
+	if err != nil {
+		t.Fatalf("Failed to open topic, error: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://gaps", WithURLMux(broker.mux()),
+		WithGapResync(), WithCallbackConcurrency(1), recordAcks(events),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	applied := make(chan UpdateMessage, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		return nil
+	})
+
+	send := func(seq uint64) {
+		t.Helper()
+		body, err := JSONCodec{}.Marshal(UpdateMessage{Op: UpdateForAddPolicy, Sec: "p", Ptype: "p",
+			Params: []string{"user" + strconv.FormatUint(seq, 10), "data1", "read"}})
+		if err != nil {
+			t.Fatalf("Failed to encode update, error: %s", err)
+		}
+		err = topic.Send(ctx, &pubsub.Message{Body: body, Metadata: map[string]string{
+			DefaultMetadataPrefix + metaOrigin:   "peer",
+			DefaultMetadataPrefix + metaSequence: strconv.FormatUint(seq, 10),
+			DefaultMetadataPrefix + metaOp:       string(UpdateForAddPolicy),
+		}})
+		if err != nil {
+			t.Fatalf("Failed to send message, error: %s", err)
+		}
+	}
+
+	// update 3 never arrives
+	for _, seq := range []uint64{1, 2, 4, 5} {
+		send(seq)
+	}
+	expectAcks(t, events, ackEvent{1, "peer", Acked}, ackEvent{2, "peer", Acked},
+		ackEvent{4, "peer", Dropped}, ackEvent{5, "peer", Acked})
+
+	want := []struct {
+		op   UpdateType
+		user string
+	}{
+		{UpdateForAddPolicy, "user1"},
+		{UpdateForAddPolicy, "user2"},
+		{Update, ""},
+		{UpdateForAddPolicy, "user5"},
+	}
+	for i, u := range want {
+		select {
+		case um := <-applied:
+			if um.Op != u.op {
+				t.Fatalf("Expected update %d to be %s, got %s", i+1, u.op, um.Op)
+			}
+			if u.user != "" && um.Params[0] != u.user {
+				t.Fatalf("Expected update %d for %s, got %v", i+1, u.user, um.Params)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of %d updates were applied", i, len(want))
+		}
+	}
+	if gaps := w.Stats().Gaps; gaps != 1 {
+		t.Fatalf("Expected 1 gap, got %d", gaps)
+	}
+}
@@ -0,0 +1,31 @@
+package watcher
+
+import "sync/atomic"
+
+// goroutineTracker counts the goroutines and pending timers owned by a
+// watcher
+type goroutineTracker struct {
+	n int64
+}
+
+func (g *goroutineTracker) add(delta int64) {
+	atomic.AddInt64(&g.n, delta)
+}
+
+// start runs fn in a counted goroutine
+func (g *goroutineTracker) start(fn func()) {
+	g.add(1)
+	go func() {
+		defer g.add(-1)
+		fn()
+	}()
+}
+
+// GoroutineCount returns the number of background goroutines and pending
+// timers the watcher owns: receive loops, callbacks, acknowledgement waits,
+// the diagnostics dump, the heartbeat and the debounce, reload throttle and
+// lease extension timers. It drops to zero once the watcher is closed and
+// running callbacks have returned, which makes it useful in leak tests.
+func (w *Watcher) GoroutineCount() int {
+	return int(atomic.LoadInt64(&w.routines.n))
+}
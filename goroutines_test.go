@@ -0,0 +1,53 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGoroutineCountAfterClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://leaks", WithURLMux(broker.mux()), WithDebounce(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	if n := w.GoroutineCount(); n != 1 {
+		t.Fatalf("Expected only the receive loop, got %d goroutines", n)
+	}
+
+	applied := make(chan struct{}, 1)
+	w.SetUpdateCallback(func(string) {})
+	w.SetUpdateCallbackEx(func(UpdateMessage) error {
+		applied <- struct{}{}
+		return nil
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-applied:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update wasn't applied in time")
+	}
+	// the receive loop and the pending debounce timer
+	waitFor(t, time.Second*5, func() bool { return w.GoroutineCount() == 2 })
+
+	// a reconnect replaces the receive loop rather than adding one
+	failed := broker.subscriptions()[0]
+	failed.fail(errors.New("connection reset"))
+	waitFor(t, time.Second*5, func() bool {
+		subs := broker.subscriptions()
+		return len(subs) == 1 && subs[0] != failed && w.Connected()
+	})
+	if n := w.GoroutineCount(); n != 2 {
+		t.Fatalf("Expected 2 goroutines after reconnecting, got %d", n)
+	}
+
+	w.Close()
+	waitFor(t, time.Second*5, func() bool { return w.GoroutineCount() == 0 })
+}
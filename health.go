@@ -0,0 +1,113 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHealthDebounce is how long a change of health must last before the
+// OnHealthChange hook hears of it, see WithHealthDebounce
+const DefaultHealthDebounce = 5 * time.Second
+
+// WithHealthDebounce sets how long the watcher must stay healthy or unhealthy
+// before the OnHealthChange hook is called, DefaultHealthDebounce by
+// default, so brief blips such as a quick reconnect don't raise alerts.
+func WithHealthDebounce(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.healthDebounce = d
+	})
+}
+
+// OnHealthChange sets the hook called when the watcher becomes unhealthy,
+// having lost its subscription, or healthy again. reason describes the
+// latest change. Changes reverted within the WithHealthDebounce window
+// aren't reported. The hook runs in its own goroutine, never after Close.
+func (w *Watcher) OnHealthChange(hook func(healthy bool, reason string)) {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	w.health.hook = hook
+}
+
+// healthTracker debounces the connection state changes into health changes.
+// A watcher starts out healthy as NewWithOptions fails otherwise. Its timer
+// isn't counted by GoroutineCount: it is stopped by Close and doesn't hold
+// the watcher open.
+type healthTracker struct {
+	clock  Clock
+	window time.Duration
+
+	mu   sync.Mutex
+	hook func(healthy bool, reason string)
+	// unhealthy is the state last reported, or assumed at start
+	unhealthy bool
+	// changed is set while the current state differs from the reported one
+	changed bool
+	reason  string
+	timer   Timer
+	// gen identifies the current timer so a stopped one that fires anyway
+	// does nothing
+	gen     uint64
+	stopped bool
+}
+
+func (h *healthTracker) observe(healthy bool, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		return
+	}
+	h.reason = reason
+	h.changed = healthy == h.unhealthy
+	if !h.changed {
+		h.cancelLocked()
+		return
+	}
+	if h.timer != nil {
+		return
+	}
+	h.gen++
+	gen := h.gen
+	h.timer = h.clock.AfterFunc(h.window, func() { h.fire(gen) })
+}
+
+func (h *healthTracker) fire(gen uint64) {
+	h.mu.Lock()
+	if h.gen != gen || h.timer == nil {
+		h.mu.Unlock()
+		return
+	}
+	h.timer = nil
+	if !h.changed {
+		h.mu.Unlock()
+		return
+	}
+	h.unhealthy, h.changed = !h.unhealthy, false
+	hook, healthy, reason := h.hook, !h.unhealthy, h.reason
+	h.mu.Unlock()
+
+	if hook != nil {
+		hook(healthy, reason)
+	}
+}
+
+// healthy reports the state last reported to the hook
+func (h *healthTracker) healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.unhealthy
+}
+
+// stop drops any pending change and disables the hook
+func (h *healthTracker) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopped = true
+	h.cancelLocked()
+}
+
+func (h *healthTracker) cancelLocked() {
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+}
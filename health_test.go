@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type healthChange struct {
+	healthy bool
+	reason  string
+}
+
+func TestOnHealthChange(t *testing.T) {
+	ctx := context.Background()
+
+	var down int32
+	errDown := errors.New("broker unavailable")
+	broker := &fakeBroker{openSubErr: func(*url.URL) error {
+		if atomic.LoadInt32(&down) == 1 {
+			return errDown
+		}
+		return nil
+	}}
+	clock := newFakeClock()
+	w, err := NewWithOptions(ctx, "fake://health", WithURLMux(broker.mux()), WithClock(clock),
+		WithHealthDebounce(time.Minute), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	changes := make(chan healthChange, 4)
+	w.OnHealthChange(func(healthy bool, reason string) {
+		changes <- healthChange{healthy, reason}
+	})
+	expectNoChange := func() {
+		t.Helper()
+		select {
+		case c := <-changes:
+			t.Fatalf("Unexpected health change: %+v", c)
+		default:
+		}
+	}
+
+	// a blip recovered within the window isn't reported
+	failed := broker.subscriptions()[0]
+	failed.fail(errors.New("connection reset"))
+	waitFor(t, time.Second*5, func() bool {
+		subs := broker.subscriptions()
+		return len(subs) == 1 && subs[0] != failed && w.Connected()
+	})
+	clock.advance(2 * time.Minute)
+	expectNoChange()
+
+	// a sustained outage is, once the window is over
+	atomic.StoreInt32(&down, 1)
+	broker.subscriptions()[0].fail(errors.New("connection reset"))
+	waitFor(t, time.Second*5, func() bool { return !w.Connected() })
+	clock.advance(59 * time.Second)
+	expectNoChange()
+	clock.advance(time.Second)
+	select {
+	case c := <-changes:
+		if c.healthy || !strings.Contains(c.reason, "connection reset") {
+			t.Fatalf("Unexpected health change: %+v", c)
+		}
+	default:
+		t.Fatal("Sustained outage wasn't reported")
+	}
+
+	// and so is the recovery
+	atomic.StoreInt32(&down, 0)
+	waitFor(t, time.Second*5, w.Connected)
+	clock.advance(time.Minute)
+	select {
+	case c := <-changes:
+		if !c.healthy {
+			t.Fatalf("Unexpected health change: %+v", c)
+		}
+	default:
+		t.Fatal("Recovery wasn't reported")
+	}
+
+	// closing isn't an outage
+	w.Close()
+	clock.advance(time.Hour)
+	expectNoChange()
+}
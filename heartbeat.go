@@ -0,0 +1,103 @@
+package watcher
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// MissedHeartbeats is the number of heartbeats a watcher may miss before
+// DeadOrigins reports it
+const MissedHeartbeats = 3
+
+// WithHeartbeat broadcasts a heartbeat every interval, from the moment the
+// watcher is connected until it's closed, so peers know it's alive even
+// when no policy changes. Heartbeats are control messages and never reach
+// the callbacks. They carry the epoch of the watcher, which changes when it
+// restarts, and interval, which receivers keep in KnownOrigins to tell the
+// watchers that went silent with DeadOrigins. The epoch and interval are
+// driven by the WithClock clock.
+func WithHeartbeat(interval time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.heartbeatInterval = interval
+	})
+}
+
+// sendHeartbeats runs until the watcher is closed, broadcasting a heartbeat
+// every WithHeartbeat interval
+func (w *Watcher) sendHeartbeats() {
+	epoch := strconv.FormatInt(w.epoch, 10)
+	interval := w.opts.heartbeatInterval.String()
+	for {
+		tick := make(chan struct{})
+		timer := w.opts.clock.AfterFunc(w.opts.heartbeatInterval, func() { close(tick) })
+
+		m := w.newControlMessage(kindHeartbeat, map[string]string{metaEpoch: epoch, metaInterval: interval})
+		if err := w.send(w.lifecycle(), m); err != nil {
+			w.log(LevelDebug, "Failed to send heartbeat", "error", err)
+		}
+
+		select {
+		case <-tick:
+		case <-w.closedCh:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// recordHeartbeat keeps the epoch and interval of the heartbeat msg in
+// KnownOrigins
+func (w *Watcher) recordHeartbeat(msg *pubsub.Message) {
+	origin := msg.Metadata[w.metadataKey(metaOrigin)]
+	epoch, _ := strconv.ParseInt(msg.Metadata[w.metadataKey(metaEpoch)], 10, 64)
+	interval, _ := time.ParseDuration(msg.Metadata[w.metadataKey(metaInterval)])
+
+	w.diag.mu.Lock()
+	info := w.diag.origins[origin]
+	restarted := info.Epoch != 0 && info.Epoch != epoch
+	info.Epoch, info.HeartbeatInterval = epoch, interval
+	info.LastHeartbeat = w.opts.clock.Now()
+	w.diag.origins[origin] = info
+	w.diag.mu.Unlock()
+	if restarted {
+		w.log(LevelInfo, "Watcher restarted", "origin", origin, "epoch", epoch)
+	}
+}
+
+// DeadOrigins returns the instance IDs of the watchers sending heartbeats
+// that missed MissedHeartbeats of them in a row, sorted
+func (w *Watcher) DeadOrigins() []string {
+	now := w.opts.clock.Now()
+	w.diag.mu.Lock()
+	defer w.diag.mu.Unlock()
+	var dead []string
+	for origin, info := range w.diag.origins {
+		if info.HeartbeatInterval > 0 && now.Sub(info.LastHeartbeat) > MissedHeartbeats*info.HeartbeatInterval {
+			dead = append(dead, origin)
+		}
+	}
+	sort.Strings(dead)
+	return dead
+}
+
+// Ping publishes a keepalive, e.g. to probe connectivity end to end in
+// production. It's a control message like heartbeats: peers record it as
+// LastPing in KnownOrigins but never run the callbacks for it, so enforcers
+// aren't disturbed. It returns the error sending it failed with, if any.
+func (w *Watcher) Ping(ctx context.Context) error {
+	return w.send(ctx, w.newControlMessage(kindPing, nil))
+}
+
+// recordPing keeps the time of the ping msg in KnownOrigins
+func (w *Watcher) recordPing(msg *pubsub.Message) {
+	origin := msg.Metadata[w.metadataKey(metaOrigin)]
+	w.diag.mu.Lock()
+	defer w.diag.mu.Unlock()
+	info := w.diag.origins[origin]
+	info.LastPing = w.opts.clock.Now()
+	w.diag.origins[origin] = info
+}
@@ -0,0 +1,101 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	mux := (&fakeBroker{}).mux()
+	receiver, err := NewWithOptions(ctx, "fake://heartbeat", WithURLMux(mux), WithClock(clock), WithInstanceID("receiver"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer receiver.Close()
+	reloads := make(chan string, 4)
+	receiver.SetUpdateCallback(func(body string) { reloads <- body })
+
+	sender, err := NewWithOptions(ctx, "fake://heartbeat", WithURLMux(mux), WithClock(clock), WithInstanceID("sender"),
+		WithHeartbeat(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer sender.Close()
+
+	lastHeartbeat := func() time.Time {
+		return receiver.KnownOrigins()["sender"].LastHeartbeat
+	}
+	waitFor(t, time.Second*5, func() bool { return !lastHeartbeat().IsZero() })
+	info := receiver.KnownOrigins()["sender"]
+	if info.LastSeen.IsZero() || info.Epoch != clock.Now().UnixNano() || info.HeartbeatInterval != time.Minute {
+		t.Fatalf("Unexpected liveness of the sender: %+v", info)
+	}
+
+	// the next heartbeat is sent after the interval
+	clock.advance(time.Minute)
+	waitFor(t, time.Second*5, func() bool { return lastHeartbeat().Equal(clock.Now()) })
+	if dead := receiver.DeadOrigins(); len(dead) != 0 {
+		t.Fatalf("Expected no dead origins, got %v", dead)
+	}
+
+	select {
+	case body := <-reloads:
+		t.Fatalf("A heartbeat fired the callback with %q", body)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// a watcher that stopped sending them is dead after missing three
+	sender.Close()
+	clock.advance(MissedHeartbeats * time.Minute)
+	if dead := receiver.DeadOrigins(); len(dead) != 0 {
+		t.Fatalf("Expected the sender to be alive until it misses %d heartbeats, got %v", MissedHeartbeats, dead)
+	}
+	clock.advance(time.Second)
+	if dead := receiver.DeadOrigins(); len(dead) != 1 || dead[0] != "sender" {
+		t.Fatalf("Expected the sender to be dead, got %v", dead)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	mux := (&fakeBroker{}).mux()
+	receiver, err := NewWithOptions(ctx, "fake://ping", WithURLMux(mux), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer receiver.Close()
+	reloads := make(chan string, 4)
+	receiver.SetUpdateCallback(func(body string) { reloads <- body })
+	receiver.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		reloads <- string(um.Op)
+		return nil
+	})
+
+	sender, err := NewWithOptions(ctx, "fake://ping", WithURLMux(mux), WithInstanceID("sender"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer sender.Close()
+
+	if err := sender.Ping(ctx); err != nil {
+		t.Fatalf("Failed to ping: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return !receiver.KnownOrigins()["sender"].LastPing.IsZero() })
+	if info := receiver.KnownOrigins()["sender"]; !info.LastPing.Equal(clock.Now()) || info.LastSeen.IsZero() {
+		t.Fatalf("Unexpected liveness of the sender: %+v", info)
+	}
+
+	select {
+	case body := <-reloads:
+		t.Fatalf("A ping fired the callbacks with %q", body)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"gocloud.dev/pubsub"
+)
+
+// DefaultIdempotencyCapacity is the number of keys remembered by the
+// in-memory IdempotencyStore used when WithIdempotency is given none
+const DefaultIdempotencyCapacity = 10000
+
+// IdempotencyStore records the idempotency keys of the updates applied, see
+// WithIdempotency. Implementations, e.g. backed by Redis to span processes,
+// must be safe for concurrent use.
+type IdempotencyStore interface {
+	// OnceApplied records key and reports whether it was recorded before.
+	OnceApplied(key string) bool
+	// Forget removes key after the callbacks of its update failed, so a
+	// redelivery applies it again.
+	Forget(key string)
+}
+
+// WithIdempotency skips the updates whose idempotency key store already
+// recorded, before they're dispatched to the callbacks or the Updates
+// channel, so a redelivered update is applied once. The key is the one
+// given to UpdateWithIdempotencyKey, or else the origin and sequence of the
+// update. Updates nacked for redelivery are forgotten. A nil store remembers
+// the last DefaultIdempotencyCapacity keys in memory.
+func WithIdempotency(store IdempotencyStore) Option {
+	return optionFunc(func(o *options) {
+		if store == nil {
+			store = NewMemoryIdempotencyStore(DefaultIdempotencyCapacity)
+		}
+		o.idempotencyStore = store
+	})
+}
+
+// UpdateWithIdempotencyKey is like UpdateContext but tags the update with
+// key, so watchers using WithIdempotency apply it once even if it's sent
+// again, e.g. by a retried job.
+func (w *Watcher) UpdateWithIdempotencyKey(ctx context.Context, key string) error {
+	m := w.newMessage(w.reloadSignal(), Update)
+	m.Metadata[w.metadataKey(metaIdempotencyKey)] = key
+	return w.broadcast(ctx, m)
+}
+
+// idempotencyKey returns the key of msg, or "" for messages without
+// sequence, e.g. from older watchers
+func (w *Watcher) idempotencyKey(msg *pubsub.Message) string {
+	if key := msg.Metadata[w.metadataKey(metaIdempotencyKey)]; key != "" {
+		return key
+	}
+	origin, seq, ok := w.messageSequence(msg)
+	if !ok {
+		return ""
+	}
+	return origin + "/" + strconv.FormatUint(seq, 10)
+}
+
+// applyOnce reports whether msg was applied before and otherwise returns
+// settle also forgetting its key if it's nacked
+func (w *Watcher) applyOnce(msg *pubsub.Message, settle func(AckOutcome)) (func(AckOutcome), bool) {
+	store := w.opts.idempotencyStore
+	if store == nil {
+		return settle, false
+	}
+	key := w.idempotencyKey(msg)
+	if key == "" {
+		return settle, false
+	}
+	if store.OnceApplied(key) {
+		return settle, true
+	}
+	return func(outcome AckOutcome) {
+		if outcome == Nacked {
+			store.Forget(key)
+		}
+		settle(outcome)
+	}, false
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore remembering a bounded number
+// of keys, forgetting the oldest first.
+type MemoryIdempotencyStore struct {
+	mu sync.Mutex
+	// keys maps every key remembered to its slot in order, a ring whose
+	// oldest slot is next
+	keys  map[string]int
+	order []string
+	next  int
+}
+
+// NewMemoryIdempotencyStore returns a store remembering the last capacity
+// keys.
+func NewMemoryIdempotencyStore(capacity int) *MemoryIdempotencyStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryIdempotencyStore{keys: map[string]int{}, order: make([]string, capacity)}
+}
+
+// OnceApplied implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) OnceApplied(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return true
+	}
+	// the oldest slot may hold a key forgotten or recorded again since
+	oldest := s.order[s.next]
+	if slot, ok := s.keys[oldest]; ok && slot == s.next {
+		delete(s.keys, oldest)
+	}
+	s.order[s.next] = key
+	s.keys[key] = s.next
+	s.next = (s.next + 1) % len(s.order)
+	return false
+}
+
+// Forget implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+}
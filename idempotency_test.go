@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+func TestIdempotencySkipsRedelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://idempotency", WithURLMux(broker.mux()),
+		WithIdempotency(nil), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	applied := make(chan string, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um.IdempotencyKey
+		return nil
+	})
+
+	topic, err := broker.mux().OpenTopic(ctx, "fake://idempotency")
+	if err != nil {
+		t.Fatalf("Failed to open topic, error: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+	// the provider delivers the same update twice
+	for i := 0; i < 2; i++ {
+		err := topic.Send(ctx, &pubsub.Message{Body: []byte(legacyUpdateBody), Metadata: map[string]string{
+			DefaultMetadataPrefix + metaOrigin:   "peer",
+			DefaultMetadataPrefix + metaSequence: "1",
+		}})
+		if err != nil {
+			t.Fatalf("Failed to send message, error: %s", err)
+		}
+	}
+	expectAcks(t, events, ackEvent{1, "peer", Acked}, ackEvent{1, "peer", Dropped})
+
+	// a job retried with the same key
+	for i := 0; i < 2; i++ {
+		if err := w.UpdateWithIdempotencyKey(ctx, "job-42"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked}, ackEvent{2, w.opts.instanceID, Dropped})
+
+	for _, want := range []string{"peer/1", "job-42"} {
+		select {
+		case key := <-applied:
+			if key != want {
+				t.Fatalf("Expected update %s to be applied, got %s", want, key)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Update %s wasn't applied", want)
+		}
+	}
+	select {
+	case key := <-applied:
+		t.Fatalf("Update %s was applied twice", key)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	s := NewMemoryIdempotencyStore(2)
+	for _, key := range []string{"a", "b"} {
+		if s.OnceApplied(key) {
+			t.Fatalf("Key %s reported applied before", key)
+		}
+	}
+	if !s.OnceApplied("a") {
+		t.Fatal("Key a wasn't remembered")
+	}
+
+	// a forgotten key is applied again
+	s.Forget("a")
+	if s.OnceApplied("a") {
+		t.Fatal("Forgotten key a reported applied")
+	}
+	if s.OnceApplied("c") || !s.OnceApplied("a") {
+		t.Fatal("Key a wasn't remembered again")
+	}
+	if s.OnceApplied("b") {
+		t.Fatal("Oldest key b wasn't evicted")
+	}
+}
@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// WithIdleTimeout makes the watcher call the OnIdle hook once no message was
+// received for d, and again every d while none arrives, e.g. to run a
+// health self-check on a quiet subscription. Being idle isn't an error and
+// receiving carries on. The quiet period is measured with the WithClock
+// clock from the moment the subscription is opened, and restarts with every
+// message received.
+func WithIdleTimeout(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.idleTimeout = d
+	})
+}
+
+// OnIdle sets the hook called by WithIdleTimeout. idle is how long no
+// message was received. The hook runs in its own goroutine, never after
+// Close: Close waits for a running call, so the hook mustn't call Close.
+func (w *Watcher) OnIdle(hook func(idle time.Duration)) {
+	w.idle.mu.Lock()
+	defer w.idle.mu.Unlock()
+	w.idle.hook = hook
+}
+
+// idleTracker times the quiet periods of the subscription. Its timer isn't
+// counted by GoroutineCount: it is stopped by Close and doesn't hold the
+// watcher open.
+type idleTracker struct {
+	clock   Clock
+	timeout time.Duration
+
+	mu   sync.Mutex
+	hook func(idle time.Duration)
+	// since is when the last message was received, or the subscription
+	// opened
+	since time.Time
+	timer Timer
+	// gen identifies the current timer so a stopped one that fires anyway
+	// does nothing
+	gen     uint64
+	stopped bool
+	// running counts the hook calls in progress, waited for by stop
+	running sync.WaitGroup
+}
+
+// touch restarts the quiet period, when WithIdleTimeout is set
+func (t *idleTracker) touch() {
+	if t.timeout <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	t.since = t.clock.Now()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.armLocked()
+}
+
+func (t *idleTracker) armLocked() {
+	t.gen++
+	gen := t.gen
+	t.timer = t.clock.AfterFunc(t.timeout, func() { t.fire(gen) })
+}
+
+func (t *idleTracker) fire(gen uint64) {
+	t.mu.Lock()
+	if t.stopped || t.gen != gen {
+		t.mu.Unlock()
+		return
+	}
+	t.armLocked()
+	hook, idle := t.hook, t.clock.Now().Sub(t.since)
+	t.running.Add(1)
+	t.mu.Unlock()
+
+	defer t.running.Done()
+	if hook != nil {
+		hook(idle)
+	}
+}
+
+// stop disables the hook and waits for a running call to return
+func (t *idleTracker) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.mu.Unlock()
+	t.running.Wait()
+}
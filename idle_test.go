@@ -0,0 +1,113 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://idle", WithURLMux((&fakeBroker{}).mux()), WithClock(clock),
+		WithIdleTimeout(time.Minute), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	idle := make(chan time.Duration, 10)
+	w.OnIdle(func(d time.Duration) { idle <- d })
+
+	expectIdle := func(want time.Duration) {
+		t.Helper()
+		select {
+		case d := <-idle:
+			if d != want {
+				t.Fatalf("Expected to be idle for %s, got %s", want, d)
+			}
+		default:
+			t.Fatalf("Expected OnIdle after %s", want)
+		}
+	}
+	expectBusy := func() {
+		t.Helper()
+		select {
+		case d := <-idle:
+			t.Fatalf("OnIdle fired early, idle for %s", d)
+		default:
+		}
+	}
+
+	// the hook fires after the quiet period, and again while quiet
+	clock.advance(59 * time.Second)
+	expectBusy()
+	clock.advance(time.Second)
+	expectIdle(time.Minute)
+	clock.advance(time.Minute)
+	expectIdle(2 * time.Minute)
+
+	// a message restarts the quiet period
+	clock.advance(30 * time.Second)
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+	clock.advance(59 * time.Second)
+	expectBusy()
+	clock.advance(time.Second)
+	expectIdle(time.Minute)
+
+	w.Close()
+	clock.advance(time.Hour)
+	expectBusy()
+}
+
+func TestIdleHookDuringClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	w, err := NewWithOptions(ctx, "fake://idle", WithURLMux((&fakeBroker{}).mux()), WithClock(clock),
+		WithIdleTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var returned int32
+	w.OnIdle(func(time.Duration) {
+		close(started)
+		<-release
+		// the watcher is still usable from the hook while Close waits
+		_ = w.Connected()
+		atomic.StoreInt32(&returned, 1)
+	})
+	go clock.advance(time.Minute)
+	<-started
+
+	// Close waits for the running hook
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while the hook was running")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close didn't return after the hook")
+	}
+	if atomic.LoadInt32(&returned) != 1 {
+		t.Fatal("Close returned before the hook")
+	}
+}
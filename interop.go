@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	"gocloud.dev/pubsub"
+)
+
+// Watcher metadata names, see restoreMetadataKeys
+var metadataNames = []string{
+	metaOrigin, metaSequence, metaOp, metaKind, metaReplyTo, metaCorrelation,
+	metaTarget, metaHostname, metaPID, metaNode, metaEncoding, metaChecksum,
+	metaTenant, metaIdempotencyKey, metaModelHash, metaCompactionKey,
+	metaEpoch, metaInterval, metaRevision, metaSnapshotFor,
+}
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// warnCapability logs a warning about a capability the providers lack, once
+// per capability, so a bridge between providers degrades without flooding
+// the log.
+func (w *Watcher) warnCapability(capability, msg string, keyvals ...interface{}) {
+	if _, warned := w.capabilityWarnings.LoadOrStore(capability, true); warned {
+		return
+	}
+	w.log(LevelWarn, msg, append(keyvals, "capability", capability)...)
+}
+
+// adaptMetadata makes up for what got lost when msg crossed from the
+// provider it was published to into another one, e.g. through a mirror
+// between Kafka and Pub/Sub: metadata keys whose case was changed are
+// restored, a gzip body whose encoding metadata was dropped is recognised,
+// and the features relying on metadata that didn't survive are reported.
+// It only fails when such a gzip body exceeds WithMaxPayloadBytes.
+func (w *Watcher) adaptMetadata(msg *pubsub.Message) error {
+	w.restoreMetadataKeys(msg)
+
+	if len(msg.Metadata) == 0 {
+		if bytes.HasPrefix(msg.Body, gzipMagic) {
+			w.warnCapability("encoding", "Received a gzip body without encoding metadata, decompressing it anyway")
+			body, err := w.decompressBody(GzipCompressor{}, msg.Body)
+			if errors.Is(err, ErrPayloadTooLarge) {
+				return err
+			}
+			if err == nil {
+				msg.Body = body
+			}
+		}
+		if !w.isReloadSignal(msg.Body) {
+			w.warnCapability("metadata", "Received an update without watcher metadata; self filtering, targets, acknowledgements and diagnostics need the providers to carry it")
+		}
+		return nil
+	}
+	if s, ok := msg.Metadata[w.metadataKey(metaSequence)]; ok {
+		if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+			w.warnCapability("sequence", "Received an update with an unreadable sequence number", "sequence", s)
+		}
+	}
+	return nil
+}
+
+// restoreMetadataKeys renames the watcher metadata keys a provider changed
+// the case of, e.g. by lowercasing them like HTTP headers
+func (w *Watcher) restoreMetadataKeys(msg *pubsub.Message) {
+	for key, value := range msg.Metadata {
+		if !strings.HasPrefix(strings.ToLower(key), strings.ToLower(w.opts.metadataPrefix)) {
+			continue
+		}
+		for _, name := range metadataNames {
+			canonical := w.metadataKey(name)
+			if key == canonical || !strings.EqualFold(key, canonical) {
+				continue
+			}
+			if _, ok := msg.Metadata[canonical]; !ok {
+				w.warnCapability("metadata-case", "Received metadata keys with a different case, restoring them", "key", key)
+				msg.Metadata[canonical] = value
+			}
+			delete(msg.Metadata, key)
+			break
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/driver"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+// mirroredMux publishes to a fake topic and subscribes to an in-memory topic
+// the fake one is mirrored into, like a bridge between two providers. mangle
+// rewrites the metadata of every mirrored message.
+func mirroredMux(t *testing.T, mangle func(map[string]string) map[string]string) *pubsub.URLMux {
+	ctx := context.Background()
+	mem := new(mempubsub.URLOpener)
+	mux := new(pubsub.URLMux)
+	mux.RegisterSubscription("mem", mem)
+
+	mux.RegisterTopic("mem", mem)
+	mirror, err := mux.OpenTopic(ctx, "mem://mirror")
+	if err != nil {
+		t.Fatalf("Failed to open mirror topic: %s", err)
+	}
+	t.Cleanup(func() { mirror.Shutdown(ctx) })
+
+	broker := &fakeBroker{
+		sendErr: func(ctx context.Context, ms []*driver.Message) error {
+			for _, m := range ms {
+				md := map[string]string{}
+				for k, v := range m.Metadata {
+					md[k] = v
+				}
+				if err := mirror.Send(ctx, &pubsub.Message{Body: m.Body, Metadata: mangle(md)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	mux.RegisterTopic("fake", broker)
+	return mux
+}
+
+func TestMixedProviders(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		mangle  func(map[string]string) map[string]string
+		opts    []Option
+		origin  bool
+		warning string
+	}{
+		{
+			name:   "metadata kept",
+			mangle: func(md map[string]string) map[string]string { return md },
+			origin: true,
+		},
+		{
+			name: "keys upper cased",
+			mangle: func(md map[string]string) map[string]string {
+				upper := map[string]string{}
+				for k, v := range md {
+					upper[strings.ToUpper(k)] = v
+				}
+				return upper
+			},
+			origin:  true,
+			warning: "different case",
+		},
+		{
+			name:    "metadata dropped",
+			mangle:  func(map[string]string) map[string]string { return nil },
+			warning: "without watcher metadata",
+		},
+		{
+			name:    "compressed, metadata dropped",
+			mangle:  func(map[string]string) map[string]string { return nil },
+			opts:    []Option{WithCompression(GzipCompressor{}, 0)},
+			warning: "gzip body without encoding metadata",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var logs syncBuffer
+			opts := append([]Option{
+				WithURLMux(mirroredMux(t, tc.mangle)),
+				WithSubscriptionURL("mem://mirror?ackdeadline=1s"),
+				WithLogger(NewJSONLogger(&logs)),
+				WithInstanceID("bridged"),
+			}, tc.opts...)
+			w, err := NewWithOptions(ctx, "fake://source", opts...)
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+
+			received := make(chan UpdateMessage, 2)
+			w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+				received <- um
+				return nil
+			})
+			for i := 0; i < 2; i++ {
+				if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+					t.Fatalf("Failed to send update: %s", err)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				select {
+				case um := <-received:
+					if um.Op != UpdateForAddPolicy || len(um.Params) != 3 {
+						t.Fatalf("Received a mangled update: %+v", um)
+					}
+					if got := um.Origin == "bridged"; got != tc.origin {
+						t.Fatalf("Expected the origin to be kept: %t, got %q", tc.origin, um.Origin)
+					}
+				case <-time.After(time.Second * 5):
+					t.Fatal("Update wasn't delivered through the mirror")
+				}
+			}
+
+			if tc.warning == "" {
+				if strings.Contains(logs.String(), `"level":"warn"`) {
+					t.Fatalf("Unexpected warning: %s", logs.String())
+				}
+				return
+			}
+			if n := strings.Count(logs.String(), tc.warning); n != 1 {
+				t.Fatalf("Expected the capability warning once, got %d times: %s", n, logs.String())
+			}
+		})
+	}
+}
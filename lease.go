@@ -0,0 +1,126 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrLeaseUnsupported is returned by a LeaseExtender that can't extend the
+// ack deadline of messages from the provider behind the subscription.
+var ErrLeaseUnsupported = errors.New("ack deadline extension isn't supported for this provider")
+
+// LeaseExtender extends the ack deadline of msg, received from sub opened
+// with subURL, to d from now. It reads the provider's client and the
+// message's ack handle with sub.As and msg.As, and returns an error wrapping
+// ErrLeaseUnsupported when it doesn't support the provider.
+type LeaseExtender func(ctx context.Context, subURL string, sub *pubsub.Subscription, msg *pubsub.Message, d time.Duration) error
+
+var (
+	leaseExtendersMu sync.RWMutex
+	leaseExtenders   []LeaseExtender
+)
+
+// RegisterLeaseExtender adds e to the extenders WithLeaseExtension tries.
+// Drivers whose provider has an ack deadline that can be extended register
+// one on import.
+func RegisterLeaseExtender(e LeaseExtender) {
+	leaseExtendersMu.Lock()
+	defer leaseExtendersMu.Unlock()
+	leaseExtenders = append(leaseExtenders, e)
+}
+
+// WithLeaseExtension extends the ack deadline of every received message to
+// extension from now, every interval until the message is settled, so a
+// callback running longer than the provider's deadline, like the SQS
+// visibility timeout or the Pub/Sub ack deadline, doesn't get it redelivered
+// in the meantime. Messages are only held for that long with
+// WithAckOnlyOnSuccess or a transactional callback. interval should leave
+// the extension time to reach the provider before the deadline. Providers
+// without a registered LeaseExtender are logged once and not extended.
+func WithLeaseExtension(interval, extension time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.leaseInterval = interval
+		o.leaseExtension = extension
+	})
+}
+
+// lease keeps extending the ack deadline of a message until stopped. timer
+// is nil while an extension is being sent.
+type lease struct {
+	mu      sync.Mutex
+	timer   Timer
+	stopped bool
+}
+
+// keepLease starts extending the ack deadline of msg, received from receiver,
+// and returns the func stopping it once msg is settled
+func (w *Watcher) keepLease(receiver subscriptionReceiver, msg *pubsub.Message) (stop func()) {
+	if w.opts.leaseInterval <= 0 {
+		return func() {}
+	}
+	sub, ok := pubsubSubscription(receiver)
+	if !ok {
+		w.warnCapability("lease", "Can't extend the ack deadline of messages not received through gocloud.dev, long callbacks may get them redelivered")
+		return func() {}
+	}
+	l := &lease{}
+	var scheduleLocked func()
+	scheduleLocked = func() {
+		w.routines.add(1)
+		l.timer = w.opts.clock.AfterFunc(w.opts.leaseInterval, func() {
+			l.mu.Lock()
+			if l.stopped {
+				l.mu.Unlock()
+				return
+			}
+			l.timer = nil
+			w.routines.add(-1)
+			l.mu.Unlock()
+
+			again := w.extendLease(sub, msg)
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.stopped || !again {
+				l.stopped = true
+				return
+			}
+			scheduleLocked()
+		})
+	}
+	l.mu.Lock()
+	scheduleLocked()
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.stopped = true
+		if l.timer != nil {
+			l.timer.Stop()
+			l.timer = nil
+			w.routines.add(-1)
+		}
+	}
+}
+
+// extendLease runs the registered extenders until one supports the
+// provider. It reports whether the lease should be extended again.
+func (w *Watcher) extendLease(sub *pubsub.Subscription, msg *pubsub.Message) bool {
+	leaseExtendersMu.RLock()
+	defer leaseExtendersMu.RUnlock()
+	for _, e := range leaseExtenders {
+		err := e(w.lifecycle(), w.subURL, sub, msg, w.opts.leaseExtension)
+		if errors.Is(err, ErrLeaseUnsupported) {
+			continue
+		}
+		if err != nil {
+			w.log(LevelWarn, "Failed to extend the ack deadline", "error", err, "id", msg.LoggableID)
+		}
+		return true
+	}
+	w.warnCapability("lease", "Can't extend the ack deadline of messages from this provider, long callbacks may get them redelivered")
+	return false
+}
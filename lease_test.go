@@ -0,0 +1,187 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// fakeLeases records the ack deadline extensions of the fake provider
+type fakeLeases struct {
+	mu       sync.Mutex
+	extended map[string][]time.Duration
+}
+
+func (l *fakeLeases) count(id string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.extended[id])
+}
+
+func init() {
+	RegisterLeaseExtender(func(_ context.Context, _ string, sub *pubsub.Subscription, msg *pubsub.Message, d time.Duration) error {
+		var leases *fakeLeases
+		if !sub.As(&leases) {
+			return ErrLeaseUnsupported
+		}
+		leases.mu.Lock()
+		defer leases.mu.Unlock()
+		leases.extended[msg.LoggableID] = append(leases.extended[msg.LoggableID], d)
+		return nil
+	})
+}
+
+func TestLeaseExtension(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leases := &fakeLeases{extended: map[string][]time.Duration{}}
+	broker := &fakeBroker{subAs: func(i interface{}) bool {
+		p, ok := i.(**fakeLeases)
+		if ok {
+			*p = leases
+		}
+		return ok
+	}}
+	clock := newFakeClock()
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://lease", WithURLMux(broker.mux()), WithClock(clock),
+		WithAckOnlyOnSuccess(), WithLeaseExtension(time.Second*20, time.Second*30), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		close(started)
+		<-release
+		return nil
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The callback didn't start")
+	}
+
+	// the callback outlives several deadlines
+	const id = "fake #1"
+	for i := 1; i <= 3; i++ {
+		clock.advance(time.Second * 20)
+		if n := leases.count(id); n != i {
+			t.Fatalf("Expected %d extensions after %s, got %d", i, time.Duration(i)*time.Second*20, n)
+		}
+	}
+	leases.mu.Lock()
+	if d := leases.extended[id][0]; d != time.Second*30 {
+		t.Fatalf("Expected the deadline extended by 30s, got %s", d)
+	}
+	leases.mu.Unlock()
+
+	close(release)
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+	clock.advance(time.Minute)
+	if n := leases.count(id); n != 3 {
+		t.Fatalf("The deadline was extended after the message was acknowledged, %d extensions", n)
+	}
+}
+
+// wrappedSubscription stands in for a receiver decorating a gocloud.dev
+// subscription
+type wrappedSubscription struct {
+	*pubsub.Subscription
+}
+
+func (s wrappedSubscription) pubsubSubscription() *pubsub.Subscription { return s.Subscription }
+
+// wrappingTransport opens wrappedSubscriptions
+type wrappingTransport struct {
+	muxTransport
+}
+
+func (t wrappingTransport) openSubscription(ctx context.Context, url string) (subscriptionReceiver, error) {
+	sub, err := t.muxTransport.openSubscription(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return wrappedSubscription{sub.(*pubsub.Subscription)}, nil
+}
+
+func TestLeaseExtensionWrapped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leases := &fakeLeases{extended: map[string][]time.Duration{}}
+	broker := &fakeBroker{subAs: func(i interface{}) bool {
+		p, ok := i.(**fakeLeases)
+		if ok {
+			*p = leases
+		}
+		return ok
+	}}
+	clock := newFakeClock()
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://lease", optionFunc(func(o *options) {
+		o.transport = wrappingTransport{muxTransport{mux: broker.mux()}}
+	}), WithClock(clock), WithAckOnlyOnSuccess(), WithLeaseExtension(time.Second*20, time.Second*30), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		close(started)
+		<-release
+		return nil
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The callback didn't start")
+	}
+
+	// the extender gets the subscription behind the wrapper
+	clock.advance(time.Second * 20)
+	if n := leases.count("fake #1"); n != 1 {
+		t.Fatalf("Expected the deadline extended through the wrapper, got %d extensions", n)
+	}
+	close(release)
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+}
+
+func TestLeaseExtensionUnsupported(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := &syncBuffer{}
+	p := &pipe{}
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "pipe://lease", withPipe(p), WithLeaseExtension(time.Second*20, time.Second*30),
+		WithLogger(NewJSONLogger(out)), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+	if !strings.Contains(out.String(), `"capability":"lease"`) {
+		t.Fatalf("Expected a warning that leases can't be extended, got: %s", out.String())
+	}
+}
@@ -0,0 +1,45 @@
+package watcher
+
+import (
+	"context"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithLegacyMode restores the behavior of the watcher from before update
+// messages carried metadata. Every method sending an update publishes the
+// bare reload signal, "Casbin Update" unless set with WithReloadSignalBody,
+// so UpdateTo and UpdateForTenant reload every watcher, and the echo of
+// UpdateAndReload is applied again. ScheduleUpdate alone sends its payload
+// as given. Every message received is acked right away after starting the
+// SetUpdateCallback callback with its body in a goroutine of its own.
+// SetUpdateCallbackEx callbacks, the Updates channel and the options shaping
+// sends and deliveries, WithMaxPayloadBytes included, are ignored.
+func WithLegacyMode() Option {
+	return optionFunc(func(o *options) {
+		o.legacyMode = true
+	})
+}
+
+// sendLegacy publishes the bare reload signal of WithLegacyMode
+func (w *Watcher) sendLegacy(ctx context.Context) error {
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	if w.topic == nil {
+		return ErrNotConnected
+	}
+	return w.topic.Send(ctx, &pubsub.Message{Body: w.reloadSignal()})
+}
+
+// handleLegacyMessage delivers msg, received from sub, in WithLegacyMode
+func (w *Watcher) handleLegacyMessage(sub subscriptionReceiver, msg *pubsub.Message) {
+	body := string(msg.Body)
+	w.routines.start(func() {
+		defer w.quiet.end()
+		if callback, finished := w.beginLegacyCallback(); callback != nil {
+			defer finished()
+			callback(body)
+		}
+	})
+	settleMessage(sub, msg, true)
+}
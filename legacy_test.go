@@ -0,0 +1,135 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestLegacyMode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var sent []*driver.Message
+	broker := &fakeBroker{
+		sendErr: func(_ context.Context, ms []*driver.Message) error {
+			mu.Lock()
+			sent = append(sent, ms...)
+			mu.Unlock()
+			return nil
+		},
+	}
+	w, err := NewWithOptions(ctx, "fake://legacy", WithURLMux(broker.mux()), WithLegacyMode(),
+		WithCallbackConcurrency(1), WithUpdatesChannel(1, BackpressureBlock))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	bodies := make(chan string, 4)
+	release := make(chan struct{})
+	w.SetUpdateCallback(func(body string) {
+		bodies <- body
+		<-release
+	})
+	w.SetUpdateCallbackEx(func(UpdateMessage) error {
+		t.Error("The Ex callback was called in legacy mode")
+		return nil
+	})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+
+	// both callbacks run at once despite the concurrency limit and the
+	// messages are acked while they are still running
+	for i := 0; i < 2; i++ {
+		select {
+		case body := <-bodies:
+			if body != legacyUpdateBody {
+				t.Fatalf("Expected the %q body, got %q", legacyUpdateBody, body)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 2 callbacks started", i)
+		}
+	}
+	sub := broker.subscriptions()[0]
+	waitFor(t, time.Second*5, func() bool {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		return sub.acks[driver.AckID(1)] && sub.acks[driver.AckID(2)]
+	})
+	close(release)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, m := range sent {
+		if string(m.Body) != legacyUpdateBody || len(m.Metadata) != 0 {
+			t.Fatalf("Expected the bare %q body, got %q with metadata %v", legacyUpdateBody, m.Body, m.Metadata)
+		}
+	}
+	select {
+	case um := <-w.Updates():
+		t.Fatalf("Unexpected update on the channel: %+v", um)
+	default:
+	}
+}
+
+func TestLegacyModeSendPaths(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var sent []*driver.Message
+	broker := &fakeBroker{
+		sendErr: func(_ context.Context, ms []*driver.Message) error {
+			mu.Lock()
+			sent = append(sent, ms...)
+			mu.Unlock()
+			return nil
+		},
+	}
+	// the payload limit is ignored too
+	w, err := NewWithOptions(ctx, "fake://legacy", WithURLMux(broker.mux()), WithLegacyMode(),
+		WithMaxPayloadBytes(1))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls int32
+	w.SetUpdateCallback(func(string) { atomic.AddInt32(&calls, 1) })
+
+	sends := []func() error{
+		func() error { return w.UpdateTo(ctx, "node-2") },
+		func() error { return w.UpdateForTenant(ctx, "tenant-a") },
+		func() error { return w.UpdateWithIdempotencyKey(ctx, "job-1") },
+		func() error { return w.UpdateAndReload(ctx) },
+	}
+	for i, send := range sends {
+		if err := send(); err != nil {
+			t.Fatalf("Failed to send update %d: %s", i+1, err)
+		}
+	}
+	// every update is received, and UpdateAndReload also reloads locally
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == int32(len(sends))+1 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != len(sends) {
+		t.Fatalf("Expected %d messages sent, got %d", len(sends), len(sent))
+	}
+	for _, m := range sent {
+		if string(m.Body) != legacyUpdateBody || len(m.Metadata) != 0 {
+			t.Fatalf("Expected the bare %q body, got %q with metadata %v", legacyUpdateBody, m.Body, m.Metadata)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel is the severity of a log entry
+type LogLevel int
+
+// Log levels, in increasing severity
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Logger receives the watcher's log entries. keysAndValues holds alternating
+// keys and values describing the entry.
+type Logger interface {
+	Log(level LogLevel, msg string, keysAndValues ...interface{})
+}
+
+// stdLogger writes entries through the standard library log package
+type stdLogger struct{}
+
+func (stdLogger) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, value := keyValue(keysAndValues, i)
+		fmt.Fprintf(&b, " %s=%v", key, value)
+	}
+	log.Println(b.String())
+}
+
+type jsonLogger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewJSONLogger returns a Logger writing one JSON object per entry to out,
+// with the level, msg and time fields followed by the entry's key/value pairs.
+// If out is nil, entries are written to os.Stderr.
+func NewJSONLogger(out io.Writer) Logger {
+	if out == nil {
+		out = os.Stderr
+	}
+	return &jsonLogger{out: out}
+}
+
+func (l *jsonLogger) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	entry := make(map[string]interface{}, 3+len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, value := keyValue(keysAndValues, i)
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		entry[key] = value
+	}
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{
+			"level": level.String(),
+			"msg":   msg,
+			"error": fmt.Sprintf("failed to encode log entry: %s", err),
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+func keyValue(keysAndValues []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(keysAndValues[i])
+	if i+1 >= len(keysAndValues) {
+		return key, "(missing)"
+	}
+	return key, keysAndValues[i+1]
+}
+
+// levelOverride replaces the configured log level until its timer reverts it
+type levelOverride struct {
+	mu    sync.Mutex
+	timer Timer
+	// level is the overriding LogLevel plus one, zero when there is none;
+	// accessed atomically
+	level int32
+}
+
+// SetLogLevelFor logs at level for d, then reverts to the level set with
+// WithLogLevel or Reconfigure, which keep applying once the override ends.
+// A new call replaces the previous override; d of zero or less ends it now.
+func (w *Watcher) SetLogLevelFor(level LogLevel, d time.Duration) {
+	o := &w.logOverride
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if d <= 0 {
+		atomic.StoreInt32(&o.level, 0)
+		return
+	}
+	atomic.StoreInt32(&o.level, int32(level)+1)
+	var timer Timer
+	timer = w.opts.clock.AfterFunc(d, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if o.timer != timer {
+			return
+		}
+		o.timer = nil
+		atomic.StoreInt32(&o.level, 0)
+		w.log(LevelInfo, "Log level override expired", "level", w.currentTunables().logLevel)
+	})
+	o.timer = timer
+}
+
+// logLevel is the minimum level of the entries currently logged
+func (w *Watcher) logLevel() LogLevel {
+	if l := atomic.LoadInt32(&w.logOverride.level); l > 0 {
+		return LogLevel(l - 1)
+	}
+	return w.currentTunables().logLevel
+}
+
+func (w *Watcher) log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	if level < w.logLevel() {
+		return
+	}
+	if w.opts.name != "" {
+		msg = w.opts.name + ": " + msg
+	}
+	w.opts.logger.Log(level, msg, keysAndValues...)
+}
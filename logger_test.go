@@ -0,0 +1,105 @@
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf)
+
+	logger.Log(LevelError, "Subscription shutdown failed", "error", errors.New("boom"), "attempt", 2)
+	logger.Log(LevelInfo, `message with "quotes"`, "dangling")
+
+	scanner := bufio.NewScanner(&buf)
+	var entries []map[string]interface{}
+	for scanner.Scan() {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Log line is not valid JSON: %q, error: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d", len(entries))
+	}
+
+	first := entries[0]
+	if first["level"] != "error" || first["msg"] != "Subscription shutdown failed" {
+		t.Fatalf("Unexpected level or msg: %v", first)
+	}
+	if first["error"] != "boom" {
+		t.Fatalf("Expected error field to hold the error text, got: %v", first["error"])
+	}
+	if first["attempt"] != float64(2) {
+		t.Fatalf("Unexpected attempt field: %v", first["attempt"])
+	}
+	if _, ok := first["time"]; !ok {
+		t.Fatal("Expected a time field")
+	}
+
+	second := entries[1]
+	if second["level"] != "info" || second["msg"] != `message with "quotes"` {
+		t.Fatalf("Unexpected level or msg: %v", second)
+	}
+	if second["dangling"] != "(missing)" {
+		t.Fatalf("Expected a placeholder for a missing value, got: %v", second["dangling"])
+	}
+}
+
+func TestLogLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	w := newWatcher("", buildOptions(WithLogger(NewJSONLogger(&buf)), WithLogLevel(LevelWarn)))
+
+	w.log(LevelInfo, "filtered")
+	w.log(LevelWarn, "kept")
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+		t.Fatalf("Expected exactly one valid log line, got %q: %s", buf.String(), err)
+	}
+	if entry["msg"] != "kept" {
+		t.Fatalf("Unexpected entry: %v", entry)
+	}
+}
+
+func TestSetLogLevelFor(t *testing.T) {
+	var buf bytes.Buffer
+	clock := newFakeClock()
+	w := newWatcher("", buildOptions(WithLogger(NewJSONLogger(&buf)), WithLogLevel(LevelWarn), WithClock(clock)))
+
+	w.SetLogLevelFor(LevelDebug, 5*time.Minute)
+	if got := w.logLevel(); got != LevelDebug {
+		t.Fatalf("Level during the override is %s", got)
+	}
+
+	// an override outlives Reconfigure, which applies once it ends
+	if err := w.Reconfigure(WithLogLevel(LevelError)); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(4 * time.Minute)
+	if got := w.logLevel(); got != LevelDebug {
+		t.Fatalf("Override reverted early, level %s", got)
+	}
+	clock.advance(time.Minute)
+	if got := w.logLevel(); got != LevelError {
+		t.Fatalf("Level after the override is %s, want error", got)
+	}
+
+	// a replaced override doesn't revert the new one
+	w.SetLogLevelFor(LevelDebug, time.Minute)
+	w.SetLogLevelFor(LevelInfo, 10*time.Minute)
+	clock.advance(2 * time.Minute)
+	if got := w.logLevel(); got != LevelInfo {
+		t.Fatalf("Level after replacing the override is %s, want info", got)
+	}
+	w.SetLogLevelFor(LevelInfo, 0)
+	if got := w.logLevel(); got != LevelError {
+		t.Fatalf("Level after ending the override is %s, want error", got)
+	}
+}
@@ -0,0 +1,187 @@
+package watcher
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"gocloud.dev/pubsub"
+)
+
+// Metadata key names, always used with the configured prefix
+const (
+	metaOrigin   = "origin"
+	metaSequence = "sequence"
+	metaOp       = "op"
+	// metaKind marks control messages, which never reach the callbacks
+	metaKind = "kind"
+	// metaReplyTo asks receivers to acknowledge the update, see WithRequiredAcks
+	metaReplyTo = "reply-to"
+	// metaCorrelation carries the reply-to value of the acknowledged update
+	metaCorrelation = "correlation"
+	// metaTarget restricts an update to the watcher with that instance ID
+	metaTarget = "target"
+	// metaHostname, metaPID and metaNode identify the publishing process, see
+	// WithProcessMetadata
+	metaHostname = "hostname"
+	metaPID      = "pid"
+	metaNode     = "node"
+	// metaEncoding names the Compressor the body was compressed with
+	metaEncoding = "encoding"
+	// metaChecksum carries the checksum of the body, see WithChecksum
+	metaChecksum = "checksum"
+	// metaTrace carries the trace context of the publisher, see WithTracing
+	metaTrace = "trace"
+	// metaPayload carries the encoded update of a legacy body, see
+	// WithDualFormat
+	metaPayload = "payload"
+	// metaTenant tags an update with the tenant it's for, see WithTenant
+	metaTenant = "tenant"
+	// metaIdempotencyKey carries the key given to UpdateWithIdempotencyKey
+	metaIdempotencyKey = "idempotency-key"
+	// metaModelHash identifies the publisher's model, see WithModelHash
+	metaModelHash = "model-hash"
+	// metaCompactionKey carries the key of the update, see WithCompactionKey
+	metaCompactionKey = "compaction-key"
+	// metaEpoch and metaInterval describe the sender of a heartbeat, see
+	// WithHeartbeat
+	metaEpoch    = "epoch"
+	metaInterval = "heartbeat-interval"
+	// metaSnapshotFor identifies a snapshot request and its answers, see
+	// WithSnapshotOnReconnect
+	metaSnapshotFor = "snapshot-for"
+	// metaRevision carries the publisher's policy revision, see WithRevision
+	metaRevision = "revision"
+)
+
+// Control message kinds
+const (
+	kindAck = "ack"
+	// kindLeave announces a watcher closing, see WithLeaveAnnouncement
+	kindLeave = "leave"
+	// kindHeartbeat tells that a watcher is alive, see WithHeartbeat
+	kindHeartbeat = "heartbeat"
+	// kindPing probes connectivity, see Ping
+	kindPing = "ping"
+	// kindSnapshotRequest asks for a snapshot, see WithSnapshotOnReconnect
+	kindSnapshotRequest = "snapshot-request"
+)
+
+func (w *Watcher) metadataKey(name string) string {
+	return w.opts.metadataPrefix + name
+}
+
+// newMessage builds an outgoing message stamped with the watcher's origin,
+// the next sequence number and the operation.
+func (w *Watcher) newMessage(body []byte, op UpdateType) *pubsub.Message {
+	m := w.newUnsequencedMessage(body, op)
+	seq := atomic.AddUint64(&w.seq, 1)
+	m.Metadata[w.metadataKey(metaSequence)] = strconv.FormatUint(seq, 10)
+	return m
+}
+
+// newUnsequencedMessage returns an update message like newMessage, without
+// a sequence, for updates not delivered in the order they're sent
+func (w *Watcher) newUnsequencedMessage(body []byte, op UpdateType) *pubsub.Message {
+	md := map[string]string{
+		w.metadataKey(metaOrigin): w.opts.instanceID,
+		w.metadataKey(metaOp):     string(op),
+	}
+	for k, v := range w.processMetadata {
+		md[k] = v
+	}
+	if w.opts.tenant != "" {
+		md[w.metadataKey(metaTenant)] = w.opts.tenant
+	}
+	if w.opts.modelHash != "" {
+		md[w.metadataKey(metaModelHash)] = w.opts.modelHash
+	}
+	w.stampRevision(md)
+	return &pubsub.Message{Body: body, Metadata: md}
+}
+
+// WithProcessMetadata stamps the hostname and process ID, and node if not
+// empty, into the metadata of every update sent. Receivers find them in the
+// Hostname, PID and Node fields of UpdateMessage.
+func WithProcessMetadata(node string) Option {
+	return optionFunc(func(o *options) {
+		o.processMetadata = true
+		o.node = node
+	})
+}
+
+// newProcessMetadata gathers the metadata stamped by WithProcessMetadata,
+// keyed with the prefix
+func (w *Watcher) newProcessMetadata() map[string]string {
+	if !w.opts.processMetadata {
+		return nil
+	}
+	md := map[string]string{
+		w.metadataKey(metaPID): strconv.Itoa(os.Getpid()),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		md[w.metadataKey(metaHostname)] = hostname
+	}
+	if w.opts.node != "" {
+		md[w.metadataKey(metaNode)] = w.opts.node
+	}
+	return md
+}
+
+// newControlMessage builds an outgoing control message of the given kind.
+// Control messages carry no sequence number.
+func (w *Watcher) newControlMessage(kind string, metadata map[string]string) *pubsub.Message {
+	md := map[string]string{
+		w.metadataKey(metaOrigin): w.opts.instanceID,
+		w.metadataKey(metaKind):   kind,
+	}
+	for k, v := range metadata {
+		md[w.metadataKey(k)] = v
+	}
+	return &pubsub.Message{Body: []byte(kind), Metadata: md}
+}
+
+// isForeign reports whether msg was published by something other than a
+// watcher using the same prefix. Messages without any metadata are treated
+// as coming from older watcher versions and are not foreign.
+func (w *Watcher) isForeign(msg *pubsub.Message) bool {
+	if len(msg.Metadata) == 0 {
+		return false
+	}
+	_, ok := msg.Metadata[w.metadataKey(metaOrigin)]
+	return !ok
+}
+
+// isWatcherMessage reports whether msg was published by a watcher using the
+// same prefix, or by an older watcher sending the legacy body without
+// metadata.
+func (w *Watcher) isWatcherMessage(msg *pubsub.Message) bool {
+	if len(msg.Metadata) == 0 {
+		return w.isReloadSignal(msg.Body)
+	}
+	_, ok := msg.Metadata[w.metadataKey(metaOrigin)]
+	return ok
+}
+
+// readMetadata copies the watcher metadata of msg into um.
+func (w *Watcher) readMetadata(msg *pubsub.Message, um *UpdateMessage) {
+	um.Origin = msg.Metadata[w.metadataKey(metaOrigin)]
+	if s, ok := msg.Metadata[w.metadataKey(metaSequence)]; ok {
+		um.Sequence, _ = strconv.ParseUint(s, 10, 64)
+	}
+	um.Hostname = msg.Metadata[w.metadataKey(metaHostname)]
+	if s, ok := msg.Metadata[w.metadataKey(metaPID)]; ok {
+		um.PID, _ = strconv.Atoi(s)
+	}
+	um.Node = msg.Metadata[w.metadataKey(metaNode)]
+	um.Tenant = msg.Metadata[w.metadataKey(metaTenant)]
+	um.IdempotencyKey = w.idempotencyKey(msg)
+	um.CompactionKey = msg.Metadata[w.metadataKey(metaCompactionKey)]
+	um.Revision = w.messageRevision(msg)
+	um.CorrelationID = w.messageCorrelation(msg)
+}
+
+// isSelf reports whether msg was published by this watcher.
+func (w *Watcher) isSelf(msg *pubsub.Message) bool {
+	return msg.Metadata[w.metadataKey(metaOrigin)] == w.opts.instanceID
+}
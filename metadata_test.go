@@ -0,0 +1,133 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+func TestMetadataPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const topicURL = "mem://metadata-prefix"
+
+	sender, err := NewWithOptions(ctx, topicURL, WithMetadataPrefix("tenant-a-"), WithInstanceID("sender"))
+	if err != nil {
+		t.Fatalf("Failed to create sender, error: %s", err)
+	}
+	defer sender.Close()
+
+	samePrefix, err := NewWithOptions(ctx, topicURL, WithMetadataPrefix("tenant-a-"))
+	if err != nil {
+		t.Fatalf("Failed to create listener, error: %s", err)
+	}
+	defer samePrefix.Close()
+
+	otherPrefix, err := NewWithOptions(ctx, topicURL, WithMetadataPrefix("tenant-b-"))
+	if err != nil {
+		t.Fatalf("Failed to create listener, error: %s", err)
+	}
+	defer otherPrefix.Close()
+
+	sameCh := make(chan UpdateMessage, 2)
+	samePrefix.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		sameCh <- um
+		return nil
+	})
+	otherCh := make(chan string, 2)
+	otherPrefix.SetUpdateCallback(func(msg string) {
+		otherCh <- msg
+	})
+
+	if err := sender.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+
+	select {
+	case um := <-sameCh:
+		if um.Origin != "sender" {
+			t.Fatalf("Expected origin %q, got %q", "sender", um.Origin)
+		}
+		if um.Sequence != 1 {
+			t.Fatalf("Expected sequence 1, got %d", um.Sequence)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Listener with the same prefix didn't receive the update in time")
+	}
+
+	// a message without metadata comes from an older watcher and must still
+	// be understood by every prefix
+	if err := sender.topic.Send(ctx, &pubsub.Message{Body: []byte(legacyUpdateBody)}); err != nil {
+		t.Fatalf("Failed to send legacy update: %s", err)
+	}
+
+	select {
+	case msg := <-otherCh:
+		if msg != legacyUpdateBody {
+			t.Fatalf("Listener with another prefix received a prefixed message: %q", msg)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Listener with another prefix didn't receive the legacy update in time")
+	}
+
+	select {
+	case msg := <-otherCh:
+		t.Fatalf("Unexpected message delivered to listener with another prefix: %q", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestOutgoingMetadata(t *testing.T) {
+	w := newWatcher("", buildOptions(WithInstanceID("node-1")))
+
+	first := w.newMessage([]byte(legacyUpdateBody), Update)
+	second := w.newMessage([]byte("{}"), UpdateForAddPolicy)
+
+	if got := first.Metadata["casbin-origin"]; got != "node-1" {
+		t.Fatalf("Unexpected origin: %q", got)
+	}
+	if got := first.Metadata["casbin-op"]; got != string(Update) {
+		t.Fatalf("Unexpected op: %q", got)
+	}
+	if first.Metadata["casbin-sequence"] != "1" || second.Metadata["casbin-sequence"] != "2" {
+		t.Fatalf("Unexpected sequences: %q, %q", first.Metadata["casbin-sequence"], second.Metadata["casbin-sequence"])
+	}
+}
+
+func TestProcessMetadata(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("No hostname available: %s", err)
+	}
+
+	w := newWatcher("", buildOptions(WithProcessMetadata("eu-west-1a")))
+	msg := w.newMessage([]byte("{}"), UpdateForAddPolicy)
+
+	if got := msg.Metadata["casbin-hostname"]; got != hostname {
+		t.Fatalf("Expected hostname %q, got %q", hostname, got)
+	}
+	if got := msg.Metadata["casbin-pid"]; got != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("Unexpected pid: %q", got)
+	}
+	if got := msg.Metadata["casbin-node"]; got != "eu-west-1a" {
+		t.Fatalf("Unexpected node: %q", got)
+	}
+
+	var um UpdateMessage
+	w.readMetadata(msg, &um)
+	if um.Hostname != hostname || um.PID != os.Getpid() || um.Node != "eu-west-1a" {
+		t.Fatalf("Process metadata not surfaced to receivers: %+v", um)
+	}
+
+	plain := newWatcher("", buildOptions()).newMessage([]byte("{}"), UpdateForAddPolicy)
+	for _, key := range []string{"casbin-hostname", "casbin-pid", "casbin-node"} {
+		if _, ok := plain.Metadata[key]; ok {
+			t.Fatalf("%s stamped without WithProcessMetadata", key)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package watcher
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics receives the watcher's measurements, e.g. to export them as
+// Prometheus histograms. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveDuration records a sample of the named duration.
+	ObserveDuration(name string, d time.Duration)
+}
+
+// LabeledMetrics is implemented by Metrics accepting labels. The samples of
+// a watcher given a name with WithName are passed to ObserveDurationLabeled,
+// with the name under LabelWatcher, instead of ObserveDuration.
+type LabeledMetrics interface {
+	Metrics
+	ObserveDurationLabeled(name string, d time.Duration, labels map[string]string)
+}
+
+// Metric names passed to Metrics
+const (
+	// MetricApplyLatency is the time a callback took to apply an update.
+	MetricApplyLatency = "casbin_watcher_apply_latency"
+	// MetricReconnectDuration is the time a successful reconnect of the
+	// subscription took.
+	MetricReconnectDuration = "casbin_watcher_reconnect_duration"
+)
+
+// WithMetrics passes the watcher's measurements to m.
+func WithMetrics(m Metrics) Option {
+	return optionFunc(func(o *options) {
+		o.metrics = m
+	})
+}
+
+func (w *Watcher) observe(name string, d time.Duration) {
+	if w.opts.metrics == nil {
+		return
+	}
+	if lm, ok := w.opts.metrics.(LabeledMetrics); ok && w.opts.name != "" {
+		lm.ObserveDurationLabeled(name, d, map[string]string{LabelWatcher: w.opts.name})
+		return
+	}
+	w.opts.metrics.ObserveDuration(name, d)
+}
+
+// latencySamples is the number of recent samples LatencySummary is computed
+// from
+const latencySamples = 1024
+
+// LatencySummary summarises recent durations
+type LatencySummary struct {
+	// Count is the number of samples since the watcher was created; the
+	// percentiles and Max cover the last 1024.
+	Count uint64        `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// latencyRecorder keeps the last latencySamples durations in a ring buffer
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	count   uint64
+}
+
+func (r *latencyRecorder) record(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.samples) < latencySamples {
+		r.samples = append(r.samples, d)
+	} else {
+		r.samples[r.next] = d
+		r.next = (r.next + 1) % latencySamples
+	}
+	r.count++
+}
+
+func (r *latencyRecorder) summary() LatencySummary {
+	r.mu.Lock()
+	sorted := append([]time.Duration(nil), r.samples...)
+	s := LatencySummary{Count: r.count}
+	r.mu.Unlock()
+	if len(sorted) == 0 {
+		return s
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	s.P50, s.P90, s.P99 = percentile(50), percentile(90), percentile(99)
+	s.Max = sorted[len(sorted)-1]
+	return s
+}
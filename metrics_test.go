@@ -0,0 +1,134 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingMetrics keeps every sample passed to it
+type recordingMetrics struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+}
+
+func (m *recordingMetrics) ObserveDuration(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.samples == nil {
+		m.samples = map[string][]time.Duration{}
+	}
+	m.samples[name] = append(m.samples[name], d)
+}
+
+func (m *recordingMetrics) observed(name string) []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Duration(nil), m.samples[name]...)
+}
+
+func TestApplyLatency(t *testing.T) {
+	ctx := context.Background()
+
+	clock := newFakeClock()
+	metrics := &recordingMetrics{}
+	w, err := NewWithOptions(ctx, "fake://latency", WithURLMux((&fakeBroker{}).mux()), WithClock(clock), WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	// the callback takes its time on the clock the watcher measures with
+	slowness := make(chan time.Duration, 1)
+	w.SetUpdateCallbackEx(func(UpdateMessage) error {
+		clock.advance(<-slowness)
+		return nil
+	})
+	for i, d := range []time.Duration{2 * time.Second, 10 * time.Millisecond, 10 * time.Millisecond} {
+		slowness <- d
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+		n := uint64(i + 1)
+		waitFor(t, time.Second*5, func() bool { return w.Stats().ApplyLatency.Count == n })
+	}
+
+	latency := w.Stats().ApplyLatency
+	if latency.Max != 2*time.Second || latency.P99 != 10*time.Millisecond || latency.P50 != 10*time.Millisecond {
+		t.Fatalf("Unexpected apply latency: %+v", latency)
+	}
+	observed := metrics.observed(MetricApplyLatency)
+	if len(observed) != 3 || observed[0] != 2*time.Second {
+		t.Fatalf("Unexpected samples passed to Metrics: %v", observed)
+	}
+}
+
+func TestReconnectMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failOpens int32
+	broker := &fakeBroker{
+		openSubErr: func(*url.URL) error {
+			if atomic.AddInt32(&failOpens, -1) >= 0 {
+				return errors.New("broker unavailable")
+			}
+			return nil
+		},
+	}
+	metrics := &recordingMetrics{}
+	logs := &syncBuffer{}
+	w, err := NewWithOptions(ctx, "fake://reconnect-metrics", WithURLMux(broker.mux()), WithMetrics(metrics),
+		WithBackoff(ExponentialBackoff{Min: reconnectMinBackoff, Max: reconnectMaxBackoff}), WithLogger(NewJSONLogger(logs)))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	// the first attempt fails, the second one reconnects
+	atomic.StoreInt32(&failOpens, 1)
+	broker.subscriptions()[0].fail(errors.New("connection reset"))
+	waitFor(t, time.Second*5, func() bool { return w.Stats().ReconnectDuration.Count == 1 })
+
+	stats := w.Stats()
+	if stats.Reconnects != 1 {
+		t.Fatalf("Expected 1 reconnect, got %d", stats.Reconnects)
+	}
+	// the backoff before both attempts is part of the reconnect
+	if min := reconnectMinBackoff * 3; stats.ReconnectDuration.Max < min {
+		t.Fatalf("Expected the reconnect to take at least %s, got %s", min, stats.ReconnectDuration.Max)
+	}
+	if observed := metrics.observed(MetricReconnectDuration); len(observed) != 1 || observed[0] != stats.ReconnectDuration.Max {
+		t.Fatalf("Expected the reconnect duration to be observed once, got %v", observed)
+	}
+	for _, want := range []string{
+		`"msg":"Reconnecting","reconnect":1`,
+		`"attempt":1,"backoff":100000000`,
+		`"attempts":2,"duration":`,
+		`"msg":"Reconnected","outcome":"connected","reconnect":1`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("Expected %s in the logs:\n%s", want, logs)
+		}
+	}
+}
+
+func TestLatencySummary(t *testing.T) {
+	var r latencyRecorder
+	for i := 1; i <= 2*latencySamples; i++ {
+		r.record(time.Duration(i) * time.Millisecond)
+	}
+	s := r.summary()
+	if s.Count != 2*latencySamples || s.Max != 2*latencySamples*time.Millisecond {
+		t.Fatalf("Unexpected summary: %+v", s)
+	}
+	// only the last samples are kept
+	if want := time.Duration(latencySamples+latencySamples/2) * time.Millisecond; s.P50 < want-time.Millisecond || s.P50 > want {
+		t.Fatalf("P50 is %s, want about %s", s.P50, want)
+	}
+}
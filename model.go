@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrModelMismatch is matched by the *ModelMismatchError sent on the Errors
+// channel
+var ErrModelMismatch = errors.New("update sent by a watcher running another model")
+
+// WithModelHash stamps hash, identifying the model of the local enforcer,
+// into every update sent, and compares it with the hash carried by the
+// updates received. An update from a watcher running another model is
+// still delivered, but logged as a warning and reported on the Errors
+// channel with a *ModelMismatchError, once per origin and hash, so drift
+// across the fleet surfaces. Any string identifying the model will do, such
+// as a version; ModelHash computes one from the model definition.
+func WithModelHash(hash string) Option {
+	return optionFunc(func(o *options) {
+		o.modelHash = hash
+	})
+}
+
+// ModelHash returns a hash of the model definition text, e.g. the content
+// of model.conf, for WithModelHash. Blank lines, comments and the
+// whitespace around lines don't change it.
+func ModelHash(model string) string {
+	h := sha256.New()
+	for _, line := range strings.Split(model, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ModelMismatchError reports an update sent by a watcher whose model hash
+// differs from this watcher's
+type ModelMismatchError struct {
+	Origin string
+	// Local is this watcher's hash, Remote the one the update carried.
+	Local, Remote string
+}
+
+func (e *ModelMismatchError) Error() string {
+	return fmt.Sprintf("watcher %q runs model %s, this watcher runs %s", e.Origin, e.Remote, e.Local)
+}
+
+func (e *ModelMismatchError) Is(target error) bool { return target == ErrModelMismatch }
+
+// modelDrift remembers the mismatching hash last reported per origin
+type modelDrift struct {
+	mu       sync.Mutex
+	reported map[string]string
+}
+
+// checkModel reports msg if it carries a model hash other than this
+// watcher's. Messages without one, from watchers not using WithModelHash,
+// are never reported.
+func (w *Watcher) checkModel(msg *pubsub.Message) {
+	remote := msg.Metadata[w.metadataKey(metaModelHash)]
+	if w.opts.modelHash == "" || remote == "" || remote == w.opts.modelHash {
+		return
+	}
+	origin := msg.Metadata[w.metadataKey(metaOrigin)]
+	d := &w.modelDrift
+	d.mu.Lock()
+	if d.reported == nil {
+		d.reported = map[string]string{}
+	}
+	seen := d.reported[origin] == remote
+	d.reported[origin] = remote
+	d.mu.Unlock()
+	if seen {
+		return
+	}
+	w.log(LevelWarn, "Received an update from a watcher running another model",
+		"origin", origin, "model", remote, "local", w.opts.modelHash, "id", msg.LoggableID)
+	w.pushError(&ModelMismatchError{Origin: origin, Local: w.opts.modelHash, Remote: remote})
+}
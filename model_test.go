@@ -0,0 +1,95 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testModel = `[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+
+func TestModelHash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reformatted := "# same model\n" + strings.ReplaceAll(testModel, "\n", "  \n\n")
+	if ModelHash(reformatted) != ModelHash(testModel) {
+		t.Fatal("Expected formatting not to change the model hash")
+	}
+	drifted := strings.Replace(testModel, "r.act == p.act", "regexMatch(r.act, p.act)", 1)
+
+	mux := (&fakeBroker{}).mux()
+	logs := &syncBuffer{}
+	w, err := NewWithOptions(ctx, "fake://model", WithURLMux(mux), WithInstanceID("receiver"),
+		WithModelHash(ModelHash(testModel)), WithErrorChannel(4), WithLogger(NewJSONLogger(logs)))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	reloads := make(chan string, 8)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		reloads <- um.Origin
+		return nil
+	})
+
+	send := func(origin, model string) {
+		t.Helper()
+		sender, err := NewWithOptions(ctx, "fake://model", WithURLMux(mux), WithInstanceID(origin),
+			WithModelHash(ModelHash(model)))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		defer sender.Close()
+		for i := 0; i < 2; i++ {
+			if err := sender.Update(); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			select {
+			case got := <-reloads:
+				if got != origin {
+					t.Fatalf("Expected the update of %s, got %s's", origin, got)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatalf("The update of %s wasn't applied", origin)
+			}
+		}
+	}
+
+	send("same", reformatted)
+	send("drifted", drifted)
+
+	// the mismatch is reported once
+	var mismatch *ModelMismatchError
+	select {
+	case err := <-w.Errors():
+		if !errors.Is(err, ErrModelMismatch) || !errors.As(err, &mismatch) {
+			t.Fatalf("Expected a model mismatch, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The model mismatch wasn't reported")
+	}
+	if mismatch.Origin != "drifted" || mismatch.Remote != ModelHash(drifted) || mismatch.Local != ModelHash(testModel) {
+		t.Fatalf("Unexpected mismatch: %+v", mismatch)
+	}
+	select {
+	case err := <-w.Errors():
+		t.Fatalf("Unexpected error: %v", err)
+	default:
+	}
+	if n := strings.Count(logs.String(), "another model"); n != 1 {
+		t.Fatalf("Expected one warning, got %d in %s", n, logs.String())
+	}
+}
@@ -0,0 +1,43 @@
+package watcher
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"gocloud.dev/pubsub"
+)
+
+// MessageIDExtractor returns the provider's native ID of a received message,
+// read with msg.As, and false when it doesn't support the provider.
+type MessageIDExtractor func(msg *pubsub.Message) (string, bool)
+
+var (
+	messageIDExtractorsMu sync.RWMutex
+	messageIDExtractors   []MessageIDExtractor
+)
+
+// RegisterMessageIDExtractor adds e to the extractors filling the MessageID
+// of received updates. Drivers whose provider assigns message IDs register
+// one on import.
+func RegisterMessageIDExtractor(e MessageIDExtractor) {
+	messageIDExtractorsMu.Lock()
+	defer messageIDExtractorsMu.Unlock()
+	messageIDExtractors = append(messageIDExtractors, e)
+}
+
+// nativeMessageID runs the registered extractors until one supports the
+// provider. It's empty for providers without native IDs.
+func (w *Watcher) nativeMessageID(msg *pubsub.Message) string {
+	if atomic.LoadInt32(&w.foreignMessages) == 1 || msg.LoggableID == localBusID {
+		// not received from a provider, As would panic
+		return ""
+	}
+	messageIDExtractorsMu.RLock()
+	defer messageIDExtractorsMu.RUnlock()
+	for _, e := range messageIDExtractors {
+		if id, ok := e(msg); ok {
+			return id
+		}
+	}
+	return ""
+}
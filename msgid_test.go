@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/driver"
+)
+
+// fakeNativeMessage is what the fake provider exposes through Message.As
+type fakeNativeMessage struct {
+	ID string
+}
+
+func init() {
+	RegisterMessageIDExtractor(func(msg *pubsub.Message) (string, bool) {
+		var native *fakeNativeMessage
+		if !msg.As(&native) {
+			return "", false
+		}
+		return native.ID, true
+	})
+}
+
+func TestNativeMessageID(t *testing.T) {
+	ctx := context.Background()
+
+	broker := &fakeBroker{
+		messageAs: func(m *driver.Message, i interface{}) bool {
+			p, ok := i.(**fakeNativeMessage)
+			if ok {
+				*p = &fakeNativeMessage{ID: fmt.Sprintf("native-%v", m.AckID)}
+			}
+			return ok
+		},
+	}
+	w, err := NewWithOptions(ctx, "fake://msgid", WithURLMux(broker.mux()), WithRecentMessages(1))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	received := make(chan UpdateMessage, 1)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		received <- um
+		return nil
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+
+	var um UpdateMessage
+	select {
+	case um = <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Callback wasn't invoked in time")
+	}
+	if !strings.HasPrefix(um.MessageID, "native-") {
+		t.Fatalf("Expected the native message ID, got %q", um.MessageID)
+	}
+	waitFor(t, time.Second*5, func() bool { return len(w.RecentMessages()) == 1 })
+	if got := w.RecentMessages()[0].MessageID; got != um.MessageID {
+		t.Fatalf("RecentMessages has ID %q, the callback got %q", got, um.MessageID)
+	}
+}
+
+func TestNativeMessageIDUnsupported(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://msgid", WithURLMux((&fakeBroker{}).mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	received := make(chan UpdateMessage, 1)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		received <- um
+		return nil
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case um := <-received:
+		if um.MessageID != "" {
+			t.Fatalf("Expected no message ID from a provider without one, got %q", um.MessageID)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Callback wasn't invoked in time")
+	}
+}
@@ -0,0 +1,15 @@
+package watcher
+
+// LabelWatcher is the label carrying the WithName name of a watcher in
+// LabeledMetrics samples
+const LabelWatcher = "watcher"
+
+// WithName labels the watcher to tell it apart from the others of the
+// process, e.g. watching other topics or models: its log messages are
+// prefixed with name, and its samples are labeled with it when the Metrics
+// implement LabeledMetrics. Unnamed by default.
+func WithName(name string) Option {
+	return optionFunc(func(o *options) {
+		o.name = name
+	})
+}
@@ -0,0 +1,85 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// labeledMetrics counts the samples of each watcher label
+type labeledMetrics struct {
+	mu        sync.Mutex
+	unlabeled int
+	labeled   map[string]int
+}
+
+func (m *labeledMetrics) ObserveDuration(string, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unlabeled++
+}
+
+func (m *labeledMetrics) ObserveDurationLabeled(name string, _ time.Duration, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.labeled == nil {
+		m.labeled = map[string]int{}
+	}
+	m.labeled[name+"/"+labels[LabelWatcher]]++
+}
+
+func (m *labeledMetrics) count(key string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.labeled[key]
+}
+
+func TestWithName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metrics := &labeledMetrics{}
+	logs := &syncBuffer{}
+	broker := &fakeBroker{}
+	for _, name := range []string{"orders", "billing"} {
+		w, err := NewWithOptions(ctx, "fake://"+name, WithURLMux(broker.mux()), WithName(name),
+			WithMetrics(metrics), WithLogger(NewJSONLogger(logs)))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		defer w.Close()
+		w.SetUpdateCallbackEx(func(UpdateMessage) error { return errors.New("apply failed") })
+	}
+
+	// both watchers share the broker, so each gets the update of the other
+	w, err := NewWithOptions(ctx, "fake://unnamed", WithURLMux(broker.mux()), WithMetrics(metrics),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallbackEx(func(UpdateMessage) error { return nil })
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+
+	waitFor(t, time.Second*5, func() bool {
+		return metrics.count(MetricApplyLatency+"/orders") == 1 && metrics.count(MetricApplyLatency+"/billing") == 1
+	})
+	waitFor(t, time.Second*5, func() bool {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.unlabeled == 1
+	})
+	for _, name := range []string{"orders", "billing"} {
+		waitFor(t, time.Second*5, func() bool {
+			return strings.Contains(logs.String(), `"msg":"`+name+`: Update callback failed"`)
+		})
+	}
+	if c := w.Config(); c.Name != "" {
+		t.Fatalf("Expected the watcher unnamed by default, got %q", c.Name)
+	}
+}
@@ -0,0 +1,115 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeferredOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failSub int32 = 1
+	broker := &fakeBroker{openSubErr: func(*url.URL) error {
+		if atomic.LoadInt32(&failSub) == 1 {
+			return errors.New("subscription unavailable")
+		}
+		return nil
+	}}
+	w := NewUnconnected("fake://open", WithURLMux(broker.mux()))
+	defer w.Close()
+	applied := make(chan UpdateMessage, 1)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		return nil
+	})
+
+	// nothing is open yet
+	if err := w.Update(); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Expected ErrNotConnected before Open, got: %v", err)
+	}
+	if w.Connected() {
+		t.Fatal("Watcher connected before Open")
+	}
+
+	// a failed Open can be retried
+	if err := w.Open(ctx); err == nil {
+		t.Fatal("Expected Open to fail while the subscription is unavailable")
+	}
+	atomic.StoreInt32(&failSub, 0)
+	if err := w.Open(ctx); err != nil {
+		t.Fatalf("Failed to open watcher, error: %s", err)
+	}
+	if err := w.Open(ctx); !errors.Is(err, ErrAlreadyOpen) {
+		t.Fatalf("Expected ErrAlreadyOpen on a second Open, got: %v", err)
+	}
+
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case um := <-applied:
+		if um.Op != UpdateForAddPolicy {
+			t.Fatalf("Expected %s, got %s", UpdateForAddPolicy, um.Op)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update wasn't applied after Open")
+	}
+
+	w.Close()
+	if err := w.Open(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed opening a closed watcher, got: %v", err)
+	}
+}
+
+func TestOpenAfterClose(t *testing.T) {
+	broker := &fakeBroker{}
+	w := NewUnconnected("fake://open", WithURLMux(broker.mux()))
+	w.Close()
+	if err := w.Open(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got: %v", err)
+	}
+	if n := len(broker.subscriptions()); n != 0 {
+		t.Fatalf("Expected nothing opened, got %d subscriptions", n)
+	}
+}
+
+func TestOpenWhileUpdating(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := NewUnconnected("fake://open", WithURLMux((&fakeBroker{}).mux()),
+		WithAsyncSend(10, SendLimitBlock, 0), WithHeartbeat(time.Millisecond))
+	defer w.Close()
+
+	// updates racing Open, run with -race, see either side of it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := w.Update(); err != nil && !errors.Is(err, ErrNotConnected) {
+				t.Errorf("Unexpected error updating during Open: %v", err)
+				return
+			}
+		}
+	}()
+	if err := w.Open(ctx); err != nil {
+		t.Fatalf("Failed to open watcher, error: %s", err)
+	}
+	<-done
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update after Open: %s", err)
+	}
+
+	// the context given to Open still bounds the life of the watcher
+	cancel()
+	select {
+	case <-w.lifecycle().Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("Cancelling the Open context didn't end the watcher's life")
+	}
+}
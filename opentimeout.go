@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultOpenTimeout bounds every attempt to open the topic or the
+// subscription when the context has no deadline, see WithOpenTimeout
+const DefaultOpenTimeout = 30 * time.Second
+
+// ErrOpenTimeout is returned by New, NewWithOptions and Open when opening
+// the topic or the subscription took longer than the open timeout
+var ErrOpenTimeout = errors.New("timed out opening pubsub connection")
+
+// WithOpenTimeout bounds how long each attempt to open the topic or the
+// subscription may take when the context given to NewWithOptions has no
+// deadline, DefaultOpenTimeout by default, so an unresponsive broker can't
+// hang New. An attempt that takes longer fails with ErrOpenTimeout and is
+// retried like other failures, see WithOpenRetry; a topic or subscription
+// opened after it was given up on is shut down. The bound also applies to
+// SwitchTopic and reconnects. Zero or less waits forever.
+func WithOpenTimeout(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.openTimeout = d
+	})
+}
+
+// boundedTransport bounds the opens of a transport with the open timeout
+type boundedTransport struct {
+	transport
+	timeout time.Duration
+}
+
+// shutdowner is what topics and subscriptions have in common
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+func (t boundedTransport) openTopic(ctx context.Context, url string) (topicSender, error) {
+	topic, err := t.bound(ctx, "topic", func(ctx context.Context) (shutdowner, error) {
+		return t.transport.openTopic(ctx, url)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sender, ok := topic.(topicSender)
+	if !ok {
+		return nil, fmt.Errorf("failed to open topic %s, error: the transport returned none", url)
+	}
+	return sender, nil
+}
+
+func (t boundedTransport) openSubscription(ctx context.Context, url string) (subscriptionReceiver, error) {
+	sub, err := t.bound(ctx, "subscription", func(ctx context.Context) (shutdowner, error) {
+		return t.transport.openSubscription(ctx, url)
+	})
+	if err != nil {
+		return nil, err
+	}
+	receiver, ok := sub.(subscriptionReceiver)
+	if !ok {
+		return nil, fmt.Errorf("failed to open subscription %s, error: the transport returned none", url)
+	}
+	return receiver, nil
+}
+
+// bound runs open in the background and gives up once the timeout elapsed,
+// even if open ignores its context. What it opens afterwards is shut down.
+func (t boundedTransport) bound(ctx context.Context, what string, open func(ctx context.Context) (shutdowner, error)) (shutdowner, error) {
+	if _, ok := ctx.Deadline(); ok || t.timeout <= 0 {
+		return open(ctx)
+	}
+	openCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	type result struct {
+		opened shutdowner
+		err    error
+	}
+	// unbuffered, so a result not taken is shut down
+	done := make(chan result)
+	abandoned := make(chan struct{})
+	go func() {
+		opened, err := open(openCtx)
+		select {
+		case done <- result{opened, err}:
+		case <-abandoned:
+			if err == nil && opened != nil {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				_ = opened.Shutdown(shutdownCtx)
+			}
+		}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil && errors.Is(r.err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil, fmt.Errorf("%w: %s after %s", ErrOpenTimeout, what, t.timeout)
+		}
+		return r.opened, r.err
+	case <-openCtx.Done():
+		close(abandoned)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %s after %s", ErrOpenTimeout, what, t.timeout)
+	}
+}
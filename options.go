@@ -0,0 +1,264 @@
+package watcher
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/model"
+	"go.opencensus.io/trace"
+	"gocloud.dev/pubsub"
+)
+
+// DefaultMetadataPrefix is prepended to every metadata key set by the watcher
+const DefaultMetadataPrefix = "casbin-"
+
+// DefaultSendTimeout bounds every send when the watcher's context has no
+// deadline, see WithSendTimeout
+const DefaultSendTimeout = 30 * time.Second
+
+// Option configures a Watcher created with NewWithOptions
+type Option interface {
+	apply(*options)
+}
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) { f(o) }
+
+type options struct {
+	subURL         string
+	instanceID     string
+	metadataPrefix string
+	logger         Logger
+	urlMux         *pubsub.URLMux
+	// transport replaces the urlMux in tests
+	transport      transport
+	strictBinding  bool
+	requiredAcks   int
+	ackTimeout     time.Duration
+	updatesBuffer  int
+	backpressure   Backpressure
+	budgetMessages int
+	budgetBytes    int64
+	openRetries    int
+	savePolicyMode SavePolicyMode
+	bodyDecoder    BodyDecoder
+	sendTimeout    time.Duration
+	// processMetadata and node configure WithProcessMetadata
+	processMetadata  bool
+	node             string
+	onAck            func(seq uint64, origin string, outcome AckOutcome)
+	errorClassifier  ErrorClassifier
+	recentMessages   int
+	ackOnlyOnSuccess bool
+	panicHandler     func(recovered interface{}) error
+	applyLock        sync.Locker
+	errorsBuffer     int
+	detachedContext  bool
+	// subscriptionFilter is forwarded to the opener, see WithSubscriptionFilter
+	subscriptionFilter  string
+	consumerName        string
+	clock               Clock
+	duplicateIDStrategy DuplicateIDStrategy
+	// compressor, compressThreshold and decompressors configure compression,
+	// see WithCompression
+	compressor        Compressor
+	compressThreshold int
+	decompressors     []Compressor
+	checksum          Checksum
+	maxSends          int
+	sendLimitPolicy   SendLimitPolicy
+	// sendQueue, sendQueuePolicy and sendRetries configure WithAsyncSend
+	sendQueue       int
+	sendQueuePolicy SendLimitPolicy
+	sendRetries     int
+	healthDebounce  time.Duration
+	announceLeave   bool
+	initialResync   bool
+	localBus        *LocalBus
+	legacyMode      bool
+	tracing         bool
+	traceSampler    trace.Sampler
+	sequenceStore   SequenceStore
+	dualFormatUntil time.Time
+	metrics         Metrics
+	// healthyAfter, maxRestarts and restartWindow configure
+	// WithReconnectSupervision
+	healthyAfter  time.Duration
+	maxRestarts   int
+	restartWindow time.Duration
+	// tenant and tenantFilter configure WithTenant and WithTenantFilter
+	tenant       string
+	tenantFilter map[string]bool
+	// maxPayloadBytes bounds received bodies, see WithMaxPayloadBytes
+	maxPayloadBytes int64
+	// leaseInterval and leaseExtension configure WithLeaseExtension
+	leaseInterval    time.Duration
+	leaseExtension   time.Duration
+	idempotencyStore IdempotencyStore
+	baselineBuffer   int
+	receiveRefresh   time.Duration
+	reloadSignal     string
+	originLanes      int
+	modelHash        string
+	callbackTimeout  time.Duration
+	compactionKey    func(UpdateMessage) string
+	backoff          Backoff
+	maxUnacked       int
+	// heartbeatInterval enables heartbeats, see WithHeartbeat
+	heartbeatInterval time.Duration
+	gapResync         bool
+	// name labels the logs and metrics, see WithName
+	name           string
+	strictOrdering bool
+	// spillPath and spillMax configure WithSpillFile
+	spillPath string
+	spillMax  int64
+	revision  func() uint64
+	// maxBufferedAge expires queued and spilled updates, see
+	// WithMaxBufferedUpdateAge
+	maxBufferedAge time.Duration
+	idleTimeout    time.Duration
+	// reloadOnApplyFailure enables WithReloadOnApplyFailure
+	reloadOnApplyFailure bool
+	minimalFinalizer     bool
+	batchSize            int
+	batchLinger          time.Duration
+	contentRevision      func() string
+	contentChanged       func(revision string, um UpdateMessage) bool
+	openTimeout          time.Duration
+	snapshotSource       func() model.Model
+	snapshotOnReconnect  bool
+	sequenceTracking     bool
+	// diagnostics writes a dump, see WithDiagnosticsDump
+	diagnostics         func(*Watcher) error
+	diagnosticsInterval time.Duration
+	diagnosticsSignals  []os.Signal
+	tunables            tunables
+}
+
+func defaultOptions() options {
+	return options{
+		instanceID:     newInstanceID(),
+		metadataPrefix: DefaultMetadataPrefix,
+		logger:         stdLogger{},
+		tunables: tunables{
+			logLevel: LevelInfo,
+		},
+		urlMux:          pubsub.DefaultURLMux(),
+		sendTimeout:     DefaultSendTimeout,
+		openTimeout:     DefaultOpenTimeout,
+		errorClassifier: DefaultErrorClassifier,
+		panicHandler:    defaultPanicHandler,
+		clock:           systemClock{},
+		healthDebounce:  DefaultHealthDebounce,
+		reloadSignal:    legacyUpdateBody,
+		backoff:         DefaultBackoff,
+	}
+}
+
+func buildOptions(opts ...Option) options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	return o
+}
+
+// WithSubscriptionURL sets the subscription URL, by default the topic URL is
+// used for both publishing and subscribing.
+func WithSubscriptionURL(url string) Option {
+	return optionFunc(func(o *options) {
+		o.subURL = url
+	})
+}
+
+// WithInstanceID sets the ID stamped as the origin of every message sent by
+// this watcher. A random ID is generated by default.
+func WithInstanceID(id string) Option {
+	return optionFunc(func(o *options) {
+		o.instanceID = id
+	})
+}
+
+// WithMetadataPrefix sets the prefix of all metadata keys the watcher sets and
+// reads, so the topic can be shared with other traffic. Messages carrying
+// metadata but none of the prefixed keys are ignored.
+func WithMetadataPrefix(prefix string) Option {
+	return optionFunc(func(o *options) {
+		o.metadataPrefix = prefix
+	})
+}
+
+// WithLogger sets the logger receiving the watcher's log entries. By default
+// entries are written with the standard library log package.
+func WithLogger(logger Logger) Option {
+	return optionFunc(func(o *options) {
+		o.logger = logger
+	})
+}
+
+// WithJSONLogger logs structured JSON entries to out, or to os.Stderr if out
+// is nil.
+func WithJSONLogger(out io.Writer) Option {
+	return WithLogger(NewJSONLogger(out))
+}
+
+// WithURLMux sets the URL mux used to open the topic and subscription,
+// pubsub.DefaultURLMux by default.
+func WithURLMux(mux *pubsub.URLMux) Option {
+	return optionFunc(func(o *options) {
+		o.urlMux = mux
+	})
+}
+
+// WithStrictBinding makes NewWithOptions fail with ErrTopicMismatch when the
+// subscription is not bound to the configured topic. The check is only made
+// for providers with a registered BindingVerifier.
+func WithStrictBinding() Option {
+	return optionFunc(func(o *options) {
+		o.strictBinding = true
+	})
+}
+
+// WithSendTimeout bounds how long Update and the UpdateFor* methods wait for
+// the provider to accept a message when the context given to NewWithOptions
+// has no deadline, DefaultSendTimeout by default. A send that takes longer
+// fails with ErrSendTimeout. Zero or less waits forever.
+func WithSendTimeout(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.sendTimeout = d
+	})
+}
+
+// WithDetachedContext only uses the context given to NewWithOptions to open
+// the topic and subscription. By default cancelling it also stops receiving
+// and fails Update and the UpdateFor* methods; with this option the watcher
+// runs until Close and sends are bounded by the send timeout, or the
+// context given to UpdateContext.
+func WithDetachedContext() Option {
+	return optionFunc(func(o *options) {
+		o.detachedContext = true
+	})
+}
+
+// WithLeaveAnnouncement makes Close tell the other watchers this one is
+// leaving, so they drop it from KnownOrigins. The announcement is best
+// effort and bounded by the close timeout.
+func WithLeaveAnnouncement() Option {
+	return optionFunc(func(o *options) {
+		o.announceLeave = true
+	})
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
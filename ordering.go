@@ -0,0 +1,116 @@
+package watcher
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// WithOriginOrdering applies the updates of each origin in the order they
+// were received while applying those of different origins concurrently. The
+// origins are hashed into the given number of lanes, and each lane runs one
+// SetUpdateCallbackEx callback at a time, so incremental updates from one
+// watcher are never applied out of order or at the same time. Lanes still
+// share the WithCallbackConcurrency slots, and within a lane arrival order
+// takes precedence over priority. The SetUpdateCallback callback isn't
+// affected. By default callbacks are only ordered by priority.
+func WithOriginOrdering(lanes int) Option {
+	return optionFunc(func(o *options) {
+		o.originLanes = lanes
+	})
+}
+
+// WithStrictOrdering processes received messages one at a time, in the
+// order the provider delivers them: the next message is only received once
+// the callbacks of the previous one returned and it was acked or nacked, so
+// callbacks see every update in exact delivery order, whatever its origin.
+// It's the strongest ordering, at the cost of throughput. Updates received
+// before the first reload can't be held behind it, so WithBaselineBuffer is
+// ignored; use WithInitialResync instead. Updates from a WithLocalBus peer
+// are applied as they're received.
+func WithStrictOrdering() Option {
+	return optionFunc(func(o *options) {
+		o.strictOrdering = true
+	})
+}
+
+// originLanes chains the callbacks of each lane: every callback waits for
+// the previous one of its lane to finish before being dispatched.
+type originLanes struct {
+	mu sync.Mutex
+	// lanes is allocated by the first join
+	lanes []lane
+}
+
+// closedLane is the predecessor of callbacks joining an idle lane
+var closedLane = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// lane chains callbacks so they run one at a time, in the order they
+// joined it
+type lane struct {
+	mu sync.Mutex
+	// tail is closed when the last callback queued finished, nil if none is
+	// pending
+	tail chan struct{}
+}
+
+// join queues a callback at the end of the lane. It returns a channel closed
+// once the callback may run, and the func to call when it finished.
+func (l *lane) join() (<-chan struct{}, func()) {
+	mine := make(chan struct{})
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev := l.tail
+	if prev == nil {
+		prev = closedLane
+	}
+	l.tail = mine
+	return prev, func() {
+		l.mu.Lock()
+		if l.tail == mine {
+			l.tail = nil
+		}
+		l.mu.Unlock()
+		close(mine)
+	}
+}
+
+// laneOf returns the lane of origin among n
+func laneOf(origin string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(origin))
+	return int(h.Sum32() % uint32(n))
+}
+
+// join queues a callback at the end of the lane of origin. It returns a
+// channel closed once the callback may run, and the func to call when it
+// finished.
+func (l *originLanes) join(origin string, n int) (<-chan struct{}, func()) {
+	l.mu.Lock()
+	if l.lanes == nil {
+		l.lanes = make([]lane, n)
+	}
+	ln := &l.lanes[laneOf(origin, n)]
+	l.mu.Unlock()
+	return ln.join()
+}
+
+// dispatchInOrder is like dispatch but, with WithOriginOrdering, only
+// dispatches fn once the previous callback of the lane of origin finished.
+func (w *Watcher) dispatchInOrder(origin string, size, priority int, fn func() error, done func(error)) {
+	if w.opts.originLanes <= 0 {
+		w.dispatch(size, priority, fn, done)
+		return
+	}
+	prev, leave := w.lanes.join(origin, w.opts.originLanes)
+	w.routines.start(func() {
+		<-prev
+		w.dispatch(size, priority, fn, func(err error) {
+			leave()
+			done(err)
+		})
+	})
+}
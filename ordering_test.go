@@ -0,0 +1,161 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOriginOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const lanes, updates = 16, 20
+	origins := []string{"alpha", "beta"}
+	if laneOf(origins[0], lanes) == laneOf(origins[1], lanes) {
+		t.Fatal("The test origins share a lane")
+	}
+
+	broker := &fakeBroker{}
+	mux := broker.mux()
+	w, err := NewWithOptions(ctx, "fake://ordering", WithURLMux(mux), WithOriginOrdering(lanes))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	applied := map[string][]uint64{}
+	overlapped := make(chan struct{})
+	var overlapOnce sync.Once
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		mu.Lock()
+		running[um.Origin]++
+		if running[um.Origin] > 1 {
+			t.Errorf("Two updates from %s applied at the same time", um.Origin)
+		}
+		if running[origins[0]] > 0 && running[origins[1]] > 0 {
+			overlapOnce.Do(func() { close(overlapped) })
+		}
+		applied[um.Origin] = append(applied[um.Origin], um.Sequence)
+		mu.Unlock()
+
+		// the first update of each origin waits for the other origin's
+		if um.Sequence == 1 {
+			select {
+			case <-overlapped:
+			case <-time.After(time.Second * 5):
+				t.Errorf("Updates from %s weren't applied alongside the other origin's", um.Origin)
+			}
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+
+		mu.Lock()
+		running[um.Origin]--
+		mu.Unlock()
+		return nil
+	})
+
+	var senders sync.WaitGroup
+	for _, origin := range origins {
+		sender, err := NewWithOptions(ctx, "fake://ordering", WithURLMux(mux), WithInstanceID(origin))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		defer sender.Close()
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for i := 0; i < updates; i++ {
+				if err := sender.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+					t.Errorf("Failed to send update: %s", err)
+				}
+			}
+		}()
+	}
+	senders.Wait()
+
+	waitFor(t, time.Second*5, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(applied[origins[0]]) == updates && len(applied[origins[1]]) == updates
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for _, origin := range origins {
+		for i, seq := range applied[origin] {
+			if seq != uint64(i+1) {
+				t.Fatalf("Expected the updates from %s in order, got %v", origin, applied[origin])
+			}
+		}
+	}
+}
+
+func TestStrictOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	mux := broker.mux()
+	var mu sync.Mutex
+	var acked, applied []string
+	w, err := NewWithOptions(ctx, "fake://strict", WithURLMux(mux), WithStrictOrdering(),
+		WithOnAck(func(seq uint64, origin string, _ AckOutcome) {
+			mu.Lock()
+			acked = append(acked, fmt.Sprintf("%s-%d", origin, seq))
+			mu.Unlock()
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	const updates = 10
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		mu.Lock()
+		if len(acked) != len(applied) {
+			t.Errorf("Update %s-%d applied before the previous one was acked", um.Origin, um.Sequence)
+		}
+		applied = append(applied, fmt.Sprintf("%s-%d", um.Origin, um.Sequence))
+		mu.Unlock()
+		// earlier updates take longer, which would reorder them if they
+		// were applied concurrently
+		time.Sleep(time.Duration(updates-um.Sequence) * time.Millisecond)
+		return nil
+	})
+
+	var senders []*Watcher
+	for _, origin := range []string{"alpha", "beta"} {
+		sender, err := NewWithOptions(ctx, "fake://strict", WithURLMux(mux), WithInstanceID(origin))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		defer sender.Close()
+		senders = append(senders, sender)
+	}
+	var want []string
+	for i := 1; i <= updates; i++ {
+		for _, sender := range senders {
+			if err := sender.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			want = append(want, fmt.Sprintf("%s-%d", sender.opts.instanceID, i))
+		}
+	}
+
+	waitFor(t, time.Second*5, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(acked) == len(want)
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(applied, want) || !reflect.DeepEqual(acked, want) {
+		t.Fatalf("Expected updates applied and acked in delivery order %v, got %v and %v", want, applied, acked)
+	}
+}
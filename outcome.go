@@ -0,0 +1,97 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrNackUnsupported is matched by the error sent on the Errors channel when
+// a message that should have been redelivered was acked instead, because
+// the provider can't nack it
+var ErrNackUnsupported = errors.New("provider can't redeliver the message")
+
+// AckOutcome is what became of a received message
+type AckOutcome int
+
+// Ack outcomes
+const (
+	// Acked messages were acknowledged after being handled.
+	Acked AckOutcome = iota
+	// Nacked messages were handed back to the provider for redelivery.
+	Nacked
+	// Dropped messages were acknowledged without being delivered everywhere:
+	// foreign or self-filtered messages, or updates discarded because the
+	// updates channel was full.
+	Dropped
+)
+
+func (o AckOutcome) String() string {
+	switch o {
+	case Acked:
+		return "acked"
+	case Nacked:
+		return "nacked"
+	case Dropped:
+		return "dropped"
+	}
+	return fmt.Sprintf("outcome(%d)", int(o))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (o AckOutcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (o *AckOutcome) UnmarshalText(text []byte) error {
+	for _, outcome := range []AckOutcome{Acked, Nacked, Dropped} {
+		if string(text) == outcome.String() {
+			*o = outcome
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ack outcome %q", text)
+}
+
+// WithOnAck sets a hook called from the receive loop after every received
+// message is acked or nacked, with the sequence number and origin read from
+// its metadata, which are zero and empty if missing. The hook must not block.
+func WithOnAck(fn func(seq uint64, origin string, outcome AckOutcome)) Option {
+	return optionFunc(func(o *options) {
+		o.onAck = fn
+	})
+}
+
+// WithAckOnlyOnSuccess delays acknowledging an update until its callbacks
+// returned, and nacks it for redelivery if one of them failed, panicked or
+// the update couldn't be decoded. By default updates are acknowledged as
+// soon as the callbacks are started. Providers that can't nack, see
+// pubsub.Message.Nackable, get failed updates acked instead: each is
+// logged and reported on the Errors channel with ErrNackUnsupported.
+func WithAckOnlyOnSuccess() Option {
+	return optionFunc(func(o *options) {
+		o.ackOnlyOnSuccess = true
+	})
+}
+
+func (w *Watcher) reportAck(msg *pubsub.Message, outcome AckOutcome) {
+	if w.opts.onAck == nil {
+		return
+	}
+	var seq uint64
+	if s, ok := msg.Metadata[w.metadataKey(metaSequence)]; ok {
+		seq, _ = strconv.ParseUint(s, 10, 64)
+	}
+	w.opts.onAck(seq, msg.Metadata[w.metadataKey(metaOrigin)], outcome)
+}
+
+// nackFallback reports msg, which failed but is acked instead of nacked
+// because the provider can't redeliver it
+func (w *Watcher) nackFallback(msg *pubsub.Message) {
+	w.warnCapability("nack", "The provider can't nack messages, failed updates are acked and reported instead")
+	w.log(LevelError, "Acking a failed update the provider can't redeliver", "id", msg.LoggableID)
+	w.pushError(fmt.Errorf("failed update message %s acked, error: %w", msg.LoggableID, ErrNackUnsupported))
+}
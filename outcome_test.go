@@ -0,0 +1,156 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+type ackEvent struct {
+	seq     uint64
+	origin  string
+	outcome AckOutcome
+}
+
+func recordAcks(events chan<- ackEvent) Option {
+	return WithOnAck(func(seq uint64, origin string, outcome AckOutcome) {
+		events <- ackEvent{seq, origin, outcome}
+	})
+}
+
+func expectAcks(t *testing.T, events <-chan ackEvent, want ...ackEvent) {
+	t.Helper()
+	for _, expected := range want {
+		select {
+		case got := <-events:
+			if got != expected {
+				t.Fatalf("Expected %+v, got %+v", expected, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Hook wasn't invoked for %+v in time", expected)
+		}
+	}
+}
+
+func TestOnAck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://outcome", WithURLMux((&fakeBroker{}).mux()),
+		WithInstanceID("node-1"), WithUpdatesChannel(1, BackpressureNack), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	expectAcks(t, events, ackEvent{1, "node-1", Acked}, ackEvent{2, "node-1", Nacked})
+}
+
+func TestOnAckDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://outcome", WithURLMux(broker.mux()),
+		WithInstanceID("node-1"), WithUpdatesChannel(1, BackpressureDrop), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	expectAcks(t, events, ackEvent{1, "node-1", Acked}, ackEvent{2, "node-1", Dropped})
+
+	if err := w.Reconfigure(WithSelfFilter(SelfFilterAll)); err != nil {
+		t.Fatalf("Failed to reconfigure: %s", err)
+	}
+	<-w.Updates()
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectAcks(t, events, ackEvent{3, "node-1", Dropped})
+
+	sub := broker.subscriptions()[0]
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for id, acked := range sub.acks {
+		if !acked {
+			t.Fatalf("Dropped message %v was nacked", id)
+		}
+	}
+}
+
+func TestNackUnsupported(t *testing.T) {
+	for _, nackable := range []bool{true, false} {
+		nackable := nackable
+		name := "nackable"
+		if !nackable {
+			name = "not nackable"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			events := make(chan ackEvent, 10)
+			broker := &fakeBroker{noNack: !nackable}
+			w, err := NewWithOptions(ctx, "fake://outcome", WithURLMux(broker.mux()), WithInstanceID("node-1"),
+				WithAckOnlyOnSuccess(), WithErrorChannel(4), recordAcks(events),
+				WithLogger(NewJSONLogger(&syncBuffer{})))
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+			w.SetUpdateCallbackEx(func(UpdateMessage) error {
+				return errors.New("database unavailable")
+			})
+
+			if err := w.Update(); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			want := Nacked
+			if !nackable {
+				want = Dropped
+			}
+			expectAcks(t, events, ackEvent{1, "node-1", want})
+			sub := broker.subscriptions()[0]
+			var acked bool
+			waitFor(t, time.Second*5, func() bool {
+				sub.mu.Lock()
+				defer sub.mu.Unlock()
+				var settled bool
+				acked, settled = sub.acks[driver.AckID(1)]
+				return settled
+			})
+			if acked == nackable {
+				t.Fatalf("Expected the message to be acked: %t, got %t", !nackable, acked)
+			}
+
+			// the callback error comes first, then the fallback's
+			unsupported := false
+			for i := 0; i < 2; i++ {
+				select {
+				case err := <-w.Errors():
+					unsupported = unsupported || errors.Is(err, ErrNackUnsupported)
+				case <-time.After(time.Millisecond * 100):
+				}
+			}
+			if unsupported == nackable {
+				t.Fatalf("Expected ErrNackUnsupported to be reported: %t", !nackable)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPayloadTooLarge is returned for a received message whose body, once
+// decompressed and decoded, exceeds the limit set with WithMaxPayloadBytes
+var ErrPayloadTooLarge = errors.New("message payload exceeds the maximum size")
+
+// LimitedDecompressor is implemented by Compressors that can stop
+// decompressing a body as soon as it exceeds limit bytes, returning
+// ErrPayloadTooLarge. The bodies decompressed by other Compressors are only
+// checked against WithMaxPayloadBytes once fully decompressed.
+type LimitedDecompressor interface {
+	DecompressLimited(body []byte, limit int64) ([]byte, error)
+}
+
+// WithMaxPayloadBytes drops the messages whose body exceeds n bytes once
+// decompressed and decoded, before they reach the callbacks, and reports
+// them on the error channel. Gzip bodies are decompressed through a bounded
+// reader, so a decompression bomb is rejected without inflating it.
+func WithMaxPayloadBytes(n int64) Option {
+	return optionFunc(func(o *options) {
+		o.maxPayloadBytes = n
+	})
+}
+
+// decompressBody decompresses body with c, bounded by the payload limit
+func (w *Watcher) decompressBody(c Compressor, body []byte) ([]byte, error) {
+	limit := w.opts.maxPayloadBytes
+	if limit <= 0 {
+		return c.Decompress(body)
+	}
+	if ld, ok := c.(LimitedDecompressor); ok {
+		return ld.DecompressLimited(body, limit)
+	}
+	body, err := c.Decompress(body)
+	if err != nil {
+		return nil, err
+	}
+	return body, w.checkPayloadSize(body)
+}
+
+// checkPayloadSize fails with ErrPayloadTooLarge when body exceeds the
+// payload limit
+func (w *Watcher) checkPayloadSize(body []byte) error {
+	if limit := w.opts.maxPayloadBytes; limit > 0 && int64(len(body)) > limit {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrPayloadTooLarge, len(body), limit)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+func TestMaxPayloadBytes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://payload", WithURLMux(broker.mux()),
+		WithMaxPayloadBytes(1024), WithErrorChannel(4), recordAcks(events),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	applied := make(chan UpdateMessage, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		return nil
+	})
+
+	topic, err := broker.mux().OpenTopic(ctx, "fake://payload")
+	if err != nil {
+		t.Fatalf("Failed to open topic, error: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+	send := func(seq uint64, body []byte, encoding string) {
+		t.Helper()
+		md := map[string]string{
+			DefaultMetadataPrefix + metaOrigin:   "peer",
+			DefaultMetadataPrefix + metaSequence: strconv.FormatUint(seq, 10),
+		}
+		if encoding != "" {
+			md[DefaultMetadataPrefix+metaEncoding] = encoding
+		}
+		if err := topic.Send(ctx, &pubsub.Message{Body: body, Metadata: md}); err != nil {
+			t.Fatalf("Failed to send message, error: %s", err)
+		}
+	}
+	expectRejected := func() {
+		t.Helper()
+		select {
+		case err := <-w.Errors():
+			if !errors.Is(err, ErrPayloadTooLarge) {
+				t.Fatalf("Expected ErrPayloadTooLarge, got: %v", err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("The oversized payload wasn't reported")
+		}
+	}
+
+	oversized, err := w.codec.Marshal(UpdateMessage{
+		Op: UpdateForAddPolicy, Sec: "p", Ptype: "p", Params: []string{strings.Repeat("a", 2048)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(1, oversized, "")
+	expectAcks(t, events, ackEvent{1, "peer", Dropped})
+	expectRejected()
+
+	// 64MiB of zeros gzip into 64KiB, well under the limit
+	bomb, err := GzipCompressor{Level: 9}.Compress(make([]byte, 64<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(2, bomb, "gzip")
+	expectAcks(t, events, ackEvent{2, "peer", Dropped})
+	expectRejected()
+
+	send(3, []byte(legacyUpdateBody), "")
+	expectAcks(t, events, ackEvent{3, "peer", Acked})
+	select {
+	case um := <-applied:
+		if um.Sequence != 3 {
+			t.Fatalf("Oversized update %d reached the callback", um.Sequence)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The update within the limit wasn't applied")
+	}
+}
+
+func TestDecompressLimitedStopsEarly(t *testing.T) {
+	bomb, err := GzipCompressor{Level: 9}.Compress(make([]byte, 64<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = GzipCompressor{}.DecompressLimited(bomb, 1024)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("Expected ErrPayloadTooLarge, got: %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("Decompressing the bomb allocated %d bytes", allocated)
+	}
+
+	body := bytes.Repeat([]byte("p, alice, data1, read\n"), 10)
+	compressed, err := GzipCompressor{}.Compress(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := (GzipCompressor{}).DecompressLimited(compressed, int64(len(body))); err != nil || !bytes.Equal(out, body) {
+		t.Fatalf("Body within the limit didn't survive, error: %v", err)
+	}
+}
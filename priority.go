@@ -0,0 +1,72 @@
+package watcher
+
+import "container/heap"
+
+// Update priorities. Callbacks waiting for a WithCallbackConcurrency slot run
+// in priority order, highest first, and in the order their updates were
+// received within a priority.
+const (
+	// PriorityNormal is the priority of incremental updates and of the
+	// generic Update signal.
+	PriorityNormal = 0
+	// PriorityHigh is the priority of UpdateForSavePolicy, whose reload or
+	// snapshot supersedes the incremental updates queued before it.
+	PriorityHigh = 10
+)
+
+// slotWaiter is a callback waiting for a slot in the limiter
+type slotWaiter struct {
+	priority int
+	arrival  uint64
+	index    int
+}
+
+// waitQueue is a heap of slotWaiters, highest priority then earliest arrival
+// at the top
+type waitQueue []*slotWaiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].arrival < q[j].arrival
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	sw := x.(*slotWaiter)
+	sw.index = len(*q)
+	*q = append(*q, sw)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	sw := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return sw
+}
+
+// enqueue takes a place in the queue for a callback of the given priority.
+// It must be called in the order updates are received, before acquire.
+func (l *limiter) enqueue(priority int) *slotWaiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.arrivals++
+	sw := &slotWaiter{priority: priority, arrival: l.arrivals}
+	heap.Push(&l.waiting, sw)
+	return sw
+}
+
+// leaveQueueLocked removes sw from the queue and wakes the other waiters,
+// one of which may now be at the top
+func (l *limiter) leaveQueueLocked(sw *slotWaiter) {
+	heap.Remove(&l.waiting, sw.index)
+	l.cond.Broadcast()
+}
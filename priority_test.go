@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPriorityJumpsQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://priority", WithURLMux((&fakeBroker{}).mux()),
+		WithCallbackConcurrency(1), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	applied := make(chan UpdateMessage, 10)
+	release := make(chan struct{})
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		<-release
+		return nil
+	})
+
+	// the first update holds the only slot while the others queue behind it
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-applied:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The first update wasn't applied")
+	}
+	for _, user := range []string{"bob", "carol"} {
+		if err := w.UpdateForAddPolicy("p", "p", user, "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	if err := w.UpdateForSavePolicy(nil); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 4 updates were received", i)
+		}
+	}
+	close(release)
+
+	want := []UpdateType{UpdateForSavePolicy, UpdateForAddPolicy, UpdateForAddPolicy}
+	for i, op := range want {
+		select {
+		case um := <-applied:
+			if um.Op != op {
+				t.Fatalf("Expected update %d to be %s, got %s", i+1, op, um.Op)
+			}
+			if op == UpdateForSavePolicy && um.Priority != PriorityHigh {
+				t.Fatalf("Expected the save to have high priority, got %d", um.Priority)
+			}
+			if i == 2 && um.Params[0] != "carol" {
+				t.Fatalf("Expected updates of equal priority in order, got %v last", um.Params)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 3 queued updates were applied", i)
+		}
+	}
+}
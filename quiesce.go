@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// quiescePoll is how often Quiesce checks whether the watcher is at rest
+const quiescePoll = 5 * time.Millisecond
+
+// quiescence counts the work in progress, see Quiesce. Its fields are
+// accessed atomically.
+type quiescence struct {
+	// busy counts the sends, received messages not settled yet and
+	// callbacks not completed yet
+	busy int64
+	// sent and echoed count the updates sent by the watcher and received
+	// back from its subscription
+	sent   uint64
+	echoed uint64
+}
+
+func (q *quiescence) begin() { atomic.AddInt64(&q.busy, 1) }
+
+func (q *quiescence) end() { atomic.AddInt64(&q.busy, -1) }
+
+// Quiesce returns once the watcher is at rest: the updates it sent,
+// including those queued by WithAsyncSend, were sent and received back, the
+// messages it received were settled and the callbacks they started,
+// debounced and throttled ones included, have returned. It's meant for tests
+// asserting everything was delivered. Updates sent by other watchers are
+// only covered once received, and the watcher doesn't wait for its own
+// updates to come back when its subscription is on another URL than its
+// topic, or in legacy mode. It fails with ErrClosed if the watcher is closed
+// before it gets to rest, and with the context's error when ctx is done.
+func (w *Watcher) Quiesce(ctx context.Context) error {
+	for !w.atRest() {
+		select {
+		case <-w.closedCh:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(quiescePoll):
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) atRest() bool {
+	if atomic.LoadInt64(&w.quiet.busy) != 0 {
+		return false
+	}
+	if w.opts.legacyMode || (w.subURL != "" && w.subURL != w.topicURL) {
+		return true
+	}
+	return atomic.LoadUint64(&w.quiet.echoed) >= atomic.LoadUint64(&w.quiet.sent)
+}
+
+// isOwnUpdate reports whether m is an update sent by this watcher, as opposed
+// to a control message
+func (w *Watcher) isOwnUpdate(m *pubsub.Message) bool {
+	_, ok := m.Metadata[w.metadataKey(metaSequence)]
+	return ok && w.isSelf(m)
+}
@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQuiesce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://quiesce", WithURLMux((&fakeBroker{}).mux()),
+		WithAsyncSend(4, SendLimitBlock, 0), WithCallbackConcurrency(2))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var applied int32
+	release := make(chan struct{})
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		<-release
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&applied, 1)
+		return nil
+	})
+
+	const burst = 20
+	for i := 0; i < burst; i++ {
+		if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+
+	// the callbacks are blocked, so the watcher can't be at rest
+	short, cancelShort := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancelShort()
+	if err := w.Quiesce(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Quiesce to time out, got: %v", err)
+	}
+
+	close(release)
+	if err := w.Quiesce(ctx); err != nil {
+		t.Fatalf("Failed to quiesce: %s", err)
+	}
+	if n := atomic.LoadInt32(&applied); n != burst {
+		t.Fatalf("Expected %d updates applied once at rest, got %d", burst, n)
+	}
+}
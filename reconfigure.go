@@ -0,0 +1,116 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotRuntimeTunable is returned by Reconfigure for options that can only
+// be set when the watcher is created
+var ErrNotRuntimeTunable = errors.New("option requires reconnection and can't be changed at runtime")
+
+// SelfFilterMode controls whether a watcher delivers its own messages
+type SelfFilterMode int
+
+// Self filter modes
+const (
+	// SelfFilterNone delivers every message, including the watcher's own.
+	SelfFilterNone SelfFilterMode = iota
+	// SelfFilterAll drops every message published by this watcher.
+	SelfFilterAll
+	// SelfFilterReloads drops the watcher's own Update and
+	// UpdateForSavePolicy messages but delivers its incremental updates,
+	// e.g. to apply them again to a model reloaded meanwhile.
+	SelfFilterReloads
+)
+
+// filtersSelf reports whether the watcher's own messages of type op are
+// dropped
+func (m SelfFilterMode) filtersSelf(op UpdateType) bool {
+	switch m {
+	case SelfFilterAll:
+		return true
+	case SelfFilterReloads:
+		return op == Update || op == UpdateForSavePolicy
+	}
+	return false
+}
+
+// tunables are the settings that can be changed with Reconfigure. The
+// current value is swapped atomically and must be treated as immutable.
+type tunables struct {
+	logLevel            LogLevel
+	debounce            time.Duration
+	selfFilter          SelfFilterMode
+	callbackConcurrency int
+	coalesceReloads     bool
+	minReloadInterval   time.Duration
+}
+
+// runtimeOption is an Option that only touches tunables and can therefore
+// be passed to Reconfigure
+type runtimeOption func(*tunables)
+
+func (f runtimeOption) apply(o *options) { f(&o.tunables) }
+
+// WithLogLevel sets the minimum level of entries passed to the logger,
+// LevelInfo by default. It can be changed with Reconfigure.
+func WithLogLevel(level LogLevel) Option {
+	return runtimeOption(func(t *tunables) {
+		t.logLevel = level
+	})
+}
+
+// WithDebounce delays the SetUpdateCallback callback until no update has
+// arrived for d, so a burst of updates triggers a single reload with the last
+// body received. Zero, the default, calls the callback for every update.
+// It can be changed with Reconfigure.
+func WithDebounce(d time.Duration) Option {
+	return runtimeOption(func(t *tunables) {
+		t.debounce = d
+	})
+}
+
+// WithSelfFilter sets whether the watcher's own messages are delivered to its
+// callbacks, SelfFilterNone by default. It can be changed with Reconfigure.
+func WithSelfFilter(mode SelfFilterMode) Option {
+	return runtimeOption(func(t *tunables) {
+		t.selfFilter = mode
+	})
+}
+
+// WithCallbackConcurrency limits how many callbacks run at the same time.
+// Zero, the default, means no limit. It can be changed with Reconfigure.
+func WithCallbackConcurrency(n int) Option {
+	return runtimeOption(func(t *tunables) {
+		t.callbackConcurrency = n
+	})
+}
+
+// Reconfigure atomically applies runtime-tunable options: WithLogLevel,
+// WithDebounce, WithSelfFilter, WithCallbackConcurrency,
+// WithCoalescedReloads and WithMinReloadInterval. If any other
+// option is given nothing is changed and an error wrapping
+// ErrNotRuntimeTunable is returned.
+func (w *Watcher) Reconfigure(opts ...Option) error {
+	for i, opt := range opts {
+		if _, ok := opt.(runtimeOption); !ok {
+			return fmt.Errorf("option %d: %w", i, ErrNotRuntimeTunable)
+		}
+	}
+
+	w.connMu.Lock()
+	defer w.connMu.Unlock()
+	o := options{tunables: *w.currentTunables()}
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	w.tunables.Store(&o.tunables)
+	w.limiter.cond.Broadcast()
+	return nil
+}
+
+func (w *Watcher) currentTunables() *tunables {
+	return w.tunables.Load().(*tunables)
+}
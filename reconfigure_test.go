@@ -0,0 +1,144 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconfigureRejectsConstructionOptions(t *testing.T) {
+	w := newWatcher("", buildOptions())
+
+	err := w.Reconfigure(WithLogLevel(LevelDebug), WithInstanceID("other"))
+	if !errors.Is(err, ErrNotRuntimeTunable) {
+		t.Fatalf("Expected ErrNotRuntimeTunable, got: %v", err)
+	}
+	if w.currentTunables().logLevel != LevelInfo {
+		t.Fatal("A rejected Reconfigure must not apply any option")
+	}
+}
+
+func TestReconfigureDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://debounce", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls int32
+	w.SetUpdateCallback(func(string) {
+		atomic.AddInt32(&calls, 1)
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 3 })
+
+	if err := w.Reconfigure(WithDebounce(time.Millisecond * 200)); err != nil {
+		t.Fatalf("Failed to reconfigure: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 4 })
+
+	time.Sleep(time.Millisecond * 400)
+	if got := atomic.LoadInt32(&calls); got != 4 {
+		t.Fatalf("Expected the debounced burst to trigger a single callback, got %d calls", got-3)
+	}
+}
+
+func TestReconfigureSelfFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://self", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	received := make(chan string, 2)
+	w.SetUpdateCallback(func(msg string) {
+		received <- msg
+	})
+
+	if err := w.Reconfigure(WithSelfFilter(SelfFilterAll)); err != nil {
+		t.Fatalf("Failed to reconfigure: %s", err)
+	}
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-received:
+		t.Fatal("Own update was delivered with SelfFilterAll")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	if err := w.Reconfigure(WithSelfFilter(SelfFilterNone)); err != nil {
+		t.Fatalf("Failed to reconfigure: %s", err)
+	}
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Own update wasn't delivered with SelfFilterNone")
+	}
+}
+
+func TestSelfFilterReloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://self", WithURLMux((&fakeBroker{}).mux()),
+		WithInstanceID("self"), WithSelfFilter(SelfFilterReloads), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	applied := make(chan UpdateType, 3)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um.Op
+		return nil
+	})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.UpdateForSavePolicy(nil); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectAcks(t, events, ackEvent{1, "self", Dropped}, ackEvent{2, "self", Acked}, ackEvent{3, "self", Dropped})
+	select {
+	case op := <-applied:
+		if op != UpdateForAddPolicy {
+			t.Fatalf("Expected only the incremental update to be applied, got %s", op)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Own incremental update wasn't applied")
+	}
+	select {
+	case op := <-applied:
+		t.Fatalf("Own %s was applied", op)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,97 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// WithReceiveRefresh replaces the subscription every maxAge, for providers
+// whose connections go stale. Once it's maxAge old the pending Receive is
+// cancelled, a new subscription is opened and receiving carries on with it.
+// Unlike a reconnect there's no backoff and the watcher stays connected. The
+// replaced subscription is shut down at the next refresh, so the messages
+// still being handled can be acked, and if opening the new one fails the
+// current one is kept until the next refresh. By default the subscription
+// lives as long as the watcher.
+func WithReceiveRefresh(maxAge time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.receiveRefresh = maxAge
+	})
+}
+
+// receiveContext is the context Receive is called with until it expires
+type receiveContext struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timer   Timer
+	expired int32
+}
+
+// newReceiveContext derives a receive context from the watcher's, expiring
+// after WithReceiveRefresh
+func (w *Watcher) newReceiveContext(parent context.Context) *receiveContext {
+	rc := &receiveContext{ctx: parent, cancel: func() {}}
+	if w.opts.receiveRefresh <= 0 {
+		return rc
+	}
+	rc.ctx, rc.cancel = context.WithCancel(parent)
+	rc.timer = w.opts.clock.AfterFunc(w.opts.receiveRefresh, func() {
+		atomic.StoreInt32(&rc.expired, 1)
+		rc.cancel()
+	})
+	return rc
+}
+
+// hasExpired reports whether rc reached its maximum age
+func (rc *receiveContext) hasExpired() bool {
+	return atomic.LoadInt32(&rc.expired) == 1
+}
+
+// stop releases rc's timer and context
+func (rc *receiveContext) stop() {
+	if rc.timer != nil {
+		rc.timer.Stop()
+	}
+	rc.cancel()
+}
+
+// renewReceiveContext replaces the expired rc with a fresh receive context
+func (w *Watcher) renewReceiveContext(parent context.Context, rc *receiveContext) *receiveContext {
+	rc.stop()
+	return w.newReceiveContext(parent)
+}
+
+// refreshSubscription opens a subscription replacing sub, and returns it, or
+// sub if it can't be opened. sub is retired: it's shut down at the next
+// refresh, or by Close.
+func (w *Watcher) refreshSubscription(ctx context.Context, sub subscriptionReceiver) subscriptionReceiver {
+	fresh, err := w.openSubscription(ctx)
+	if err != nil {
+		w.log(LevelWarn, "Failed to refresh subscription, keeping it", "error", err)
+		return sub
+	}
+	w.connMu.Lock()
+	if w.closed {
+		w.connMu.Unlock()
+		_ = fresh.Shutdown(context.Background())
+		return sub
+	}
+	w.sub = fresh
+	retired := w.retired
+	w.retired = sub
+	w.connMu.Unlock()
+	if retired != nil {
+		shutdownSubscription(retired)
+	}
+	n := atomic.AddUint64(&w.stats.receiveRefreshes, 1)
+	w.log(LevelDebug, "Subscription refreshed", "refresh", n)
+	return fresh
+}
+
+// shutdownSubscription shuts sub down, giving it some time to send its acks
+func shutdownSubscription(sub subscriptionReceiver) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = sub.Shutdown(ctx)
+}
@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReceiveRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://refresh", WithURLMux(broker.mux()), WithClock(clock),
+		WithReceiveRefresh(time.Minute), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	applied := make(chan string, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um.Params[0]
+		return nil
+	})
+	expectApplied := func(want string) {
+		t.Helper()
+		select {
+		case got := <-applied:
+			if got != want {
+				t.Fatalf("Expected %s to be applied, got %s", want, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Update %s wasn't applied", want)
+		}
+	}
+
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectApplied("alice")
+
+	for i := 1; i <= 2; i++ {
+		clock.advance(time.Minute)
+		waitFor(t, time.Second*5, func() bool { return w.Stats().ReceiveRefreshes == uint64(i) })
+
+		user := []string{"bob", "carol"}[i-1]
+		if err := w.UpdateForAddPolicy("p", "p", user, "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+		expectApplied(user)
+	}
+
+	// the first subscription was shut down by the second refresh, the
+	// second one is kept until the next
+	if n := len(broker.subscriptions()); n != 2 {
+		t.Fatalf("Expected the current and the retired subscriptions open, got %d", n)
+	}
+	if stats := w.Stats(); stats.Reconnects != 0 {
+		t.Fatalf("Expected no reconnect, got %d", stats.Reconnects)
+	}
+	if !w.Connected() {
+		t.Fatal("Expected the watcher to stay connected")
+	}
+}
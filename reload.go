@@ -0,0 +1,132 @@
+package watcher
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"gocloud.dev/pubsub"
+)
+
+// maxLocalApplied bounds the sequences remembered by UpdateAndReload when
+// their echoes don't come back, e.g. with a separate subscription
+const maxLocalApplied = 1024
+
+// localApplied remembers the sequences of updates whose callbacks already ran
+// in this process, so their echoes aren't applied a second time
+type localApplied struct {
+	mu   sync.Mutex
+	seqs map[uint64]struct{}
+}
+
+func (l *localApplied) add(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seqs == nil {
+		l.seqs = map[uint64]struct{}{}
+	}
+	l.seqs[seq] = struct{}{}
+	if len(l.seqs) > maxLocalApplied {
+		for s := range l.seqs {
+			if s+maxLocalApplied <= seq {
+				delete(l.seqs, s)
+			}
+		}
+	}
+}
+
+// take reports whether seq was added, and forgets it
+func (l *localApplied) take(seq uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.seqs[seq]
+	delete(l.seqs, seq)
+	return ok
+}
+
+// UpdateAndReload is like UpdateContext but also runs the callbacks of this
+// watcher for the update before returning, so the process reads its own
+// writes without waiting for the update to come back from the broker. When
+// it does come back it is ignored, the callbacks run once.
+// The update is sent even when the callbacks fail; the error returned is
+// the send error if any, else the error of the first failed callback.
+func (w *Watcher) UpdateAndReload(ctx context.Context) error {
+	m := w.newMessage(w.reloadSignal(), Update)
+	um := UpdateMessage{Op: Update}
+	w.readMetadata(m, &um)
+	// legacy messages carry no sequence to recognize the echo by
+	if !w.opts.legacyMode && !w.currentTunables().selfFilter.filtersSelf(Update) {
+		w.local.add(um.Sequence)
+	}
+
+	sendErr := w.broadcast(ctx, m)
+	if sendErr != nil {
+		w.local.take(um.Sequence)
+	}
+	if err := w.reloadLocally(um); sendErr == nil {
+		return err
+	}
+	return sendErr
+}
+
+// reloadLocally runs the callbacks for um in the calling goroutine
+func (w *Watcher) reloadLocally(um UpdateMessage) error {
+	w.connMu.RLock()
+	callbackEx := w.callbackFuncEx
+	callbackBatch := w.callbackBatch
+	enforcers := w.enforcers
+	w.connMu.RUnlock()
+
+	var errs []error
+	if callback, finished := w.beginLegacyCallback(); callback != nil {
+		errs = append(errs, w.call(func() error {
+			defer finished()
+			callback(w.opts.reloadSignal)
+			return nil
+		}))
+	}
+	if callbackEx != nil {
+		errs = append(errs, w.call(func() error {
+			return callbackEx(um)
+		}))
+	}
+	if callbackBatch != nil {
+		errs = append(errs, w.call(func() error {
+			return callbackBatch([]UpdateMessage{um})
+		}))
+	}
+	for _, lane := range enforcers {
+		lane := lane
+		errs = append(errs, w.call(func() error {
+			_, err := lane.apply(um)
+			return err
+		}))
+	}
+	var first error
+	for _, err := range errs {
+		if err != nil {
+			w.reportCallbackError(um, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if first == nil && len(errs) > 0 {
+		w.establishBaseline()
+		w.recordContentRevision()
+	}
+	return first
+}
+
+// isLocallyApplied reports whether msg is the echo of an update sent with
+// UpdateAndReload or already received from the local bus
+func (w *Watcher) isLocallyApplied(msg *pubsub.Message) bool {
+	seq, err := strconv.ParseUint(msg.Metadata[w.metadataKey(metaSequence)], 10, 64)
+	if err != nil {
+		return false
+	}
+	if !w.isSelf(msg) {
+		return w.opts.localBus != nil && w.busApplied.take(msg.Metadata[w.metadataKey(metaOrigin)], seq)
+	}
+	return w.local.take(seq)
+}
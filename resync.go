@@ -0,0 +1,57 @@
+package watcher
+
+import "context"
+
+// WithInitialResync runs the callbacks once, as for an Update, as soon as
+// the first one is set, so the enforcer loads the current policy at startup
+// whatever the provider delivers. WaitInitialResync blocks until it
+// completed.
+func WithInitialResync() Option {
+	return optionFunc(func(o *options) {
+		o.initialResync = true
+	})
+}
+
+// initialResync tracks the WithInitialResync reload. done is closed once it
+// completed, with its result in err.
+type initialResync struct {
+	started bool
+	done    chan struct{}
+	err     error
+}
+
+// startInitialResync starts the initial resync unless it already started
+func (w *Watcher) startInitialResync() {
+	if !w.opts.initialResync {
+		return
+	}
+	w.connMu.Lock()
+	started := w.resync.started
+	w.resync.started = true
+	w.connMu.Unlock()
+	if started {
+		return
+	}
+	w.routines.start(func() {
+		w.resync.err = w.reloadLocally(UpdateMessage{Op: Update})
+		close(w.resync.done)
+	})
+}
+
+// WaitInitialResync blocks until the WithInitialResync reload completed and
+// returns the error of the first callback that failed. It returns right away
+// when WithInitialResync isn't set, and ErrClosed if the watcher is closed
+// before the reload completed.
+func (w *Watcher) WaitInitialResync(ctx context.Context) error {
+	if !w.opts.initialResync {
+		return nil
+	}
+	select {
+	case <-w.resync.done:
+		return w.resync.err
+	case <-w.closedCh:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInitialResync(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://resync", WithURLMux((&fakeBroker{}).mux()), WithInitialResync())
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls int32
+	callback := func(string) { atomic.AddInt32(&calls, 1) }
+	w.SetUpdateCallback(callback)
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	if err := w.WaitInitialResync(waitCtx); err != nil {
+		t.Fatalf("Initial resync failed: %s", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected the callback to run once at startup, got %d calls", n)
+	}
+
+	// setting callbacks again doesn't resync again
+	w.SetUpdateCallback(callback)
+	w.SetUpdateCallbackEx(func(UpdateMessage) error { return nil })
+	time.Sleep(time.Millisecond * 100)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected a single initial resync, got %d calls", n)
+	}
+}
+
+func TestInitialResyncError(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://resync", WithURLMux((&fakeBroker{}).mux()), WithInitialResync())
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	errLoad := errors.New("database unavailable")
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		if um.Op != Update {
+			t.Errorf("Expected the resync to be a full reload, got %s", um.Op)
+		}
+		return errLoad
+	})
+	if err := w.WaitInitialResync(ctx); !errors.Is(err, errLoad) {
+		t.Fatalf("Expected the callback error, got: %v", err)
+	}
+}
+
+func TestInitialResyncDisabled(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://resync", WithURLMux((&fakeBroker{}).mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls int32
+	w.SetUpdateCallback(func(string) { atomic.AddInt32(&calls, 1) })
+	if err := w.WaitInitialResync(ctx); err != nil {
+		t.Fatalf("Expected no wait without WithInitialResync, got: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("Expected no resync, got %d calls", n)
+	}
+}
@@ -0,0 +1,46 @@
+package watcher
+
+import (
+	"strconv"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithRevision tracks the policy revision of the local enforcer, e.g. a
+// counter bumped by the adapter on every write. Updates sent are stamped
+// with current(), and updates received with a revision at or below
+// current() are acked without running the callbacks, since the local
+// policy already has them. Callbacks find the revision of an update in
+// UpdateMessage.Revision. Updates without a revision, or with revision zero,
+// are always applied.
+func WithRevision(current func() uint64) Option {
+	return optionFunc(func(o *options) {
+		o.revision = current
+	})
+}
+
+// stampRevision stamps the current revision on the metadata of an update
+func (w *Watcher) stampRevision(md map[string]string) {
+	if w.opts.revision == nil {
+		return
+	}
+	if rev := w.opts.revision(); rev > 0 {
+		md[w.metadataKey(metaRevision)] = strconv.FormatUint(rev, 10)
+	}
+}
+
+// messageRevision returns the revision msg was sent with, zero if none
+func (w *Watcher) messageRevision(msg *pubsub.Message) uint64 {
+	rev, _ := strconv.ParseUint(msg.Metadata[w.metadataKey(metaRevision)], 10, 64)
+	return rev
+}
+
+// isCurrentRevision reports whether the local policy already has the
+// revision of msg
+func (w *Watcher) isCurrentRevision(msg *pubsub.Message) bool {
+	if w.opts.revision == nil {
+		return false
+	}
+	rev := w.messageRevision(msg)
+	return rev > 0 && rev <= w.opts.revision()
+}
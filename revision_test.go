@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRevisionSkipsAppliedUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	var published uint64
+	publisher, err := NewWithOptions(ctx, "fake://revision", WithURLMux(broker.mux()),
+		WithInstanceID("publisher"), WithRevision(func() uint64 { return atomic.LoadUint64(&published) }))
+	if err != nil {
+		t.Fatalf("Failed to create publisher, error: %s", err)
+	}
+	defer publisher.Close()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://revision", WithURLMux(broker.mux()),
+		WithRevision(func() uint64 { return 5 }), recordAcks(events),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	applied := make(chan UpdateMessage, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		return nil
+	})
+
+	// the watcher is at revision 5, so revision 3 is already applied
+	for _, rev := range []uint64{3, 6} {
+		atomic.StoreUint64(&published, rev)
+		if err := publisher.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	expectAcks(t, events, ackEvent{1, "publisher", Dropped}, ackEvent{2, "publisher", Acked})
+
+	select {
+	case um := <-applied:
+		if um.Revision != 6 {
+			t.Fatalf("Expected only revision 6 to be applied, got %d", um.Revision)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The update for revision 6 wasn't applied")
+	}
+	select {
+	case um := <-applied:
+		t.Fatalf("Expected a single update to be applied, got revision %d", um.Revision)
+	default:
+	}
+}
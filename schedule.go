@@ -0,0 +1,268 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+var (
+	// ErrUnknownSchedule is returned by CancelScheduledUpdate for an ID that
+	// isn't pending: it never existed, already fired or was cancelled.
+	ErrUnknownSchedule = errors.New("no pending scheduled update with this ID")
+	// ErrSchedulingUnsupported is returned by a Scheduler that can't schedule
+	// messages for the provider behind the topic.
+	ErrSchedulingUnsupported = errors.New("scheduled delivery isn't supported for this provider")
+)
+
+// Scheduler hands m to the provider behind topic for delivery at the given
+// time. It returns a func cancelling the delivery, or an error wrapping
+// ErrSchedulingUnsupported when it doesn't support the provider.
+type Scheduler func(ctx context.Context, topic *pubsub.Topic, m *pubsub.Message, at time.Time) (cancel func(context.Context) error, err error)
+
+var (
+	schedulersMu sync.RWMutex
+	schedulers   []Scheduler
+)
+
+// RegisterScheduler adds s to the schedulers ScheduleUpdate tries before
+// falling back to an in-process timer. Drivers whose provider supports
+// scheduled delivery register one on import.
+func RegisterScheduler(s Scheduler) {
+	schedulersMu.Lock()
+	defer schedulersMu.Unlock()
+	schedulers = append(schedulers, s)
+}
+
+// scheduleNatively runs the registered schedulers until one supports the
+// provider. ok is false when none does.
+func (w *Watcher) scheduleNatively(ctx context.Context, sender topicSender, m *pubsub.Message, at time.Time) (cancel func(context.Context) error, ok bool, err error) {
+	topic, isPubsub := pubsubTopic(sender)
+	if !isPubsub {
+		w.warnCapability("schedule", "Can't schedule updates on a topic not opened through gocloud.dev, they're sent by an in-process timer")
+		return nil, false, nil
+	}
+	schedulersMu.RLock()
+	defer schedulersMu.RUnlock()
+	for _, s := range schedulers {
+		cancel, err := s(ctx, topic, m, at)
+		if errors.Is(err, ErrSchedulingUnsupported) {
+			continue
+		}
+		return cancel, true, err
+	}
+	return nil, false, nil
+}
+
+// ScheduledUpdate is an update waiting to be sent, see ScheduleUpdate
+type ScheduledUpdate struct {
+	ID      string
+	At      time.Time
+	Payload []byte
+	// Native is set when the provider delivers the update, rather than an
+	// in-process timer sending it.
+	Native bool
+}
+
+// scheduler holds the pending scheduled updates
+type scheduler struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[string]*scheduled
+}
+
+// scheduled is a pending update: either timer sends it, or the provider
+// does and cancel revokes it
+type scheduled struct {
+	ScheduledUpdate
+	// seq orders updates scheduled for the same time
+	seq    uint64
+	timer  Timer
+	cancel func(context.Context) error
+}
+
+// ScheduleUpdate notifies the watchers at the given time, or right away if it
+// has passed, with payload as the message body: an encoded UpdateMessage, or
+// nil for the reload signal sent by Update. It returns the ID to cancel it with.
+//
+// The provider's scheduled delivery is used when a registered Scheduler
+// supports it, and the update survives this process. Otherwise an in-process
+// timer sends it, driven by the WithClock clock; such updates are dropped by
+// Close and failures to send them are reported on the Errors channel. The
+// timer's updates get their sequence when they're sent, while the provider's
+// carry none.
+func (w *Watcher) ScheduleUpdate(ctx context.Context, at time.Time, payload []byte) (string, error) {
+	if payload == nil {
+		payload = w.reloadSignal()
+	}
+	op := Update
+	if um, err := w.decode(payload); err == nil {
+		op = um.Op
+	}
+
+	w.connMu.RLock()
+	closed, topic := w.closed, w.topic
+	w.connMu.RUnlock()
+	if closed {
+		return "", ErrClosed
+	}
+	if topic == nil {
+		return "", ErrNotConnected
+	}
+	// the provider delivers it after the updates sent meanwhile, so it
+	// carries no sequence
+	m := w.newUnsequencedMessage(payload, op)
+	if err := w.compress(m); err != nil {
+		return "", err
+	}
+	cancel, native, err := w.scheduleNatively(ctx, topic, m, at)
+	if err != nil {
+		return "", fmt.Errorf("failed to schedule update, error: %w", err)
+	}
+
+	entry := &scheduled{ScheduledUpdate: ScheduledUpdate{At: at, Payload: payload, Native: native}, cancel: cancel}
+	if !w.addScheduled(entry, op) {
+		if native {
+			_ = cancel(ctx)
+		}
+		return "", ErrClosed
+	}
+	return entry.ID, nil
+}
+
+// addScheduled adds entry to the pending updates, starting its timer unless
+// the provider delivers it. It returns false if the watcher was closed.
+func (w *Watcher) addScheduled(entry *scheduled, op UpdateType) bool {
+	// Close drops the pending updates while holding the write lock
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	if w.closed {
+		return false
+	}
+
+	s := &w.schedule
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	entry.seq, entry.ID = s.next, strconv.FormatUint(s.next, 10)
+	if s.pending == nil {
+		s.pending = map[string]*scheduled{}
+	}
+	s.pending[entry.ID] = entry
+	if entry.Native {
+		return true
+	}
+	id, payload := entry.ID, entry.Payload
+	w.routines.add(1)
+	entry.timer = w.opts.clock.AfterFunc(entry.At.Sub(w.opts.clock.Now()), func() { w.fireScheduled(id, payload, op) })
+	return true
+}
+
+// ScheduledUpdates returns the pending scheduled updates, earliest first
+func (w *Watcher) ScheduledUpdates() []ScheduledUpdate {
+	s := &w.schedule
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.pruneNativeLocked()
+	entries := make([]*scheduled, 0, len(s.pending))
+	for _, entry := range s.pending {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].At.Equal(entries[j].At) {
+			return entries[i].At.Before(entries[j].At)
+		}
+		return entries[i].seq < entries[j].seq
+	})
+	updates := make([]ScheduledUpdate, len(entries))
+	for i, entry := range entries {
+		updates[i] = entry.ScheduledUpdate
+	}
+	return updates
+}
+
+// CancelScheduledUpdate stops the pending scheduled update id from being
+// sent. It returns an error wrapping ErrUnknownSchedule if it isn't pending,
+// or the provider's error if it failed to cancel a native delivery within
+// the send timeout.
+func (w *Watcher) CancelScheduledUpdate(id string) error {
+	s := &w.schedule
+	s.mu.Lock()
+	w.pruneNativeLocked()
+	entry, ok := s.pending[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownSchedule, id)
+	}
+	delete(s.pending, id)
+	if !entry.Native {
+		entry.timer.Stop()
+		w.routines.add(-1)
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	ctx := w.lifecycle()
+	if timeout := w.opts.sendTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := entry.cancel(ctx); err != nil {
+		return fmt.Errorf("failed to cancel scheduled update %s, error: %w", id, err)
+	}
+	return nil
+}
+
+// pruneNativeLocked forgets the native deliveries whose time has come, the
+// provider having sent them
+func (w *Watcher) pruneNativeLocked() {
+	now := w.opts.clock.Now()
+	for id, entry := range w.schedule.pending {
+		if entry.Native && !entry.At.After(now) {
+			delete(w.schedule.pending, id)
+		}
+	}
+}
+
+// fireScheduled sends the update id, with the sequence of the time it's sent
+func (w *Watcher) fireScheduled(id string, payload []byte, op UpdateType) {
+	s := &w.schedule
+	s.mu.Lock()
+	_, ok := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+	if !ok {
+		// cancelled while the timer fired
+		return
+	}
+	defer w.routines.add(-1)
+
+	m := w.newMessage(payload, op)
+	if err := w.broadcast(w.lifecycle(), m); err != nil {
+		w.log(LevelError, "Failed to send scheduled update", "error", err, "id", id)
+		w.pushError(fmt.Errorf("failed to send scheduled update %s, error: %w", id, err))
+	}
+}
+
+// stopScheduled drops every pending scheduled update. Native deliveries are
+// left to the provider.
+func (w *Watcher) stopScheduled() {
+	s := &w.schedule
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range s.pending {
+		if !entry.Native {
+			entry.timer.Stop()
+			w.routines.add(-1)
+		}
+		delete(s.pending, id)
+	}
+}
@@ -0,0 +1,183 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// fakeNativeScheduler stands in for a provider with scheduled delivery,
+// exposed through As by fake topics
+type fakeNativeScheduler struct {
+	scheduled map[string]time.Time
+	cancelled []string
+}
+
+func init() {
+	RegisterScheduler(func(ctx context.Context, topic *pubsub.Topic, m *pubsub.Message, at time.Time) (func(context.Context) error, error) {
+		var ns *fakeNativeScheduler
+		if !topic.As(&ns) {
+			return nil, ErrSchedulingUnsupported
+		}
+		body := string(m.Body)
+		ns.scheduled[body] = at
+		return func(context.Context) error {
+			ns.cancelled = append(ns.cancelled, body)
+			return nil
+		}, nil
+	})
+}
+
+func TestScheduledUpdates(t *testing.T) {
+	ctx := context.Background()
+
+	clock := newFakeClock()
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://scheduled", WithURLMux(broker.mux()), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	received := make(chan UpdateMessage, 2)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		received <- um
+		return nil
+	})
+
+	start := clock.Now()
+	payload, err := w.codec.Marshal(UpdateMessage{Op: UpdateForAddPolicy, Sec: "p", Ptype: "p", Params: []string{"alice", "data1", "read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := w.ScheduleUpdate(ctx, start.Add(time.Minute), nil)
+	if err != nil {
+		t.Fatalf("Failed to schedule update: %s", err)
+	}
+	second, err := w.ScheduleUpdate(ctx, start.Add(2*time.Minute), payload)
+	if err != nil {
+		t.Fatalf("Failed to schedule update: %s", err)
+	}
+
+	pending := w.ScheduledUpdates()
+	if len(pending) != 2 || pending[0].ID != first || pending[1].ID != second || pending[0].Native {
+		t.Fatalf("Unexpected pending updates: %+v", pending)
+	}
+	if err := w.CancelScheduledUpdate(first); err != nil {
+		t.Fatalf("Failed to cancel update: %s", err)
+	}
+	if err := w.CancelScheduledUpdate(first); !errors.Is(err, ErrUnknownSchedule) {
+		t.Fatalf("Cancelling twice: got %v, want ErrUnknownSchedule", err)
+	}
+
+	// an update sent meanwhile comes before the scheduled one in sequence
+	if err := w.UpdateForAddPolicy("p", "p", "bob", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	var sent uint64
+	select {
+	case um := <-received:
+		sent = um.Sequence
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update wasn't sent")
+	}
+
+	clock.advance(time.Minute)
+	clock.advance(59 * time.Second)
+	select {
+	case um := <-received:
+		t.Fatalf("Update sent before its time: %+v", um)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	clock.advance(time.Second)
+	select {
+	case um := <-received:
+		if um.Op != UpdateForAddPolicy || um.Params[0] != "alice" {
+			t.Fatalf("Unexpected update: %+v", um)
+		}
+		if um.Sequence <= sent {
+			t.Fatalf("Scheduled update sent with sequence %d, not after %d", um.Sequence, sent)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Scheduled update wasn't sent")
+	}
+	if pending := w.ScheduledUpdates(); len(pending) != 0 {
+		t.Fatalf("Fired update still pending: %+v", pending)
+	}
+	if err := w.CancelScheduledUpdate(second); !errors.Is(err, ErrUnknownSchedule) {
+		t.Fatalf("Cancelling a fired update: got %v, want ErrUnknownSchedule", err)
+	}
+}
+
+func TestNativeScheduledUpdates(t *testing.T) {
+	ctx := context.Background()
+
+	clock := newFakeClock()
+	ns := &fakeNativeScheduler{scheduled: map[string]time.Time{}}
+	broker := &fakeBroker{topicAs: func(i interface{}) bool {
+		p, ok := i.(**fakeNativeScheduler)
+		if ok {
+			*p = ns
+		}
+		return ok
+	}}
+	w, err := NewWithOptions(ctx, "fake://scheduled", WithURLMux(broker.mux()), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	at := clock.Now().Add(time.Hour)
+	id, err := w.ScheduleUpdate(ctx, at, nil)
+	if err != nil {
+		t.Fatalf("Failed to schedule update: %s", err)
+	}
+	if got := ns.scheduled[legacyUpdateBody]; !got.Equal(at) {
+		t.Fatalf("Provider scheduled the update at %s, want %s", got, at)
+	}
+	if pending := w.ScheduledUpdates(); len(pending) != 1 || !pending[0].Native {
+		t.Fatalf("Unexpected pending updates: %+v", pending)
+	}
+	if n := w.GoroutineCount(); n != 1 {
+		t.Fatalf("A native delivery runs %d goroutines besides the receive loop", n-1)
+	}
+	if err := w.CancelScheduledUpdate(id); err != nil {
+		t.Fatalf("Failed to cancel update: %s", err)
+	}
+	if len(ns.cancelled) != 1 {
+		t.Fatal("Cancel wasn't passed to the provider")
+	}
+
+	// once delivered by the provider the update is no longer pending
+	if _, err := w.ScheduleUpdate(ctx, at, nil); err != nil {
+		t.Fatalf("Failed to schedule update: %s", err)
+	}
+	clock.advance(time.Hour)
+	if pending := w.ScheduledUpdates(); len(pending) != 0 {
+		t.Fatalf("Delivered update still pending: %+v", pending)
+	}
+}
+
+func TestScheduledUpdatesDroppedOnClose(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := NewWithOptions(ctx, "fake://scheduled", WithURLMux((&fakeBroker{}).mux()), WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	if _, err := w.ScheduleUpdate(ctx, time.Now().Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	waitFor(t, time.Second*5, func() bool { return w.GoroutineCount() == 0 })
+	if pending := w.ScheduledUpdates(); len(pending) != 0 {
+		t.Fatalf("Pending updates survived Close: %+v", pending)
+	}
+	if _, err := w.ScheduleUpdate(ctx, time.Now(), nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Scheduling after Close: got %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,57 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTooManySends is returned with SendLimitReject when every send slot is in
+// use
+var ErrTooManySends = errors.New("too many updates being sent")
+
+// SendLimitPolicy decides what happens to an update sent while every slot
+// allowed by WithMaxConcurrentSends is in use
+type SendLimitPolicy int
+
+// Send limit policies
+const (
+	// SendLimitBlock waits for a slot, until the context is done.
+	SendLimitBlock SendLimitPolicy = iota
+	// SendLimitReject fails the send with a retryable SendError matching
+	// ErrTooManySends.
+	SendLimitReject
+)
+
+// WithMaxConcurrentSends caps the number of updates handed to the provider at
+// the same time to n, protecting the broker and its client from bursts of
+// Update calls. policy applies to the sends beyond the cap.
+func WithMaxConcurrentSends(n int, policy SendLimitPolicy) Option {
+	return optionFunc(func(o *options) {
+		o.maxSends = n
+		o.sendLimitPolicy = policy
+	})
+}
+
+// acquireSend takes a send slot and returns the func giving it back
+func (w *Watcher) acquireSend(ctx context.Context) (func(), error) {
+	if w.sendSlots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-w.sendSlots }
+	select {
+	case w.sendSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if w.opts.sendLimitPolicy == SendLimitReject {
+		return nil, &SendError{Kind: Transient, Err: ErrTooManySends}
+	}
+	select {
+	case w.sendSlots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, &SendError{Kind: w.opts.errorClassifier(ctx.Err()), Err: ctx.Err()}
+	case <-w.closedCh:
+		return nil, &SendError{Kind: Shutdown, Err: ErrClosed}
+	}
+}
@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestMaxConcurrentSends(t *testing.T) {
+	ctx := context.Background()
+
+	var active, peak int32
+	broker := &fakeBroker{sendErr: func(context.Context, []*driver.Message) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}}
+	w, err := NewWithOptions(ctx, "fake://limited", WithURLMux(broker.mux()), WithMaxConcurrentSends(3, SendLimitBlock))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Update(); err != nil {
+				t.Errorf("Failed to send update: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if p := atomic.LoadInt32(&peak); p > 3 {
+		t.Fatalf("%d sends ran at the same time, cap is 3", p)
+	}
+}
+
+func TestMaxConcurrentSendsReject(t *testing.T) {
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	sending := make(chan struct{}, 1)
+	broker := &fakeBroker{sendErr: func(context.Context, []*driver.Message) error {
+		sending <- struct{}{}
+		<-release
+		return nil
+	}}
+	w, err := NewWithOptions(ctx, "fake://limited", WithURLMux(broker.mux()), WithMaxConcurrentSends(1, SendLimitReject))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- w.Update() }()
+	<-sending
+
+	err = w.Update()
+	var sendErr *SendError
+	if !errors.Is(err, ErrTooManySends) || !errors.As(err, &sendErr) || !sendErr.Retryable() {
+		t.Fatalf("Expected a retryable ErrTooManySends, got: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.Update(); err != nil {
+		t.Fatalf("Slot wasn't released: %s", err)
+	}
+}
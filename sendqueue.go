@@ -0,0 +1,222 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrSendQueueFull is returned with SendLimitReject when the WithAsyncSend
+// queue is full
+var ErrSendQueueFull = errors.New("send queue is full")
+
+// WithAsyncSend makes Update and the UpdateFor* methods return as soon as the
+// update is queued, in a queue of size updates, instead of once the provider
+// accepted it. A single background sender publishes the queued updates in
+// order, retrying a failed send up to retries times while its SendError is
+// retryable. Updates it can't send are logged and, with WithErrorChannel,
+// reported on Errors, or spilled with WithSpillFile. policy applies when the
+// queue is full. Close sends what is still queued within its timeout.
+func WithAsyncSend(size int, policy SendLimitPolicy, retries int) Option {
+	return optionFunc(func(o *options) {
+		if size < 1 {
+			size = 1
+		}
+		o.sendQueue = size
+		o.sendQueuePolicy = policy
+		o.sendRetries = retries
+	})
+}
+
+// sendQueue feeds the background sender. Close sets stopped and closes stop
+// to turn new and waiting updates away, and once the updates being queued
+// are in, closes drain to make the sender send what is left and exit,
+// closing done. It's created with the watcher and started is set once Open
+// started the sender.
+type sendQueue struct {
+	ch      chan queuedMessage
+	stop    chan struct{}
+	drain   chan struct{}
+	done    chan struct{}
+	started int32
+
+	mu      sync.Mutex
+	stopped bool
+	// pushing counts the updates being queued
+	pushing sync.WaitGroup
+}
+
+// queuedMessage is an update in the queue, queued at the given time
+type queuedMessage struct {
+	m      *pubsub.Message
+	queued time.Time
+}
+
+// newSendQueue returns the queue of the background sender, or nil unless
+// WithAsyncSend is set
+func newSendQueue(o options) *sendQueue {
+	if o.sendQueue <= 0 {
+		return nil
+	}
+	return &sendQueue{
+		ch:    make(chan queuedMessage, o.sendQueue),
+		stop:  make(chan struct{}),
+		drain: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// queued reports whether updates go through the background sender, which
+// runs once the watcher is open
+func (q *sendQueue) queued() bool {
+	return q != nil && atomic.LoadInt32(&q.started) == 1
+}
+
+// startSendQueue starts the background sender if WithAsyncSend is set
+func (w *Watcher) startSendQueue() {
+	q := w.queue
+	if q == nil || !atomic.CompareAndSwapInt32(&q.started, 0, 1) {
+		return
+	}
+	w.routines.start(func() {
+		defer close(q.done)
+		for {
+			select {
+			case qm := <-q.ch:
+				w.sendQueued(qm)
+			case <-q.drain:
+				for {
+					select {
+					case qm := <-q.ch:
+						w.sendQueued(qm)
+					default:
+						return
+					}
+				}
+			}
+		}
+	})
+}
+
+// enqueue queues m for the background sender
+func (w *Watcher) enqueue(ctx context.Context, m *pubsub.Message) error {
+	// sendQueued ends it once m was sent or given up on
+	w.quiet.begin()
+	err := w.pushQueue(ctx, m)
+	if err != nil {
+		w.quiet.end()
+	}
+	return err
+}
+
+// pushQueue adds m to the queue, applying the policy when it is full
+func (w *Watcher) pushQueue(ctx context.Context, m *pubsub.Message) error {
+	q := w.queue
+	qm := queuedMessage{m: m, queued: w.opts.clock.Now()}
+	// Close waits for the updates being queued before draining the queue,
+	// so none is left behind
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return &SendError{Kind: Shutdown, Err: ErrClosed}
+	}
+	q.pushing.Add(1)
+	q.mu.Unlock()
+	defer q.pushing.Done()
+	select {
+	case q.ch <- qm:
+		return nil
+	default:
+	}
+	if w.opts.sendQueuePolicy == SendLimitReject {
+		return &SendError{Kind: Transient, Err: ErrSendQueueFull}
+	}
+	select {
+	case q.ch <- qm:
+		return nil
+	case <-ctx.Done():
+		return &SendError{Kind: w.opts.errorClassifier(ctx.Err()), Err: ctx.Err()}
+	case <-q.stop:
+		return &SendError{Kind: Shutdown, Err: ErrClosed}
+	}
+}
+
+// sendQueued sends qm, retrying retryable failures with the WithBackoff
+// backoff until it's sent, given up on or expired
+func (w *Watcher) sendQueued(qm queuedMessage) {
+	defer w.quiet.end()
+	m := qm.m
+	for attempt := 0; ; attempt++ {
+		if w.expireBuffered(m.Metadata, qm.queued) {
+			return
+		}
+		err := w.sendNow(w.lifecycle(), m)
+		if err == nil {
+			if attempt > 0 {
+				w.opts.backoff.Reset()
+			}
+			return
+		}
+		var sendErr *SendError
+		retryable := errors.As(err, &sendErr) && sendErr.Retryable()
+		if attempt >= w.opts.sendRetries || !retryable {
+			w.giveUpQueued(qm, err, attempt+1)
+			return
+		}
+		select {
+		case <-w.lifecycle().Done():
+			w.giveUpQueued(qm, err, attempt+1)
+			return
+		case <-time.After(w.opts.backoff.Next(attempt + 1)):
+		}
+	}
+}
+
+// giveUpQueued reports that qm couldn't be sent after attempts attempts,
+// failing with err. It's spilled, with WithSpillFile, when the failure may
+// pass or the watcher is closing, so the next run publishes it.
+func (w *Watcher) giveUpQueued(qm queuedMessage, err error, attempts int) {
+	m := qm.m
+	seq := m.Metadata[w.metadataKey(metaSequence)]
+	var sendErr *SendError
+	spillable := errors.As(err, &sendErr) && (sendErr.Retryable() || sendErr.Kind == Shutdown)
+	if spillable && w.opts.spillPath != "" {
+		spillErr := w.spillMessage(m, qm.queued)
+		if spillErr == nil {
+			w.log(LevelWarn, "Failed to send queued update, spilled it to publish later", "error", err, "sequence", seq, "attempts", attempts)
+			return
+		}
+		w.log(LevelError, "Failed to spill queued update", "error", spillErr, "sequence", seq)
+		w.pushError(fmt.Errorf("failed to spill queued update %s, error: %w", seq, spillErr))
+	}
+	w.log(LevelError, "Failed to send queued update", "error", err, "sequence", seq, "attempts", attempts)
+	w.pushError(fmt.Errorf("failed to send queued update %s, error: %w", seq, err))
+}
+
+// flushSendQueue stops the background sender once it sent the queued
+// updates, or ctx is done
+func (w *Watcher) flushSendQueue(ctx context.Context) {
+	q := w.queue
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.stopped = true
+	q.mu.Unlock()
+	close(q.stop)
+	q.pushing.Wait()
+	close(q.drain)
+	if !q.queued() {
+		return
+	}
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		w.log(LevelWarn, "Close timed out sending the queued updates", "error", ctx.Err())
+	}
+}
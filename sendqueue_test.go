@@ -0,0 +1,207 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestAsyncSend(t *testing.T) {
+	ctx := context.Background()
+
+	const latency = 50 * time.Millisecond
+	broker := &fakeBroker{
+		sendErr: func(context.Context, []*driver.Message) error {
+			time.Sleep(latency)
+			return nil
+		},
+	}
+	w, err := NewWithOptions(ctx, "fake://async", WithURLMux(broker.mux()), WithAsyncSend(10, SendLimitBlock, 0))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var mu sync.Mutex
+	var received []uint64
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		mu.Lock()
+		received = append(received, um.Sequence)
+		mu.Unlock()
+		return nil
+	})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to queue update: %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= latency {
+		t.Fatalf("Update waited for the broker, 5 updates took %s", elapsed)
+	}
+
+	waitFor(t, time.Second*5, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 5
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(received); i++ {
+		if received[i] <= received[i-1] {
+			t.Fatalf("Updates were sent out of order: %v", received)
+		}
+	}
+}
+
+func TestAsyncSendErrors(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		errTransient = errors.New("broker unavailable")
+		errPermanent = errors.New("topic deleted")
+	)
+	var attempts int32
+	var fail atomic.Value
+	broker := &fakeBroker{
+		sendErr: func(context.Context, []*driver.Message) error {
+			atomic.AddInt32(&attempts, 1)
+			return fail.Load().(func() error)()
+		},
+		errorCode: func(err error) gcerrors.ErrorCode {
+			if errors.Is(err, errPermanent) {
+				return gcerrors.NotFound
+			}
+			return gcerrors.Internal
+		},
+	}
+	fail.Store(func() error { return nil })
+	w, err := NewWithOptions(ctx, "fake://async", WithURLMux(broker.mux()), WithAsyncSend(10, SendLimitBlock, 2), WithErrorChannel(1))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	// transient failures are retried until the send succeeds
+	var failures int32
+	fail.Store(func() error {
+		if atomic.AddInt32(&failures, 1) <= 2 {
+			return errTransient
+		}
+		return nil
+	})
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to queue update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&attempts) == 3 })
+
+	// permanent failures are given up on and reported
+	fail.Store(func() error { return errPermanent })
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to queue update: %s", err)
+	}
+	select {
+	case err := <-w.Errors():
+		var sendErr *SendError
+		if !errors.As(err, &sendErr) || !errors.Is(err, errPermanent) || sendErr.Retryable() {
+			t.Fatalf("Expected the permanent send error, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The failed send wasn't reported")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 4 {
+		t.Fatalf("Expected a permanent failure not to be retried, %d attempts", n-3)
+	}
+}
+
+func TestAsyncSendQueueFull(t *testing.T) {
+	ctx := context.Background()
+
+	var sending int32
+	release := make(chan struct{})
+	broker := &fakeBroker{
+		sendErr: func(context.Context, []*driver.Message) error {
+			atomic.AddInt32(&sending, 1)
+			<-release
+			return nil
+		},
+	}
+	w, err := NewWithOptions(ctx, "fake://async", WithURLMux(broker.mux()), WithAsyncSend(1, SendLimitReject, 0))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	// the first update is being sent, the second fills the queue
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to queue update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&sending) == 1 })
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to queue update: %s", err)
+	}
+	err = w.Update()
+	var sendErr *SendError
+	if !errors.Is(err, ErrSendQueueFull) || !errors.As(err, &sendErr) || !sendErr.Retryable() {
+		t.Fatalf("Expected a retryable ErrSendQueueFull, got: %v", err)
+	}
+
+	// Close sends what is still queued
+	close(release)
+	if err := w.CloseContext(ctx); err != nil {
+		t.Fatalf("Failed to close watcher: %s", err)
+	}
+	if n := atomic.LoadInt32(&sending); n != 2 {
+		t.Fatalf("Expected Close to send the queued update, %d sent", n)
+	}
+}
+
+func TestAsyncSendClosing(t *testing.T) {
+	ctx := context.Background()
+
+	var sent int64
+	broker := &fakeBroker{
+		sendErr: func(_ context.Context, ms []*driver.Message) error {
+			atomic.AddInt64(&sent, int64(len(ms)))
+			return nil
+		},
+	}
+	w, err := NewWithOptions(ctx, "fake://async", WithURLMux(broker.mux()), WithAsyncSend(2, SendLimitBlock, 0))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+
+	// every update queued while closing is sent, the others are turned away
+	var queued int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := w.Update()
+				if err == nil {
+					atomic.AddInt64(&queued, 1)
+					continue
+				}
+				if !errors.Is(err, ErrClosed) {
+					t.Errorf("Unexpected error queueing while closing: %v", err)
+				}
+				return
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	w.Close()
+	wg.Wait()
+	if got, want := atomic.LoadInt64(&sent), atomic.LoadInt64(&queued); got != want {
+		t.Fatalf("%d updates were queued but %d sent", want, got)
+	}
+}
@@ -0,0 +1,288 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"gocloud.dev/pubsub"
+)
+
+// SequenceStore persists, for each origin, the sequence up to which every
+// update was applied, so that a restarted watcher still recognises the
+// updates it already applied when an at-least-once provider delivers them
+// again. Implementations, e.g. backed by Redis or a local file, must be safe
+// for concurrent use.
+type SequenceStore interface {
+	// Load returns the mark saved for origin, or zero if none was.
+	Load(ctx context.Context, origin string) (uint64, error)
+	// Save records that every sequence up to seq was applied from origin.
+	Save(ctx context.Context, origin string, seq uint64) error
+}
+
+// WithSequenceStore drops the updates already applied from their origin,
+// as recorded in store: every sequence up to the mark loaded from store the
+// first time the origin is seen, and those acked since. Updates may be acked
+// out of order, e.g. delivered unordered or applied concurrently; the mark
+// saved to store only moves up once every sequence below it was acked.
+// Sequences restart with each publishing process, so publishers must keep
+// the default random instance ID rather than set one with WithInstanceID.
+// Updates without a sequence, e.g. from older watchers, are never dropped.
+func WithSequenceStore(store SequenceStore) Option {
+	return optionFunc(func(o *options) {
+		o.sequenceStore = store
+	})
+}
+
+// WithSequenceTracking records the sequences acked from each origin without
+// a SequenceStore, for ExportSequenceState. Updates aren't dropped until
+// ImportSequenceState is called.
+func WithSequenceTracking() Option {
+	return optionFunc(func(o *options) {
+		o.sequenceTracking = true
+	})
+}
+
+// maxSequencesAhead bounds the sequences kept above the mark of an origin.
+// Beyond it, the missing sequences holding the mark back are given up on.
+const maxSequencesAhead = 1024
+
+// sequenceMarks follows the sequences applied from each origin, for
+// WithSequenceStore, WithSequenceTracking and ImportSequenceState.
+type sequenceMarks struct {
+	// imported is set atomically once ImportSequenceState was called, so
+	// the marks drop updates even without a store
+	imported int32
+	mu       sync.Mutex
+	origins  map[string]*originMarks
+	// saving serializes the saves, made without holding mu, so the store
+	// never goes backwards
+	saving sync.Mutex
+}
+
+// originMarks are the sequences applied from an origin: every one above
+// floor up to mark, and those in ahead. An origin first seen mid-stream
+// starts with floor and mark just below its first sequence, so the earlier
+// ones, e.g. delivered out of order, aren't taken as applied.
+type originMarks struct {
+	floor uint64
+	mark  uint64
+	ahead map[uint64]struct{}
+	// saved is the mark last saved to the store
+	saved uint64
+}
+
+// has reports whether seq was applied
+func (m *originMarks) has(seq uint64) bool {
+	_, ok := m.ahead[seq]
+	return seq > m.floor && seq <= m.mark || ok
+}
+
+// add records seq as applied, moving the mark up over the sequences now
+// contiguous
+func (m *originMarks) add(seq uint64) {
+	if seq <= m.floor || m.has(seq) {
+		return
+	}
+	if m.ahead == nil {
+		m.ahead = map[uint64]struct{}{}
+	}
+	m.ahead[seq] = struct{}{}
+	if len(m.ahead) > maxSequencesAhead {
+		// the missing sequences are lost, e.g. expired by the provider
+		lowest := seq
+		for s := range m.ahead {
+			if s < lowest {
+				lowest = s
+			}
+		}
+		m.mark = lowest - 1
+	}
+	m.advance()
+}
+
+// raise records every sequence up to mark as applied, e.g. as loaded from
+// the store or imported
+func (m *originMarks) raise(mark uint64) {
+	if mark < m.floor || mark <= m.mark && m.floor == 0 {
+		// the sequences between mark and the floor stay unknown
+		return
+	}
+	m.floor = 0
+	if mark > m.mark {
+		m.mark = mark
+	}
+	for s := range m.ahead {
+		if s <= m.mark {
+			delete(m.ahead, s)
+		}
+	}
+	m.advance()
+}
+
+// advance moves the mark up over the sequences ahead now contiguous
+func (m *originMarks) advance() {
+	for {
+		if _, ok := m.ahead[m.mark+1]; !ok {
+			return
+		}
+		delete(m.ahead, m.mark+1)
+		m.mark++
+	}
+}
+
+// ExportSequenceState returns the mark of each origin, up to which every
+// sequence was acked, e.g. to hand over to the instance replacing this one
+// with ImportSequenceState during a blue-green deploy. It is empty unless
+// the watcher has a SequenceStore or WithSequenceTracking.
+func (w *Watcher) ExportSequenceState() map[string]uint64 {
+	w.seqMarks.mu.Lock()
+	defer w.seqMarks.mu.Unlock()
+	state := make(map[string]uint64, len(w.seqMarks.origins))
+	for origin, m := range w.seqMarks.origins {
+		state[origin] = m.mark
+	}
+	return state
+}
+
+// ImportSequenceState raises the marks to those of state, exported by
+// another watcher with ExportSequenceState, and from then on drops the
+// updates already applied as with WithSequenceStore, which is given the new
+// marks. Marks already higher are kept.
+func (w *Watcher) ImportSequenceState(state map[string]uint64) {
+	atomic.StoreInt32(&w.seqMarks.imported, 1)
+	w.seqMarks.mu.Lock()
+	for origin, mark := range state {
+		w.originMarksLocked(origin, 0).raise(mark)
+	}
+	w.seqMarks.mu.Unlock()
+	for origin := range state {
+		w.saveSequence(origin)
+	}
+}
+
+// dropsApplied reports whether updates already applied are dropped
+func (w *Watcher) dropsApplied() bool {
+	return w.opts.sequenceStore != nil || atomic.LoadInt32(&w.seqMarks.imported) == 1
+}
+
+// tracksSequences reports whether the sequences acked are recorded
+func (w *Watcher) tracksSequences() bool {
+	return w.dropsApplied() || w.opts.sequenceTracking
+}
+
+// originMarksLocked returns the marks of origin, created if needed to start
+// just below seq, the sequence first seen. It must be called while holding
+// w.seqMarks.mu.
+func (w *Watcher) originMarksLocked(origin string, seq uint64) *originMarks {
+	if w.seqMarks.origins == nil {
+		w.seqMarks.origins = map[string]*originMarks{}
+	}
+	m, ok := w.seqMarks.origins[origin]
+	if !ok {
+		m = &originMarks{}
+		if seq > 0 {
+			m.floor, m.mark = seq-1, seq-1
+		}
+		w.seqMarks.origins[origin] = m
+	}
+	return m
+}
+
+// messageSequence returns the origin and sequence of an update, ok is false
+// for messages without them
+func (w *Watcher) messageSequence(msg *pubsub.Message) (origin string, seq uint64, ok bool) {
+	origin = msg.Metadata[w.metadataKey(metaOrigin)]
+	seq, err := strconv.ParseUint(msg.Metadata[w.metadataKey(metaSequence)], 10, 64)
+	return origin, seq, origin != "" && err == nil
+}
+
+// isAlreadyApplied reports whether msg was already applied from its origin
+func (w *Watcher) isAlreadyApplied(msg *pubsub.Message) bool {
+	if !w.dropsApplied() {
+		return false
+	}
+	origin, seq, ok := w.messageSequence(msg)
+	if !ok || !w.loadMark(origin, seq) {
+		return false
+	}
+	w.seqMarks.mu.Lock()
+	defer w.seqMarks.mu.Unlock()
+	return w.originMarksLocked(origin, seq).has(seq)
+}
+
+// loadMark loads the mark of origin from the store on first use, seq being
+// the sequence received, and reports whether it's known. A failed load is
+// reported and retried with the next update.
+func (w *Watcher) loadMark(origin string, seq uint64) bool {
+	w.seqMarks.mu.Lock()
+	_, ok := w.seqMarks.origins[origin]
+	w.seqMarks.mu.Unlock()
+	if ok || w.opts.sequenceStore == nil {
+		return true
+	}
+
+	loaded, err := w.opts.sequenceStore.Load(w.lifecycle(), origin)
+	if err != nil {
+		w.log(LevelError, "Failed to load the sequence high-water mark", "error", err, "origin", origin)
+		w.pushError(fmt.Errorf("failed to load the sequence of %q, error: %w", origin, err))
+		return false
+	}
+	w.seqMarks.mu.Lock()
+	defer w.seqMarks.mu.Unlock()
+	m := w.originMarksLocked(origin, seq)
+	if loaded > 0 {
+		m.raise(loaded)
+	}
+	if loaded > m.saved {
+		m.saved = loaded
+	}
+	return true
+}
+
+// saveSequenceOnAck returns settle also recording the sequence of msg, and
+// saving the mark to the store, before it is acked
+func (w *Watcher) saveSequenceOnAck(msg *pubsub.Message, settle func(AckOutcome)) func(AckOutcome) {
+	origin, seq, ok := w.messageSequence(msg)
+	if !ok || !w.tracksSequences() {
+		return settle
+	}
+	return func(outcome AckOutcome) {
+		if outcome == Acked {
+			w.seqMarks.mu.Lock()
+			w.originMarksLocked(origin, seq).add(seq)
+			w.seqMarks.mu.Unlock()
+			w.saveSequence(origin)
+		}
+		settle(outcome)
+	}
+}
+
+// saveSequence saves the mark of origin to the store if it moved up since
+// it was last saved
+func (w *Watcher) saveSequence(origin string) {
+	if w.opts.sequenceStore == nil {
+		return
+	}
+	w.seqMarks.saving.Lock()
+	defer w.seqMarks.saving.Unlock()
+	w.seqMarks.mu.Lock()
+	m := w.originMarksLocked(origin, 0)
+	mark, saved := m.mark, m.saved
+	w.seqMarks.mu.Unlock()
+	if mark <= saved {
+		return
+	}
+	if err := w.opts.sequenceStore.Save(w.lifecycle(), origin, mark); err != nil {
+		w.log(LevelError, "Failed to save the sequence high-water mark", "error", err, "origin", origin, "sequence", mark)
+		w.pushError(fmt.Errorf("failed to save sequence %d of %q, error: %w", mark, origin, err))
+		return
+	}
+	w.seqMarks.mu.Lock()
+	if mark > m.saved {
+		m.saved = mark
+	}
+	w.seqMarks.mu.Unlock()
+}
@@ -0,0 +1,214 @@
+package watcher
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// memorySequenceStore is a SequenceStore surviving the watchers using it
+type memorySequenceStore struct {
+	mu    sync.Mutex
+	marks map[string]uint64
+}
+
+func (s *memorySequenceStore) Load(_ context.Context, origin string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.marks[origin], nil
+}
+
+func (s *memorySequenceStore) Save(_ context.Context, origin string, seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marks[origin] = seq
+	return nil
+}
+
+func TestSequenceStoreSurvivesRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	store := &memorySequenceStore{marks: map[string]uint64{}}
+	topic, err := broker.mux().OpenTopic(ctx, "fake://sequences")
+	if err != nil {
+		t.Fatalf("Failed to open topic, error: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+	send := func(seq uint64) {
+		t.Helper()
+		err := topic.Send(ctx, &pubsub.Message{Body: []byte(legacyUpdateBody), Metadata: map[string]string{
+			DefaultMetadataPrefix + metaOrigin:   "peer",
+			DefaultMetadataPrefix + metaSequence: strconv.FormatUint(seq, 10),
+		}})
+		if err != nil {
+			t.Fatalf("Failed to send message, error: %s", err)
+		}
+	}
+	start := func() (*Watcher, <-chan ackEvent, <-chan uint64) {
+		t.Helper()
+		events := make(chan ackEvent, 10)
+		w, err := NewWithOptions(ctx, "fake://sequences", WithURLMux(broker.mux()),
+			WithSequenceStore(store), recordAcks(events))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		applied := make(chan uint64, 10)
+		w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+			applied <- um.Sequence
+			return nil
+		})
+		return w, events, applied
+	}
+
+	w, events, applied := start()
+	send(1)
+	send(2)
+	expectAcks(t, events, ackEvent{1, "peer", Acked}, ackEvent{2, "peer", Acked})
+	waitFor(t, time.Second*5, func() bool { return len(applied) == 2 })
+	w.Close()
+	if mark, _ := store.Load(ctx, "peer"); mark != 2 {
+		t.Fatalf("Expected sequence 2 to be saved, got %d", mark)
+	}
+
+	// the provider redelivers an update applied before the restart
+	w, events, applied = start()
+	defer w.Close()
+	send(2)
+	send(3)
+	expectAcks(t, events, ackEvent{2, "peer", Dropped}, ackEvent{3, "peer", Acked})
+	select {
+	case seq := <-applied:
+		if seq != 3 {
+			t.Fatalf("Expected only the new update to be applied, got %d", seq)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The new update wasn't applied")
+	}
+	waitFor(t, time.Second*5, func() bool {
+		mark, _ := store.Load(ctx, "peer")
+		return mark == 3
+	})
+}
+
+func TestSequenceStateHandover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	topic, err := broker.mux().OpenTopic(ctx, "fake://handover")
+	if err != nil {
+		t.Fatalf("Failed to open topic, error: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+	send := func(origin string, seq uint64) {
+		t.Helper()
+		err := topic.Send(ctx, &pubsub.Message{Body: []byte(legacyUpdateBody), Metadata: map[string]string{
+			DefaultMetadataPrefix + metaOrigin:   origin,
+			DefaultMetadataPrefix + metaSequence: strconv.FormatUint(seq, 10),
+		}})
+		if err != nil {
+			t.Fatalf("Failed to send message, error: %s", err)
+		}
+	}
+	start := func() (*Watcher, <-chan ackEvent) {
+		t.Helper()
+		events := make(chan ackEvent, 10)
+		w, err := NewWithOptions(ctx, "fake://handover", WithURLMux(broker.mux()), WithSequenceTracking(), recordAcks(events))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		w.SetUpdateCallback(func(string) {})
+		return w, events
+	}
+
+	blue, events := start()
+	send("peer", 1)
+	send("peer", 2)
+	send("other", 1)
+	expectAcks(t, events, ackEvent{1, "peer", Acked}, ackEvent{2, "peer", Acked}, ackEvent{1, "other", Acked})
+	state := blue.ExportSequenceState()
+	blue.Close()
+	if state["peer"] != 2 || state["other"] != 1 {
+		t.Fatalf("Unexpected exported state: %v", state)
+	}
+
+	// the provider redelivers updates blue applied to green
+	green, events := start()
+	defer green.Close()
+	green.ImportSequenceState(state)
+	send("peer", 2)
+	send("peer", 3)
+	send("other", 1)
+	send("newcomer", 1)
+	expectAcks(t, events, ackEvent{2, "peer", Dropped}, ackEvent{3, "peer", Acked},
+		ackEvent{1, "other", Dropped}, ackEvent{1, "newcomer", Acked})
+	if state := green.ExportSequenceState(); state["peer"] != 3 || state["newcomer"] != 1 {
+		t.Fatalf("Expected the marks to move on after the import, got %v", state)
+	}
+}
+
+func TestSequenceStoreOutOfOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	store := &memorySequenceStore{marks: map[string]uint64{}}
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://sequences", WithURLMux(broker.mux()),
+		WithSequenceStore(store), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	send := func(seq uint64) {
+		t.Helper()
+		err := w.topic.Send(ctx, &pubsub.Message{Body: []byte(legacyUpdateBody), Metadata: map[string]string{
+			DefaultMetadataPrefix + metaOrigin:   "peer",
+			DefaultMetadataPrefix + metaSequence: strconv.FormatUint(seq, 10),
+		}})
+		if err != nil {
+			t.Fatalf("Failed to send message, error: %s", err)
+		}
+	}
+
+	// the watcher joins mid-stream and the provider reorders deliveries:
+	// an update older than those acked is applied unless it was itself
+	for _, seq := range []uint64{5, 4, 7, 6, 7} {
+		send(seq)
+	}
+	expectAcks(t, events, ackEvent{5, "peer", Acked}, ackEvent{4, "peer", Acked}, ackEvent{7, "peer", Acked},
+		ackEvent{6, "peer", Acked}, ackEvent{7, "peer", Dropped})
+	waitFor(t, time.Second*5, func() bool {
+		mark, _ := store.Load(ctx, "peer")
+		return mark == 7
+	})
+}
+
+func TestSequencesUntracked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://sequences", WithURLMux((&fakeBroker{}).mux()), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Acked})
+	if state := w.ExportSequenceState(); len(state) != 0 {
+		t.Fatalf("Expected no marks without a store or tracking, got %v", state)
+	}
+}
@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestReloadSignalBody(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var sent []*driver.Message
+	broker := &fakeBroker{
+		sendErr: func(_ context.Context, ms []*driver.Message) error {
+			mu.Lock()
+			sent = append(sent, ms...)
+			mu.Unlock()
+			return nil
+		},
+	}
+	mux := broker.mux()
+	w, err := NewWithOptions(ctx, "fake://signal", WithURLMux(mux), WithReloadSignalBody("RELOAD"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	bodies := make(chan string, 4)
+	ops := make(chan UpdateType, 4)
+	w.SetUpdateCallback(func(body string) { bodies <- body })
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		ops <- um.Op
+		return nil
+	})
+	expectReload := func(body string) {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			select {
+			case got := <-bodies:
+				if got != body {
+					t.Fatalf("Expected the %q body, got %q", body, got)
+				}
+			case op := <-ops:
+				if op != Update {
+					t.Fatalf("Expected a generic reload, got %s", op)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("The reload wasn't applied")
+			}
+		}
+	}
+
+	// the watcher's own signal round-trips
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectReload("RELOAD")
+	mu.Lock()
+	body := string(sent[0].Body)
+	mu.Unlock()
+	if body != "RELOAD" {
+		t.Fatalf("Expected the custom body to be sent, got %q", body)
+	}
+
+	// as does a bare one from a consumer in another language, and the default
+	topic, err := mux.OpenTopic(ctx, "fake://signal")
+	if err != nil {
+		t.Fatalf("Failed to open topic: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+	for _, body := range []string{"RELOAD", legacyUpdateBody} {
+		if err := topic.Send(ctx, &pubsub.Message{Body: []byte(body)}); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+		expectReload(body)
+	}
+}
@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/casbin/casbin/model"
+)
+
+// ErrUnknownPolicyType is returned when a snapshot holds rules for a policy
+// type the model doesn't define
+var ErrUnknownPolicyType = errors.New("policy type not defined in the model")
+
+// snapshotSections are the model sections holding policy rules
+var snapshotSections = []string{"p", "g"}
+
+// SavePolicyMode controls what UpdateForSavePolicy publishes
+type SavePolicyMode int
+
+// Save policy modes
+const (
+	// SavePolicySignal only tells receivers to reload the whole policy.
+	SavePolicySignal SavePolicyMode = iota
+	// SavePolicySnapshot sends every rule of the model in the Snapshot field.
+	SavePolicySnapshot
+)
+
+// WithSavePolicyMode sets what UpdateForSavePolicy publishes,
+// SavePolicySignal by default. Snapshots grow with the policy and may exceed
+// the message size limit of the provider.
+func WithSavePolicyMode(mode SavePolicyMode) Option {
+	return optionFunc(func(o *options) {
+		o.savePolicyMode = mode
+	})
+}
+
+// PolicySnapshot holds every policy rule of a model by section and policy
+// type, e.g. snapshot["p"]["p"] or snapshot["g"]["g2"]
+type PolicySnapshot map[string]map[string][][]string
+
+// NewPolicySnapshot copies the policy rules of m
+func NewPolicySnapshot(m model.Model) PolicySnapshot {
+	s := PolicySnapshot{}
+	for _, sec := range snapshotSections {
+		for ptype, ast := range m[sec] {
+			if s[sec] == nil {
+				s[sec] = map[string][][]string{}
+			}
+			rules := make([][]string, 0, len(ast.Policy))
+			for _, rule := range ast.Policy {
+				rules = append(rules, append([]string(nil), rule...))
+			}
+			s[sec][ptype] = rules
+		}
+	}
+	return s
+}
+
+// Apply replaces the policy rules of m with the snapshot. Role links must be
+// rebuilt afterwards, e.g. with the enforcer's BuildRoleLinks. m is left
+// unchanged if the snapshot holds a policy type m doesn't define.
+func (s PolicySnapshot) Apply(m model.Model) error {
+	for sec, ptypes := range s {
+		for ptype := range ptypes {
+			if _, ok := m[sec][ptype]; !ok {
+				return fmt.Errorf("%w: %s.%s", ErrUnknownPolicyType, sec, ptype)
+			}
+		}
+	}
+
+	m.ClearPolicy()
+	for sec, ptypes := range s {
+		for ptype, rules := range ptypes {
+			m[sec][ptype].Policy = rules
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/model"
+)
+
+const rbacModel = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+func newRBACModel() model.Model {
+	m := model.Model{}
+	m.LoadModelFromText(rbacModel)
+	return m
+}
+
+func saveAndReceive(t *testing.T, mode SavePolicyMode, m model.Model) UpdateMessage {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	publisher, err := NewWithOptions(ctx, "fake://save", WithURLMux(broker.mux()), WithSavePolicyMode(mode))
+	if err != nil {
+		t.Fatalf("Failed to create publisher, error: %s", err)
+	}
+	defer publisher.Close()
+	receiver, err := NewWithOptions(ctx, "fake://save", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create receiver, error: %s", err)
+	}
+	defer receiver.Close()
+
+	received := make(chan UpdateMessage, 1)
+	receiver.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		received <- um
+		return nil
+	})
+
+	if err := publisher.UpdateForSavePolicy(m); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	select {
+	case um := <-received:
+		if um.Op != UpdateForSavePolicy {
+			t.Fatalf("Unexpected op: %s", um.Op)
+		}
+		return um
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update wasn't received in time")
+	}
+	return UpdateMessage{}
+}
+
+func TestUpdateForSavePolicySignal(t *testing.T) {
+	m := newRBACModel()
+	m.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+
+	if um := saveAndReceive(t, SavePolicySignal, m); um.Snapshot != nil {
+		t.Fatalf("Signal-only mode sent a snapshot: %v", um.Snapshot)
+	}
+}
+
+func TestUpdateForSavePolicySnapshot(t *testing.T) {
+	m := newRBACModel()
+	m.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	m.AddPolicy("p", "p", []string{"admin", "data2", "write"})
+	m.AddPolicy("g", "g", []string{"bob", "admin"})
+
+	um := saveAndReceive(t, SavePolicySnapshot, m)
+	if um.Snapshot == nil {
+		t.Fatal("Snapshot mode didn't send a snapshot")
+	}
+
+	replica := newRBACModel()
+	replica.AddPolicy("p", "p", []string{"mallory", "data1", "read"})
+	if err := um.Snapshot.Apply(replica); err != nil {
+		t.Fatalf("Failed to apply snapshot, error: %s", err)
+	}
+	for _, key := range [][2]string{{"p", "p"}, {"g", "g"}} {
+		if got, want := replica.GetPolicy(key[0], key[1]), m.GetPolicy(key[0], key[1]); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Unexpected %s rules after applying the snapshot: %v, want %v", key[1], got, want)
+		}
+	}
+}
+
+func TestPolicySnapshotApplyUnknownType(t *testing.T) {
+	m := newRBACModel()
+	m.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+
+	s := PolicySnapshot{"g": {"g2": {{"alice", "admin", "domain1"}}}}
+	if err := s.Apply(m); !errors.Is(err, ErrUnknownPolicyType) {
+		t.Fatalf("Expected ErrUnknownPolicyType, got: %v", err)
+	}
+	if len(m.GetPolicy("p", "p")) != 1 {
+		t.Fatal("A failed Apply must leave the model unchanged")
+	}
+}
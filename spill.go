@@ -0,0 +1,265 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// ErrSpillFull is reported when an update can't be spilled because the
+// WithSpillFile file reached its size limit
+var ErrSpillFull = errors.New("spill file is full")
+
+// WithSpillFile appends the updates the WithAsyncSend sender gives up on
+// after a retryable failure, e.g. while the broker is unreachable, or
+// because the watcher closed before sending them, to the file at path, and
+// publishes them again in the background, retrying with the WithBackoff
+// backoff until the broker accepts them. Updates are synced to disk before
+// being counted in Stats().Spilled, and the file left by a previous run is
+// drained when the watcher opens, so updates survive the outage and a
+// restart; only an update a crash cut short is lost. An update that would
+// grow the file beyond maxBytes is dropped and reported as ErrSpillFull.
+// Spilled updates are published after those sent meanwhile.
+func WithSpillFile(path string, maxBytes int64) Option {
+	return optionFunc(func(o *options) {
+		o.spillPath = path
+		o.spillMax = maxBytes
+	})
+}
+
+// spill guards the spill file. wake is signalled when updates were spilled.
+type spill struct {
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// spilledMessage is a line of the spill file. Queued is when the update was
+// queued, zero in the files of earlier versions.
+type spilledMessage struct {
+	Body     []byte            `json:"body"`
+	Metadata map[string]string `json:"metadata"`
+	Queued   time.Time         `json:"queued,omitempty"`
+}
+
+// startSpillDrainer starts draining the spill file if WithSpillFile is set
+func (w *Watcher) startSpillDrainer() {
+	if w.opts.spillPath == "" {
+		return
+	}
+	w.spill.wake = make(chan struct{}, 1)
+	// leftovers of a previous run are drained right away
+	w.spill.wake <- struct{}{}
+	w.routines.start(w.drainSpill)
+}
+
+// spillMessage appends m, queued at the given time, to the spill file
+func (w *Watcher) spillMessage(m *pubsub.Message, queued time.Time) error {
+	line, err := json.Marshal(spilledMessage{Body: m.Body, Metadata: m.Metadata, Queued: queued})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s := &w.spill
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(w.opts.spillPath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := trimPartialLine(f)
+	if err != nil {
+		return err
+	}
+	if size+int64(len(line)) > w.opts.spillMax {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrSpillFull, size, w.opts.spillMax)
+	}
+	if _, err := f.Write(line); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&w.stats.spilled, 1)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// trimPartialLine cuts the incomplete last line a crash may have left in f,
+// so the next line isn't appended to it, and returns the size of f
+func trimPartialLine(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return 0, nil
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return size, nil
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return 0, err
+	}
+	size = int64(bytes.LastIndexByte(data, '\n') + 1)
+	if err := f.Truncate(size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// drainSpill publishes the spilled updates whenever some were spilled,
+// retrying until the file is empty or the watcher closed
+func (w *Watcher) drainSpill() {
+	for {
+		select {
+		case <-w.spill.wake:
+		case <-w.closedCh:
+			return
+		case <-w.lifecycle().Done():
+			return
+		}
+		for attempt := 1; ; attempt++ {
+			err := w.drainSpillOnce()
+			if err == nil {
+				if attempt > 1 {
+					w.opts.backoff.Reset()
+				}
+				break
+			}
+			backoff := w.opts.backoff.Next(attempt)
+			w.log(LevelWarn, "Failed to publish spilled updates, retrying", "error", err, "attempt", attempt, "backoff", backoff)
+			select {
+			case <-w.closedCh:
+				return
+			case <-w.lifecycle().Done():
+				return
+			case <-time.After(backoff):
+			}
+		}
+	}
+}
+
+// drainSpillOnce publishes the spilled updates in order until one fails
+// with a retryable error, or while closing, which is returned, and removes
+// those sent from the file. Updates failing for good or expired are dropped.
+func (w *Watcher) drainSpillOnce() error {
+	w.spill.mu.Lock()
+	data, err := os.ReadFile(w.opts.spillPath)
+	w.spill.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	consumed, drained := 0, 0
+	var sendErr error
+	for {
+		i := bytes.IndexByte(data[consumed:], '\n')
+		if i < 0 {
+			// an incomplete last line was cut short by a crash
+			break
+		}
+		var sm spilledMessage
+		if err := json.Unmarshal(data[consumed:consumed+i], &sm); err != nil {
+			w.log(LevelError, "Dropping corrupt spilled update", "error", err)
+			consumed += i + 1
+			continue
+		}
+		if w.expireBuffered(sm.Metadata, sm.Queued) {
+			consumed += i + 1
+			continue
+		}
+		if err := w.sendNow(w.lifecycle(), &pubsub.Message{Body: sm.Body, Metadata: sm.Metadata}); err != nil {
+			var se *SendError
+			if errors.As(err, &se) && se.Kind != Fatal {
+				// kept for the next attempt, or the next run if closing
+				sendErr = err
+				break
+			}
+			seq := sm.Metadata[w.metadataKey(metaSequence)]
+			w.log(LevelError, "Dropping spilled update", "error", err, "sequence", seq)
+			w.pushError(fmt.Errorf("failed to send spilled update %s, error: %w", seq, err))
+		} else {
+			drained++
+		}
+		consumed += i + 1
+	}
+	if consumed > 0 {
+		if err := w.truncateSpill(consumed); err != nil {
+			return err
+		}
+	}
+	if drained > 0 {
+		w.log(LevelInfo, "Published spilled updates", "count", drained)
+	}
+	return sendErr
+}
+
+// truncateSpill removes the first n bytes of the spill file, keeping what
+// was spilled since it was read
+func (w *Watcher) truncateSpill(n int) error {
+	w.spill.mu.Lock()
+	defer w.spill.mu.Unlock()
+	data, err := os.ReadFile(w.opts.spillPath)
+	if err != nil {
+		return err
+	}
+	tmp := w.opts.spillPath + ".tmp"
+	if err := writeSynced(tmp, data[n:]); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.opts.spillPath); err != nil {
+		return err
+	}
+	// the rename only survives a power loss once the directory is synced
+	return syncDir(filepath.Dir(w.opts.spillPath))
+}
+
+// writeSynced writes data to the file at path and syncs it to disk
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir syncs the directory at path, making the entries renamed into it
+// durable
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
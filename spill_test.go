@@ -0,0 +1,265 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestSpillFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var outage int32 = 1
+	broker := &fakeBroker{sendErr: func(context.Context, []*driver.Message) error {
+		if atomic.LoadInt32(&outage) == 1 {
+			return errors.New("broker unreachable")
+		}
+		return nil
+	}}
+	path := filepath.Join(t.TempDir(), "spill.jsonl")
+	newSender := func() *Watcher {
+		t.Helper()
+		w, err := NewWithOptions(ctx, "fake://spill", WithURLMux(broker.mux()),
+			WithAsyncSend(10, SendLimitBlock, 1), WithSpillFile(path, 1<<20),
+			WithBackoff(ConstantBackoff(10*time.Millisecond)), WithLogger(NewJSONLogger(&syncBuffer{})))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		return w
+	}
+
+	receiver, err := NewWithOptions(ctx, "fake://spill", WithURLMux(broker.mux()), WithCallbackConcurrency(1))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer receiver.Close()
+	var mu sync.Mutex
+	var received []uint64
+	receiver.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, um.Sequence)
+		return nil
+	})
+
+	// the updates sent during the outage outlive the sender
+	sender := newSender()
+	for i := 0; i < 2; i++ {
+		if err := sender.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+			t.Fatalf("Failed to queue update: %s", err)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return sender.Stats().Spilled == 2 })
+	sender.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the spill file, error: %s", err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 2 {
+		t.Fatalf("Expected 2 spilled updates, got %d", n)
+	}
+
+	// once the broker is back the next sender publishes them
+	atomic.StoreInt32(&outage, 0)
+	sender = newSender()
+	defer sender.Close()
+	waitFor(t, time.Second*5, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	})
+	mu.Lock()
+	if received[0] != 1 || received[1] != 2 {
+		t.Fatalf("Expected the spilled updates 1 and 2, got %v", received)
+	}
+	mu.Unlock()
+	waitFor(t, time.Second*5, func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && len(data) == 0
+	})
+}
+
+func TestSpillFileFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{sendErr: func(context.Context, []*driver.Message) error {
+		return errors.New("broker unreachable")
+	}}
+	w, err := NewWithOptions(ctx, "fake://spill", WithURLMux(broker.mux()),
+		WithAsyncSend(10, SendLimitBlock, 0), WithSpillFile(filepath.Join(t.TempDir(), "spill.jsonl"), 16),
+		WithErrorChannel(4), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to queue update: %s", err)
+	}
+	select {
+	case err := <-w.Errors():
+		if !errors.Is(err, ErrSpillFull) {
+			t.Fatalf("Expected ErrSpillFull, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("No error reported in time")
+	}
+	if n := w.Stats().Spilled; n != 0 {
+		t.Fatalf("Expected nothing spilled, got %d", n)
+	}
+}
+
+func TestSpillFileMaxAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var outage int32 = 1
+	broker := &fakeBroker{sendErr: func(context.Context, []*driver.Message) error {
+		if atomic.LoadInt32(&outage) == 1 {
+			return errors.New("broker unreachable")
+		}
+		return nil
+	}}
+	path := filepath.Join(t.TempDir(), "spill.jsonl")
+	clock := newFakeClock()
+	newSender := func() *Watcher {
+		t.Helper()
+		w, err := NewWithOptions(ctx, "fake://spill-age", WithURLMux(broker.mux()),
+			WithAsyncSend(10, SendLimitBlock, 0), WithSpillFile(path, 1<<20), WithMaxBufferedUpdateAge(time.Hour),
+			WithClock(clock), WithBackoff(ConstantBackoff(10*time.Millisecond)), WithLogger(NewJSONLogger(&syncBuffer{})))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		return w
+	}
+
+	receiver, err := NewWithOptions(ctx, "fake://spill-age", WithURLMux(broker.mux()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer receiver.Close()
+	received := make(chan UpdateMessage, 10)
+	receiver.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		received <- um
+		return nil
+	})
+
+	// the first update is spilled two hours before the outage ends, the
+	// second half an hour before
+	sender := newSender()
+	for i, user := range []string{"alice", "bob"} {
+		if err := sender.UpdateForAddPolicy("p", "p", user, "data1", "read"); err != nil {
+			t.Fatalf("Failed to queue update: %s", err)
+		}
+		waitFor(t, time.Second*5, func() bool { return sender.Stats().Spilled == uint64(i+1) })
+		clock.advance([]time.Duration{90 * time.Minute, 30 * time.Minute}[i])
+	}
+	sender.Close()
+
+	// the first sender may expire the old update itself while retrying
+	first := sender
+	atomic.StoreInt32(&outage, 0)
+	sender = newSender()
+	defer sender.Close()
+	select {
+	case um := <-received:
+		if um.Params[0] != "bob" {
+			t.Fatalf("Expected only the recent update to be published, got %v", um.Params)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The recent spilled update wasn't published")
+	}
+	if n := first.Stats().Expired + sender.Stats().Expired; n != 1 {
+		t.Fatalf("Expected 1 expired update, got %d", n)
+	}
+	waitFor(t, time.Second*5, func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && len(data) == 0
+	})
+	select {
+	case um := <-received:
+		t.Fatalf("Expected the old update to be discarded, got %v", um.Params)
+	default:
+	}
+}
+
+func TestSpillFileAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.jsonl")
+	w := newWatcher("fake://spill", buildOptions(WithSpillFile(path, 1<<20)))
+	if err := w.spillMessage(w.newMessage([]byte("first"), Update), time.Now()); err != nil {
+		t.Fatalf("Failed to spill update: %s", err)
+	}
+
+	// a crash cut the next update short
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"body":"cut`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := w.spillMessage(w.newMessage([]byte("second"), Update), time.Now()); err != nil {
+		t.Fatalf("Failed to spill update: %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Expected the 2 complete updates, got %q", data)
+	}
+	for i, want := range []string{"first", "second"} {
+		var sm spilledMessage
+		if err := json.Unmarshal(lines[i], &sm); err != nil || string(sm.Body) != want {
+			t.Fatalf("Line %d is %q, error: %v", i+1, lines[i], err)
+		}
+	}
+}
+
+func TestSpillFileOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{sendErr: func(context.Context, []*driver.Message) error {
+		return errors.New("broker unreachable")
+	}}
+	path := filepath.Join(t.TempDir(), "spill.jsonl")
+	w, err := NewWithOptions(ctx, "fake://spill-close", WithURLMux(broker.mux()),
+		WithAsyncSend(10, SendLimitBlock, 100), WithSpillFile(path, 1<<20),
+		WithBackoff(ConstantBackoff(time.Hour)), WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+			t.Fatalf("Failed to queue update: %s", err)
+		}
+	}
+
+	// the update backing off and those still queued are spilled, not dropped
+	closeCtx, closeCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer closeCancel()
+	_ = w.CloseContext(closeCtx)
+	waitFor(t, time.Second*5, func() bool { return w.Stats().Spilled == 3 })
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the spill file, error: %s", err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 3 {
+		t.Fatalf("Expected 3 spilled updates, got %d", n)
+	}
+}
@@ -0,0 +1,69 @@
+package watcher
+
+import "sync/atomic"
+
+// Stats are the watcher's counters since it was created
+type Stats struct {
+	// Shed is the number of updates dropped to stay within WithMemoryBudget.
+	Shed uint64 `json:"shed"`
+	// CallbackErrors is the number of callbacks that returned an error or
+	// panicked.
+	CallbackErrors uint64 `json:"callbackErrors"`
+	// ApplyLatency is the time callbacks took to apply updates, excluding
+	// the time spent waiting for a WithCallbackConcurrency slot or the
+	// WithApplyLock lock.
+	ApplyLatency LatencySummary `json:"applyLatency"`
+	// Reconnects is the number of times the subscription failed and the
+	// watcher started reconnecting.
+	Reconnects uint64 `json:"reconnects"`
+	// ReconnectDuration is the time successful reconnects took, from the
+	// failure to the new subscription, backoff included.
+	ReconnectDuration LatencySummary `json:"reconnectDuration"`
+	// ReceiveRefreshes is the number of times the subscription was
+	// replaced, see WithReceiveRefresh.
+	ReceiveRefreshes uint64 `json:"receiveRefreshes"`
+	// Unacked is the number of messages currently received but not acked
+	// or nacked yet, see WithMaxUnacked.
+	Unacked int `json:"unacked"`
+	// Gaps is the number of times updates went missing and the policy was
+	// reloaded, see WithGapResync.
+	Gaps uint64 `json:"gaps"`
+	// Spilled is the number of updates written to the spill file, see
+	// WithSpillFile.
+	Spilled uint64 `json:"spilled"`
+	// Expired is the number of buffered updates discarded for being too
+	// old, see WithMaxBufferedUpdateAge.
+	Expired uint64 `json:"expired"`
+	// Unchanged is the number of reloads skipped because the policy was
+	// unchanged, see WithSkipUnchangedReloads.
+	Unchanged uint64 `json:"unchanged"`
+}
+
+// counters back Stats and are updated atomically
+type counters struct {
+	shed             uint64
+	callbackErrors   uint64
+	reconnects       uint64
+	receiveRefreshes uint64
+	gaps             uint64
+	spilled          uint64
+	expired          uint64
+	unchanged        uint64
+}
+
+// Stats returns a snapshot of the watcher's counters
+func (w *Watcher) Stats() Stats {
+	return Stats{
+		Shed:              atomic.LoadUint64(&w.stats.shed),
+		CallbackErrors:    atomic.LoadUint64(&w.stats.callbackErrors),
+		ApplyLatency:      w.applyLatency.summary(),
+		Reconnects:        atomic.LoadUint64(&w.stats.reconnects),
+		ReconnectDuration: w.reconnectDuration.summary(),
+		ReceiveRefreshes:  atomic.LoadUint64(&w.stats.receiveRefreshes),
+		Unacked:           w.unacked.count(),
+		Gaps:              atomic.LoadUint64(&w.stats.gaps),
+		Spilled:           atomic.LoadUint64(&w.stats.spilled),
+		Expired:           atomic.LoadUint64(&w.stats.expired),
+		Unchanged:         atomic.LoadUint64(&w.stats.unchanged),
+	}
+}
@@ -0,0 +1,60 @@
+package watcher
+
+import "time"
+
+// DefaultStatsInterval is the StatsStream interval used when the one given
+// is zero or less
+const DefaultStatsInterval = time.Second
+
+// StatsStream returns a channel receiving a Stats snapshot right away, then
+// every interval of the WithClock clock, DefaultStatsInterval if it's zero or
+// less, and whenever the watcher connects or loses its connection, e.g. for
+// a live dashboard. A snapshot the reader didn't take before the next one is
+// replaced by it. The channel is closed by Close.
+func (w *Watcher) StatsStream(interval time.Duration) <-chan Stats {
+	if interval <= 0 {
+		interval = DefaultStatsInterval
+	}
+	ch := make(chan Stats, 1)
+	if w.isClosed() {
+		close(ch)
+		return ch
+	}
+	w.routines.start(func() {
+		defer close(ch)
+		for {
+			tick := make(chan struct{})
+			timer := w.opts.clock.AfterFunc(interval, func() { close(tick) })
+			w.state.mu.Lock()
+			changed := w.state.changed
+			w.state.mu.Unlock()
+
+			emitStats(ch, w.Stats())
+
+			select {
+			case <-tick:
+			case <-changed:
+				timer.Stop()
+			case <-w.closedCh:
+				timer.Stop()
+				return
+			}
+		}
+	})
+	return ch
+}
+
+// emitStats puts s on ch, replacing the snapshot not read yet. ch must have
+// a buffer of one and a single writer.
+func emitStats(ch chan Stats, s Stats) {
+	select {
+	case ch <- s:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- s
+}
@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatsStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	clock := newFakeClock()
+	w, err := NewWithOptions(ctx, "fake://stats-stream", WithURLMux(broker.mux()), WithClock(clock),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	stream := w.StatsStream(time.Second)
+	next := func() Stats {
+		t.Helper()
+		select {
+		case s, ok := <-stream:
+			if !ok {
+				t.Fatal("The stream was closed early")
+			}
+			return s
+		case <-time.After(time.Second * 5):
+			t.Fatal("No snapshot arrived in time")
+		}
+		return Stats{}
+	}
+	next()
+
+	// snapshots follow the clock
+	select {
+	case <-stream:
+		t.Fatal("A snapshot arrived before the interval elapsed")
+	case <-time.After(time.Millisecond * 50):
+	}
+	clock.advance(time.Second)
+	next()
+
+	// and connection changes, without waiting for the interval
+	broker.subscriptions()[0].fail(errors.New("connection reset"))
+	for next().Reconnects != 1 {
+	}
+
+	w.Close()
+	waitFor(t, time.Second*5, func() bool {
+		select {
+		case _, ok := <-stream:
+			return !ok
+		default:
+			return false
+		}
+	})
+}
+
+func TestStatsStreamDefaultInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	w, err := NewWithOptions(ctx, "fake://stats-stream", WithURLMux((&fakeBroker{}).mux()), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	// a zero interval takes the default rather than spinning
+	stream := w.StatsStream(0)
+	<-stream
+	select {
+	case <-stream:
+		t.Fatal("A snapshot arrived before the default interval elapsed")
+	case <-time.After(time.Millisecond * 50):
+	}
+	clock.advance(DefaultStatsInterval)
+	select {
+	case <-stream:
+	case <-time.After(time.Second * 5):
+		t.Fatal("No snapshot arrived after the default interval")
+	}
+}
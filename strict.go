@@ -0,0 +1,56 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"gocloud.dev/pubsub"
+)
+
+var (
+	// ErrTopicMismatch is returned in strict binding mode when the
+	// subscription receives messages from a different topic.
+	ErrTopicMismatch = errors.New("subscription is not bound to the configured topic")
+	// ErrBindingUnknown is returned by a BindingVerifier that can't check
+	// the provider behind the subscription.
+	ErrBindingUnknown = errors.New("subscription binding can't be verified for this provider")
+)
+
+// BindingVerifier checks that sub, opened from subURL, receives the messages
+// published to topicURL. It returns an error wrapping ErrTopicMismatch when it
+// doesn't and ErrBindingUnknown when it doesn't support the provider.
+type BindingVerifier func(ctx context.Context, topicURL, subURL string, sub *pubsub.Subscription) error
+
+var (
+	bindingVerifiersMu sync.RWMutex
+	bindingVerifiers   []BindingVerifier
+)
+
+// RegisterBindingVerifier adds v to the verifiers consulted in strict binding
+// mode. Drivers able to inspect subscriptions register one on import.
+func RegisterBindingVerifier(v BindingVerifier) {
+	bindingVerifiersMu.Lock()
+	defer bindingVerifiersMu.Unlock()
+	bindingVerifiers = append(bindingVerifiers, v)
+}
+
+// verifyBinding runs the registered verifiers until one supports the
+// provider. Subscriptions no verifier can check are accepted.
+func (w *Watcher) verifyBinding(ctx context.Context, topicURL, subURL string, receiver subscriptionReceiver) error {
+	sub, ok := pubsubSubscription(receiver)
+	if !ok {
+		w.warnCapability("binding", "Can't verify the binding of a subscription not opened through gocloud.dev, accepting it")
+		return nil
+	}
+	bindingVerifiersMu.RLock()
+	defer bindingVerifiersMu.RUnlock()
+	for _, v := range bindingVerifiers {
+		err := v(ctx, topicURL, subURL, sub)
+		if errors.Is(err, ErrBindingUnknown) {
+			continue
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gocloud.dev/pubsub"
+)
+
+// fakeBinding is exposed through As by fake subscriptions bound to a topic
+type fakeBinding struct {
+	topicURL string
+}
+
+func init() {
+	RegisterBindingVerifier(func(ctx context.Context, topicURL, subURL string, sub *pubsub.Subscription) error {
+		var b fakeBinding
+		if !sub.As(&b) {
+			return ErrBindingUnknown
+		}
+		if b.topicURL != topicURL {
+			return fmt.Errorf("%w: bound to %s", ErrTopicMismatch, b.topicURL)
+		}
+		return nil
+	})
+}
+
+func bindTo(topicURL string) func(i interface{}) bool {
+	return func(i interface{}) bool {
+		b, ok := i.(*fakeBinding)
+		if ok {
+			b.topicURL = topicURL
+		}
+		return ok
+	}
+}
+
+func TestStrictBinding(t *testing.T) {
+	ctx := context.Background()
+
+	matching := &fakeBroker{subAs: bindTo("fake://policy")}
+	w, err := NewWithOptions(ctx, "fake://policy", WithURLMux(matching.mux()), WithStrictBinding())
+	if err != nil {
+		t.Fatalf("Strict mode rejected a matching binding: %s", err)
+	}
+	w.Close()
+
+	mismatched := &fakeBroker{subAs: bindTo("fake://other")}
+	w, err = NewWithOptions(ctx, "fake://policy", WithURLMux(mismatched.mux()), WithStrictBinding())
+	if !errors.Is(err, ErrTopicMismatch) {
+		t.Fatalf("Expected ErrTopicMismatch, got: %v", err)
+	}
+	if w.Connected() {
+		t.Fatal("A watcher failing verification must not be connected")
+	}
+	w.Close()
+
+	// without strict mode the binding isn't checked
+	w, err = NewWithOptions(ctx, "fake://policy", WithURLMux(mismatched.mux()))
+	if err != nil {
+		t.Fatalf("Binding was checked outside strict mode: %s", err)
+	}
+	w.Close()
+
+	// providers no verifier understands are accepted
+	unknown := &fakeBroker{}
+	w, err = NewWithOptions(ctx, "fake://policy", WithURLMux(unknown.mux()), WithStrictBinding())
+	if err != nil {
+		t.Fatalf("Strict mode rejected an unverifiable provider: %s", err)
+	}
+	w.Close()
+}
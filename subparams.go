@@ -0,0 +1,62 @@
+package watcher
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// subscriptionParam maps subscription URL schemes to the query parameter
+// their opener reads a setting from
+type subscriptionParam struct {
+	mu   sync.RWMutex
+	keys map[string]string
+	// unsupported is wrapped when a value is set for an unregistered scheme
+	unsupported error
+}
+
+func (p *subscriptionParam) register(scheme, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys == nil {
+		p.keys = map[string]string{}
+	}
+	p.keys[scheme] = key
+}
+
+// set adds value to u under the key registered for its scheme. An empty value
+// leaves u unchanged.
+func (p *subscriptionParam) set(u *url.URL, value string) error {
+	if value == "" {
+		return nil
+	}
+	p.mu.RLock()
+	key, ok := p.keys[u.Scheme]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", p.unsupported, u.Scheme)
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return nil
+}
+
+// subscriptionURL is the subscription URL with the filter and consumer name
+// added
+func (w *Watcher) subscriptionURL() (string, error) {
+	if w.opts.subscriptionFilter == "" && w.opts.consumerName == "" {
+		return w.subURL, nil
+	}
+	u, err := url.Parse(w.subURL)
+	if err != nil {
+		return "", err
+	}
+	if err := filterParam.set(u, w.opts.subscriptionFilter); err != nil {
+		return "", err
+	}
+	if err := consumerParam.set(u, w.opts.consumerName); err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
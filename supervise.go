@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrReceiveCrashLoop is matched by the error sent on the Errors channel
+// when the subscription keeps failing, see WithReconnectSupervision
+var ErrReceiveCrashLoop = errors.New("subscription keeps failing")
+
+// WithReconnectSupervision makes reconnects remember their backoff: a
+// subscription failing again within healthyAfter of reconnecting resumes
+// from the backoff the last reconnect ended with instead of starting over,
+// while one that stayed up for healthyAfter starts from the minimum again.
+// When the subscription fails more than maxRestarts times within window,
+// an error matching ErrReceiveCrashLoop is logged and sent on the Errors
+// channel, once per streak. By default every reconnect starts from the
+// minimum backoff and failures aren't counted.
+func WithReconnectSupervision(healthyAfter time.Duration, maxRestarts int, window time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.healthyAfter = healthyAfter
+		o.maxRestarts = maxRestarts
+		o.restartWindow = window
+	})
+}
+
+// supervisor is the reconnect state kept by WithReconnectSupervision
+type supervisor struct {
+	mu sync.Mutex
+	// step is the backoff attempt the last reconnect succeeded at, at
+	// connectedAt, after waiting backoff
+	step        int
+	backoff     time.Duration
+	connectedAt time.Time
+	// restarts holds the failure times within the window
+	restarts  []time.Time
+	escalated bool
+}
+
+// restartAttempt records a subscription failure and returns the backoff
+// attempt to reconnect from
+func (w *Watcher) restartAttempt() int {
+	if w.opts.healthyAfter <= 0 && w.opts.maxRestarts <= 0 {
+		return 1
+	}
+	s := &w.supervisor
+	now := w.opts.clock.Now()
+	s.mu.Lock()
+	step := 1
+	if s.step > 0 && now.Sub(s.connectedAt) < w.opts.healthyAfter {
+		step = s.step + 1
+	}
+	var crashLoop error
+	if w.opts.maxRestarts > 0 {
+		kept := s.restarts[:0]
+		for _, t := range s.restarts {
+			if now.Sub(t) < w.opts.restartWindow {
+				kept = append(kept, t)
+			}
+		}
+		s.restarts = append(kept, now)
+		if len(s.restarts) <= w.opts.maxRestarts {
+			s.escalated = false
+		} else if !s.escalated {
+			s.escalated = true
+			crashLoop = fmt.Errorf("%w: %d failures within %s", ErrReceiveCrashLoop, len(s.restarts), w.opts.restartWindow)
+		}
+	}
+	s.mu.Unlock()
+
+	if crashLoop != nil {
+		w.log(LevelError, "Subscription is crash-looping", "error", crashLoop)
+		w.pushError(crashLoop)
+	}
+	return step
+}
+
+// reconnected records that a reconnect succeeded at the backoff attempt
+// step, after waiting backoff
+func (w *Watcher) reconnected(step int, backoff time.Duration) {
+	s := &w.supervisor
+	s.mu.Lock()
+	s.step, s.backoff, s.connectedAt = step, backoff, w.opts.clock.Now()
+	s.mu.Unlock()
+}
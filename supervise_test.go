@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectSupervision(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failOpens int32
+	broker := &fakeBroker{
+		openSubErr: func(*url.URL) error {
+			if atomic.AddInt32(&failOpens, -1) >= 0 {
+				return errors.New("broker unavailable")
+			}
+			return nil
+		},
+	}
+	clock := newFakeClock()
+	w, err := NewWithOptions(ctx, "fake://supervise", WithURLMux(broker.mux()), WithClock(clock),
+		WithReconnectSupervision(time.Minute, 1, time.Minute), WithErrorChannel(4),
+		WithBackoff(ExponentialBackoff{Min: reconnectMinBackoff, Max: reconnectMaxBackoff}),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	lastBackoff := func() time.Duration {
+		w.supervisor.mu.Lock()
+		defer w.supervisor.mu.Unlock()
+		return w.supervisor.backoff
+	}
+	failAndReconnect := func(opens int32, wantBackoff time.Duration) {
+		t.Helper()
+		atomic.StoreInt32(&failOpens, opens)
+		failed := broker.subscriptions()[0]
+		failed.fail(errors.New("connection reset"))
+		waitFor(t, time.Second*5, func() bool {
+			subs := broker.subscriptions()
+			return len(subs) == 1 && subs[0] != failed && w.Connected()
+		})
+		if got := lastBackoff(); got != wantBackoff {
+			t.Fatalf("Expected to reconnect after a %s backoff, got %s", wantBackoff, got)
+		}
+	}
+	expectCrashLoop := func(want bool) {
+		t.Helper()
+		select {
+		case err := <-w.Errors():
+			if !want || !errors.Is(err, ErrReceiveCrashLoop) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		default:
+			if want {
+				t.Fatal("The crash loop wasn't reported")
+			}
+		}
+	}
+
+	failAndReconnect(1, reconnectMinBackoff*2)
+	expectCrashLoop(false)
+
+	// failing again right after reconnecting resumes the backoff and is
+	// one failure too many
+	failAndReconnect(0, reconnectMinBackoff*4)
+	expectCrashLoop(true)
+
+	// after a healthy interval the backoff starts over
+	clock.advance(time.Minute)
+	failAndReconnect(0, reconnectMinBackoff)
+	expectCrashLoop(false)
+}
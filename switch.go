@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+)
+
+// SwitchTopic publishes to newTopicURL from now on while the subscription and
+// its receive loop are left untouched, e.g. after the topic was renamed.
+// Updates being sent when it's called complete on the old topic, which is
+// then shut down so its pending sends are flushed; later sends, including
+// WithAsyncSend retries, go to the new one. With WithStrictBinding the
+// subscription must be bound to the new topic.
+func (w *Watcher) SwitchTopic(ctx context.Context, newTopicURL string) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	topic, err := w.transport().openTopic(ctx, newTopicURL)
+	if err != nil {
+		return fmt.Errorf("failed to open topic %s, error: %w", newTopicURL, err)
+	}
+	if w.opts.strictBinding {
+		w.connMu.RLock()
+		sub := w.sub
+		w.connMu.RUnlock()
+		if sub != nil {
+			if err := w.verifyBinding(ctx, newTopicURL, w.subURL, sub); err != nil {
+				_ = topic.Shutdown(ctx)
+				return err
+			}
+		}
+	}
+
+	// taking the write lock waits for the sends holding the read lock
+	w.connMu.Lock()
+	if w.closed {
+		w.connMu.Unlock()
+		_ = topic.Shutdown(ctx)
+		return ErrClosed
+	}
+	old := w.topic
+	w.topic, w.topicURL = topic, newTopicURL
+	w.connMu.Unlock()
+	w.log(LevelInfo, "Switched topic", "topic", newTopicURL)
+
+	if old != nil {
+		if err := old.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shut down the previous topic, error: %w", err)
+		}
+	}
+	return nil
+}
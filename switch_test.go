@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+func TestSwitchTopic(t *testing.T) {
+	ctx := context.Background()
+
+	var sentOld, sentNew int32
+	counter := func(n *int32) func(context.Context, []*driver.Message) error {
+		return func(_ context.Context, ms []*driver.Message) error {
+			atomic.AddInt32(n, int32(len(ms)))
+			return nil
+		}
+	}
+	old := &fakeBroker{sendErr: counter(&sentOld)}
+	renamed := &fakeBroker{sendErr: counter(&sentNew)}
+	mux := old.mux()
+	mux.RegisterTopic("renamed", renamed)
+
+	w, err := NewWithOptions(ctx, "fake://topic", WithURLMux(mux))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	peer, err := NewWithOptions(ctx, "fake://topic", WithURLMux(mux))
+	if err != nil {
+		t.Fatalf("Failed to create peer, error: %s", err)
+	}
+	defer peer.Close()
+
+	var received int32
+	w.SetUpdateCallback(func(string) { atomic.AddInt32(&received, 1) })
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&received) == 1 })
+	if err := w.SwitchTopic(ctx, "renamed://topic"); err != nil {
+		t.Fatalf("Failed to switch topic: %s", err)
+	}
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update after the switch: %s", err)
+	}
+	if o, n := atomic.LoadInt32(&sentOld), atomic.LoadInt32(&sentNew); o != 1 || n != 1 {
+		t.Fatalf("Expected one update on each topic, got %d on the old and %d on the new", o, n)
+	}
+
+	// the subscription kept receiving from the original topic
+	if err := peer.Update(); err != nil {
+		t.Fatalf("Failed to send update from peer: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&received) == 2 })
+	if n := len(old.subscriptions()); n != 2 {
+		t.Fatalf("Expected the subscriptions to be left alone, %d open", n)
+	}
+	if !w.Connected() {
+		t.Fatal("Watcher disconnected by the topic switch")
+	}
+
+	w.Close()
+	if err := w.SwitchTopic(ctx, "renamed://other"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed after Close, got: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"context"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithTenant tags every update this watcher sends, including Update and the
+// UpdateFor* methods, with tenant, e.g. the domain or model of its enforcer,
+// and makes it ignore the updates of other tenants. Untagged updates, sent
+// by watchers without a tenant, still reach every watcher. Use
+// WithTenantFilter to receive the updates of other tenants as well.
+func WithTenant(tenant string) Option {
+	return optionFunc(func(o *options) {
+		o.tenant = tenant
+	})
+}
+
+// WithTenantFilter makes the watcher receive the updates of the given
+// tenants, and untagged ones, instead of only those of its own tenant. With
+// no tenants every update is received, whatever its tenant.
+func WithTenantFilter(tenants ...string) Option {
+	return optionFunc(func(o *options) {
+		o.tenantFilter = make(map[string]bool, len(tenants))
+		for _, tenant := range tenants {
+			o.tenantFilter[tenant] = true
+		}
+	})
+}
+
+// UpdateForTenant is like UpdateContext but tags the update with tenant
+// rather than the tenant set with WithTenant, so only the watchers of that
+// tenant reload.
+func (w *Watcher) UpdateForTenant(ctx context.Context, tenant string) error {
+	m := w.newMessage(w.reloadSignal(), Update)
+	m.Metadata[w.metadataKey(metaTenant)] = tenant
+	return w.broadcast(ctx, m)
+}
+
+// isOtherTenant reports whether msg is tagged with a tenant this watcher
+// doesn't receive updates for
+func (w *Watcher) isOtherTenant(msg *pubsub.Message) bool {
+	tenant, ok := msg.Metadata[w.metadataKey(metaTenant)]
+	if !ok {
+		return false
+	}
+	if w.opts.tenantFilter != nil {
+		return len(w.opts.tenantFilter) > 0 && !w.opts.tenantFilter[tenant]
+	}
+	return w.opts.tenant != "" && tenant != w.opts.tenant
+}
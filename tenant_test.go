@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTenantFiltering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	received := make(chan string, 8)
+	newNode := func(id string, opts ...Option) *Watcher {
+		opts = append(opts, WithURLMux(broker.mux()), WithInstanceID(id))
+		w, err := NewWithOptions(ctx, "fake://tenants", opts...)
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		t.Cleanup(w.Close)
+		w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+			if um.Origin != id {
+				received <- id + ":" + um.Tenant
+			}
+			return nil
+		})
+		return w
+	}
+	tenantA := newNode("a", WithTenant("tenant-a"))
+	newNode("b", WithTenant("tenant-b"))
+	newNode("all", WithTenantFilter())
+	untagged := newNode("untagged")
+
+	expect := func(want ...string) {
+		t.Helper()
+		seen := map[string]bool{}
+		for range want {
+			select {
+			case got := <-received:
+				seen[got] = true
+			case <-time.After(time.Second * 5):
+				t.Fatalf("Expected deliveries %v, got %v", want, seen)
+			}
+		}
+		for _, w := range want {
+			if !seen[w] {
+				t.Fatalf("Expected deliveries %v, got %v", want, seen)
+			}
+		}
+		select {
+		case got := <-received:
+			t.Fatalf("Unexpected delivery %s", got)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	// a tenant-A update doesn't reach the tenant-B watcher
+	if err := tenantA.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expect("all:tenant-a", "untagged:tenant-a")
+
+	if err := untagged.UpdateForTenant(ctx, "tenant-b"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expect("b:tenant-b", "all:tenant-b")
+
+	// untagged updates reach every tenant
+	if err := untagged.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expect("a:", "b:", "all:")
+}
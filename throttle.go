@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// WithMinReloadInterval starts the SetUpdateCallback callback at most once
+// every d. The first update after a quiet interval reloads right away; the
+// updates arriving within d of it collapse into a single trailing reload
+// with the last body received, run once d has elapsed, so no change is
+// missed. Zero, the default, doesn't throttle reloads. Unlike
+// WithMaxConcurrentSends it applies on the receiving side. It can be
+// changed with Reconfigure.
+func WithMinReloadInterval(d time.Duration) Option {
+	return runtimeOption(func(t *tunables) {
+		t.minReloadInterval = d
+	})
+}
+
+// throttler lets a reload start at most once per interval. The done funcs of
+// every collapsed trigger are called once the trailing reload completes, or
+// with errNotRun if the watcher is closed first.
+// The trailing body counts towards the memory budget; if it is shed the
+// collapsed triggers complete with errNotRun.
+type throttler struct {
+	clock    Clock
+	budget   *memoryBudget
+	routines *goroutineTracker
+	mu       sync.Mutex
+	// last is when the last reload started, if fired
+	last  time.Time
+	fired bool
+	timer Timer
+	// gen identifies the current timer so a stopped one that fires anyway
+	// does nothing
+	gen     uint64
+	pending *throttledReload
+	stopped bool
+}
+
+type throttledReload struct {
+	body  string
+	fire  func(string, func(error))
+	entry *budgetEntry
+	done  []func(error)
+}
+
+// wrap returns fire throttled to once per interval
+func (t *throttler) wrap(interval time.Duration, fire func(string, func(error))) func(string, func(error)) {
+	return func(body string, done func(error)) {
+		t.trigger(interval, body, fire, done)
+	}
+}
+
+func (t *throttler) trigger(interval time.Duration, body string, fire func(string, func(error)), done func(error)) {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		done(errNotRun)
+		return
+	}
+	now := t.clock.Now()
+	if t.timer == nil && (!t.fired || now.Sub(t.last) >= interval) {
+		t.last, t.fired = now, true
+		t.mu.Unlock()
+		fire(body, done)
+		return
+	}
+
+	p := t.pending
+	if p == nil {
+		p = &throttledReload{}
+		t.pending = p
+	}
+	t.budget.release(p.entry)
+	var entry *budgetEntry
+	entry, evict := t.budget.hold(int64(len(body)), func() { t.shed(entry) })
+	p.body, p.fire, p.entry = body, fire, entry
+	p.done = append(p.done, done)
+	if t.timer == nil {
+		t.routines.add(1)
+		t.gen++
+		gen := t.gen
+		t.timer = t.clock.AfterFunc(t.last.Add(interval).Sub(now), func() { t.fireTrailing(gen) })
+	}
+	t.mu.Unlock()
+	evict()
+}
+
+// fireTrailing runs the trailing reload collapsed while the timer gen was
+// pending
+func (t *throttler) fireTrailing(gen uint64) {
+	t.mu.Lock()
+	if t.gen != gen || t.timer == nil {
+		t.mu.Unlock()
+		return
+	}
+	t.timer = nil
+	t.routines.add(-1)
+	p := t.pending
+	t.pending = nil
+	if p == nil {
+		// the trailing body was shed
+		t.mu.Unlock()
+		return
+	}
+	t.budget.release(p.entry)
+	t.last = t.clock.Now()
+	t.mu.Unlock()
+	p.fire(p.body, func(err error) {
+		for _, fn := range p.done {
+			fn(err)
+		}
+	})
+}
+
+// shed drops the trailing body if entry still holds it
+func (t *throttler) shed(entry *budgetEntry) {
+	t.mu.Lock()
+	p := t.pending
+	if p == nil || p.entry != entry {
+		t.mu.Unlock()
+		return
+	}
+	t.pending = nil
+	t.mu.Unlock()
+	for _, fn := range p.done {
+		fn(errNotRun)
+	}
+}
+
+func (t *throttler) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+		t.routines.add(-1)
+	}
+	p := t.pending
+	t.pending = nil
+	if p != nil {
+		t.budget.release(p.entry)
+	}
+	t.mu.Unlock()
+	if p != nil {
+		for _, fn := range p.done {
+			fn(errNotRun)
+		}
+	}
+}
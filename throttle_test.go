@@ -0,0 +1,54 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMinReloadInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://throttle", WithURLMux((&fakeBroker{}).mux()),
+		WithClock(clock), WithMinReloadInterval(time.Minute), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls int32
+	w.SetUpdateCallback(func(string) { atomic.AddInt32(&calls, 1) })
+
+	// the first update reloads right away, the others within the interval
+	// wait for its end
+	for i := 0; i < 6; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 6 updates were received", i)
+		}
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 1 })
+	time.Sleep(time.Millisecond * 100)
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("Expected a single reload within the interval, got %d", got)
+	}
+
+	clock.advance(time.Minute)
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 2 })
+	clock.advance(time.Minute)
+	time.Sleep(time.Millisecond * 100)
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("Expected one trailing reload at the end of the interval, got %d", got-1)
+	}
+	waitFor(t, time.Second*5, func() bool { return w.GoroutineCount() == 1 })
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
+	"gocloud.dev/pubsub"
+)
+
+// Span names and attributes recorded with WithTracing
+const (
+	SpanPublish = "casbin-watcher.Publish"
+	SpanReceive = "casbin-watcher.Receive"
+	// AttributeNoUpstreamContext is set to true on receive spans started as
+	// a new root because the message carried no usable trace context, e.g.
+	// when it was published by an older watcher or without tracing.
+	AttributeNoUpstreamContext = "casbin.no_upstream_context"
+	AttributeOutcome           = "casbin.outcome"
+)
+
+// WithTracing records an OpenCensus span for every update published and
+// received, sampled with sampler or the globally configured sampler if
+// nil. The trace context of the publish span travels in the message
+// metadata so the receive spans of the other watchers join the same trace.
+func WithTracing(sampler trace.Sampler) Option {
+	return optionFunc(func(o *options) {
+		o.tracing = true
+		o.traceSampler = sampler
+	})
+}
+
+func (w *Watcher) spanOptions(kind int) []trace.StartOption {
+	opts := []trace.StartOption{trace.WithSpanKind(kind)}
+	if w.opts.traceSampler != nil {
+		opts = append(opts, trace.WithSampler(w.opts.traceSampler))
+	}
+	return opts
+}
+
+// startPublishSpan starts the span of sending m, a child of the span in
+// ctx if any, and stamps its trace context into the metadata of m. It
+// returns a nil span when tracing is disabled.
+func (w *Watcher) startPublishSpan(ctx context.Context, m *pubsub.Message) (context.Context, *trace.Span) {
+	if !w.opts.tracing {
+		return ctx, nil
+	}
+	ctx, span := trace.StartSpan(ctx, SpanPublish, w.spanOptions(trace.SpanKindClient)...)
+	m.Metadata[w.metadataKey(metaTrace)] = base64.StdEncoding.EncodeToString(propagation.Binary(span.SpanContext()))
+	return ctx, span
+}
+
+// startReceiveSpan starts the span of processing msg. Messages without a
+// valid trace context start a new root span marked with
+// AttributeNoUpstreamContext. It returns nil when tracing is disabled.
+func (w *Watcher) startReceiveSpan(ctx context.Context, msg *pubsub.Message) *trace.Span {
+	if !w.opts.tracing {
+		return nil
+	}
+	opts := w.spanOptions(trace.SpanKindServer)
+	if parent, ok := w.upstreamContext(msg); ok {
+		_, span := trace.StartSpanWithRemoteParent(ctx, SpanReceive, parent, opts...)
+		return span
+	}
+	// the receive loop context carries no span, so this is a root span
+	_, span := trace.StartSpan(ctx, SpanReceive, opts...)
+	span.AddAttributes(trace.BoolAttribute(AttributeNoUpstreamContext, true))
+	return span
+}
+
+// upstreamContext decodes the trace context stamped by the publisher
+func (w *Watcher) upstreamContext(msg *pubsub.Message) (trace.SpanContext, bool) {
+	encoded, ok := msg.Metadata[w.metadataKey(metaTrace)]
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		w.log(LevelDebug, "Ignoring undecodable trace context", "error", err, "id", msg.LoggableID)
+		return trace.SpanContext{}, false
+	}
+	sc, ok := propagation.FromBinary(b)
+	if !ok {
+		w.log(LevelDebug, "Ignoring invalid trace context", "id", msg.LoggableID)
+	}
+	return sc, ok
+}
@@ -0,0 +1,117 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opencensus.io/trace"
+	"gocloud.dev/pubsub"
+)
+
+// spanRecorder is a trace exporter keeping the watcher spans
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(s *trace.SpanData) {
+	if s.Name != SpanPublish && s.Name != SpanReceive {
+		return
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+}
+
+func (r *spanRecorder) named(name string) []*trace.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var spans []*trace.SpanData
+	for _, s := range r.spans {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTracingWithoutUpstreamContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rec := &spanRecorder{}
+	trace.RegisterExporter(rec)
+	defer trace.UnregisterExporter(rec)
+
+	broker := &fakeBroker{}
+	w, err := NewWithOptions(ctx, "fake://tracing", WithURLMux(broker.mux()), WithTracing(trace.AlwaysSample()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	topic, err := broker.mux().OpenTopic(ctx, "fake://tracing")
+	if err != nil {
+		t.Fatalf("Failed to open topic, error: %s", err)
+	}
+	defer topic.Shutdown(ctx)
+	// an older watcher sends no metadata at all, a broken one an invalid context
+	for _, m := range []*pubsub.Message{
+		{Body: []byte(legacyUpdateBody)},
+		{Body: []byte(legacyUpdateBody), Metadata: map[string]string{
+			DefaultMetadataPrefix + metaOrigin: "peer",
+			DefaultMetadataPrefix + metaTrace:  "not a trace context",
+		}},
+	} {
+		if err := topic.Send(ctx, m); err != nil {
+			t.Fatalf("Failed to send message, error: %s", err)
+		}
+	}
+
+	waitFor(t, time.Second*5, func() bool { return len(rec.named(SpanReceive)) == 2 })
+	for _, s := range rec.named(SpanReceive) {
+		if !s.SpanContext.IsSampled() || s.TraceID == (trace.TraceID{}) {
+			t.Fatalf("Expected a valid sampled span, got %+v", s.SpanContext)
+		}
+		if s.ParentSpanID != (trace.SpanID{}) || s.HasRemoteParent {
+			t.Fatalf("Expected a root span, got parent %s", s.ParentSpanID)
+		}
+		if s.Attributes[AttributeNoUpstreamContext] != true {
+			t.Fatalf("Expected the root span to be marked, got attributes %v", s.Attributes)
+		}
+		if s.Attributes[AttributeOutcome] != Acked.String() {
+			t.Fatalf("Expected the message to be acked, got attributes %v", s.Attributes)
+		}
+	}
+}
+
+func TestTracingPropagatesContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rec := &spanRecorder{}
+	trace.RegisterExporter(rec)
+	defer trace.UnregisterExporter(rec)
+
+	w, err := NewWithOptions(ctx, "fake://tracing", WithURLMux((&fakeBroker{}).mux()), WithTracing(trace.AlwaysSample()))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	waitFor(t, time.Second*5, func() bool { return len(rec.named(SpanReceive)) == 1 })
+	publish, receive := rec.named(SpanPublish)[0], rec.named(SpanReceive)[0]
+	if receive.TraceID != publish.TraceID || receive.ParentSpanID != publish.SpanID || !receive.HasRemoteParent {
+		t.Fatalf("Expected the receive span to continue the publish span %s, got %+v", publish.SpanID, receive)
+	}
+	if _, ok := receive.Attributes[AttributeNoUpstreamContext]; ok {
+		t.Fatal("A span with an upstream context was marked as without")
+	}
+}
@@ -0,0 +1,102 @@
+package watcher
+
+import (
+	"context"
+
+	"gocloud.dev/pubsub"
+)
+
+// topicSender is what the watcher needs of a topic. *pubsub.Topic is the
+// production implementation; tests may use fakes free of gocloud.dev.
+type topicSender interface {
+	Send(ctx context.Context, m *pubsub.Message) error
+	Shutdown(ctx context.Context) error
+}
+
+// subscriptionReceiver is what the watcher needs of a subscription.
+// *pubsub.Subscription is the production implementation.
+type subscriptionReceiver interface {
+	Receive(ctx context.Context) (*pubsub.Message, error)
+	Shutdown(ctx context.Context) error
+}
+
+// messageSettler is implemented by subscriptionReceivers whose messages
+// weren't received through gocloud.dev and so can't be settled with
+// msg.Ack and msg.Nack, nor inspected with msg.As, which panics on them.
+// Their messages aren't nackable and have no native ID.
+type messageSettler interface {
+	settle(msg *pubsub.Message, ack bool)
+}
+
+// pubsubSubscription returns the gocloud.dev subscription behind sub, for
+// the driver hooks taking one. Receivers wrapping one expose it with a
+// pubsubSubscription method.
+func pubsubSubscription(sub subscriptionReceiver) (*pubsub.Subscription, bool) {
+	switch s := sub.(type) {
+	case *pubsub.Subscription:
+		return s, true
+	case interface{ pubsubSubscription() *pubsub.Subscription }:
+		return s.pubsubSubscription(), true
+	}
+	return nil, false
+}
+
+// pubsubTopic returns the gocloud.dev topic behind topic, like
+// pubsubSubscription
+func pubsubTopic(topic topicSender) (*pubsub.Topic, bool) {
+	switch t := topic.(type) {
+	case *pubsub.Topic:
+		return t, true
+	case interface{ pubsubTopic() *pubsub.Topic }:
+		return t.pubsubTopic(), true
+	}
+	return nil, false
+}
+
+// settleMessage acks or nacks msg, received from sub
+func settleMessage(sub subscriptionReceiver, msg *pubsub.Message, ack bool) {
+	if s, ok := sub.(messageSettler); ok {
+		s.settle(msg, ack)
+	} else if ack {
+		msg.Ack()
+	} else {
+		msg.Nack()
+	}
+}
+
+// transport opens topics and subscriptions by URL
+type transport interface {
+	openTopic(ctx context.Context, url string) (topicSender, error)
+	openSubscription(ctx context.Context, url string) (subscriptionReceiver, error)
+}
+
+// muxTransport is the production transport, opening through a URL mux
+type muxTransport struct {
+	mux *pubsub.URLMux
+}
+
+func (t muxTransport) openTopic(ctx context.Context, url string) (topicSender, error) {
+	topic, err := t.mux.OpenTopic(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+func (t muxTransport) openSubscription(ctx context.Context, url string) (subscriptionReceiver, error) {
+	sub, err := t.mux.OpenSubscription(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// transport returns the transport set by tests, or the WithURLMux one,
+// bounded by the open timeout
+func (w *Watcher) transport() transport {
+	var t transport = muxTransport{mux: w.opts.urlMux}
+	if w.opts.transport != nil {
+		t = w.opts.transport
+	}
+	return boundedTransport{transport: t, timeout: w.opts.openTimeout}
+}
@@ -0,0 +1,255 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+var errPipeClosed = errors.New("pipe subscription shut down")
+
+// pipe is a transport free of gocloud.dev delivering what its topics send to
+// all its subscriptions
+type pipe struct {
+	mu   sync.Mutex
+	subs []*pipeSubscription
+	// sendErr, when set, fails the sends it returns an error for
+	sendErr func() error
+	// shutdown, when set, holds subscription shutdowns until it's closed
+	shutdown chan struct{}
+	sends    int32
+	acked    int32
+	ids      int64
+}
+
+func withPipe(p *pipe) Option {
+	return optionFunc(func(o *options) {
+		o.transport = p
+	})
+}
+
+func (p *pipe) openTopic(context.Context, string) (topicSender, error) {
+	return pipeTopic{p}, nil
+}
+
+func (p *pipe) openSubscription(context.Context, string) (subscriptionReceiver, error) {
+	s := &pipeSubscription{p: p, msgs: make(chan *pubsub.Message, 100), done: make(chan struct{})}
+	p.mu.Lock()
+	p.subs = append(p.subs, s)
+	p.mu.Unlock()
+	return s, nil
+}
+
+// inject delivers a message as if sent by another watcher
+func (p *pipe) inject(body string, metadata map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.subs {
+		md := make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			md[k] = v
+		}
+		id := strconv.FormatInt(atomic.AddInt64(&p.ids, 1), 10)
+		s.msgs <- &pubsub.Message{LoggableID: "pipe-" + id, Body: []byte(body), Metadata: md}
+	}
+}
+
+type pipeTopic struct {
+	p *pipe
+}
+
+func (t pipeTopic) Send(_ context.Context, m *pubsub.Message) error {
+	atomic.AddInt32(&t.p.sends, 1)
+	if t.p.sendErr != nil {
+		if err := t.p.sendErr(); err != nil {
+			return err
+		}
+	}
+	t.p.inject(string(m.Body), m.Metadata)
+	return nil
+}
+
+func (t pipeTopic) Shutdown(context.Context) error { return nil }
+
+type pipeSubscription struct {
+	p    *pipe
+	msgs chan *pubsub.Message
+	once sync.Once
+	done chan struct{}
+}
+
+func (s *pipeSubscription) Receive(ctx context.Context) (*pubsub.Message, error) {
+	select {
+	case msg := <-s.msgs:
+		return msg, nil
+	case <-s.done:
+		return nil, errPipeClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *pipeSubscription) Shutdown(ctx context.Context) error {
+	s.once.Do(func() { close(s.done) })
+	if s.p.shutdown != nil {
+		select {
+		case <-s.p.shutdown:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *pipeSubscription) settle(_ *pubsub.Message, ack bool) {
+	if ack {
+		atomic.AddInt32(&s.p.acked, 1)
+	}
+}
+
+func TestPipeSendRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failures int32
+	p := &pipe{sendErr: func() error {
+		if atomic.AddInt32(&failures, 1) <= 2 {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}}
+	w, err := NewWithOptions(ctx, "pipe://retry", withPipe(p), WithAsyncSend(10, SendLimitBlock, 3),
+		WithBackoff(ExponentialBackoff{Min: time.Millisecond, Max: time.Millisecond * 10}),
+		WithLogger(NewJSONLogger(&syncBuffer{})))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	applied := make(chan UpdateMessage, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		return nil
+	})
+
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to queue update: %s", err)
+	}
+	select {
+	case um := <-applied:
+		if um.Op != UpdateForAddPolicy {
+			t.Fatalf("Expected the added policy, got %s", um.Op)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The update wasn't delivered after the send was retried")
+	}
+	if n := atomic.LoadInt32(&p.sends); n != 3 {
+		t.Fatalf("Expected 3 send attempts, got %d", n)
+	}
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&p.acked) == 1 })
+}
+
+func TestPipeSelfFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := &pipe{}
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "pipe://self", withPipe(p), WithSelfFilter(SelfFilterReloads),
+		WithCallbackConcurrency(1), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+	applied := make(chan UpdateMessage, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um
+		return nil
+	})
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	p.inject(legacyUpdateBody, map[string]string{
+		DefaultMetadataPrefix + metaOrigin:   "peer",
+		DefaultMetadataPrefix + metaSequence: "1",
+		DefaultMetadataPrefix + metaOp:       string(Update),
+	})
+	expectAcks(t, events, ackEvent{1, w.opts.instanceID, Dropped}, ackEvent{2, w.opts.instanceID, Acked},
+		ackEvent{1, "peer", Acked})
+
+	for i, op := range []UpdateType{UpdateForAddPolicy, Update} {
+		select {
+		case um := <-applied:
+			if um.Op != op {
+				t.Fatalf("Expected update %d to be %s, got %s", i+1, op, um.Op)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 2 updates were applied", i)
+		}
+	}
+	if n := atomic.LoadInt32(&p.acked); n != 3 {
+		t.Fatalf("Expected the 3 messages to be acked, got %d", n)
+	}
+}
+
+func TestPipeCoalescedReloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := &pipe{}
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "pipe://coalesce", withPipe(p), WithCoalescedReloads(true), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	var calls int32
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	w.SetUpdateCallback(func(string) {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-release
+	})
+
+	reload := func(seq int) {
+		p.inject(legacyUpdateBody, map[string]string{
+			DefaultMetadataPrefix + metaOrigin:   "peer",
+			DefaultMetadataPrefix + metaSequence: strconv.Itoa(seq),
+			DefaultMetadataPrefix + metaOp:       string(Update),
+		})
+	}
+	reload(1)
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The first update didn't start a reload")
+	}
+	for seq := 2; seq <= 4; seq++ {
+		reload(seq)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Only %d of 4 updates were received", i)
+		}
+	}
+	close(release)
+
+	waitFor(t, time.Second*5, func() bool { return atomic.LoadInt32(&calls) == 2 })
+	time.Sleep(time.Millisecond * 100)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("Expected the reloads received meanwhile to be coalesced into 1, got %d calls", n)
+	}
+}
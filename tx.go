@@ -0,0 +1,63 @@
+package watcher
+
+import "fmt"
+
+// Tx is a transaction an update is applied in, see SetTransactionalCallback
+type Tx interface {
+	// Apply applies the update within the transaction.
+	Apply(UpdateMessage) error
+	Commit() error
+	Rollback() error
+}
+
+// SetTransactionalCallback is like SetUpdateCallbackEx but applies every
+// update in its own transaction: begin starts it, the update is passed to
+// Apply, and the transaction is committed if Apply succeeds or rolled back
+// if it fails or panics. Whether or not WithAckOnlyOnSuccess is set, an
+// update whose transaction isn't committed is nacked so it's redelivered.
+func (w *Watcher) SetTransactionalCallback(begin func() (Tx, error)) error {
+	w.connMu.Lock()
+	w.callbackFuncEx = func(um UpdateMessage) error {
+		return applyInTx(begin, um)
+	}
+	w.callbackTx = true
+	w.connMu.Unlock()
+	w.callbackSet()
+	return nil
+}
+
+func applyInTx(begin func() (Tx, error), um UpdateMessage) (err error) {
+	tx, err := begin()
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		// roll back on errors and panics alike, the panic carries on
+		if rbErr := tx.Rollback(); rbErr != nil && err != nil {
+			err = fmt.Errorf("%w (rollback failed, error: %s)", err, rbErr)
+		}
+	}()
+	if err := tx.Apply(um); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// ackOnSuccess reports whether messages are only acknowledged once their
+// callbacks succeeded
+func (w *Watcher) ackOnSuccess() bool {
+	if w.opts.ackOnlyOnSuccess {
+		return true
+	}
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	return w.callbackTx
+}
@@ -0,0 +1,107 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub/driver"
+)
+
+// fakeTx records what happened to a transaction
+type fakeTx struct {
+	mu       sync.Mutex
+	applyErr error
+	applied  []UpdateMessage
+	events   []string
+}
+
+func (tx *fakeTx) Apply(um UpdateMessage) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.applied = append(tx.applied, um)
+	return tx.applyErr
+}
+
+func (tx *fakeTx) Commit() error   { tx.record("commit"); return nil }
+func (tx *fakeTx) Rollback() error { tx.record("rollback"); return nil }
+
+func (tx *fakeTx) record(event string) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.events = append(tx.events, event)
+}
+
+func (tx *fakeTx) recorded() []string {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return append([]string(nil), tx.events...)
+}
+
+func TestTransactionalCallback(t *testing.T) {
+	errApply := errors.New("constraint violated")
+
+	for _, tc := range []struct {
+		name     string
+		applyErr error
+		event    string
+		acked    bool
+	}{
+		{"commit", nil, "commit", true},
+		{"rollback", errApply, "rollback", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			broker := &fakeBroker{}
+			w, err := NewWithOptions(ctx, "fake://tx", WithURLMux(broker.mux()), WithLogger(NewJSONLogger(&syncBuffer{})))
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+			tx := &fakeTx{applyErr: tc.applyErr}
+			w.SetTransactionalCallback(func() (Tx, error) { return tx, nil })
+
+			if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+				t.Fatalf("Failed to send update: %s", err)
+			}
+			sub := broker.subscriptions()[0]
+			var acked, settled bool
+			waitFor(t, time.Second*5, func() bool {
+				sub.mu.Lock()
+				defer sub.mu.Unlock()
+				acked, settled = sub.acks[driver.AckID(1)]
+				return settled
+			})
+			if acked != tc.acked {
+				t.Fatalf("Update acked: %t, want %t", acked, tc.acked)
+			}
+			if events := tx.recorded(); len(events) != 1 || events[0] != tc.event {
+				t.Fatalf("Transaction events %v, want only %s", events, tc.event)
+			}
+			if len(tx.applied) != 1 || tx.applied[0].Op != UpdateForAddPolicy {
+				t.Fatalf("Unexpected updates applied: %+v", tx.applied)
+			}
+		})
+	}
+}
+
+func TestTransactionRolledBackOnPanic(t *testing.T) {
+	tx := &fakeTx{}
+	w := newWatcher("", buildOptions())
+	err := w.call(func() error {
+		return applyInTx(func() (Tx, error) { return panickingTx{tx}, nil }, UpdateMessage{Op: Update})
+	})
+	if !errors.Is(err, ErrCallbackPanic) {
+		t.Fatalf("Expected ErrCallbackPanic, got: %v", err)
+	}
+	if events := tx.recorded(); len(events) != 1 || events[0] != "rollback" {
+		t.Fatalf("Transaction events %v, want only rollback", events)
+	}
+}
+
+type panickingTx struct{ *fakeTx }
+
+func (panickingTx) Apply(UpdateMessage) error { panic("nil model") }
@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+)
+
+// WithMaxUnacked pauses receiving while n messages or more are received but
+// not acked or nacked yet, e.g. held by WithAckOnlyOnSuccess until their
+// callbacks returned, and resumes once settling them brought the count
+// below n. It bounds the updates redelivered at once if the process
+// crashes. The provider's driver may still prefetch messages meanwhile.
+// Stats().Unacked reports the count whether or not a limit is set.
+func WithMaxUnacked(n int) Option {
+	return optionFunc(func(o *options) {
+		o.maxUnacked = n
+	})
+}
+
+// unacked counts the received messages not settled yet
+type unacked struct {
+	mu sync.Mutex
+	n  int
+	// settled is closed and replaced whenever a message is settled
+	settled chan struct{}
+}
+
+func (u *unacked) add() {
+	u.mu.Lock()
+	u.n++
+	u.mu.Unlock()
+}
+
+func (u *unacked) done() {
+	u.mu.Lock()
+	u.n--
+	if u.settled != nil {
+		close(u.settled)
+		u.settled = nil
+	}
+	u.mu.Unlock()
+}
+
+func (u *unacked) count() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.n
+}
+
+// waitForRoom blocks while WithMaxUnacked messages are unacked, or one with
+// WithStrictOrdering. It returns false if ctx is done or the watcher closed
+// meanwhile.
+func (w *Watcher) waitForRoom(ctx context.Context) bool {
+	max := w.opts.maxUnacked
+	if w.opts.strictOrdering {
+		max = 1
+	}
+	if max <= 0 {
+		return true
+	}
+	paused := false
+	for {
+		u := &w.unacked
+		u.mu.Lock()
+		if n := u.n; n < max {
+			u.mu.Unlock()
+			if paused {
+				w.log(LevelDebug, "Resuming receive", "unacked", n)
+			}
+			return true
+		}
+		if u.settled == nil {
+			u.settled = make(chan struct{})
+		}
+		settled := u.settled
+		u.mu.Unlock()
+		if !paused {
+			paused = true
+			w.log(LevelDebug, "Pausing receive, too many unacked messages", "unacked", max)
+		}
+
+		select {
+		case <-settled:
+		case <-ctx.Done():
+			return false
+		case <-w.closedCh:
+			return false
+		}
+	}
+}
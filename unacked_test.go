@@ -0,0 +1,61 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMaxUnacked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "fake://unacked", WithURLMux((&fakeBroker{}).mux()),
+		WithAckOnlyOnSuccess(), WithMaxUnacked(2))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	applying := make(chan string, 4)
+	release := make(chan struct{})
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applying <- um.Params[0]
+		<-release
+		return nil
+	})
+	expectApplying := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case <-applying:
+			case <-time.After(time.Second * 5):
+				t.Fatalf("Only %d of %d updates were received", i, n)
+			}
+		}
+		select {
+		case user := <-applying:
+			t.Fatalf("Update for %s received beyond the unacked limit", user)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		if err := w.UpdateForAddPolicy("p", "p", user, "data1", "read"); err != nil {
+			t.Fatalf("Failed to send update: %s", err)
+		}
+	}
+	expectApplying(2)
+	if n := w.Stats().Unacked; n != 2 {
+		t.Fatalf("Expected 2 unacked messages, got %d", n)
+	}
+
+	// each ack lets another message in
+	release <- struct{}{}
+	expectApplying(1)
+	release <- struct{}{}
+	expectApplying(1)
+
+	close(release)
+	waitFor(t, time.Second*5, func() bool { return w.Stats().Unacked == 0 })
+}
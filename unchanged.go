@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"gocloud.dev/pubsub"
+)
+
+// WithSkipUnchangedReloads skips the reloads that wouldn't change the
+// policy, to spare the database in chatty clusters. After each successful
+// reload the watcher records revision(), a revision of the policy content
+// just loaded, e.g. a hash of the rules or the last modification time of
+// the table. Before running the callbacks for a received Update or
+// UpdateForSavePolicy, it asks changed whether the stored policy differs
+// from that revision; when it reports false the update is acked without
+// reloading and counted in Stats().Unchanged. Updates are always applied
+// until a first reload recorded a revision, and incremental updates always
+// are.
+func WithSkipUnchangedReloads(revision func() string, changed func(revision string, um UpdateMessage) bool) Option {
+	return optionFunc(func(o *options) {
+		o.contentRevision = revision
+		o.contentChanged = changed
+	})
+}
+
+// contentRevision is the revision recorded after the last successful reload
+type contentRevision struct {
+	mu       sync.Mutex
+	revision string
+	recorded bool
+}
+
+// recordContentRevision records the revision of the policy just reloaded
+func (w *Watcher) recordContentRevision() {
+	if w.opts.contentRevision == nil {
+		return
+	}
+	rev := w.opts.contentRevision()
+	w.content.mu.Lock()
+	w.content.revision, w.content.recorded = rev, true
+	w.content.mu.Unlock()
+}
+
+// contentHook wraps applied, called once the callbacks for msg returned, to
+// record the revision of the policy after a successful reload
+func (w *Watcher) contentHook(msg *pubsub.Message, applied func(ok bool)) func(ok bool) {
+	if w.opts.contentRevision == nil || !w.isReload(msg) {
+		return applied
+	}
+	return func(ok bool) {
+		if ok {
+			w.recordContentRevision()
+		}
+		if applied != nil {
+			applied(ok)
+		}
+	}
+}
+
+// isUnchanged reports whether the reload requested by msg wouldn't change
+// the policy, according to WithSkipUnchangedReloads
+func (w *Watcher) isUnchanged(msg *pubsub.Message) bool {
+	if w.opts.contentChanged == nil || !w.isReload(msg) {
+		return false
+	}
+	w.content.mu.Lock()
+	rev, recorded := w.content.revision, w.content.recorded
+	w.content.mu.Unlock()
+	if !recorded {
+		return false
+	}
+	var um UpdateMessage
+	w.readMetadata(msg, &um)
+	if um.Op == "" {
+		um.Op = Update
+	}
+	if w.opts.contentChanged(rev, um) {
+		return false
+	}
+	atomic.AddUint64(&w.stats.unchanged, 1)
+	return true
+}
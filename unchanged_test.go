@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSkipUnchangedReloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var changed int32 = 1
+	asked := make(chan string, 10)
+	events := make(chan ackEvent, 10)
+	w, err := NewWithOptions(ctx, "fake://unchanged", WithURLMux((&fakeBroker{}).mux()),
+		WithSkipUnchangedReloads(func() string { return "v1" }, func(revision string, um UpdateMessage) bool {
+			asked <- revision
+			return atomic.LoadInt32(&changed) == 1
+		}), WithAckOnlyOnSuccess(), recordAcks(events))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	applied := make(chan UpdateType, 10)
+	w.SetUpdateCallbackEx(func(um UpdateMessage) error {
+		applied <- um.Op
+		return nil
+	})
+	expectApplied := func(op UpdateType) {
+		t.Helper()
+		select {
+		case got := <-applied:
+			if got != op {
+				t.Fatalf("Expected %s to be applied, got %s", op, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s wasn't applied", op)
+		}
+	}
+
+	// the first reload always runs and records the revision
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectApplied(Update)
+	waitFor(t, time.Second*5, func() bool {
+		w.content.mu.Lock()
+		defer w.content.mu.Unlock()
+		return w.content.recorded
+	})
+	if len(asked) != 0 {
+		t.Fatal("Expected no check before a revision was recorded")
+	}
+
+	atomic.StoreInt32(&changed, 0)
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	origin := w.opts.instanceID
+	expectAcks(t, events, ackEvent{1, origin, Acked}, ackEvent{2, origin, Dropped})
+	if rev := <-asked; rev != "v1" {
+		t.Fatalf("Expected the check against revision v1, got %q", rev)
+	}
+	if n := w.Stats().Unchanged; n != 1 {
+		t.Fatalf("Expected 1 skipped reload, got %d", n)
+	}
+
+	// incremental updates are never skipped
+	if err := w.UpdateForAddPolicy("p", "p", "alice", "data1", "read"); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectApplied(UpdateForAddPolicy)
+
+	atomic.StoreInt32(&changed, 1)
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	expectApplied(Update)
+	select {
+	case op := <-applied:
+		t.Fatalf("Unexpected update applied: %s", op)
+	default:
+	}
+}
@@ -0,0 +1,194 @@
+package watcher
+
+import (
+	"fmt"
+
+	"github.com/casbin/casbin/model"
+)
+
+// UpdateType identifies the kind of policy change carried by an update message
+type UpdateType string
+
+// Update types, named after the watcher methods that publish them
+const (
+	Update                        UpdateType = "Update"
+	UpdateForAddPolicy            UpdateType = "UpdateForAddPolicy"
+	UpdateForRemovePolicy         UpdateType = "UpdateForRemovePolicy"
+	UpdateForRemoveFilteredPolicy UpdateType = "UpdateForRemoveFilteredPolicy"
+	UpdateForSavePolicy           UpdateType = "UpdateForSavePolicy"
+	UpdateForAddPolicies          UpdateType = "UpdateForAddPolicies"
+	UpdateForRemovePolicies       UpdateType = "UpdateForRemovePolicies"
+	UpdateForUpdatePolicy         UpdateType = "UpdateForUpdatePolicy"
+	UpdateForUpdatePolicies       UpdateType = "UpdateForUpdatePolicies"
+)
+
+// legacyUpdateBody is the body sent by Update and understood by every
+// watcher version as "reload the whole policy"
+const legacyUpdateBody = "Casbin Update"
+
+// WithReloadSignalBody sets the body of the generic reload signal sent by
+// Update, for consumers written in other languages that expect their own
+// sentinel instead of "Casbin Update". Received messages with either body
+// are reloads, so watchers can switch one at a time. An empty body keeps
+// the default.
+func WithReloadSignalBody(body string) Option {
+	return optionFunc(func(o *options) {
+		if body != "" {
+			o.reloadSignal = body
+		}
+	})
+}
+
+// reloadSignal returns the body of the generic reload signal
+func (w *Watcher) reloadSignal() []byte {
+	return []byte(w.opts.reloadSignal)
+}
+
+// isReloadSignal reports whether body is the generic reload signal, the
+// configured one or the default
+func (w *Watcher) isReloadSignal(body []byte) bool {
+	return string(body) == w.opts.reloadSignal || string(body) == legacyUpdateBody
+}
+
+// UpdateMessage is a decoded policy change. Params holds a single rule,
+// Rules holds several; for the UpdateForUpdatePolicy* types they carry the
+// old rule(s) and NewParams/NewRules carry the replacements. Origin,
+// Sequence, and Hostname, PID and Node when sent with WithProcessMetadata,
+// are filled from the message metadata on receive, as is Tenant when sent
+// with WithTenant. Snapshot is only set for UpdateForSavePolicy in the
+// SavePolicySnapshot mode. Priority orders the callbacks waiting for a slot,
+// see PriorityHigh.
+type UpdateMessage struct {
+	Op          UpdateType     `json:"op"`
+	Sec         string         `json:"sec,omitempty"`
+	Ptype       string         `json:"ptype,omitempty"`
+	Params      []string       `json:"params,omitempty"`
+	Rules       [][]string     `json:"rules,omitempty"`
+	NewParams   []string       `json:"newParams,omitempty"`
+	NewRules    [][]string     `json:"newRules,omitempty"`
+	FieldIndex  int            `json:"fieldIndex,omitempty"`
+	FieldValues []string       `json:"fieldValues,omitempty"`
+	Snapshot    PolicySnapshot `json:"snapshot,omitempty"`
+	Priority    int            `json:"priority,omitempty"`
+	Origin      string         `json:"-"`
+	Sequence    uint64         `json:"-"`
+	Hostname    string         `json:"-"`
+	PID         int            `json:"-"`
+	Node        string         `json:"-"`
+	Tenant      string         `json:"-"`
+	// IdempotencyKey identifies the update across redeliveries, see
+	// WithIdempotency.
+	IdempotencyKey string `json:"-"`
+	// CompactionKey is the key the update was sent with, see
+	// WithCompactionKey.
+	CompactionKey string `json:"-"`
+	// MessageID is the ID the provider assigned to the message, when its
+	// driver registered a MessageIDExtractor.
+	MessageID string `json:"-"`
+	// Revision is the policy revision of the publisher, see WithRevision.
+	Revision uint64 `json:"-"`
+	// CorrelationID identifies the update in the logs of the publisher and
+	// of every watcher applying it, see LogFieldCorrelation.
+	CorrelationID string `json:"-"`
+}
+
+// SetUpdateCallbackEx sets a callback that receives the decoded update
+// message instead of the raw body. It is called for every message, including
+// the legacy Update signal which is delivered with Op set to Update.
+func (w *Watcher) SetUpdateCallbackEx(callbackFunc func(UpdateMessage) error) error {
+	w.connMu.Lock()
+	w.callbackFuncEx = callbackFunc
+	w.callbackTx = false
+	w.connMu.Unlock()
+	if callbackFunc != nil {
+		w.callbackSet()
+	}
+	return nil
+}
+
+// UpdateForAddPolicy notifies other instances that a policy rule was added.
+func (w *Watcher) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	return w.publish(UpdateMessage{Op: UpdateForAddPolicy, Sec: sec, Ptype: ptype, Params: params})
+}
+
+// UpdateForRemovePolicy notifies other instances that a policy rule was removed.
+func (w *Watcher) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	return w.publish(UpdateMessage{Op: UpdateForRemovePolicy, Sec: sec, Ptype: ptype, Params: params})
+}
+
+// UpdateForRemoveFilteredPolicy notifies other instances that the policy rules
+// matching the filter were removed.
+func (w *Watcher) UpdateForRemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return w.publish(UpdateMessage{
+		Op:          UpdateForRemoveFilteredPolicy,
+		Sec:         sec,
+		Ptype:       ptype,
+		FieldIndex:  fieldIndex,
+		FieldValues: fieldValues,
+	})
+}
+
+// UpdateForSavePolicy notifies other instances that the whole policy was saved.
+// Receivers are expected to reload the policy, or to apply the snapshot of
+// model when the watcher uses SavePolicySnapshot.
+func (w *Watcher) UpdateForSavePolicy(model model.Model) error {
+	um := UpdateMessage{Op: UpdateForSavePolicy, Priority: PriorityHigh}
+	if w.opts.savePolicyMode == SavePolicySnapshot && model != nil {
+		um.Snapshot = NewPolicySnapshot(model)
+	}
+	return w.publish(um)
+}
+
+// UpdateForAddPolicies notifies other instances that policy rules were added.
+func (w *Watcher) UpdateForAddPolicies(sec, ptype string, rules ...[]string) error {
+	return w.publish(UpdateMessage{Op: UpdateForAddPolicies, Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+// UpdateForRemovePolicies notifies other instances that policy rules were removed.
+func (w *Watcher) UpdateForRemovePolicies(sec, ptype string, rules ...[]string) error {
+	return w.publish(UpdateMessage{Op: UpdateForRemovePolicies, Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+// UpdateForUpdatePolicy notifies other instances that a policy rule was replaced.
+func (w *Watcher) UpdateForUpdatePolicy(sec, ptype string, oldRule, newRule []string) error {
+	return w.publish(UpdateMessage{
+		Op:        UpdateForUpdatePolicy,
+		Sec:       sec,
+		Ptype:     ptype,
+		Params:    oldRule,
+		NewParams: newRule,
+	})
+}
+
+// UpdateForUpdatePolicies notifies other instances that policy rules were replaced.
+func (w *Watcher) UpdateForUpdatePolicies(sec, ptype string, oldRules, newRules [][]string) error {
+	return w.publish(UpdateMessage{
+		Op:       UpdateForUpdatePolicies,
+		Sec:      sec,
+		Ptype:    ptype,
+		Rules:    oldRules,
+		NewRules: newRules,
+	})
+}
+
+func (w *Watcher) publish(um UpdateMessage) error {
+	body, err := w.codec.Marshal(um)
+	if err != nil {
+		return fmt.Errorf("failed to encode update message, error: %w", err)
+	}
+	m := w.newDualFormatMessage(body, um.Op)
+	if m == nil {
+		m = w.newMessage(body, um.Op)
+	}
+	w.setCompactionKey(m, um)
+	return w.broadcast(w.lifecycle(), m)
+}
+
+// decode turns a received body into an update message. The reload signal
+// is always understood regardless of the configured codec.
+func (w *Watcher) decode(body []byte) (UpdateMessage, error) {
+	if w.isReloadSignal(body) {
+		return UpdateMessage{Op: Update}, nil
+	}
+	return w.codec.Unmarshal(body)
+}
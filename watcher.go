@@ -7,9 +7,12 @@ import (
 	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/casbin/casbin/persist"
+	"go.opencensus.io/trace"
+	"gocloud.dev/gcerrors"
 	"gocloud.dev/pubsub"
 )
 
@@ -19,19 +22,124 @@ var _ persist.Watcher = &Watcher{}
 // Errors
 var (
 	ErrNotConnected = errors.New("pubsub not connected, cannot dispatch update message")
+	ErrClosed       = errors.New("watcher has been closed")
+	ErrSendTimeout  = errors.New("timed out sending update message")
+	ErrAlreadyOpen  = errors.New("watcher is already open")
 )
 
 // Watcher implements Casbin updates watcher to synchronize policy changes
 // between the nodes
 type Watcher struct {
-	url          string
-	subURL       string
-	topicURL     string
-	callbackFunc func(string)
-	connMu       *sync.RWMutex
-	ctx          context.Context
-	topic        *pubsub.Topic
-	sub          *pubsub.Subscription
+	// seq, stats, routines and quiet are accessed atomically and must stay
+	// 64-bit aligned
+	seq            uint64
+	stats          counters
+	routines       goroutineTracker
+	quiet          quiescence
+	opts           options
+	url            string
+	subURL         string
+	topicURL       string
+	callbackFunc   func(string)
+	callbackFuncEx func(UpdateMessage) error
+	// callbackTx is set when callbackFuncEx applies updates in transactions
+	callbackTx bool
+	// callbackBatch receives the updates of a batch, see
+	// SetUpdateBatchCallback
+	callbackBatch func([]UpdateMessage) error
+	codec         Codec
+	connMu        *sync.RWMutex
+	topic         topicSender
+	sub           subscriptionReceiver
+	closed        bool
+	closedCh      chan struct{}
+	closeOnce     sync.Once
+	updates       chan UpdateMessage
+	errs          chan error
+	// delivering counts the updates being put on updates
+	delivering sync.WaitGroup
+	// sendSlots holds a token per update being sent, see WithMaxConcurrentSends
+	sendSlots chan struct{}
+	// queue is the WithAsyncSend queue, nil when sending synchronously
+	queue *sendQueue
+	state connState
+	// tunables holds the *tunables currently in effect, see Reconfigure
+	tunables atomic.Value
+	limiter  limiter
+	// logOverride is set by SetLogLevelFor
+	logOverride levelOverride
+	budget      *memoryBudget
+	debounce    debouncer
+	coalesce    coalescer
+	throttle    throttler
+	acks        ackWaiters
+	// processMetadata is gathered once for WithProcessMetadata
+	processMetadata map[string]string
+	diag            diagnostics
+	// local holds the updates already applied by UpdateAndReload
+	local localApplied
+	// busApplied holds the updates received from the local bus
+	busApplied peerApplied
+	schedule   scheduler
+	health     healthTracker
+	idle       idleTracker
+	// applyLatency and reconnectDuration back the Stats summaries
+	applyLatency      latencyRecorder
+	reconnectDuration latencyRecorder
+	// wired is set once a callback was set, see warnUnwired
+	wired       int32
+	unwiredOnce sync.Once
+	resync      initialResync
+	// capabilityWarnings holds the capabilities already warned about, see
+	// warnCapability
+	capabilityWarnings sync.Map
+	// seqMarks follows the sequences applied from each origin, see
+	// WithSequenceStore and ExportSequenceState
+	seqMarks   sequenceMarks
+	supervisor supervisor
+	// callbackRuns tracks the running calls of callbackFunc, see
+	// SetUpdateCallbackDrained
+	callbackRuns callbackRuns
+	// baseline holds the updates received before the first reload, see
+	// WithBaselineBuffer
+	baseline baseline
+	// lanes orders the callbacks per origin, see WithOriginOrdering
+	lanes originLanes
+	// modelDrift remembers the model mismatches reported, see WithModelHash
+	modelDrift modelDrift
+	// unacked counts the messages not settled yet, see WithMaxUnacked
+	unacked unacked
+	// epoch identifies this run of the watcher in heartbeats
+	epoch int64
+	// gaps follows the sequences received, see WithGapResync
+	gaps sequenceGaps
+	// opened is set once Open succeeded
+	opened bool
+	// opening is set while Open opens the topic and the subscription
+	opening bool
+	// enforcers are the enforcers registered with AddEnforcer
+	enforcers []*enforcerLane
+	// spill guards the WithSpillFile file
+	spill spill
+	// applied records when the callbacks last succeeded, see LastApplied
+	applied lastApplied
+	// batches maps the messages of the batch being processed to it
+	batches sync.Map
+	// batchLane chains the batch callbacks so they run one at a time, in
+	// the order received
+	batchLane lane
+	// content is the policy revision recorded by WithSkipUnchangedReloads
+	content contentRevision
+	// catchup is the snapshot request of WithSnapshotOnReconnect
+	catchup catchup
+	// foreignMessages is set when the subscription's messages don't support
+	// As, see messageSettler
+	foreignMessages int32
+	// life holds the *lifecycle, replaced by Open
+	life atomic.Value
+	// retired is the subscription replaced by the last WithReceiveRefresh
+	// refresh, shut down by the next one
+	retired subscriptionReceiver
 }
 
 // New creates a new watcher  https://gocloud.dev/concepts/urls/
@@ -50,111 +158,811 @@ func New(ctx context.Context, url ...string) (*Watcher, error) {
 		log.Panic("does not require more than two URLs")
 	}
 
-	w := &Watcher{
-		topicURL: topicURL,
-		subURL:   subURL,
-		connMu:   &sync.RWMutex{},
+	return NewWithOptions(ctx, topicURL, WithSubscriptionURL(subURL))
+}
+
+// NewWithOptions creates a new watcher publishing to topicURL and configured
+// by opts. Unless WithSubscriptionURL is given, topicURL is also used to
+// subscribe to updates.
+func NewWithOptions(ctx context.Context, topicURL string, opts ...Option) (*Watcher, error) {
+	w := NewUnconnected(topicURL, opts...)
+	return w, w.Open(ctx)
+}
+
+// NewUnconnected creates a watcher like NewWithOptions but doesn't open
+// anything until Open is called, e.g. for dependency injection frameworks
+// wiring objects before starting them. Until then updates fail with
+// ErrNotConnected and nothing is received, but callbacks can be set.
+func NewUnconnected(topicURL string, opts ...Option) *Watcher {
+	o := buildOptions(opts...)
+	if o.subURL == "" {
+		o.subURL = topicURL
 	}
 
+	w := newWatcher(topicURL, o)
+
 	runtime.SetFinalizer(w, finalizer)
+	return w
+}
 
-	err := w.initializeConnections(ctx)
+// Open opens the topic and the subscription of a watcher created with
+// NewUnconnected and starts receiving updates. ctx bounds the opening and,
+// unless WithDetachedContext is given, the life of the watcher. It fails with
+// ErrAlreadyOpen if the watcher is open or being opened, and ErrClosed if it
+// was closed. Open may be called again after it failed.
+func (w *Watcher) Open(ctx context.Context) error {
+	if err := w.initializeConnections(ctx); err != nil {
+		return err
+	}
+	w.startSendQueue()
+	w.startSpillDrainer()
+	if w.opts.localBus != nil {
+		w.opts.localBus.join(w)
+	}
+	if w.opts.diagnostics != nil {
+		w.routines.start(w.dumpDiagnostics)
+	}
+	if w.opts.heartbeatInterval > 0 {
+		w.routines.start(w.sendHeartbeats)
+	}
+	return nil
+}
 
-	return w, err
+func newWatcher(topicURL string, o options) *Watcher {
+	w := &Watcher{
+		opts:     o,
+		topicURL: topicURL,
+		subURL:   o.subURL,
+		codec:    JSONCodec{},
+		connMu:   &sync.RWMutex{},
+		closedCh: make(chan struct{}),
+		state:    newConnState(),
+	}
+	if o.updatesBuffer > 0 {
+		w.updates = make(chan UpdateMessage, o.updatesBuffer)
+	}
+	if o.errorsBuffer > 0 {
+		w.errs = make(chan error, o.errorsBuffer)
+	}
+	if o.maxSends > 0 {
+		w.sendSlots = make(chan struct{}, o.maxSends)
+	}
+	w.setLifecycle(context.Background())
+	w.queue = newSendQueue(o)
+	w.processMetadata = w.newProcessMetadata()
+	w.diag.origins = map[string]OriginInfo{}
+	w.diag.replaced = map[string]map[string]bool{}
+	if o.recentMessages > 0 {
+		w.diag.recent = make([]RecentMessage, o.recentMessages)
+	}
+	w.budget = newMemoryBudget(o.budgetMessages, o.budgetBytes, &w.stats.shed)
+	w.debounce.clock, w.debounce.budget, w.debounce.routines = o.clock, w.budget, &w.routines
+	w.throttle.clock, w.throttle.budget, w.throttle.routines = o.clock, w.budget, &w.routines
+	w.health.clock, w.health.window = o.clock, o.healthDebounce
+	w.idle.clock, w.idle.timeout = o.clock, o.idleTimeout
+	w.limiter.cond = sync.NewCond(&w.limiter.mu)
+	w.acks.pending = map[string]*ackWaiter{}
+	w.resync.done = make(chan struct{})
+	w.epoch = o.clock.Now().UnixNano()
+	t := o.tunables
+	w.tunables.Store(&t)
+	return w
 }
 
 // SetUpdateCallback sets the callback function that the watcher will call
 // when the policy in DB has been changed by other instances.
 // A classic callback is Enforcer.LoadPolicy().
+// It doesn't wait for the calls of the previous callback still running, see
+// SetUpdateCallbackDrained.
 func (w *Watcher) SetUpdateCallback(callbackFunc func(string)) error {
 	w.connMu.Lock()
 	w.callbackFunc = callbackFunc
 	w.connMu.Unlock()
+	if callbackFunc != nil {
+		w.callbackSet()
+	}
 	return nil
 }
 
+// initializeConnections opens the topic and the subscription. They're opened
+// without the lock, retries and their backoff included, which is only taken
+// to install them.
 func (w *Watcher) initializeConnections(ctx context.Context) error {
+	w.connMu.Lock()
+	if w.closed {
+		w.connMu.Unlock()
+		return ErrClosed
+	}
+	if w.opened || w.opening {
+		w.connMu.Unlock()
+		return ErrAlreadyOpen
+	}
+	w.opening = true
+	if !w.opts.detachedContext {
+		w.stopLifecycle()
+		w.setLifecycle(ctx)
+	}
+	topic, topicURL := w.topic, w.topicURL
+	w.connMu.Unlock()
+
+	// the topic of a previous Open failing to subscribe is reused
+	var err error
+	if topic == nil {
+		err = w.retryOpen(ctx, "topic", func() (err error) {
+			topic, err = w.transport().openTopic(ctx, topicURL)
+			return err
+		})
+	}
+	var sub subscriptionReceiver
+	if err == nil {
+		sub, err = w.subscribeToUpdates(ctx, topicURL)
+	}
+
 	w.connMu.Lock()
 	defer w.connMu.Unlock()
-	w.ctx = ctx
-	topic, err := pubsub.OpenTopic(ctx, w.topicURL)
+	w.opening = false
+	if w.closed {
+		if sub != nil {
+			_ = sub.Shutdown(ctx)
+		}
+		return ErrClosed
+	}
+	if topic != nil && w.topic == nil {
+		w.topic = topic
+	}
 	if err != nil {
 		return err
 	}
-	w.topic = topic
+	w.sub = sub
+	// the transport, and so the kind of subscription, never changes
+	if _, ok := sub.(messageSettler); ok {
+		atomic.StoreInt32(&w.foreignMessages, 1)
+	}
+	w.opened = true
+	w.setConnected(true, "subscription opened")
+	w.idle.touch()
+	w.routines.start(func() { w.receive(w.lifecycle(), sub) })
+	return nil
+}
+
+// lifecycle is cancelled by Close and bounds the receive loop and the sends
+// not given a context
+type lifecycle struct {
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+// setLifecycle starts a lifecycle bounded by parent. It's read without the
+// lock, so it's swapped atomically.
+func (w *Watcher) setLifecycle(parent context.Context) {
+	ctx, stop := context.WithCancel(parent)
+	w.life.Store(&lifecycle{ctx: ctx, stop: stop})
+}
+
+// lifecycle returns the context of the current lifecycle
+func (w *Watcher) lifecycle() context.Context {
+	return w.life.Load().(*lifecycle).ctx
+}
 
-	return w.subscribeToUpdates(ctx)
+// stopLifecycle cancels the current lifecycle
+func (w *Watcher) stopLifecycle() {
+	w.life.Load().(*lifecycle).stop()
 }
 
-func (w *Watcher) subscribeToUpdates(ctx context.Context) error {
-	sub, err := pubsub.OpenSubscription(ctx, w.subURL)
+// subscribeToUpdates opens the subscription receiving the updates published
+// to topicURL
+func (w *Watcher) subscribeToUpdates(ctx context.Context, topicURL string) (subscriptionReceiver, error) {
+	var sub subscriptionReceiver
+	err := w.retryOpen(ctx, "subscription", func() (err error) {
+		sub, err = w.openSubscription(ctx)
+		if err != nil || !w.opts.strictBinding {
+			return err
+		}
+		if err = w.verifyBinding(ctx, topicURL, w.subURL, sub); err != nil {
+			_ = sub.Shutdown(ctx)
+		}
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open updates subscription, error: %w", err)
+		return nil, err
 	}
-	w.sub = sub
-	go func() {
-		for {
-			msg, err := sub.Receive(ctx)
-			if err != nil {
-				if ctx.Err() == context.Canceled {
-					// nothing to do
-					return
-				}
-				log.Printf("Error while receiving an update message: %s\n", err)
+	return sub, nil
+}
+
+func (w *Watcher) openSubscription(ctx context.Context) (subscriptionReceiver, error) {
+	subURL, err := w.subscriptionURL()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open updates subscription, error: %w", err)
+	}
+	sub, err := w.transport().openSubscription(ctx, subURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open updates subscription, error: %w", err)
+	}
+	return sub, nil
+}
+
+func (w *Watcher) receive(ctx context.Context, sub subscriptionReceiver) {
+	rc := w.newReceiveContext(ctx)
+	defer func() { rc.stop() }()
+	for {
+		if !w.waitForRoom(ctx) {
+			return
+		}
+		msg, err := sub.Receive(rc.ctx)
+		if err != nil {
+			if rc.hasExpired() && ctx.Err() == nil && !w.isClosed() {
+				sub = w.refreshSubscription(ctx, sub)
+				rc = w.renewReceiveContext(ctx, rc)
+				continue
+			}
+			if ctx.Err() == context.Canceled || w.isClosed() {
+				// nothing to do
+				return
+			}
+			switch w.opts.errorClassifier(err) {
+			case Shutdown:
+				w.log(LevelDebug, "Subscription shut down", "error", err)
+				w.setConnected(false, "subscription shut down: "+err.Error())
+				return
+			case Fatal:
+				w.log(LevelError, "Subscription failed permanently, not reconnecting", "error", err)
+				w.setConnected(false, "subscription failed permanently: "+err.Error())
 				return
 			}
-			w.executeCallback(msg)
+			w.log(LevelError, "Error while receiving an update message", "error", err)
+			if sub = w.reconnect(ctx, sub, err); sub == nil {
+				return
+			}
+			continue
+		}
+		w.idle.touch()
+		if w.opts.legacyMode {
+			w.quiet.begin()
+			w.handleLegacyMessage(sub, msg)
+			continue
+		}
+		if w.batching() {
+			w.processBatch(ctx, sub, w.receiveBatch(rc, sub, msg))
+			continue
+		}
+		w.processMessage(ctx, sub, msg, nil)
+	}
+}
 
-			msg.Ack()
+// processMessage handles msg, received from sub, and settles it, through
+// hold if not nil, e.g. to settle it along with the other messages of its
+// batch
+func (w *Watcher) processMessage(ctx context.Context, sub subscriptionReceiver, msg *pubsub.Message, hold func(settle func())) {
+	w.quiet.begin()
+	w.unacked.add()
+	span := w.startReceiveSpan(ctx, msg)
+	stopLease := w.keepLease(sub, msg)
+	w.handleMessage(msg, func(outcome AckOutcome) {
+		settle := func() {
+			stopLease()
+			w.settle(sub, msg, outcome)
+			w.unacked.done()
+			span.AddAttributes(trace.StringAttribute(AttributeOutcome, outcome.String()))
+			span.End()
+			if w.isOwnUpdate(msg) {
+				atomic.AddUint64(&w.quiet.echoed, 1)
+			}
+			w.quiet.end()
 		}
-	}()
-	return nil
+		if hold != nil {
+			hold(settle)
+		} else {
+			settle()
+		}
+	})
+}
+
+// settle acks or nacks msg, received from sub, according to outcome and
+// records it
+func (w *Watcher) settle(sub subscriptionReceiver, msg *pubsub.Message, outcome AckOutcome) {
+	if outcome == Nacked && !msg.Nackable() {
+		// the provider can't redeliver it
+		w.nackFallback(msg)
+		outcome = Dropped
+	}
+	settleMessage(sub, msg, outcome != Nacked)
+	w.reportAck(msg, outcome)
+	w.recordMessage(msg, outcome)
 }
 
-func (w *Watcher) executeCallback(msg *pubsub.Message) {
+// handleMessage processes msg and calls settle once with its outcome, which
+// with WithAckOnlyOnSuccess is only known once the callbacks returned.
+func (w *Watcher) handleMessage(msg *pubsub.Message, settle func(AckOutcome)) {
+	if err := w.adaptMetadata(msg); err != nil {
+		w.log(LevelError, "Dropping oversized message", "error", err, "id", msg.LoggableID)
+		w.pushError(err)
+		settle(Dropped)
+		return
+	}
+	if err := w.verifyChecksum(msg); err != nil {
+		w.log(LevelError, "Dropping corrupted message", "error", err, "id", msg.LoggableID)
+		w.pushError(err)
+		settle(Dropped)
+		return
+	}
+	if err := w.decompress(msg); err != nil {
+		reason := "Dropping undecodable message"
+		if errors.Is(err, ErrPayloadTooLarge) {
+			reason = "Dropping oversized message"
+		}
+		w.log(LevelError, reason, "error", err, "id", msg.LoggableID)
+		w.pushError(err)
+		settle(Dropped)
+		return
+	}
+	w.preferStructured(msg)
+	if err := w.checkPayloadSize(msg.Body); err != nil {
+		w.log(LevelError, "Dropping oversized message", "error", err, "id", msg.LoggableID)
+		w.pushError(err)
+		settle(Dropped)
+		return
+	}
+	body := string(msg.Body)
+	if w.opts.bodyDecoder != nil && !w.isWatcherMessage(msg) {
+		translated, ok := w.opts.bodyDecoder(msg.Body, msg.Metadata)
+		if !ok {
+			settle(Dropped)
+			return
+		}
+		body = translated
+	} else if w.isForeign(msg) {
+		// another application or watcher namespace shares the topic
+		settle(Dropped)
+		return
+	}
+	w.checkModel(msg)
+	if kind := msg.Metadata[w.metadataKey(metaKind)]; kind != "" {
+		w.handleControl(kind, msg)
+		settle(Acked)
+		return
+	}
+	if w.resyncOnGap(msg, settle) {
+		return
+	}
+	op := UpdateType(msg.Metadata[w.metadataKey(metaOp)])
+	if w.isOwnMessage(msg) && w.currentTunables().selfFilter.filtersSelf(op) {
+		settle(Dropped)
+		return
+	}
+	if w.isLocallyApplied(msg) {
+		// the callbacks already ran in UpdateAndReload
+		settle(Dropped)
+		return
+	}
+	if target := msg.Metadata[w.metadataKey(metaTarget)]; target != "" && target != w.opts.instanceID {
+		// addressed to another watcher, see UpdateTo
+		settle(Dropped)
+		return
+	}
+	if w.isOtherTenant(msg) {
+		settle(Dropped)
+		return
+	}
+	if w.isStaleSnapshot(msg) {
+		w.log(LevelDebug, "Dropping snapshot answering another request", "id", msg.LoggableID)
+		settle(Dropped)
+		return
+	}
+	if w.isCurrentRevision(msg) {
+		w.log(LevelDebug, "Dropping update for a revision already applied", "id", msg.LoggableID, "revision", w.messageRevision(msg))
+		settle(Dropped)
+		return
+	}
+	if w.isAlreadyApplied(msg) {
+		w.log(LevelDebug, "Dropping update already applied before", "id", msg.LoggableID)
+		settle(Dropped)
+		return
+	}
+	settle = w.saveSequenceOnAck(msg, settle)
+	settle, seen := w.applyOnce(msg, settle)
+	if seen {
+		w.log(LevelDebug, "Dropping update with an idempotency key already applied", "id", msg.LoggableID)
+		settle(Dropped)
+		return
+	}
+	if w.holdUntilBaseline(msg, body, settle) {
+		return
+	}
+	if w.isUnchanged(msg) {
+		w.log(LevelDebug, "Skipping reload, the policy is unchanged", "id", msg.LoggableID)
+		settle(Dropped)
+		return
+	}
+	w.dispatchMessage(msg, body, settle, w.contentHook(msg, w.baselineHook(msg)))
+}
+
+// dispatchMessage delivers msg to the Updates channel and the callbacks, and
+// settles it. applied, if not nil, is called once the callbacks returned
+// with whether they all succeeded.
+func (w *Watcher) dispatchMessage(msg *pubsub.Message, body string, settle func(AckOutcome), applied func(ok bool)) {
+	outcome := Acked
+	um, err := w.decode([]byte(body))
+	// the metadata also tells which update a legacy callback failed for when
+	// the body couldn't be decoded
+	w.readMetadata(msg, &um)
+	um.MessageID = w.nativeMessageID(msg)
+	if err != nil && um.Op == "" {
+		um.Op = UpdateType(msg.Metadata[w.metadataKey(metaOp)])
+	}
+	execute := func(done func(ok bool)) {
+		w.executeCallback(msg, body, um, err, done)
+	}
+	if w.opts.reloadOnApplyFailure && err == nil && um.Op.isIncremental() {
+		execute = func(done func(ok bool)) {
+			w.executeCallback(msg, body, um, err, w.reloadOnFailure(msg, um, done))
+		}
+	}
+	if err == nil {
+		if outcome = w.deliverToChannel(um, msg.Nackable()); outcome == Nacked {
+			settle(Nacked)
+			return
+		}
+	}
+	if outcome == Acked && w.ackOnSuccess() {
+		execute(func(ok bool) {
+			if ok {
+				settle(Acked)
+			} else {
+				settle(Nacked)
+			}
+			if applied != nil {
+				applied(ok)
+			}
+		})
+		return
+	}
+	if w.opts.strictOrdering {
+		// the next message is received once this one is settled
+		execute(func(ok bool) {
+			if applied != nil {
+				applied(ok)
+			}
+			settle(outcome)
+		})
+		return
+	}
+	execute(applied)
+	settle(outcome)
+}
+
+// handleControl processes watcher-to-watcher control messages. Unknown kinds
+// come from newer versions and are ignored.
+func (w *Watcher) handleControl(kind string, msg *pubsub.Message) {
+	switch kind {
+	case kindAck:
+		w.acks.deliver(msg.Metadata[w.metadataKey(metaCorrelation)], msg.Metadata[w.metadataKey(metaOrigin)])
+	case kindLeave:
+		w.forgetOrigin(msg.Metadata[w.metadataKey(metaOrigin)])
+	case kindHeartbeat:
+		w.recordHeartbeat(msg)
+	case kindPing:
+		w.recordPing(msg)
+	case kindSnapshotRequest:
+		w.answerSnapshotRequest(msg)
+	default:
+		w.log(LevelDebug, "Ignoring unknown control message", "kind", kind, "id", msg.LoggableID)
+	}
+}
+
+// executeCallback runs the callbacks for msg, whose body may have been
+// translated by the body decoder. um is body decoded, or decodeErr tells why
+// it couldn't be. done, if not nil, is called once every callback returned,
+// with whether they all succeeded.
+func (w *Watcher) executeCallback(msg *pubsub.Message, body string, um UpdateMessage, decodeErr error, done func(ok bool)) {
 	w.connMu.RLock()
 	defer w.connMu.RUnlock()
+
+	// applied tracks the callbacks of this message so the publisher can be
+	// acknowledged once they all succeeded
+	var applied sync.WaitGroup
+	var failed int32
+	callbacks := 0
+	callbackDone := func(err error) {
+		defer w.quiet.end()
+		if err != nil {
+			atomic.StoreInt32(&failed, 1)
+			if !errors.Is(err, errNotRun) {
+				w.reportCallbackError(um, err)
+			}
+		}
+		applied.Done()
+	}
+
 	if w.callbackFunc != nil {
-		go w.callbackFunc(string(msg.Body))
+		callbacks++
+		applied.Add(1)
+		w.quiet.begin()
+		t := w.currentTunables()
+		var fire func(string, func(error))
+		if t.debounce > 0 || t.minReloadInterval > 0 {
+			// the reload may run later, with the callback set by then
+			fire = w.runLegacyCallback
+		} else {
+			fire = func(body string, done func(error)) {
+				w.dispatchLegacyCallback(body, um.Priority, done)
+			}
+		}
+		if t.coalesceReloads {
+			fire = w.coalesce.wrap(fire)
+		}
+		if t.minReloadInterval > 0 {
+			fire = w.throttle.wrap(t.minReloadInterval, fire)
+		}
+		legacyDone := w.recordApplied(&w.applied.callback, &w.applied.mu, callbackDone)
+		if t.debounce > 0 {
+			w.debounce.trigger(t.debounce, body, fire, legacyDone)
+		} else {
+			fire(body, legacyDone)
+		}
 	}
+	if w.callbackFuncEx != nil || w.callbackBatch != nil || len(w.enforcers) > 0 {
+		if decodeErr != nil {
+			w.log(LevelError, "Failed to decode update message", "error", decodeErr, "id", msg.LoggableID)
+			w.pushError(fmt.Errorf("failed to decode update message, error: %w", decodeErr))
+			atomic.StoreInt32(&failed, 1)
+		} else {
+			if w.callbackFuncEx != nil {
+				callbacks++
+				applied.Add(1)
+				w.quiet.begin()
+				callback := w.callbackFuncEx
+				w.dispatchInOrder(um.Origin, len(msg.Body), um.Priority, func() error {
+					return callback(um)
+				}, w.recordApplied(&w.applied.callbackEx, &w.applied.mu, callbackDone))
+			}
+			for _, lane := range w.enforcers {
+				callbacks++
+				applied.Add(1)
+				w.quiet.begin()
+				w.dispatchToEnforcer(lane, len(msg.Body), um, callbackDone)
+			}
+			if w.callbackBatch != nil {
+				callbacks++
+				applied.Add(1)
+				w.quiet.begin()
+				w.batchUpdate(w.callbackBatch, msg, um, callbackDone)
+			}
+		}
+	}
+
+	replyTo := msg.Metadata[w.metadataKey(metaReplyTo)]
+	sendAck := replyTo != "" && callbacks > 0 && !w.isSelf(msg)
+	if callbacks == 0 {
+		if done != nil {
+			done(failed == 0)
+		}
+		return
+	}
+	logApplied := w.logLevel() <= LevelDebug
+	if !sendAck && done == nil && !logApplied {
+		return
+	}
+	// the acknowledgement is sent once the callbacks completed
+	w.quiet.begin()
+	w.routines.start(func() {
+		defer w.quiet.end()
+		applied.Wait()
+		ok := atomic.LoadInt32(&failed) == 0
+		if ok && logApplied {
+			w.log(LevelDebug, "Applied update", "op", um.Op, "origin", um.Origin, "sequence", um.Sequence,
+				LogFieldCorrelation, um.CorrelationID)
+		}
+		if ok && sendAck {
+			w.sendAck(replyTo)
+		}
+		if done != nil {
+			done(ok)
+		}
+	})
+}
+
+// runLegacyCallback invokes the current legacy callback, if still set, and
+// then calls done
+func (w *Watcher) runLegacyCallback(body string, done func(error)) {
+	w.connMu.RLock()
+	callback := w.callbackFunc
+	w.connMu.RUnlock()
+	if callback == nil {
+		done(errNotRun)
+		return
+	}
+	w.dispatchLegacyCallback(body, PriorityNormal, done)
+}
+
+// dispatchLegacyCallback dispatches a call of the legacy callback set when
+// it starts, so a callback swapped meanwhile is never called afterwards
+func (w *Watcher) dispatchLegacyCallback(body string, priority int, done func(error)) {
+	w.dispatch(len(body), priority, func() error {
+		callback, finished := w.beginLegacyCallback()
+		if callback == nil {
+			return errNotRun
+		}
+		defer finished()
+		callback(body)
+		return nil
+	}, done)
 }
 
 // Update calls the update callback of other instances to synchronize their policy.
 // It is usually called after changing the policy in DB, like Enforcer.SavePolicy(),
 // Enforcer.AddPolicy(), Enforcer.RemovePolicy(), etc.
 func (w *Watcher) Update() error {
+	return w.UpdateContext(w.lifecycle())
+}
+
+// UpdateContext is like Update but sends the update within ctx.
+func (w *Watcher) UpdateContext(ctx context.Context) error {
+	m := w.newMessage(w.reloadSignal(), Update)
+	w.setCompactionKey(m, UpdateMessage{Op: Update})
+	return w.broadcast(ctx, m)
+}
+
+// UpdateTo asks the watcher with the instance ID targetID, and only that
+// one, to reload the policy. The other watchers ignore the update.
+func (w *Watcher) UpdateTo(ctx context.Context, targetID string) error {
+	m := w.newMessage(w.reloadSignal(), Update)
+	m.Metadata[w.metadataKey(metaTarget)] = targetID
+	return w.broadcast(ctx, m)
+}
+
+// send publishes m. When ctx has no deadline the send is bounded by the
+// send timeout.
+func (w *Watcher) send(ctx context.Context, m *pubsub.Message) error {
 	w.connMu.RLock()
 	defer w.connMu.RUnlock()
 	if w.topic == nil {
-		return ErrNotConnected
+		kind := Transient
+		if w.closed {
+			kind = Shutdown
+		}
+		return &SendError{Kind: kind, Err: ErrNotConnected}
 	}
-	m := &pubsub.Message{Body: []byte("Casbin Update")}
-	return w.topic.Send(w.ctx, m)
+
+	// a black-holed broker must not block the caller forever
+	sendCtx := ctx
+	timeout := w.opts.sendTimeout
+	if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+		var cancel context.CancelFunc
+		sendCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := w.compress(m); err != nil {
+		return err
+	}
+	w.stampChecksum(m)
+	err := w.topic.Send(sendCtx, m)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("%w after %s", ErrSendTimeout, timeout)
+	}
+	err = detectTopicDisabled(err)
+	return &SendError{Kind: w.opts.errorClassifier(err), Err: err}
 }
 
 // Close stops and releases the watcher, the callback function will not be called any more.
 func (w *Watcher) Close() {
-	finalizer(w)
+	w.closeWithTimeout()
+}
+
+// CloseContext is like Close but waits for the subscription to shut down
+// until ctx is done, and returns the error reported by the provider if the
+// shutdown failed.
+func (w *Watcher) CloseContext(ctx context.Context) error {
+	return w.close(ctx)
+}
+
+// isExpectedShutdownError reports whether a Shutdown error is part of a
+// normal exit: the shutdown deadline or the process context expiring, or the
+// subscription having already been shut down by a reconnect.
+func isExpectedShutdownError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.Canceled, gcerrors.DeadlineExceeded, gcerrors.FailedPrecondition:
+		return true
+	}
+	return false
 }
 
 func finalizer(w *Watcher) {
-	w.connMu.Lock()
-	defer w.connMu.Unlock()
+	if w.opts.minimalFinalizer {
+		w.release()
+		return
+	}
+	w.closeWithTimeout()
+}
 
+// closeWithTimeout closes the watcher, waiting up to 10s for the
+// subscription to shut down
+func (w *Watcher) closeWithTimeout() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if err := w.close(ctx); err != nil {
+		w.log(LevelError, "Subscription shutdown failed", "error", err)
+	}
+}
+
+// announceLeave sends the WithLeaveAnnouncement message, within ctx
+func (w *Watcher) announceLeave(ctx context.Context) {
+	if !w.opts.announceLeave {
+		return
+	}
+	if err := w.send(ctx, w.newControlMessage(kindLeave, nil)); err != nil {
+		w.log(LevelDebug, "Failed to announce leaving", "error", err)
+	}
+}
+
+func (w *Watcher) close(ctx context.Context) error {
+	// closedCh is closed before taking the lock so that goroutines blocked,
+	// e.g. on a full updates channel, let go
+	w.closeOnce.Do(func() {
+		if w.opts.localBus != nil {
+			w.opts.localBus.leave(w)
+		}
+		w.flushSendQueue(ctx)
+		w.announceLeave(ctx)
+		// without the lock, for a running hook may use the watcher
+		w.idle.stop()
+		close(w.closedCh)
+	})
+
+	w.connMu.Lock()
+	defer w.connMu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.health.stop()
+	w.setConnected(false, "closed")
+
 	if w.topic != nil {
 		w.topic = nil
 	}
 
+	var err error
 	if w.sub != nil {
-		err := w.sub.Shutdown(ctx)
-		if err != nil {
-			log.Printf("Subscription shutdown failed, error: %s\n", err)
+		err = w.sub.Shutdown(ctx)
+		if isExpectedShutdownError(err) {
+			w.log(LevelDebug, "Subscription shutdown interrupted", "error", err)
+			err = nil
 		}
 		w.sub = nil
 	}
+	if w.retired != nil {
+		_ = w.retired.Shutdown(ctx)
+		w.retired = nil
+	}
+	w.stopLifecycle()
 
+	w.stopScheduled()
+	w.debounce.stop()
+	w.coalesce.stop()
+	w.throttle.stop()
+	if w.updates != nil {
+		w.delivering.Wait()
+		close(w.updates)
+	}
+	if w.errs != nil {
+		close(w.errs)
+	}
 	w.callbackFunc = nil
+	w.callbackFuncEx = nil
+	w.callbackBatch = nil
+	w.callbackTx = false
+	w.enforcers = nil
+	w.SetLogLevelFor(0, 0)
+	return err
 }
@@ -1,15 +1,19 @@
 package watcher
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/casbin/casbin"
 	gnatsd "github.com/nats-io/nats-server/v2/test"
 	"github.com/nats-io/nats.go"
+	"gocloud.dev/pubsub/driver"
 
 	// Enable inmemory and NATS drivers
 	_ "github.com/fresh8gaming/casbin-go-cloud-watcher/drivers/mempubsub"
@@ -230,3 +234,234 @@ func TestWithEnforcerMemoryB(t *testing.T) {
 	}
 	close(cannel)
 }
+
+func TestFinalizerAfterCloseIsSilent(t *testing.T) {
+	var buf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := NewWithOptions(ctx, "mem://finalizer-topic", WithLogger(NewJSONLogger(&buf)), WithLogLevel(LevelDebug))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	w.Close()
+	buf.Reset()
+
+	finalizer(w)
+	if buf.Len() != 0 {
+		t.Fatalf("Finalizer of a closed watcher logged: %s", buf.String())
+	}
+}
+
+func TestIsExpectedShutdownError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := New(ctx, "mem://shutdown-topic")
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	w.connMu.RLock()
+	sub := w.sub
+	w.connMu.RUnlock()
+	sub.Shutdown(ctx)
+	alreadyShutdown := sub.Shutdown(ctx)
+
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, fmt.Errorf("wrapped: %w", context.DeadlineExceeded), alreadyShutdown} {
+		if !isExpectedShutdownError(err) {
+			t.Fatalf("Expected %v to be a normal shutdown error", err)
+		}
+	}
+	for _, err := range []error{nil, errors.New("connection reset")} {
+		if isExpectedShutdownError(err) {
+			t.Fatalf("Expected %v not to be a normal shutdown error", err)
+		}
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+	broker := &fakeBroker{}
+	broker.sendErr = func(context.Context, []*driver.Message) error {
+		<-release
+		return nil
+	}
+
+	w, err := NewWithOptions(ctx, "fake://blackhole", WithURLMux(broker.mux()), WithSendTimeout(time.Millisecond*200))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer w.Close()
+
+	start := time.Now()
+	err = w.Update()
+	if !errors.Is(err, ErrSendTimeout) {
+		t.Fatalf("Expected ErrSendTimeout, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*200 || elapsed > time.Second*5 {
+		t.Fatalf("Update gave up after %s instead of the send timeout", elapsed)
+	}
+}
+
+func TestUpdateTo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := &fakeBroker{}
+	received := make(chan string, 6)
+	newNode := func(id string) *Watcher {
+		w, err := NewWithOptions(ctx, "fake://targeted", WithURLMux(broker.mux()), WithInstanceID(id))
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		t.Cleanup(w.Close)
+		w.SetUpdateCallback(func(string) {
+			received <- id
+		})
+		return w
+	}
+	publisher := newNode("publisher")
+	newNode("node-a")
+	newNode("node-b")
+
+	if err := publisher.UpdateTo(ctx, "node-a"); err != nil {
+		t.Fatalf("Failed to send targeted update: %s", err)
+	}
+	select {
+	case id := <-received:
+		if id != "node-a" {
+			t.Fatalf("Targeted update delivered to %s", id)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Targeted update wasn't delivered in time")
+	}
+
+	// a broadcast still reaches everyone
+	if err := publisher.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	seen := map[string]bool{}
+	for len(seen) < 3 {
+		select {
+		case id := <-received:
+			if seen[id] {
+				t.Fatalf("Targeted update delivered to %s", id)
+			}
+			seen[id] = true
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Broadcast only reached %v", seen)
+		}
+	}
+}
+
+func TestDetachedContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	broker := &fakeBroker{}
+	received := make(chan string, 4)
+	newNode := func(id string, opts ...Option) *Watcher {
+		opts = append(opts, WithURLMux(broker.mux()), WithInstanceID(id))
+		w, err := NewWithOptions(ctx, "fake://detached", opts...)
+		if err != nil {
+			t.Fatalf("Failed to create watcher, error: %s", err)
+		}
+		w.SetUpdateCallback(func(string) {
+			received <- id
+		})
+		return w
+	}
+	attached := newNode("attached")
+	defer attached.Close()
+	detached := newNode("detached", WithDetachedContext())
+	cancel()
+
+	if err := attached.Update(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Update after cancelling the context: got %v, want context.Canceled", err)
+	}
+
+	if err := detached.Update(); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	sendCtx, sendCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer sendCancel()
+	if err := detached.UpdateContext(sendCtx); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-received:
+			if id != "detached" {
+				t.Fatalf("Update delivered to %s after its context was cancelled", id)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("Update wasn't delivered in time")
+		}
+	}
+
+	if err := detached.CloseContext(sendCtx); err != nil {
+		t.Fatalf("Failed to close watcher: %s", err)
+	}
+	if err := detached.UpdateContext(sendCtx); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("UpdateContext after close: got %v, want ErrNotConnected", err)
+	}
+}
+
+func TestUpdateAndReload(t *testing.T) {
+	ctx := context.Background()
+
+	broker := &fakeBroker{}
+	echoes := make(chan AckOutcome, 1)
+	publisher, err := NewWithOptions(ctx, "fake://reload", WithURLMux(broker.mux()), WithInstanceID("publisher"),
+		WithOnAck(func(seq uint64, origin string, outcome AckOutcome) {
+			if origin == "publisher" {
+				echoes <- outcome
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer publisher.Close()
+	listener, err := NewWithOptions(ctx, "fake://reload", WithURLMux(broker.mux()), WithInstanceID("listener"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher, error: %s", err)
+	}
+	defer listener.Close()
+
+	var local int32
+	publisher.SetUpdateCallback(func(string) {
+		atomic.AddInt32(&local, 1)
+	})
+	remote := make(chan struct{}, 2)
+	listener.SetUpdateCallback(func(string) {
+		remote <- struct{}{}
+	})
+
+	if err := publisher.UpdateAndReload(ctx); err != nil {
+		t.Fatalf("Failed to send update: %s", err)
+	}
+	if n := atomic.LoadInt32(&local); n != 1 {
+		t.Fatalf("Local callback ran %d times before UpdateAndReload returned, want 1", n)
+	}
+	select {
+	case <-remote:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Update wasn't broadcast")
+	}
+	select {
+	case outcome := <-echoes:
+		if outcome != Dropped {
+			t.Fatalf("Echo of the update was %s, want dropped", outcome)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Echo of the update didn't arrive")
+	}
+	if n := atomic.LoadInt32(&local); n != 1 {
+		t.Fatalf("Local callback ran %d times, want 1", n)
+	}
+}
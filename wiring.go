@@ -0,0 +1,23 @@
+package watcher
+
+import "sync/atomic"
+
+// callbackSet records that a callback was set, so Update doesn't warn about
+// the watcher never reacting to its peers, and starts the initial resync
+func (w *Watcher) callbackSet() {
+	atomic.StoreInt32(&w.wired, 1)
+	w.startInitialResync()
+}
+
+// warnUnwired logs a warning, once, when updates are published by a watcher
+// that never had a callback set and has no updates channel: it publishes
+// its changes but ignores those of its peers, which usually means
+// SetUpdateCallback was forgotten after Enforcer.SetWatcher.
+func (w *Watcher) warnUnwired() {
+	if w.updates != nil || atomic.LoadInt32(&w.wired) != 0 {
+		return
+	}
+	w.unwiredOnce.Do(func() {
+		w.log(LevelWarn, "Publishing an update but no update callback was ever set, peer updates are ignored; call SetUpdateCallback")
+	})
+}
@@ -0,0 +1,45 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWarnUnwired(t *testing.T) {
+	ctx := context.Background()
+	const warning = "no update callback was ever set"
+
+	for _, tc := range []struct {
+		name  string
+		wire  func(*Watcher)
+		warns int
+	}{
+		{"unwired", func(*Watcher) {}, 1},
+		{"callback", func(w *Watcher) { w.SetUpdateCallback(func(string) {}) }, 0},
+		{"callback ex", func(w *Watcher) { w.SetUpdateCallbackEx(func(UpdateMessage) error { return nil }) }, 0},
+		{"cleared callback", func(w *Watcher) {
+			w.SetUpdateCallback(func(string) {})
+			w.SetUpdateCallback(nil)
+		}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs syncBuffer
+			w, err := NewWithOptions(ctx, "fake://wiring", WithURLMux((&fakeBroker{}).mux()), WithLogger(NewJSONLogger(&logs)))
+			if err != nil {
+				t.Fatalf("Failed to create watcher, error: %s", err)
+			}
+			defer w.Close()
+
+			tc.wire(w)
+			for i := 0; i < 2; i++ {
+				if err := w.Update(); err != nil {
+					t.Fatalf("Failed to send update: %s", err)
+				}
+			}
+			if got := strings.Count(logs.String(), warning); got != tc.warns {
+				t.Fatalf("Expected %d warnings, got %d: %s", tc.warns, got, logs.String())
+			}
+		})
+	}
+}